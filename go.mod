@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/ameade/spec v0.3.0 // - Apache 2.0 license
 	github.com/container-storage-interface/spec v1.2.0 // - Apache 2.0 license
+	github.com/golang/protobuf v1.5.0
 	github.com/jpillora/backoff v0.0.0-20180909062703-3050d21c67d7 // - MIT license
 	github.com/kr/pretty v0.1.0 // indirect; indirect - MIT license
 	github.com/kubernetes-csi/csi-test v2.2.0+incompatible
@@ -18,13 +19,14 @@ require (
 	google.golang.org/grpc v1.25.1
 	google.golang.org/protobuf v1.28.1
 	gopkg.in/yaml.v2 v2.2.5
+	k8s.io/apimachinery v0.0.0-20190205091131-4b4ea28f2790 // - Apache 2.0 license
 	k8s.io/klog v0.2.0 // indirect; indirect - MIT license
 	k8s.io/kubernetes v1.13.3 // - MIT license
 	k8s.io/utils v0.0.0-20190212002617-cdba02414f76 // indirect; indirect - MIT license
 )
 
 require (
-	github.com/golang/protobuf v1.5.0 // indirect
+	github.com/gogo/protobuf v1.3.1 // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/hpcloud/tail v1.0.0 // indirect
 	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
@@ -32,6 +34,6 @@ require (
 	google.golang.org/genproto v0.0.0-20190819201941-24fa4b261c55 // indirect
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	gopkg.in/fsnotify.v1 v1.4.7 // indirect
+	gopkg.in/inf.v0 v0.9.1 // indirect
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
-	k8s.io/apimachinery v0.0.0-20190205091131-4b4ea28f2790 // indirect
 )
@@ -16,14 +16,20 @@ limitations under the License.
 package main
 
 import (
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
     "github.com/hammer-space/csi-plugin/pkg/common"
     "net"
     "net/url"
     "os"
     "os/signal"
+    "path/filepath"
     "strconv"
     "strings"
     "syscall"
+    "time"
 
     log "github.com/sirupsen/logrus"
     "github.com/hammer-space/csi-plugin/pkg/driver"
@@ -37,55 +43,375 @@ func init() {
     log.SetOutput(os.Stdout)
     log.SetLevel(log.DebugLevel)
     log.SetReportCaller(true)
+    log.AddHook(common.RecentLogs)
 }
 
-func validateEnvironmentVars() {
+// parseEnvironmentVars validates the environment and sets the configuration from it
+func parseEnvironmentVars() error {
     endpoint := os.Getenv("CSI_ENDPOINT")
     if len(endpoint) == 0 {
-        log.Error("CSI_ENDPOINT must be defined and must be a path")
-        os.Exit(1)
+        return errors.New("CSI_ENDPOINT must be defined and must be a path")
     }
     if strings.Contains(endpoint, ":") {
-        log.Error("CSI_ENDPOINT must be a unix path")
-        os.Exit(1)
+        return errors.New("CSI_ENDPOINT must be a unix path")
     }
 
-    hsEndpoint := os.Getenv("HS_ENDPOINT")
-    if len(hsEndpoint) == 0 {
-        log.Error("HS_ENDPOINT must be defined")
-        os.Exit(1)
+    if common.ServiceMode == common.ServiceModeNode && os.Getenv("CSI_NODE_NAME") == "" {
+        return errors.New("CSI_NODE_NAME must be defined with -mode=node")
     }
 
-    endpointUrl, err := url.Parse(hsEndpoint)
-    if err != nil || endpointUrl.Scheme != "https" || endpointUrl.Host == "" {
-        log.Error("HS_ENDPOINT must be a valid HTTPS URL")
-        os.Exit(1)
+    var err error
+    if os.Getenv("HS_NODE_CREDENTIALLESS") != "" {
+        common.NodeCredentialless, err = strconv.ParseBool(os.Getenv("HS_NODE_CREDENTIALLESS"))
+        if err != nil {
+            return errors.New("HS_NODE_CREDENTIALLESS must be a bool")
+        }
     }
-
-    username := os.Getenv("HS_USERNAME")
-    if len(username) == 0 {
-        log.Error("HS_USERNAME must be defined")
-        os.Exit(1)
+    if common.NodeCredentialless && common.ServiceMode != common.ServiceModeNode {
+        return errors.New("HS_NODE_CREDENTIALLESS requires -mode=node")
     }
-    password := os.Getenv("HS_PASSWORD")
-    if len(password) == 0 {
-        log.Error("HS_PASSWORD must be defined")
-        os.Exit(1)
+
+    // Nodes without credentials never call the Hammerspace API
+    if !common.NodeCredentialless {
+        hsEndpoint := os.Getenv("HS_ENDPOINT")
+        if len(hsEndpoint) == 0 {
+            return errors.New("HS_ENDPOINT must be defined")
+        }
+
+        endpointUrl, err := url.Parse(hsEndpoint)
+        if err != nil || endpointUrl.Scheme != "https" || endpointUrl.Host == "" {
+            return errors.New("HS_ENDPOINT must be a valid HTTPS URL")
+        }
+
+        username := os.Getenv("HS_USERNAME")
+        if len(username) == 0 {
+            return errors.New("HS_USERNAME must be defined")
+        }
+        password := os.Getenv("HS_PASSWORD")
+        if len(password) == 0 {
+            return errors.New("HS_PASSWORD must be defined")
+        }
     }
     if os.Getenv("HS_TLS_VERIFY") != "" {
         _, err = strconv.ParseBool(os.Getenv("HS_TLS_VERIFY"))
         if err != nil {
-            log.Error("HS_TLS_VERIFY must be a bool")
-            os.Exit(1)
+            return errors.New("HS_TLS_VERIFY must be a bool")
         }
     }
     if os.Getenv("CSI_MAJOR_VERSION") != "0" || os.Getenv("CSI_MAJOR_VERSION") != "1" {
         if err != nil {
-            log.Error("CSI_MAJOR_VERSION must be set to \"0\" or \"1\"")
-            os.Exit(1)
+            return errors.New("CSI_MAJOR_VERSION must be set to \"0\" or \"1\"")
+        }
+    }
+    if os.Getenv("CSI_MAJOR_VERSION") == "0" && !driver.CSIv0Supported {
+        return errors.New("CSI_MAJOR_VERSION is \"0\" but this plugin was built without CSI 0.3 support")
+    }
+    if os.Getenv("HS_CSI_V0_BLOCK_VOLUMES") != "" {
+        common.CSIv0BlockVolumes, err = strconv.ParseBool(os.Getenv("HS_CSI_V0_BLOCK_VOLUMES"))
+        if err != nil {
+            return errors.New("HS_CSI_V0_BLOCK_VOLUMES must be a bool")
         }
     }
     common.DataPortalMountPrefix = os.Getenv("HS_DATA_PORTAL_MOUNT_PREFIX")
+    if localAddress, exists := os.LookupEnv("HS_LOCAL_DATA_PORTAL_ADDRESS"); exists {
+        common.LocalDataPortalAddress = localAddress
+    }
+    if os.Getenv("HS_ANVIL_MOUNT_FALLBACK") != "" {
+        common.AnvilMountFallback, err = strconv.ParseBool(os.Getenv("HS_ANVIL_MOUNT_FALLBACK"))
+        if err != nil {
+            return errors.New("HS_ANVIL_MOUNT_FALLBACK must be a bool")
+        }
+    }
+    if os.Getenv("HS_DISABLE_SHOWMOUNT") != "" {
+        common.DisableShowmount, err = strconv.ParseBool(os.Getenv("HS_DISABLE_SHOWMOUNT"))
+        if err != nil {
+            return errors.New("HS_DISABLE_SHOWMOUNT must be a bool")
+        }
+    }
+
+    if scrubInterval := os.Getenv("HS_BACKING_FILE_SCRUB_INTERVAL"); scrubInterval != "" {
+        common.BackingFileScrubInterval, err = time.ParseDuration(scrubInterval)
+        if err != nil || common.BackingFileScrubInterval < 0 {
+            return errors.New("HS_BACKING_FILE_SCRUB_INTERVAL must be a non-negative duration, Ex: 1h")
+        }
+    }
+    if unmountTimeout := os.Getenv("HS_UNMOUNT_TIMEOUT"); unmountTimeout != "" {
+        common.UnmountTimeout, err = time.ParseDuration(unmountTimeout)
+        if err != nil || common.UnmountTimeout <= 0 {
+            return errors.New("HS_UNMOUNT_TIMEOUT must be a positive duration, Ex: 30s")
+        }
+    }
+    if healthTimeout := os.Getenv("HS_MOUNT_HEALTH_CHECK_TIMEOUT"); healthTimeout != "" {
+        common.MountHealthCheckTimeout, err = time.ParseDuration(healthTimeout)
+        if err != nil || common.MountHealthCheckTimeout <= 0 {
+            return errors.New("HS_MOUNT_HEALTH_CHECK_TIMEOUT must be a positive duration, Ex: 5s")
+        }
+    }
+    if mountPolicy := os.Getenv("HS_BACKING_SHARE_MOUNT_POLICY"); mountPolicy != "" {
+        common.BackingShareMountPolicy, err = common.ParseMountPolicy(mountPolicy)
+        if err != nil {
+            return fmt.Errorf("HS_BACKING_SHARE_MOUNT_POLICY is invalid, %v", err)
+        }
+    }
+    if bindTimeout := os.Getenv("HS_SOCKET_BIND_TIMEOUT"); bindTimeout != "" {
+        common.SocketBindTimeout, err = time.ParseDuration(bindTimeout)
+        if err != nil || common.SocketBindTimeout < 0 {
+            return errors.New("HS_SOCKET_BIND_TIMEOUT must be a non-negative duration, Ex: 30s")
+        }
+    }
+    if unmountDelay := os.Getenv("HS_BACKING_SHARE_UNMOUNT_DELAY"); unmountDelay != "" {
+        common.BackingShareUnmountDelay, err = time.ParseDuration(unmountDelay)
+        if err != nil || common.BackingShareUnmountDelay < 0 {
+            return errors.New("HS_BACKING_SHARE_UNMOUNT_DELAY must be a non-negative duration, Ex: 30s")
+        }
+    }
+    if freezeTimeout := os.Getenv("HS_FREEZE_TIMEOUT"); freezeTimeout != "" {
+        common.FreezeTimeout, err = time.ParseDuration(freezeTimeout)
+        if err != nil || common.FreezeTimeout <= 0 {
+            return errors.New("HS_FREEZE_TIMEOUT must be a positive duration, Ex: 30s")
+        }
+    }
+    if trashRetention := os.Getenv("HS_FILE_TRASH_RETENTION"); trashRetention != "" {
+        common.FileTrashRetention, err = time.ParseDuration(trashRetention)
+        if err != nil || common.FileTrashRetention < 0 {
+            return errors.New("HS_FILE_TRASH_RETENTION must be a non-negative duration, Ex: 24h")
+        }
+    }
+    if reclaimInterval := os.Getenv("HS_RECLAIM_SPACE_INTERVAL"); reclaimInterval != "" {
+        common.ReclaimSpaceInterval, err = time.ParseDuration(reclaimInterval)
+        if err != nil || common.ReclaimSpaceInterval < 0 {
+            return errors.New("HS_RECLAIM_SPACE_INTERVAL must be a non-negative duration, Ex: 24h")
+        }
+    }
+    if sampleInterval := os.Getenv("HS_LOG_SAMPLE_INTERVAL"); sampleInterval != "" {
+        common.LogSampleInterval, err = time.ParseDuration(sampleInterval)
+        if err != nil || common.LogSampleInterval < 0 {
+            return errors.New("HS_LOG_SAMPLE_INTERVAL must be a non-negative duration, Ex: 1m")
+        }
+    }
+    if sampleBurst := os.Getenv("HS_LOG_SAMPLE_BURST"); sampleBurst != "" {
+        common.LogSampleBurst, err = strconv.Atoi(sampleBurst)
+        if err != nil || common.LogSampleBurst < 1 {
+            return errors.New("HS_LOG_SAMPLE_BURST must be a positive integer")
+        }
+    }
+    if defaultSize := os.Getenv("HS_DEFAULT_VOLUME_SIZE"); defaultSize != "" {
+        common.DefaultBackingFileSizeBytes, err = common.ParseSize(defaultSize)
+        if err != nil || common.DefaultBackingFileSizeBytes <= 0 {
+            return errors.New("HS_DEFAULT_VOLUME_SIZE must be a positive size, such as 1Gi")
+        }
+    }
+    if minSize := os.Getenv("HS_MIN_VOLUME_SIZE"); minSize != "" {
+        common.MinVolumeSizeBytes, err = common.ParseSize(minSize)
+        if err != nil {
+            return errors.New("HS_MIN_VOLUME_SIZE must be a size, such as 1Gi")
+        }
+    }
+    if maxSize := os.Getenv("HS_MAX_VOLUME_SIZE"); maxSize != "" {
+        common.MaxVolumeSizeBytes, err = common.ParseSize(maxSize)
+        if err != nil {
+            return errors.New("HS_MAX_VOLUME_SIZE must be a size, such as 1Ti")
+        }
+    }
+    if common.MaxVolumeSizeBytes > 0 && common.MinVolumeSizeBytes > common.MaxVolumeSizeBytes {
+        return errors.New("HS_MIN_VOLUME_SIZE must not be greater than HS_MAX_VOLUME_SIZE")
+    }
+    if common.DefaultBackingFileSizeBytes < common.MinVolumeSizeBytes ||
+        (common.MaxVolumeSizeBytes > 0 && common.DefaultBackingFileSizeBytes > common.MaxVolumeSizeBytes) {
+        return errors.New("HS_DEFAULT_VOLUME_SIZE must be between HS_MIN_VOLUME_SIZE and HS_MAX_VOLUME_SIZE")
+    }
+    if concurrency := os.Getenv("HS_NODE_PUBLISH_CONCURRENCY"); concurrency != "" {
+        common.NodePublishConcurrency, err = strconv.Atoi(concurrency)
+        if err != nil || common.NodePublishConcurrency < 0 {
+            return errors.New("HS_NODE_PUBLISH_CONCURRENCY must be a non-negative integer")
+        }
+    }
+    if os.Getenv("HS_LOAD_LOOP_MODULE") != "" {
+        common.LoadLoopModuleOnStart, err = strconv.ParseBool(os.Getenv("HS_LOAD_LOOP_MODULE"))
+        if err != nil {
+            return errors.New("HS_LOAD_LOOP_MODULE must be a bool")
+        }
+    }
+    if maxLoop := os.Getenv("HS_LOOP_MAX_DEVICES"); maxLoop != "" {
+        common.LoopMaxDevices, err = strconv.Atoi(maxLoop)
+        if err != nil || common.LoopMaxDevices < 0 {
+            return errors.New("HS_LOOP_MAX_DEVICES must be a non-negative integer")
+        }
+    }
+    if budget := os.Getenv("HS_LOOP_DEVICE_BUDGET"); budget != "" {
+        common.LoopDeviceBudget, err = strconv.Atoi(budget)
+        if err != nil || common.LoopDeviceBudget < 0 {
+            return errors.New("HS_LOOP_DEVICE_BUDGET must be a non-negative integer")
+        }
+    }
+    if retries := os.Getenv("HS_LOSETUP_RETRIES"); retries != "" {
+        common.LosetupRetries, err = strconv.Atoi(retries)
+        if err != nil || common.LosetupRetries < 0 {
+            return errors.New("HS_LOSETUP_RETRIES must be a non-negative integer")
+        }
+    }
+    if retryInterval := os.Getenv("HS_LOSETUP_RETRY_INTERVAL"); retryInterval != "" {
+        common.LosetupRetryInterval, err = time.ParseDuration(retryInterval)
+        if err != nil || common.LosetupRetryInterval < 0 {
+            return errors.New("HS_LOSETUP_RETRY_INTERVAL must be a non-negative duration, Ex: 1s")
+        }
+    }
+    if concurrency := os.Getenv("HS_DELETE_VOLUME_CONCURRENCY"); concurrency != "" {
+        common.DeleteVolumeConcurrency, err = strconv.Atoi(concurrency)
+        if err != nil || common.DeleteVolumeConcurrency < 0 {
+            return errors.New("HS_DELETE_VOLUME_CONCURRENCY must be a non-negative integer")
+        }
+    }
+    if concurrency := os.Getenv("HS_SNAPSHOT_DELETE_CONCURRENCY"); concurrency != "" {
+        common.SnapshotDeleteConcurrency, err = strconv.Atoi(concurrency)
+        if err != nil || common.SnapshotDeleteConcurrency < 0 {
+            return errors.New("HS_SNAPSHOT_DELETE_CONCURRENCY must be a non-negative integer")
+        }
+    }
+    if cacheTTL := os.Getenv("HS_DATA_PORTAL_CACHE_TTL"); cacheTTL != "" {
+        common.DataPortalCacheTTL, err = time.ParseDuration(cacheTTL)
+        if err != nil || common.DataPortalCacheTTL < 0 {
+            return errors.New("HS_DATA_PORTAL_CACHE_TTL must be a non-negative duration, Ex: 1m")
+        }
+    }
+    if os.Getenv("HS_NODE_MOUNT_WARMUP") != "" {
+        common.NodeMountWarmup, err = strconv.ParseBool(os.Getenv("HS_NODE_MOUNT_WARMUP"))
+        if err != nil {
+            return errors.New("HS_NODE_MOUNT_WARMUP must be a bool")
+        }
+    }
+    if thresholds, exists := os.LookupEnv("HS_USAGE_THRESHOLDS"); exists {
+        common.UsageThresholds = []int{}
+        for _, threshold := range strings.Split(thresholds, ",") {
+            if strings.TrimSpace(threshold) == "" {
+                continue
+            }
+            percent, err := strconv.Atoi(strings.TrimSpace(threshold))
+            if err != nil || percent < 1 || percent > 100 {
+                return errors.New("HS_USAGE_THRESHOLDS must be a comma separated list of percentages, Ex: 80,90,95")
+            }
+            common.UsageThresholds = append(common.UsageThresholds, percent)
+        }
+    }
+    if os.Getenv("HS_USAGE_EVENTS") != "" {
+        common.UsageEvents, err = strconv.ParseBool(os.Getenv("HS_USAGE_EVENTS"))
+        if err != nil {
+            return errors.New("HS_USAGE_EVENTS must be a bool")
+        }
+    }
+    common.LeaderCheck = os.Getenv("HS_LEADER_CHECK")
+    if err := driver.ValidateLeaderCheck(common.LeaderCheck); err != nil {
+        return fmt.Errorf("HS_LEADER_CHECK is invalid, %v", err)
+    }
+    if policy := os.Getenv("HS_CAPACITY_CHECK_POLICY"); policy != "" {
+        if err := driver.ValidateCapacityCheckPolicy(policy); err != nil {
+            return fmt.Errorf("HS_CAPACITY_CHECK_POLICY is invalid, %v", err)
+        }
+        common.CapacityCheckPolicy = policy
+    }
+    if comment, exists := os.LookupEnv("HS_DEFAULT_COMMENT"); exists {
+        if len(comment) > 255 {
+            return errors.New("HS_DEFAULT_COMMENT must be at most 255 characters")
+        }
+        common.DefaultShareComment = comment
+    }
+    if extendedInfo := os.Getenv("HS_DEFAULT_EXTENDED_INFO"); extendedInfo != "" {
+        common.DefaultExtendedInfo, err = driver.ParseDefaultExtendedInfo(extendedInfo)
+        if err != nil {
+            return fmt.Errorf("HS_DEFAULT_EXTENDED_INFO is invalid, %v", err)
+        }
+    }
+    if tiers := os.Getenv("HS_OBJECTIVE_TIERS"); tiers != "" {
+        common.ObjectiveTiers, err = driver.ParseObjectiveTiers(tiers)
+        if err != nil {
+            return fmt.Errorf("HS_OBJECTIVE_TIERS is invalid, %v", err)
+        }
+    }
+    if disabled := os.Getenv("HS_DISABLED_CONTROLLER_CAPABILITIES"); disabled != "" {
+        common.DisabledControllerCapabilities, err = driver.ParseDisabledControllerCapabilities(disabled)
+        if err != nil {
+            return fmt.Errorf("HS_DISABLED_CONTROLLER_CAPABILITIES is invalid, %v", err)
+        }
+    }
+    if faults := os.Getenv("HS_FAULT_INJECTION"); faults != "" {
+        common.FaultInjection, err = common.ParseFaultInjection(faults)
+        if err != nil {
+            return fmt.Errorf("HS_FAULT_INJECTION is invalid, %v", err)
+        }
+        log.Warnf("injecting faults for chaos testing, %s", faults)
+    }
+    common.VolumePolicyHook = os.Getenv("HS_VOLUME_POLICY_HOOK")
+    if err := driver.ValidatePolicyHook(common.VolumePolicyHook); err != nil {
+        return fmt.Errorf("HS_VOLUME_POLICY_HOOK is invalid, %v", err)
+    }
+    common.SnapshotHookAllowlist = nil
+    for _, hook := range strings.Split(os.Getenv("HS_SNAPSHOT_HOOK_ALLOWLIST"), ",") {
+        if hook = strings.TrimSpace(hook); hook != "" {
+            common.SnapshotHookAllowlist = append(common.SnapshotHookAllowlist, hook)
+        }
+    }
+    common.MetricsAddress = os.Getenv("CSI_METRICS_ADDRESS")
+    if os.Getenv("HS_SUPPORT_BUNDLE") != "" {
+        common.SupportBundleEnabled, err = strconv.ParseBool(os.Getenv("HS_SUPPORT_BUNDLE"))
+        if err != nil {
+            return errors.New("HS_SUPPORT_BUNDLE must be a bool")
+        }
+    }
+    common.CSIAddonsEndpoint = os.Getenv("CSI_ADDONS_ENDPOINT")
+    if strings.Contains(common.CSIAddonsEndpoint, ":") {
+        return errors.New("CSI_ADDONS_ENDPOINT must be a unix path")
+    }
+    if stateDir, exists := os.LookupEnv("HS_NODE_STATE_DIR"); exists {
+        common.NodeStateDir = stateDir
+    }
+    if rootDir, exists := os.LookupEnv("HS_KUBELET_ROOT_DIR"); exists {
+        if rootDir != "" {
+            if !filepath.IsAbs(rootDir) {
+                return errors.New("HS_KUBELET_ROOT_DIR must be an absolute path")
+            }
+            rootDir = filepath.Clean(rootDir)
+        }
+        common.KubeletRootDir = rootDir
+    }
+    for name, value := range map[string]*int{
+        "HS_HTTP_MAX_IDLE_CONNS":          &common.HTTPMaxIdleConns,
+        "HS_HTTP_MAX_IDLE_CONNS_PER_HOST": &common.HTTPMaxIdleConnsPerHost,
+    } {
+        if setting := os.Getenv(name); setting != "" {
+            *value, err = strconv.Atoi(setting)
+            if err != nil || *value < 0 {
+                return fmt.Errorf("%s must be a non-negative integer", name)
+            }
+        }
+    }
+    for name, value := range map[string]*time.Duration{
+        "HS_HTTP_IDLE_CONN_TIMEOUT":       &common.HTTPIdleConnTimeout,
+        "HS_HTTP_TLS_HANDSHAKE_TIMEOUT":   &common.HTTPTLSHandshakeTimeout,
+        "HS_HTTP_RESPONSE_HEADER_TIMEOUT": &common.HTTPResponseHeaderTimeout,
+        "HS_API_READ_TIMEOUT":             &common.APIReadTimeout,
+        "HS_API_WRITE_TIMEOUT":            &common.APIWriteTimeout,
+    } {
+        if setting := os.Getenv(name); setting != "" {
+            *value, err = time.ParseDuration(setting)
+            if err != nil || *value < 0 {
+                return fmt.Errorf("%s must be a non-negative duration, Ex: 30s", name)
+            }
+        }
+    }
+    if os.Getenv("HS_HTTP2") != "" {
+        common.HTTP2, err = strconv.ParseBool(os.Getenv("HS_HTTP2"))
+        if err != nil {
+            return errors.New("HS_HTTP2 must be a bool")
+        }
+    }
+    return nil
+}
+
+func validateEnvironmentVars() {
+    if err := parseEnvironmentVars(); err != nil {
+        log.Error(err)
+        os.Exit(1)
+    }
 }
 
 type Server interface {
@@ -93,7 +419,109 @@ type Server interface {
     Stop()
 }
 
+// runPreflight prints a report of the checks made before deploying the plugin to a node,
+// exiting non-zero if any failed
+func runPreflight() {
+    // Keep stdout for the report
+    log.SetOutput(os.Stderr)
+
+    tlsVerify, _ := strconv.ParseBool(os.Getenv("HS_TLS_VERIFY"))
+    report := driver.RunPreflight(
+        parseEnvironmentVars(),
+        os.Getenv("HS_ENDPOINT"),
+        os.Getenv("HS_USERNAME"),
+        os.Getenv("HS_PASSWORD"),
+        tlsVerify,
+    )
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(report)
+    if !report.Passed {
+        os.Exit(1)
+    }
+    os.Exit(0)
+}
+
+// runRestore brings back a deleted volume and prints the PersistentVolume to create for it as JSON
+func runRestore(volumeID, pvName, fsType string) {
+    // Keep stdout for the PersistentVolume
+    log.SetOutput(os.Stderr)
+    validateEnvironmentVars()
+
+    csiDriver := driver.NewCSIDriver(
+        os.Getenv("HS_ENDPOINT"),
+        os.Getenv("HS_USERNAME"),
+        os.Getenv("HS_PASSWORD"),
+        os.Getenv("HS_TLS_VERIFY"),
+    )
+    restored, err := csiDriver.RestoreDeletedVolume(volumeID, pvName, fsType)
+    if err != nil {
+        log.Error(err)
+        os.Exit(1)
+    }
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(restored.PersistentVolume)
+    os.Exit(0)
+}
+
+// runModify applies the parameters, a JSON object of parameter names to values, to an existing
+// volume. When a file-backed volume is moved to another backing share it prints the
+// PersistentVolume to create for it at its new path as JSON.
+func runModify(volumeID, parameters, pvName, fsType string) {
+    // Keep stdout for the PersistentVolume
+    log.SetOutput(os.Stderr)
+    params := map[string]string{}
+    if err := json.Unmarshal([]byte(parameters), &params); err != nil {
+        log.Errorf("invalid -modify-parameters, %v", err)
+        os.Exit(1)
+    }
+    validateEnvironmentVars()
+
+    csiDriver := driver.NewCSIDriver(
+        os.Getenv("HS_ENDPOINT"),
+        os.Getenv("HS_USERNAME"),
+        os.Getenv("HS_PASSWORD"),
+        os.Getenv("HS_TLS_VERIFY"),
+    )
+    moved, err := csiDriver.ModifyVolume(volumeID, params, pvName, fsType)
+    if moved != nil {
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        encoder.Encode(moved.PersistentVolume)
+    }
+    if err != nil {
+        log.Error(err)
+        os.Exit(1)
+    }
+    os.Exit(0)
+}
+
 func main() {
+    mode := flag.String("mode", common.ServiceModeAll, "CSI services to serve, all, controller for the controller Deployment or node for the node DaemonSet")
+    preflight := flag.Bool("preflight", false, "Check the environment, Hammerspace cluster and host, print a JSON report and exit")
+    restoreVolume := flag.String("restore-volume", "", "Restore the deleted volume with this ID, print the PersistentVolume to create for it and exit")
+    restorePVName := flag.String("restore-pv-name", "", "Name of the PersistentVolume of the restored volume, restored-<volume name> by default")
+    restoreFsType := flag.String("restore-fs-type", "", "Filesystem of a restored file-backed volume, restored as a block volume when empty")
+    modifyVolume := flag.String("modify-volume", "", "Apply -modify-parameters to the volume with this ID and exit")
+    modifyParameters := flag.String("modify-parameters", "{}", "JSON object of the volume parameters to change, e.g. {\"objectives\": \"keep-online\"}")
+    modifyPVName := flag.String("modify-pv-name", "", "Name of the PersistentVolume of a volume moved to another backingShareName, moved-<volume name> by default")
+    modifyFsType := flag.String("modify-fs-type", "", "Filesystem of a moved file-backed volume, described as a block volume when empty")
+    flag.Parse()
+    if err := driver.ValidateServiceMode(*mode); err != nil {
+        log.Error(err)
+        os.Exit(1)
+    }
+    common.ServiceMode = *mode
+    if *preflight {
+        runPreflight()
+    }
+    if *restoreVolume != "" {
+        runRestore(*restoreVolume, *restorePVName, *restoreFsType)
+    }
+    if *modifyVolume != "" {
+        runModify(*modifyVolume, *modifyParameters, *modifyPVName, *modifyFsType)
+    }
 
     validateEnvironmentVars()
 
@@ -115,10 +543,22 @@ func main() {
     } else {
         server = csiDriver
     }
+    driver.RecordBuildInfo()
+
+    if common.MetricsAddress != "" {
+        common.RegisterHTTPHandler("/healthz/detailed", csiDriver.ServeDetailedHealth)
+        if common.SupportBundleEnabled {
+            common.RegisterHTTPHandler(driver.SupportBundlePath, csiDriver.ServeSupportBundle)
+        }
+        go func() {
+            if err := common.ServeMetrics(common.MetricsAddress); err != nil {
+                log.Errorf("Metrics endpoint stopped: %v", err)
+            }
+        }()
+    }
 
     // Listen
-    os.Remove(endpoint)
-    l, err := net.Listen("unix", endpoint)
+    l, err := common.ListenUnixSocket(endpoint, common.SocketBindTimeout)
     if err != nil {
         log.Errorf("Error: Unable to listen on %s socket: %v\n",
             endpoint,
@@ -131,10 +571,24 @@ func main() {
     if err := server.Start(l); err != nil {
         log.Errorf("Error: Unable to start CSI server: %v\n",
             err)
+        server.Stop()
+        l.Close()
+        os.Remove(endpoint)
         os.Exit(1)
     }
     log.Info("hammerspace driver started")
 
+    if common.CSIAddonsEndpoint != "" {
+        addonsListener, err := common.ListenUnixSocket(common.CSIAddonsEndpoint, common.SocketBindTimeout)
+        if err != nil {
+            log.Errorf("Error: Unable to listen on %s socket: %v\n", common.CSIAddonsEndpoint, err)
+            server.Stop()
+            os.Exit(1)
+        }
+        defer os.Remove(common.CSIAddonsEndpoint)
+        csiDriver.ServeCSIAddons(addonsListener)
+    }
+
     // Wait for signal
     sigc := make(chan os.Signal, 1)
     sigs := []os.Signal{
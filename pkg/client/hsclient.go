@@ -19,6 +19,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -31,6 +32,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -43,9 +45,30 @@ import (
 )
 
 const (
-	BasePath            = "/mgmt/v1.2/rest"
+	// Version of the Hammerspace management API the client is written against
+	APILevel            = "v1.2"
+	BasePath            = "/mgmt/" + APILevel + "/rest"
 	taskPollTimeout     = 3600 * time.Second // Seconds
 	taskPollIntervalCap = 30 * time.Second   //Seconds, The maximum duration between calls when polling task objects
+
+	// After a failed login, further attempts wait for a growing, jittered cool-down shared by all
+	// callers, so invalid credentials do not turn every API call into a login request
+	loginBackoffMin = 1 * time.Second
+	loginBackoffMax = 2 * time.Minute
+
+	// Response bodies longer than this are truncated in logs, listings of large clusters run into megabytes
+	maxLoggedBodyBytes = 4096
+
+	// Executing tasks are listed this many at a time, at most taskListMaxPages pages per listing
+	taskListPageSize = 100
+	taskListMaxPages = 50
+	// How long a listing of the executing tasks answers further lookups, so that the retries of many
+	// volume creations do not each list the tasks of the cluster
+	taskListCacheTTL = 5 * time.Second
+
+	// Shares are listed this many at a time by ListSharesPaged, at most shareListMaxPages pages
+	shareListPageSize = 100
+	shareListMaxPages = 1000
 )
 
 type HammerspaceClient struct {
@@ -53,22 +76,40 @@ type HammerspaceClient struct {
 	password   string
 	endpoint   string
 	httpclient *http.Client
+
+	loginLock        sync.Mutex
+	lastLogin        time.Time // time of the last successful login
+	lastLoginErr     error     // error of the last login attempt
+	lastLoginCode    codes.Code
+	loginRetryAt     time.Time // no login is attempted before this time
+	loginBackoff     backoff.Backoff
+	loginAttemptLock sync.Mutex // serializes login attempts
+
+	tasksLock       sync.Mutex
+	executingShares map[string]bool // names of the shares with an executing task
+	tasksListedAt   time.Time
 }
 
 func NewHammerspaceClient(endpoint, username, password string, tlsVerify bool) (*HammerspaceClient, error) {
+	hsclient, err := newHammerspaceClient(endpoint, username, password, tlsVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	err = hsclient.EnsureLogin()
+
+	return hsclient, err
+}
+
+// newHammerspaceClient returns a client which has not logged in yet
+func newHammerspaceClient(endpoint, username, password string, tlsVerify bool) (*HammerspaceClient, error) {
 	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	tr := &http.Transport{
-		MaxIdleConns:       10,
-		IdleConnTimeout:    30 * time.Second,
-		DisableCompression: true,
-		TLSClientConfig:    &tls.Config{InsecureSkipVerify: !tlsVerify},
-	}
 	httpclient := &http.Client{
-		Transport: tr,
+		Transport: newTransport(tlsVerify),
 		Jar:       jar,
 	}
 	hsclient := &HammerspaceClient{
@@ -76,11 +117,35 @@ func NewHammerspaceClient(endpoint, username, password string, tlsVerify bool) (
 		password:   password,
 		endpoint:   endpoint,
 		httpclient: httpclient,
-	}
+		loginBackoff: backoff.Backoff{
+			Min:    loginBackoffMin,
+			Max:    loginBackoffMax,
+			Factor: 2,
+			Jitter: true,
+		},
+	}
+	return hsclient, nil
+}
 
-	err = hsclient.EnsureLogin()
+// newTransport returns the transport for requests to the Hammerspace API, tuned with the
+// HS_HTTP_* settings. All requests go to the API gateway, so the idle connections per host
+// default to the total rather than Go's default of 2.
+func newTransport(tlsVerify bool) *http.Transport {
+	return &http.Transport{
+		MaxIdleConns:          common.HTTPMaxIdleConns,
+		MaxIdleConnsPerHost:   common.HTTPMaxIdleConnsPerHost,
+		IdleConnTimeout:       common.HTTPIdleConnTimeout,
+		TLSHandshakeTimeout:   common.HTTPTLSHandshakeTimeout,
+		ResponseHeaderTimeout: common.HTTPResponseHeaderTimeout,
+		ForceAttemptHTTP2:     common.HTTP2,
+		DisableCompression:    true,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: !tlsVerify},
+	}
+}
 
-	return hsclient, err
+// Endpoint returns the URL of the Hammerspace API the client sends requests to
+func (client *HammerspaceClient) Endpoint() string {
+	return client.endpoint
 }
 
 // GetAnvilPortal returns the hostname of the configured Hammerspace API gateway
@@ -136,7 +201,8 @@ func (client *HammerspaceClient) GetPortalFloatingIp() (string, error) {
 // those with a matching nodeID are put at the top of the list
 func (client *HammerspaceClient) GetDataPortals(nodeID string) ([]common.DataPortal, error) {
 	req, err := client.generateRequest("GET", "/data-portals/", "")
-	statusCode, respBody, _, err := client.doRequest(*req)
+	var portals []common.DataPortal
+	statusCode, err := client.doListRequest(*req, &portals)
 
 	if err != nil {
 		log.Error(err)
@@ -146,17 +212,14 @@ func (client *HammerspaceClient) GetDataPortals(nodeID string) ([]common.DataPor
 		return nil, errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 200))
 	}
 
-	var portals []common.DataPortal
-	err = json.Unmarshal([]byte(respBody), &portals)
-	if err != nil {
-		log.Error("Error parsing JSON response: " + err.Error())
-		return nil, err
-	}
-
-	// filter dataportals
+	// filter dataportals, clusters may only have NFSv4 portals
 	var filteredPortals []common.DataPortal
 	for _, p := range portals {
-		if p.OperState == "UP" && p.AdminState == "UP" && p.DataPortalType == "NFS_V3" {
+		if p.OperState != "UP" || p.AdminState != "UP" {
+			continue
+		}
+		switch p.DataPortalType {
+		case common.DataPortalTypeNFSv3, common.DataPortalTypeNFSv4:
 			filteredPortals = append(filteredPortals, p)
 		}
 	}
@@ -179,15 +242,41 @@ func (client *HammerspaceClient) GetDataPortals(nodeID string) ([]common.DataPor
 	return sortedPortals, nil
 }
 
-// Logs into Hammerspace Anvil Server
+// Logs into Hammerspace Anvil Server. Concurrent callers share a single attempt, and after a
+// failure no attempt is made until the login cool-down has passed.
 func (client *HammerspaceClient) EnsureLogin() error {
+	start := time.Now()
+	client.loginAttemptLock.Lock()
+	defer client.loginAttemptLock.Unlock()
+
+	client.loginLock.Lock()
+	lastLogin, lastErr, lastCode, retryAt := client.lastLogin, client.lastLoginErr, client.lastLoginCode, client.loginRetryAt
+	client.loginLock.Unlock()
+	if lastLogin.After(start) {
+		// Logged in by another caller while this one waited
+		return nil
+	}
+	if wait := time.Until(retryAt); wait > 0 {
+		return status.Errorf(lastCode, common.LoginCoolDown, wait.Round(time.Millisecond), lastErr)
+	}
+
 	v := url.Values{}
 	v.Add("username", client.username)
 	v.Add("password", client.password)
 
-	resp, err := client.httpclient.PostForm(fmt.Sprintf("%s%s/login", client.endpoint, BasePath), v)
+	// Logins are given the time of reads, they do not start a task
+	ctx, cancel := contextWithTimeout(common.APIReadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s%s/login", client.endpoint, BasePath),
+		strings.NewReader(v.Encode()))
 	if err != nil {
-		return err
+		return status.Errorf(codes.Internal, common.LoginFailed, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.httpclient.Do(req)
+	if err != nil {
+		client.recordLogin(err, codes.Unavailable)
+		return status.Errorf(codes.Unavailable, common.LoginFailed, err)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
@@ -203,30 +292,115 @@ func (client *HammerspaceClient) EnsureLogin() error {
 		log.Error(err)
 	}
 	if resp.StatusCode != 200 {
-		err = errors.New("failed to login to Hammerspace Anvil")
+		err = fmt.Errorf("failed to login to Hammerspace Anvil, received status code %d", resp.StatusCode)
 		responseLog.Error(err)
+		client.recordLogin(err, codes.Unauthenticated)
+		return status.Errorf(codes.Unauthenticated, common.LoginFailed, err)
 	}
-	return err
+	client.recordLogin(nil, codes.OK)
+	return nil
 }
 
-func (client *HammerspaceClient) doRequest(req http.Request) (int, string, map[string][]string, error) {
+func (client *HammerspaceClient) recordLogin(err error, code codes.Code) {
+	client.loginLock.Lock()
+	defer client.loginLock.Unlock()
+	client.lastLoginErr = err
+	client.lastLoginCode = code
+	if err == nil {
+		client.lastLogin = time.Now()
+		client.loginRetryAt = time.Time{}
+		client.loginBackoff.Reset()
+		return
+	}
+	wait := client.loginBackoff.Duration()
+	client.loginRetryAt = time.Now().Add(wait)
+	log.Warnf("login to Hammerspace Anvil failed, not retrying for %v", wait.Round(time.Millisecond))
+}
+
+// LastLogin returns the time of the last successful login, and the error of the last login attempt
+func (client *HammerspaceClient) LastLogin() (time.Time, error) {
+	client.loginLock.Lock()
+	defer client.loginLock.Unlock()
+	return client.lastLogin, client.lastLoginErr
+}
+
+// requestTimeout returns how long a request to the API may take. Reads are expected to return
+// quickly, while writes may wait for the API to start a task.
+func requestTimeout(method string) time.Duration {
+	if method == "GET" || method == "HEAD" {
+		return common.APIReadTimeout
+	}
+	return common.APIWriteTimeout
+}
+
+// contextWithTimeout returns a context which expires after timeout, or never if it is 0
+func contextWithTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// withRequestTimeout returns the request with the timeout of its category set on its context.
+// The context must be cancelled once the response body has been read.
+func withRequestTimeout(req http.Request) (http.Request, context.CancelFunc) {
+	ctx, cancel := contextWithTimeout(requestTimeout(req.Method))
+	return *req.WithContext(ctx), cancel
+}
+
+// sendRequest sends a request, logging in and sending it again if the session has expired. When
+// the login fails the response is returned closed along with the login error.
+// injectAPIFault delays a request or returns an error response in its place when fault injection
+// is enabled, nil if the request should be sent
+func injectAPIFault(req http.Request) *http.Response {
+	if rule, inject := common.InjectFault(common.FaultAPIDelay); inject {
+		select {
+		case <-time.After(rule.Delay):
+		case <-req.Context().Done():
+		}
+	}
+	if rule, inject := common.InjectFault(common.FaultAPIError); inject {
+		return &http.Response{
+			StatusCode: rule.StatusCode,
+			Status:     fmt.Sprintf("%d %s", rule.StatusCode, http.StatusText(rule.StatusCode)),
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(common.InjectedAPIError)),
+			Request:    &req,
+		}
+	}
+	return nil
+}
+
+func (client *HammerspaceClient) sendRequest(req http.Request) (*http.Response, error) {
 	log.Debugf("sending request %s %s", req.Method, req.URL)
 
+	if resp := injectAPIFault(req); resp != nil {
+		return resp, nil
+	}
 	resp, err := client.httpclient.Do(&req)
 	// Attempt to login
 	if err == nil && (resp.StatusCode == 401 || resp.StatusCode == 403) {
-		client.EnsureLogin()
+		resp.Body.Close()
+		if loginErr := client.EnsureLogin(); loginErr != nil {
+			return resp, loginErr
+		}
 		resp, err = client.httpclient.Do(&req)
 	}
-	if err != nil {
-		return 0, "", nil, err
+	if err != nil && req.Context().Err() == context.DeadlineExceeded {
+		return nil, status.Errorf(codes.DeadlineExceeded, common.APIRequestTimeout,
+			req.Method, req.URL.Path, requestTimeout(req.Method))
+	}
+	return resp, err
+}
+
+// logResponse logs a response, with its body truncated to maxLoggedBodyBytes
+func logResponse(req http.Request, resp *http.Response, body string) {
+	if len(body) > maxLoggedBodyBytes {
+		body = fmt.Sprintf("%s... (%d bytes truncated)", body[:maxLoggedBodyBytes], len(body)-maxLoggedBodyBytes)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	bodyString := string(body)
 	responseLog := log.WithFields(log.Fields{
 		"statusCode":  resp.StatusCode,
-		"body":        bodyString,
+		"body":        body,
 		"headers":     resp.Header,
 		"request_url": req.URL,
 	})
@@ -235,9 +409,51 @@ func (client *HammerspaceClient) doRequest(req http.Request) (int, string, map[s
 	} else {
 		responseLog.Debug("received response")
 	}
+}
+
+func (client *HammerspaceClient) doRequest(req http.Request) (int, string, map[string][]string, error) {
+	req, cancel := withRequestTimeout(req)
+	defer cancel()
+	resp, err := client.sendRequest(req)
+	if err != nil {
+		var statusCode int
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		return statusCode, "", nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	bodyString := string(body)
+	logResponse(req, resp, bodyString)
 	return resp.StatusCode, bodyString, resp.Header, err
 }
 
+// doListRequest sends a request to a list endpoint and decodes the JSON array it returns into v
+// as it is received, rather than buffering the whole listing. Other status codes than 200 are
+// returned with their body logged.
+func (client *HammerspaceClient) doListRequest(req http.Request, v interface{}) (int, error) {
+	req, cancel := withRequestTimeout(req)
+	defer cancel()
+	resp, err := client.sendRequest(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, err := ioutil.ReadAll(resp.Body)
+		logResponse(req, resp, string(body))
+		return resp.StatusCode, err
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	log.WithFields(log.Fields{
+		"statusCode":  resp.StatusCode,
+		"headers":     resp.Header,
+		"request_url": req.URL,
+	}).Debug("received list response")
+	return resp.StatusCode, err
+}
+
 func (client *HammerspaceClient) generateRequest(verb, urlPath, body string) (*http.Request, error) {
 	req, err := http.NewRequest(verb,
 		fmt.Sprintf("%s%s%s", client.endpoint, BasePath, urlPath),
@@ -252,6 +468,12 @@ func (client *HammerspaceClient) generateRequest(verb, urlPath, body string) (*h
 }
 
 func (client *HammerspaceClient) WaitForTaskCompletion(taskLocation string) (bool, error) {
+	return client.WaitForTaskProgress(taskLocation, nil)
+}
+
+// WaitForTaskProgress waits for a task like WaitForTaskCompletion, passing the task to progress,
+// when set, each time it is polled
+func (client *HammerspaceClient) WaitForTaskProgress(taskLocation string, progress func(common.Task)) (bool, error) {
 	b := &backoff.Backoff{
 		Max:    taskPollIntervalCap,
 		Factor: 1.5,
@@ -286,6 +508,9 @@ func (client *HammerspaceClient) WaitForTaskCompletion(taskLocation string) (boo
 			log.Error(err)
 			return false, nil
 		}
+		if progress != nil {
+			progress(task)
+		}
 		if task.ExitValue != "NONE" {
 			if task.Status == "COMPLETED" || task.Status == "FAILED" || task.Status == "HALTED" || task.Status == "CANCELLED" {
 				return true, nil
@@ -300,18 +525,16 @@ func (client *HammerspaceClient) WaitForTaskCompletion(taskLocation string) (boo
 
 func (client *HammerspaceClient) ListShares() ([]common.ShareResponse, error) {
 	req, err := client.generateRequest("GET", "/shares", "")
-	statusCode, respBody, _, err := client.doRequest(*req)
+	var shares []common.ShareResponse
+	statusCode, err := client.doListRequest(*req, &shares)
 
-	if err != nil {
-		log.Error(err)
-		return nil, err
-	}
 	if statusCode != 200 {
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
 		return nil, errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 200))
 	}
-
-	var shares []common.ShareResponse
-	err = json.Unmarshal([]byte(respBody), &shares)
 	if err != nil {
 		log.Error("Error parsing JSON response: " + err.Error())
 	}
@@ -320,20 +543,57 @@ func (client *HammerspaceClient) ListShares() ([]common.ShareResponse, error) {
 	return shares, nil
 }
 
+// ListSharesPaged lists the shares matching spec, a filter expression of the API such as
+// shareState!=REMOVED, a page at a time. Clusters which do not page return all shares at once, and
+// shares seen on an earlier page are skipped, so the caller must still filter them.
+func (client *HammerspaceClient) ListSharesPaged(spec string) ([]common.ShareResponse, error) {
+	shares := []common.ShareResponse{}
+	seen := map[string]bool{}
+	for page := 0; page < shareListMaxPages; page++ {
+		req, err := client.generateRequest("GET", fmt.Sprintf("/shares?spec=%s&page=%d&page.size=%d",
+			url.QueryEscape(spec), page, shareListPageSize), "")
+		if err != nil {
+			return nil, err
+		}
+		var pageShares []common.ShareResponse
+		statusCode, err := client.doListRequest(*req, &pageShares)
+		if statusCode != 200 {
+			if err != nil {
+				return nil, err
+			}
+			return nil, errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 200))
+		}
+		if err != nil {
+			return nil, err
+		}
+		added := 0
+		for _, share := range pageShares {
+			if !seen[share.Name] {
+				seen[share.Name] = true
+				shares = append(shares, share)
+				added++
+			}
+		}
+		if len(pageShares) != shareListPageSize || added == 0 {
+			return shares, nil
+		}
+	}
+	log.Warnf("stopped listing shares after %d pages", shareListMaxPages)
+	return shares, nil
+}
+
 func (client *HammerspaceClient) ListObjectives() ([]common.ClusterObjectiveResponse, error) {
 	req, err := client.generateRequest("GET", "/objectives", "")
-	statusCode, respBody, _, err := client.doRequest(*req)
+	var objs []common.ClusterObjectiveResponse
+	statusCode, err := client.doListRequest(*req, &objs)
 
-	if err != nil {
-		log.Error(err)
-		return nil, err
-	}
 	if statusCode != 200 {
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
 		return nil, errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 200))
 	}
-
-	var objs []common.ClusterObjectiveResponse
-	err = json.Unmarshal([]byte(respBody), &objs)
 	if err != nil {
 		log.Error("Error parsing JSON response: " + err.Error())
 	}
@@ -434,16 +694,66 @@ func (client *HammerspaceClient) DoesFileExist(path string) (bool, error) {
 	return file != nil, err
 }
 
+// SetFileTag sets a tag with a string value on the file or directory at path in the namespace
+func (client *HammerspaceClient) SetFileTag(path, name, value string) error {
+	log.Debugf("Set tag %s on %s to %s", name, path, value)
+	return client.setFileMetadata(path, "tag "+name, fmt.Sprintf("/files/tag/set?path=%s&name=%s&value=%s",
+		url.QueryEscape(path), url.QueryEscape(name), url.QueryEscape(value)))
+}
+
+// SetFileAttribute sets an attribute of the file or directory at path in the namespace to the
+// result of an expression
+func (client *HammerspaceClient) SetFileAttribute(path, name, expression string) error {
+	log.Debugf("Set attribute %s on %s to %s", name, path, expression)
+	return client.setFileMetadata(path, "attribute "+name, fmt.Sprintf("/files/attribute/set?path=%s&name=%s&expression=%s",
+		url.QueryEscape(path), url.QueryEscape(name), url.QueryEscape(expression)))
+}
+
+func (client *HammerspaceClient) setFileMetadata(path, description, urlPath string) error {
+	req, err := client.generateRequest("POST", urlPath, "")
+	if err != nil {
+		return err
+	}
+	statusCode, _, respHeaders, err := client.doRequest(*req)
+
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if statusCode == 404 {
+		return errors.New(common.FileNotFound)
+	}
+	if statusCode != 200 && statusCode != 202 {
+		return errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 200))
+	}
+
+	if locs, exists := respHeaders["Location"]; exists && statusCode == 202 {
+		success, err := client.WaitForTaskCompletion(locs[0])
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		if !success {
+			return fmt.Errorf(common.FileMetadataNotSet, description, path)
+		}
+	}
+	return nil
+}
+
 func (client *HammerspaceClient) CreateShare(name string,
 	exportPath string,
 	size int64, //size in bytes
 	objectives []string,
 	exportOptions []common.ShareExportOptions,
 	deleteDelay int64,
-	comment string) error {
+	comment string,
+	additionalExtendedInfo map[string]string) error {
 
 	log.Debug("Creating share: " + name)
 	extendedInfo := common.GetCommonExtendedInfo()
+	for k, v := range additionalExtendedInfo {
+		extendedInfo[k] = v
+	}
 	if exportOptions == nil { // send empty list to api req
 		exportOptions = make([]common.ShareExportOptions, 0)
 	}
@@ -495,7 +805,7 @@ func (client *HammerspaceClient) CreateShare(name string,
 			return err
 		}
 		if !success {
-			defer client.DeleteShare(share.Name, 0)
+			defer client.DeleteShare(share.Name, 0, true)
 			return errors.New("Share failed to create")
 		}
 
@@ -520,9 +830,14 @@ func (client *HammerspaceClient) CreateShareFromSnapshot(name string,
 	exportOptions []common.ShareExportOptions,
 	deleteDelay int64,
 	comment string,
-	snapshotPath string) error {
+	snapshotPath string,
+	additionalExtendedInfo map[string]string,
+	progress func(common.Task)) error {
 	log.Debug("Creating share from snapshot: " + name)
 	extendedInfo := common.GetCommonExtendedInfo()
+	for k, v := range additionalExtendedInfo {
+		extendedInfo[k] = v
+	}
 
 	if exportOptions == nil { // send empty list to api req
 		exportOptions = make([]common.ShareExportOptions, 0)
@@ -569,13 +884,13 @@ func (client *HammerspaceClient) CreateShareFromSnapshot(name string,
 
 	// ensure the location header is set and also make sure length >= 1
 	if locs, exists := respHeaders["Location"]; exists {
-		success, err := client.WaitForTaskCompletion(locs[0])
+		success, err := client.WaitForTaskProgress(locs[0], progress)
 		if err != nil {
 			log.Error(err)
 			return err
 		}
 		if !success {
-			defer client.DeleteShare(share.Name, 0)
+			defer client.DeleteShare(share.Name, 0, true)
 			return errors.New("Share failed to create")
 		}
 
@@ -593,32 +908,57 @@ func (client *HammerspaceClient) CreateShareFromSnapshot(name string,
 	return nil
 }
 
+// CheckIfShareCreateTaskIsRunning returns whether a task on the share, such as its creation or
+// its restore from a snapshot, is executing
 func (client *HammerspaceClient) CheckIfShareCreateTaskIsRunning(shareName string) (bool, error) {
-	req, err := client.generateRequest("GET", "/tasks", "")
-	if err != nil {
-		log.Error("Failed to generate request object")
-		return false, err
-	}
-	statusCode, respBody, _, err := client.doRequest(*req)
-	if err != nil {
-		return false, err
-	}
-	if statusCode != 200 {
-		return false, errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 200))
-	}
-	var tasks []common.Task
-	err = json.Unmarshal([]byte(respBody), &tasks)
-	if err != nil {
-		log.Error(err)
-		return false, nil
+	client.tasksLock.Lock()
+	defer client.tasksLock.Unlock()
+	if client.executingShares == nil || time.Since(client.tasksListedAt) > taskListCacheTTL {
+		executing, err := client.listExecutingTaskShares()
+		if err != nil {
+			return false, err
+		}
+		client.executingShares = executing
+		client.tasksListedAt = time.Now()
 	}
-	for _, task := range tasks {
-		log.Debug(fmt.Printf("Task Name: %v\n  Task Status: %s\n Share Name: %s\n", task.ParamsMap.Name, task.Status, shareName))
-		if task.Status == "EXECUTING" && task.ParamsMap.Name == shareName {
-			return true, nil
+	return client.executingShares[shareName], nil
+}
+
+// listExecutingTaskShares returns the names of the shares with an executing task. The cluster
+// filters the tasks by status and returns them a page at a time, clusters which do not page
+// return them all at once.
+func (client *HammerspaceClient) listExecutingTaskShares() (map[string]bool, error) {
+	executing := map[string]bool{}
+	for page := 0; page < taskListMaxPages; page++ {
+		req, err := client.generateRequest("GET", fmt.Sprintf("/tasks?spec=%s&page=%d&page.size=%d",
+			url.QueryEscape("status==EXECUTING"), page, taskListPageSize), "")
+		if err != nil {
+			log.Error("Failed to generate request object")
+			return nil, err
+		}
+		var tasks []common.Task
+		statusCode, err := client.doListRequest(*req, &tasks)
+		if statusCode != 200 {
+			if err != nil {
+				return nil, err
+			}
+			return nil, errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 200))
+		}
+		if err != nil {
+			log.Error(err)
+			return executing, nil
+		}
+		for _, task := range tasks {
+			if task.Status == "EXECUTING" && task.ParamsMap.Name != "" {
+				executing[task.ParamsMap.Name] = true
+			}
+		}
+		if len(tasks) != taskListPageSize {
+			return executing, nil
 		}
 	}
-	return false, nil
+	log.Warnf("stopped listing executing tasks after %d pages", taskListMaxPages)
+	return executing, nil
 }
 
 // Set objectives on a share, at the specified path, optionally clearing previously-set objectives at the path
@@ -660,6 +1000,37 @@ func (client *HammerspaceClient) SetObjectives(shareName string,
 	return nil
 }
 
+// Remove objectives from a share at the specified path
+// The path must start with a slash
+func (client *HammerspaceClient) RemoveObjectives(shareName string,
+	path string,
+	objectives []string) error {
+	log.Debugf("Removing objectives. Share=%s, Path=%s, Objectives=%v: ", shareName, path, objectives)
+	for _, objectiveName := range objectives {
+		urlPath := fmt.Sprintf("/shares/%s/objective-unset?path=%s&objective-identifier=%s",
+			shareName, path, objectiveName)
+		req, err := client.generateRequest("POST", urlPath, "")
+		if err != nil {
+			log.Errorf("Failed to remove objective %s from share %s at path %s, %v",
+				objectiveName, shareName, path, err)
+			return err
+		}
+		statusCode, _, _, err := client.doRequest(*req)
+		if err != nil {
+			log.Errorf("Failed to remove objective %s from share %s at path %s, %v",
+				objectiveName, shareName, path, err)
+			return err
+		}
+		if statusCode != 200 {
+			log.Errorf("Failed to remove objective %s from share %s at path %s, status code %d",
+				objectiveName, shareName, path, statusCode)
+			return errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 200))
+		}
+	}
+
+	return nil
+}
+
 func (client *HammerspaceClient) UpdateShareSize(name string,
 	size int64, //size in bytes
 ) error {
@@ -707,8 +1078,102 @@ func (client *HammerspaceClient) UpdateShareSize(name string,
 	return nil
 }
 
-func (client *HammerspaceClient) DeleteShare(name string, deleteDelay int64) error {
-	queryParams := "?delete-path=true"
+// UpdateShareExtendedInfo sets the given keys in the extended info of a share, keeping the others
+func (client *HammerspaceClient) UpdateShareExtendedInfo(name string, extendedInfo map[string]string) error {
+
+	log.Debugf("Update share extended info : %s to %v", name, extendedInfo)
+
+	return client.updateShare(name, func(share map[string]interface{}) {
+		shareExtendedInfo, _ := share["extendedInfo"].(map[string]interface{})
+		if shareExtendedInfo == nil {
+			shareExtendedInfo = map[string]interface{}{}
+		}
+		for k, v := range extendedInfo {
+			shareExtendedInfo[k] = v
+		}
+		share["extendedInfo"] = shareExtendedInfo
+	})
+}
+
+// DeleteShareExtendedInfo removes keys from the extended info of a share
+func (client *HammerspaceClient) DeleteShareExtendedInfo(name string, keys []string) error {
+
+	log.Debugf("Delete share extended info : %s %v", name, keys)
+
+	return client.updateShare(name, func(share map[string]interface{}) {
+		shareExtendedInfo, _ := share["extendedInfo"].(map[string]interface{})
+		for _, k := range keys {
+			delete(shareExtendedInfo, k)
+		}
+	})
+}
+
+// UpdateShareComment sets the comment of a share
+func (client *HammerspaceClient) UpdateShareComment(name, comment string) error {
+
+	log.Debugf("Update share comment : %s to %s", name, comment)
+
+	return client.updateShare(name, func(share map[string]interface{}) {
+		share["comment"] = comment
+	})
+}
+
+// UpdateShareExportOptions replaces the export options of a share
+func (client *HammerspaceClient) UpdateShareExportOptions(name string, exportOptions []common.ShareExportOptions) error {
+
+	log.Debugf("Update share export options : %s to %v", name, exportOptions)
+
+	if exportOptions == nil { // send empty list to api req
+		exportOptions = make([]common.ShareExportOptions, 0)
+	}
+	return client.updateShare(name, func(share map[string]interface{}) {
+		share["exportOptions"] = exportOptions
+	})
+}
+
+// updateShare applies modify to the fields of a share and waits for the update to complete,
+// leaving the fields it does not change as the cluster returned them
+func (client *HammerspaceClient) updateShare(name string, modify func(map[string]interface{})) error {
+	share, err := client.GetShareRawFields(name)
+	if err != nil || share == nil {
+		return errors.New(common.ShareNotFound)
+	}
+
+	modify(share)
+	shareString := new(bytes.Buffer)
+	json.NewEncoder(shareString).Encode(share)
+
+	req, err := client.generateRequest("PUT", "/shares/"+url.PathEscape(name), shareString.String())
+	statusCode, _, respHeaders, err := client.doRequest(*req)
+
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if statusCode != 202 {
+		return errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 202))
+	}
+
+	if locs, exists := respHeaders["Location"]; exists {
+		success, err := client.WaitForTaskCompletion(locs[0])
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		if !success {
+			return errors.New("Share failed to update")
+		}
+	} else {
+		log.Errorf("No task returned to monitor")
+	}
+
+	return nil
+}
+
+// DeleteShare removes the share. When deletePath is false only the export is removed and the
+// underlying data is preserved on the cluster
+func (client *HammerspaceClient) DeleteShare(name string, deleteDelay int64, deletePath bool) error {
+	queryParams := "?delete-path=" + strconv.FormatBool(deletePath)
 	if deleteDelay >= 0 {
 		queryParams = queryParams + "&delete-delay=" + strconv.Itoa(int(deleteDelay))
 	}
@@ -750,6 +1215,36 @@ func (client *HammerspaceClient) DeleteShare(name string, deleteDelay int64) err
 	return nil
 }
 
+// UndeleteShare brings back a share which was deleted with a delay and is still in the REMOVED state
+func (client *HammerspaceClient) UndeleteShare(name string) error {
+	req, err := client.generateRequest("POST", "/shares/"+url.PathEscape(name)+"/undelete", "")
+	if err != nil {
+		return err
+	}
+	statusCode, _, respHeaders, err := client.doRequest(*req)
+	if err != nil {
+		return err
+	}
+	if statusCode == 404 {
+		return errors.New(common.ShareNotFound)
+	}
+	if statusCode != 200 && statusCode != 202 {
+		return errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 202))
+	}
+
+	if locs, exists := respHeaders["Location"]; exists && statusCode == 202 {
+		success, err := client.WaitForTaskCompletion(locs[0])
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		if !success {
+			return errors.New("share-undelete task failed")
+		}
+	}
+	return nil
+}
+
 func (client *HammerspaceClient) SnapshotShare(shareName string) (string, error) {
 	req, err := client.generateRequest("POST",
 		fmt.Sprintf("/share-snapshots/snapshot-create/%s", url.PathEscape(shareName)), "")
@@ -804,6 +1299,43 @@ func (client *HammerspaceClient) GetShareSnapshots(shareName string) ([]string,
 	return snapshotNames, nil
 }
 
+// GetShareSnapshot returns the name of the snapshot of a share matching snapshotName exactly,
+// or "" if neither the share nor the snapshot exist
+func (client *HammerspaceClient) GetShareSnapshot(shareName, snapshotName string) (string, error) {
+	req, err := client.generateRequest("GET",
+		fmt.Sprintf("/share-snapshots/snapshot-list/%s", url.PathEscape(shareName)), "")
+	if err != nil {
+		return "", err
+	}
+	statusCode, respBody, _, err := client.doRequest(*req)
+	if err != nil {
+		return "", err
+	}
+	if statusCode == 404 {
+		return "", nil
+	}
+	if statusCode != 200 {
+		return "", errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 200))
+	}
+
+	var snapshotNames []string
+	err = json.Unmarshal([]byte(respBody), &snapshotNames)
+	if err != nil {
+		log.Error("Error parsing JSON response: " + err.Error())
+		return "", err
+	}
+	snapshotName = strings.TrimSpace(snapshotName)
+	if snapshotName == "" || snapshotName == "current" {
+		return "", nil
+	}
+	for _, name := range snapshotNames {
+		if strings.TrimSpace(name) == snapshotName {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
 func (client *HammerspaceClient) DeleteShareSnapshot(shareName, snapshotName string) error {
 	req, _ := client.generateRequest("POST",
 		fmt.Sprintf("/share-snapshots/snapshot-delete/%s/%s",
@@ -821,6 +1353,14 @@ func (client *HammerspaceClient) DeleteShareSnapshot(shareName, snapshotName str
 	}
 }
 
+// DeleteShareSnapshots deletes snapshots of a share, running up to
+// common.SnapshotDeleteConcurrency deletions at once
+func (client *HammerspaceClient) DeleteShareSnapshots(shareName string, snapshotNames []string) error {
+	return deleteConcurrently(snapshotNames, func(snapshotName string) error {
+		return client.DeleteShareSnapshot(shareName, snapshotName)
+	})
+}
+
 func (client *HammerspaceClient) GetFileSnapshots(filePath string) ([]common.FileSnapshot, error) {
 	req, _ := client.generateRequest("GET",
 		fmt.Sprintf("/file-snapshots/list?filename-expression=%s", url.PathEscape(filePath)), "")
@@ -871,6 +1411,45 @@ func (client *HammerspaceClient) DeleteFileSnapshot(filePath, snapshotName strin
 	}
 }
 
+// DeleteFileSnapshots deletes snapshots of a file, running up to
+// common.SnapshotDeleteConcurrency deletions at once
+func (client *HammerspaceClient) DeleteFileSnapshots(filePath string, snapshotNames []string) error {
+	return deleteConcurrently(snapshotNames, func(snapshotName string) error {
+		return client.DeleteFileSnapshot(filePath, snapshotName)
+	})
+}
+
+// deleteConcurrently calls deleteOne for each name, up to common.SnapshotDeleteConcurrency at
+// once, and returns the errors of the deletions which failed
+func deleteConcurrently(names []string, deleteOne func(string) error) error {
+	limit := common.SnapshotDeleteConcurrency
+	if limit <= 0 || limit > len(names) {
+		limit = len(names)
+	}
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	failed := []string{}
+	slots := make(chan struct{}, limit)
+	for _, name := range names {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := deleteOne(name); err != nil {
+				lock.Lock()
+				failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+				lock.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return fmt.Errorf(common.SnapshotsNotDeleted, len(failed), len(names), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
 func (client *HammerspaceClient) SnapshotFile(filepath string) (string, error) {
 	req, err := client.generateRequest("POST", fmt.Sprintf("/file-snapshots/create?filename-expression=%s", url.PathEscape(filepath)), "")
 	statusCode, respBody, _, err := client.doRequest(*req)
@@ -924,10 +1503,51 @@ func (client *HammerspaceClient) GetClusterAvailableCapacity() (int64, error) {
 	if err != nil {
 		log.Error("Error parsing JSON response: " + err.Error())
 	}
-	free, err := strconv.ParseInt(cluster.Capacity["free"], 10, 64)
+
+	return int64(cluster.Capacity["free"]), nil
+}
+
+// GetClusterSoftwareVersion returns the software version reported by the Hammerspace cluster
+func (client *HammerspaceClient) GetClusterSoftwareVersion() (string, error) {
+	req, err := client.generateRequest("GET", "/cntl/state", "")
+	statusCode, respBody, _, err := client.doRequest(*req)
+
 	if err != nil {
-		log.Error("Error parsing free cluster capacity: " + err.Error())
+		log.Error(err)
+		return "", err
+	}
+	if statusCode != 200 {
+		return "", errors.New(
+			fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 200))
 	}
 
-	return free, nil
+	var cluster common.ClusterResponse
+	err = json.Unmarshal([]byte(respBody), &cluster)
+	if err != nil {
+		log.Error("Error parsing JSON response: " + err.Error())
+		return "", err
+	}
+	return cluster.SoftwareVersion, nil
+}
+
+// ListLicenses returns the licenses installed on the Hammerspace cluster
+func (client *HammerspaceClient) ListLicenses() ([]common.LicenseResponse, error) {
+	req, err := client.generateRequest("GET", "/licenses", "")
+	statusCode, respBody, _, err := client.doRequest(*req)
+
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if statusCode != 200 {
+		return nil, errors.New(fmt.Sprintf(common.UnexpectedHSStatusCode, statusCode, 200))
+	}
+
+	var licenses []common.LicenseResponse
+	err = json.Unmarshal([]byte(respBody), &licenses)
+	if err != nil {
+		log.Error("Error parsing JSON response: " + err.Error())
+		return nil, err
+	}
+	return licenses, nil
 }
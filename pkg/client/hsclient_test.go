@@ -17,17 +17,24 @@ limitations under the License.
 package client
 
 import (
+    "encoding/json"
     "fmt"
     "io/ioutil"
     "net/http"
     "net/http/httptest"
     "reflect"
+    "strconv"
+    "strings"
+    "sync"
     "testing"
+    "time"
 
     //log "github.com/sirupsen/logrus"
 
     common "github.com/hammer-space/csi-plugin/pkg/common"
     testutils "github.com/hammer-space/csi-plugin/test/utils"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
 )
 
 var (
@@ -85,6 +92,10 @@ func TestListShares(t *testing.T) {
 
     expectedShares := []common.ShareResponse{
         common.ShareResponse{
+            Uoid: map[string]string{
+                "uuid":       "acd90e88-ed23-3464-90ee-320e11de31ae",
+                "objectType": "SHARE",
+            },
             Name:         "root",
             ExportPath:   "/",
             ExtendedInfo: map[string]string{},
@@ -97,12 +108,16 @@ func TestListShares(t *testing.T) {
                 },
             },
             Space: common.ShareSpaceResponse{
-                Total:     "64393052160",
-                Used:      "0",
-                Available: "63909851136",
+                Total:     64393052160,
+                Used:      0,
+                Available: 63909851136,
             },
         },
         common.ShareResponse{
+            Uoid: map[string]string{
+                "uuid":       "ac486652-6957-43cd-ac75-9885b3b3e9c9",
+                "objectType": "SHARE",
+            },
             Name:       "test-client-code",
             ExportPath: "/test-client-code",
             ExtendedInfo: map[string]string{
@@ -122,9 +137,9 @@ func TestListShares(t *testing.T) {
                 },
             },
             Space: common.ShareSpaceResponse{
-                Total:     "1073741824",
-                Used:      "0",
-                Available: "1073741824",
+                Total:     1073741824,
+                Used:      0,
+                Available: 1073741824,
             },
         },
     }
@@ -188,7 +203,7 @@ func TestCreateShare(t *testing.T) {
     `, common.Version, common.CsiPluginName, common.Githash, common.CsiVersion)
     err := hsclient.CreateShare("test",
         "/test", -1,
-        []string{}, []common.ShareExportOptions{}, 0, "")
+        []string{}, []common.ShareExportOptions{}, 0, "", nil)
     if err != nil {
         t.Error(err)
     }
@@ -212,7 +227,7 @@ func TestCreateShare(t *testing.T) {
         "/test",
         -1, []string{"test-obj", "test-obj2"},
         []common.ShareExportOptions{},
-        0, "")
+        0, "", nil)
     if err != nil {
         t.Error(err)
     }
@@ -236,7 +251,7 @@ func TestCreateShare(t *testing.T) {
         100,
         []string{},
         []common.ShareExportOptions{},
-        -1, "")
+        -1, "", nil)
     if err != nil {
         t.Error(err)
     }
@@ -284,7 +299,7 @@ func TestCreateShare(t *testing.T) {
         100,
         []string{},
         exportOptions,
-        0, "")
+        0, "", nil)
     if err != nil {
         t.Error(err)
     }
@@ -305,9 +320,591 @@ func TestCreateShare(t *testing.T) {
          "shareSizeLimit":0,
          "exportOptions":[]}
     `, common.Version, common.CsiPluginName, common.Githash, common.CsiVersion)
-    err = hsclient.CreateShare("test", "/test", -1, []string{}, []common.ShareExportOptions{}, 0, "")
+    err = hsclient.CreateShare("test", "/test", -1, []string{}, []common.ShareExportOptions{}, 0, "", nil)
     if err == nil {
         t.Logf("Expected error")
         t.Fail()
     }
 }
+
+func TestEnsureLoginCoolDown(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+
+    logins := 0
+    loginStatusCode := 401
+    Mux.HandleFunc(BasePath+"/login", func(w http.ResponseWriter, r *http.Request) {
+        logins++
+        w.WriteHeader(loginStatusCode)
+    })
+    Mux.HandleFunc(BasePath+"/shares", func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(401)
+    })
+
+    err := hsclient.EnsureLogin()
+    if status.Code(err) != codes.Unauthenticated {
+        t.Logf("Expected Unauthenticated, received %v", err)
+        t.FailNow()
+    }
+
+    // Within the cool-down no login is attempted
+    _, err = hsclient.ListShares()
+    if status.Code(err) != codes.Unauthenticated {
+        t.Logf("Expected Unauthenticated, received %v", err)
+        t.FailNow()
+    }
+    if logins != 1 {
+        t.Logf("Expected 1 login attempt, received %d", logins)
+        t.FailNow()
+    }
+
+    // After the cool-down login is attempted again
+    hsclient.loginRetryAt = time.Now()
+    loginStatusCode = 200
+    err = hsclient.EnsureLogin()
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if logins != 2 {
+        t.Logf("Expected 2 login attempts, received %d", logins)
+        t.FailNow()
+    }
+    if _, err := hsclient.LastLogin(); err != nil {
+        t.Logf("Unexpected last login error, %v", err)
+        t.FailNow()
+    }
+}
+
+func TestGetShareSnapshot(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+
+    Mux.HandleFunc(BasePath+"/share-snapshots/snapshot-list/test-share", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `["2019.10.01.12.00.00.snap", "2019.10.01.12.00.00.snap-2", "current"]`)
+    })
+
+    for snapshotName, expected := range map[string]string{
+        "2019.10.01.12.00.00.snap":   "2019.10.01.12.00.00.snap",
+        "2019.10.01.12.00.00.snap-2": "2019.10.01.12.00.00.snap-2",
+        "2019.10.01.12.00.00":        "",
+        "current":                    "",
+    } {
+        snapshot, err := hsclient.GetShareSnapshot("test-share", snapshotName)
+        if err != nil {
+            t.Logf("Unexpected error, %v", err)
+            t.FailNow()
+        }
+        if snapshot != expected {
+            t.Logf("Expected snapshot '%s' for %s, received '%s'", expected, snapshotName, snapshot)
+            t.FailNow()
+        }
+    }
+
+    snapshot, err := hsclient.GetShareSnapshot("missing-share", "2019.10.01.12.00.00.snap")
+    if err != nil || snapshot != "" {
+        t.Logf("Expected no snapshot of a missing share, received '%s', %v", snapshot, err)
+        t.FailNow()
+    }
+}
+
+func TestDoListRequest(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+    hsclient.executingShares = nil
+
+    statusCode := 200
+    requests := 0
+    unfiltered := []string{}
+    Mux.HandleFunc(BasePath+"/tasks", func(w http.ResponseWriter, r *http.Request) {
+        requests++
+        if r.URL.Query().Get("spec") != "status==EXECUTING" {
+            unfiltered = append(unfiltered, r.URL.RawQuery)
+        }
+        w.WriteHeader(statusCode)
+        fmt.Fprintf(w, `[{"uuid": "1", "status": "EXECUTING", "paramsMap": {"name": "test-share"}}]`)
+    })
+
+    running, err := hsclient.CheckIfShareCreateTaskIsRunning("test-share")
+    if err != nil || !running {
+        t.Logf("Expected task of test-share to be running, %v", err)
+        t.FailNow()
+    }
+    running, err = hsclient.CheckIfShareCreateTaskIsRunning("other-share")
+    if err != nil || running {
+        t.Logf("Expected no task of other-share to be running, %v", err)
+        t.FailNow()
+    }
+    if requests != 1 {
+        t.Logf("Expected the second lookup to use the tasks listed by the first, listed %d times", requests)
+        t.FailNow()
+    }
+    if len(unfiltered) > 0 {
+        t.Logf("Expected tasks to be filtered by status, received %v", unfiltered)
+        t.FailNow()
+    }
+
+    statusCode = 500
+    hsclient.tasksListedAt = time.Time{}
+    if _, err = hsclient.CheckIfShareCreateTaskIsRunning("test-share"); err == nil {
+        t.Logf("Expected error")
+        t.FailNow()
+    }
+}
+
+func TestListExecutingTaskSharesPages(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+
+    pages := []string{}
+    Mux.HandleFunc(BasePath+"/tasks", func(w http.ResponseWriter, r *http.Request) {
+        page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+        pages = append(pages, r.URL.Query().Get("page"))
+        count := taskListPageSize
+        if page == 1 {
+            count = 1
+        }
+        tasks := make([]common.Task, count)
+        for i := range tasks {
+            tasks[i] = common.Task{Status: "EXECUTING", ParamsMap: common.TaskParamsMap{Name: fmt.Sprintf("share-%d-%d", page, i)}}
+        }
+        json.NewEncoder(w).Encode(tasks)
+    })
+
+    executing, err := hsclient.listExecutingTaskShares()
+    if err != nil || len(executing) != taskListPageSize+1 || !executing["share-1-0"] {
+        t.Logf("Expected the tasks of both pages, received %d, %v", len(executing), err)
+        t.FailNow()
+    }
+    if !reflect.DeepEqual(pages, []string{"0", "1"}) {
+        t.Logf("Expected pages 0 and 1 to be listed, received %v", pages)
+        t.FailNow()
+    }
+}
+
+func TestListSharesPaged(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+
+    paged := true
+    specs := map[string]bool{}
+    Mux.HandleFunc(BasePath+"/shares", func(w http.ResponseWriter, r *http.Request) {
+        specs[r.URL.Query().Get("spec")] = true
+        page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+        count := shareListPageSize
+        if page == 1 {
+            count = 2
+        }
+        if !paged {
+            // Clusters which do not page return every share for every page
+            page = 0
+        }
+        shares := make([]common.ShareResponse, count)
+        for i := range shares {
+            shares[i] = common.ShareResponse{Name: fmt.Sprintf("share-%d-%d", page, i)}
+        }
+        json.NewEncoder(w).Encode(shares)
+    })
+
+    shares, err := hsclient.ListSharesPaged("shareState!=REMOVED")
+    if err != nil || len(shares) != shareListPageSize+2 {
+        t.Logf("Expected the shares of both pages, received %d, %v", len(shares), err)
+        t.FailNow()
+    }
+    paged = false
+    shares, err = hsclient.ListSharesPaged("shareState!=REMOVED")
+    if err != nil || len(shares) != shareListPageSize {
+        t.Logf("Expected the shares once, received %d, %v", len(shares), err)
+        t.FailNow()
+    }
+    if !reflect.DeepEqual(specs, map[string]bool{"shareState!=REMOVED": true}) {
+        t.Logf("Expected the filter to be passed to the API, received %v", specs)
+        t.FailNow()
+    }
+}
+
+func TestNewTransport(t *testing.T) {
+    defer func(perHost int, timeout time.Duration, http2 bool) {
+        common.HTTPMaxIdleConnsPerHost = perHost
+        common.HTTPResponseHeaderTimeout = timeout
+        common.HTTP2 = http2
+    }(common.HTTPMaxIdleConnsPerHost, common.HTTPResponseHeaderTimeout, common.HTTP2)
+    common.HTTPMaxIdleConnsPerHost = 32
+    common.HTTPResponseHeaderTimeout = time.Minute
+    common.HTTP2 = true
+
+    tr := newTransport(false)
+    if tr.MaxIdleConnsPerHost != 32 || tr.ResponseHeaderTimeout != time.Minute || !tr.ForceAttemptHTTP2 {
+        t.Logf("Transport settings not applied, %+v", tr)
+        t.FailNow()
+    }
+    if !tr.TLSClientConfig.InsecureSkipVerify {
+        t.Logf("Expected certificates not to be verified")
+        t.FailNow()
+    }
+}
+
+func TestRequestTimeout(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+    defer func(timeout time.Duration) {
+        common.APIReadTimeout = timeout
+    }(common.APIReadTimeout)
+    common.APIReadTimeout = 50 * time.Millisecond
+
+    release := make(chan struct{})
+    defer close(release)
+    Mux.HandleFunc(BasePath+"/shares", func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-release:
+        case <-r.Context().Done():
+        }
+    })
+
+    _, err := hsclient.ListShares()
+    if status.Code(err) != codes.DeadlineExceeded {
+        t.Logf("Expected DeadlineExceeded, received %v", err)
+        t.FailNow()
+    }
+    if requestTimeout("POST") != common.APIWriteTimeout {
+        t.Logf("Expected writes to use the write timeout")
+        t.FailNow()
+    }
+}
+
+func TestInjectAPIFault(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+    defer func() { common.FaultInjection = map[string]common.FaultRule{} }()
+
+    requests := 0
+    Mux.HandleFunc(BasePath+"/shares", func(w http.ResponseWriter, r *http.Request) {
+        requests++
+        w.Write([]byte("[]"))
+    })
+
+    common.FaultInjection = map[string]common.FaultRule{
+        common.FaultAPIError: {Rate: 1, StatusCode: 503},
+    }
+    if _, err := hsclient.ListShares(); err == nil {
+        t.Logf("Expected the injected error")
+        t.FailNow()
+    }
+    if requests != 0 {
+        t.Logf("Expected the request not to be sent, received %d requests", requests)
+        t.FailNow()
+    }
+
+    common.FaultInjection = map[string]common.FaultRule{
+        common.FaultAPIDelay: {Rate: 1, Delay: 20 * time.Millisecond},
+    }
+    start := time.Now()
+    if _, err := hsclient.ListShares(); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if time.Since(start) < 20*time.Millisecond || requests != 1 {
+        t.Logf("Expected the request to be delayed and sent")
+        t.FailNow()
+    }
+}
+
+func TestSetFileMetadata(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+
+    statusCode := 200
+    var query map[string][]string
+    method := ""
+    Mux.HandleFunc(BasePath+"/files/tag/set", func(w http.ResponseWriter, r *http.Request) {
+        method = r.Method
+        query = r.URL.Query()
+        w.WriteHeader(statusCode)
+    })
+    Mux.HandleFunc(BasePath+"/files/attribute/set", func(w http.ResponseWriter, r *http.Request) {
+        query = r.URL.Query()
+        w.WriteHeader(statusCode)
+    })
+
+    err := hsclient.SetFileTag("/backing-share/pvc-1", "team", "a&b c")
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    expected := map[string][]string{"path": {"/backing-share/pvc-1"}, "name": {"team"}, "value": {"a&b c"}}
+    if method != "POST" {
+        t.Logf("Expected POST, received %s", method)
+        t.FailNow()
+    }
+    if !reflect.DeepEqual(query, expected) {
+        t.Logf("Expected query %v, received %v", expected, query)
+        t.FailNow()
+    }
+
+    err = hsclient.SetFileAttribute("/pvc-2", "CSI_DETAILS", "CSI_DETAILS_TABLE{'1.2.0'}")
+    if err != nil || query["expression"][0] != "CSI_DETAILS_TABLE{'1.2.0'}" {
+        t.Logf("Expected the attribute expression to be sent, received %v, %v", query, err)
+        t.FailNow()
+    }
+
+    statusCode = 404
+    if err = hsclient.SetFileTag("/missing", "team", "a"); err == nil || err.Error() != common.FileNotFound {
+        t.Logf("Expected %s, received %v", common.FileNotFound, err)
+        t.FailNow()
+    }
+    statusCode = 500
+    if err = hsclient.SetFileTag("/pvc-2", "team", "a"); err == nil {
+        t.Logf("Expected error")
+        t.FailNow()
+    }
+}
+
+func TestUndeleteShare(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+
+    statusCode := 200
+    undeleted := ""
+    method := ""
+    Mux.HandleFunc(BasePath+"/shares/pvc-1/undelete", func(w http.ResponseWriter, r *http.Request) {
+        method = r.Method
+        undeleted = "pvc-1"
+        w.WriteHeader(statusCode)
+    })
+
+    if err := hsclient.UndeleteShare("pvc-1"); err != nil || undeleted != "pvc-1" || method != "POST" {
+        t.Logf("Expected share to be undeleted with a POST, received %s, %v", method, err)
+        t.FailNow()
+    }
+    if err := hsclient.UndeleteShare("missing"); err == nil || err.Error() != common.ShareNotFound {
+        t.Logf("Expected %s, received %v", common.ShareNotFound, err)
+        t.FailNow()
+    }
+    statusCode = 409
+    if err := hsclient.UndeleteShare("pvc-1"); err == nil {
+        t.Logf("Expected error")
+        t.FailNow()
+    }
+}
+
+func TestUpdateShareComment(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+
+    var updated map[string]interface{}
+    Mux.HandleFunc(BasePath+"/shares/pvc-1", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == "PUT" {
+            json.NewDecoder(r.Body).Decode(&updated)
+            w.Header().Set("Location", Server.URL+BasePath+"/tasks/update-1")
+            w.WriteHeader(202)
+            return
+        }
+        fmt.Fprintf(w, `{"name": "pvc-1", "comment": "old", "extendedInfo": {"csi_created_by_plugin_name": "com.hammerspace.csi"}}`)
+    })
+    Mux.HandleFunc(BasePath+"/tasks/update-1", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `{"uuid": "update-1", "status": "COMPLETED", "exitValue": "0"}`)
+    })
+
+    if err := hsclient.UpdateShareComment("pvc-1", "new"); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if updated["comment"] != "new" {
+        t.Logf("Expected comment to be updated, received %v", updated["comment"])
+        t.FailNow()
+    }
+    extendedInfo, _ := updated["extendedInfo"].(map[string]interface{})
+    if extendedInfo["csi_created_by_plugin_name"] != "com.hammerspace.csi" {
+        t.Logf("Expected extended info to be kept, received %v", updated["extendedInfo"])
+        t.FailNow()
+    }
+}
+
+func TestWaitForTaskProgress(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+
+    polls := 0
+    Mux.HandleFunc(BasePath+"/tasks/restore-1", func(w http.ResponseWriter, r *http.Request) {
+        polls++
+        if polls < 2 {
+            fmt.Fprintf(w, `{"uuid": "restore-1", "status": "EXECUTING", "exitValue": "NONE", "progress": 50}`)
+            return
+        }
+        fmt.Fprintf(w, `{"uuid": "restore-1", "status": "COMPLETED", "exitValue": "0", "progress": 100}`)
+    })
+
+    progress := []float64{}
+    success, err := hsclient.WaitForTaskProgress(Server.URL+BasePath+"/tasks/restore-1", func(task common.Task) {
+        progress = append(progress, task.Progress)
+    })
+    if err != nil || !success {
+        t.Logf("Expected the task to complete, received %v, %v", success, err)
+        t.FailNow()
+    }
+    if !reflect.DeepEqual(progress, []float64{50, 100}) {
+        t.Logf("Expected the progress of each poll, received %v", progress)
+        t.FailNow()
+    }
+}
+
+func TestClientPool(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+    logins := 0
+    Mux.HandleFunc(BasePath+"/login", func(w http.ResponseWriter, r *http.Request) {
+        logins++
+        if r.FormValue("username") == "denied" {
+            w.WriteHeader(401)
+        }
+    })
+    other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        logins++
+    }))
+    defer other.Close()
+
+    pool := NewClientPool()
+    first, err := pool.Get(Server.URL, "tenant", "pass", false)
+    if err != nil || first.Endpoint() != Server.URL {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if again, _ := pool.Get(Server.URL, "tenant", "pass", false); again != first || logins != 1 {
+        t.Logf("Expected the client to be reused, %d logins", logins)
+        t.FailNow()
+    }
+    if changed, _ := pool.Get(Server.URL, "tenant", "other", false); changed == first {
+        t.Logf("Expected other credentials to use another client")
+        t.FailNow()
+    }
+    second, err := pool.Get(other.URL, "tenant", "pass", false)
+    if err != nil || second == first || second.Endpoint() != other.URL {
+        t.Logf("Expected a client for the other endpoint, received %v", err)
+        t.FailNow()
+    }
+    if _, err := pool.Get(Server.URL, "denied", "pass", false); err == nil {
+        t.Logf("Expected the login to fail")
+        t.FailNow()
+    }
+    // The failed client is kept, and waits for its login cool-down rather than logging in again
+    logins = 0
+    if _, err := pool.Get(Server.URL, "denied", "pass", false); err == nil || logins != 0 {
+        t.Logf("Expected the failed login to be answered from the cool-down, %d logins, %v", logins, err)
+        t.FailNow()
+    }
+
+    pool.maxClients = 2
+    pool.Get(Server.URL, "tenant", "pass", false)
+    if _, err := pool.Get(Server.URL, "tenant", "third", false); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if len(pool.clients) != 2 || !pool.Holds(first) || pool.Holds(second) {
+        t.Logf("Expected the least recently used clients to be dropped, have %d", len(pool.clients))
+        t.FailNow()
+    }
+}
+
+func TestGetDataPortals(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+
+    Mux.HandleFunc(BasePath+"/data-portals/", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprint(w, `[
+            {"operState": "UP", "adminState": "UP", "dataPortalType": "NFS_V3", "node": {"name": "node-2"}, "uoid": {"uuid": "v3"}},
+            {"operState": "UP", "adminState": "UP", "dataPortalType": "NFS_V4", "node": {"name": "node-1"}, "uoid": {"uuid": "v4"}},
+            {"operState": "UP", "adminState": "UP", "dataPortalType": "SMB", "node": {"name": "node-1"}, "uoid": {"uuid": "smb"}},
+            {"operState": "DOWN", "adminState": "UP", "dataPortalType": "NFS_V4", "node": {"name": "node-2"}, "uoid": {"uuid": "down"}}
+        ]`)
+    })
+
+    portals, err := hsclient.GetDataPortals("node-1")
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    uuids := []string{}
+    for _, p := range portals {
+        uuids = append(uuids, p.Uoid["uuid"])
+    }
+    if !reflect.DeepEqual(uuids, []string{"v4", "v3"}) {
+        t.Logf("Expected the NFS data-portals which are up, co-located first, received %v", uuids)
+        t.FailNow()
+    }
+}
+
+func TestDeleteShareExtendedInfo(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+
+    var updated map[string]interface{}
+    Mux.HandleFunc(BasePath+"/shares/pvc-1", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == "PUT" {
+            json.NewDecoder(r.Body).Decode(&updated)
+            w.Header().Set("Location", Server.URL+BasePath+"/tasks/update-1")
+            w.WriteHeader(202)
+            return
+        }
+        fmt.Fprintf(w, `{"name": "pvc-1", "extendedInfo": {"csi_created_by_plugin_name": "com.hammerspace.csi", "csi_snapshot_snap-1": "x"}}`)
+    })
+    Mux.HandleFunc(BasePath+"/tasks/update-1", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `{"uuid": "update-1", "status": "COMPLETED", "exitValue": "0"}`)
+    })
+
+    if err := hsclient.DeleteShareExtendedInfo("pvc-1", []string{"csi_snapshot_snap-1"}); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    expected := map[string]interface{}{"csi_created_by_plugin_name": "com.hammerspace.csi"}
+    if !reflect.DeepEqual(updated["extendedInfo"], expected) {
+        t.Logf("Expected %v, received %v", expected, updated["extendedInfo"])
+        t.FailNow()
+    }
+}
+
+func TestDeleteShareSnapshots(t *testing.T) {
+    setupHTTP()
+    defer tearDownHTTP()
+    defer func(limit int) { common.SnapshotDeleteConcurrency = limit }(common.SnapshotDeleteConcurrency)
+    common.SnapshotDeleteConcurrency = 2
+
+    var lock sync.Mutex
+    inFlight, maxInFlight := 0, 0
+    deleted := map[string]bool{}
+    Mux.HandleFunc(BasePath+"/share-snapshots/snapshot-delete/pvc-1/", func(w http.ResponseWriter, r *http.Request) {
+        name := strings.TrimPrefix(r.URL.Path, BasePath+"/share-snapshots/snapshot-delete/pvc-1/")
+        lock.Lock()
+        inFlight++
+        if inFlight > maxInFlight {
+            maxInFlight = inFlight
+        }
+        lock.Unlock()
+        time.Sleep(20 * time.Millisecond)
+        lock.Lock()
+        inFlight--
+        deleted[name] = true
+        lock.Unlock()
+        if name == "snap-4" {
+            w.WriteHeader(500)
+        }
+    })
+
+    snapshots := []string{"snap-1", "snap-2", "snap-3", "snap-4", "snap-5"}
+    err := hsclient.DeleteShareSnapshots("pvc-1", snapshots)
+    if err == nil || !strings.Contains(err.Error(), "snap-4") || strings.Contains(err.Error(), "snap-1") {
+        t.Logf("Expected the deletion of snap-4 to fail, %v", err)
+        t.FailNow()
+    }
+    if len(deleted) != len(snapshots) {
+        t.Logf("Expected every snapshot to be deleted, received %v", deleted)
+        t.FailNow()
+    }
+    if maxInFlight != 2 {
+        t.Logf("Expected 2 deletions at once, received %d", maxInFlight)
+        t.FailNow()
+    }
+    if err := hsclient.DeleteShareSnapshots("pvc-1", nil); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// At most this many clients are kept by a ClientPool, the least recently used is dropped for more
+const clientPoolMaxClients = 64
+
+// ClientPool holds the clients logged in to the Anvils of several Hammerspace clusters, by
+// endpoint and credentials, so one plugin can serve volumes on each of them
+type ClientPool struct {
+	lock       sync.Mutex
+	clients    map[string]*pooledClient
+	maxClients int
+}
+
+type pooledClient struct {
+	client   *HammerspaceClient
+	lastUsed time.Time
+}
+
+func NewClientPool() *ClientPool {
+	return &ClientPool{clients: make(map[string]*pooledClient), maxClients: clientPoolMaxClients}
+}
+
+// credentialsKey identifies a set of credentials without keeping the password
+func credentialsKey(username, password string, tlsVerify bool) string {
+	sum := sha256.Sum256([]byte(password))
+	return strings.Join([]string{username, hex.EncodeToString(sum[:]), strconv.FormatBool(tlsVerify)}, "\x00")
+}
+
+// Get returns the client for the Anvil at endpoint with the given credentials, logging it in on
+// first use. Clients which fail to login are kept, so further requests with the same credentials
+// wait for the login cool-down of the client rather than each attempting a login.
+func (pool *ClientPool) Get(endpoint, username, password string, tlsVerify bool) (*HammerspaceClient, error) {
+	client, err := pool.client(endpoint, username, password, tlsVerify)
+	if err != nil {
+		return nil, err
+	}
+	// Logins are made outside the lock of the pool, a slow Anvil must not hold up requests with
+	// other credentials. Concurrent logins of one client are serialized by EnsureLogin.
+	if lastLogin, _ := client.LastLogin(); lastLogin.IsZero() {
+		if err := client.EnsureLogin(); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// client returns the pooled client for the credentials, adding one which has not logged in yet
+func (pool *ClientPool) client(endpoint, username, password string, tlsVerify bool) (*HammerspaceClient, error) {
+	key := endpoint + "\x00" + credentialsKey(username, password, tlsVerify)
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	if pooled, exists := pool.clients[key]; exists {
+		pooled.lastUsed = time.Now()
+		return pooled.client, nil
+	}
+	client, err := newHammerspaceClient(endpoint, username, password, tlsVerify)
+	if err != nil {
+		return nil, err
+	}
+	pool.evict()
+	pool.clients[key] = &pooledClient{client: client, lastUsed: time.Now()}
+	return client, nil
+}
+
+// evict drops the least recently used clients until there is room for another
+func (pool *ClientPool) evict() {
+	for len(pool.clients) >= pool.maxClients && len(pool.clients) > 0 {
+		oldestKey := ""
+		var oldest time.Time
+		for key, pooled := range pool.clients {
+			if oldestKey == "" || pooled.lastUsed.Before(oldest) {
+				oldestKey, oldest = key, pooled.lastUsed
+			}
+		}
+		delete(pool.clients, oldestKey)
+	}
+}
+
+// Holds returns whether client is still kept by the pool
+func (pool *ClientPool) Holds(client *HammerspaceClient) bool {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	for _, pooled := range pool.clients {
+		if pooled.client == client {
+			return true
+		}
+	}
+	return false
+}
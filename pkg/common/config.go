@@ -25,9 +25,31 @@ const (
     // Must end with a "/"
     ShareStagingDir             = "/tmp"
     SharePathPrefix             = "/"
-    DefaultBackingFileSizeBytes = 1073741824
     DefaultVolumeNameFormat     = "%s"
 
+    // Values for the deleteMode volume parameter
+    DeleteModePurge      = "purge"              // Remove the share and its data (default)
+    DeleteModeExportOnly = "delete-export-only" // Remove the share but preserve the underlying path
+    DeleteModeRetain     = "retain"             // Leave the share and its data in place
+
+    // Values for the transport volume parameter
+    TransportTCP    = "tcp"
+    TransportRDMA   = "rdma"
+    DefaultRDMAPort = 20049
+    RDMADeviceDir   = "/sys/class/infiniband"
+
+    // Values for the blockPublishMode volume parameter
+    BlockPublishModeBind   = "bind"   // Bind mount the loop device onto a file at the target path (default)
+    BlockPublishModeDevice = "device" // Create a block device node for the loop device at the target path
+    // Values for the fsckOnStage volume parameter
+    FsckOnStageCheck  = "check"  // Fail staging if the filesystem has errors
+    FsckOnStageRepair = "repair" // Repair the errors which are safe to repair automatically
+
+    // Values for the -mode flag, the CSI services served by the plugin
+    ServiceModeAll        = "all"        // Identity, controller and node services (default)
+    ServiceModeController = "controller" // Identity and controller services, for the controller Deployment
+    ServiceModeNode       = "node"       // Identity and node services, for the node DaemonSet
+
     // Topology keys
     TopologyKeyDataPortal       = "topology.csi.hammerspace.com/is-data-portal"
 )
@@ -36,13 +58,126 @@ var (
     // These should be set at compile time
     Version = "NONE"
     Githash = "NONE"
+    BuildDate = "NONE"
 
     CsiVersion = "1"
+    // The CSI services served by the plugin, one of the ServiceMode values
+    ServiceMode = ServiceModeAll
+    // Whether the CSI 0.3 server translates raw block volume capabilities rather than rejecting them
+    CSIv0BlockVolumes = false
+
+    // Binaries which must be on the PATH for the plugin to report ready
+    RequiredBinaries = []string{"mount.nfs", "umount", "qemu-img", "mkfs.ext4", "mkfs.xfs"}
+    // Binaries additionally required on nodes
+    RequiredNodeBinaries = []string{"losetup"}
+
+    // Host files checked for kernel support of NFS and loop devices
+    ProcFilesystems   = "/proc/filesystems"
+    LoopControlDevice = "/dev/loop-control"
+    SysBlockDir       = "/sys/block"
+    // Whether nodes load the loop module at startup if the host has no loop control device
+    LoadLoopModuleOnStart = false
+    // max_loop passed when loading the loop module, 0 keeps the module's default
+    LoopMaxDevices = 0
 
     // The list of export path prefixes to try to use, in order, when mounting to a data portal
     DefaultDataPortalMountPrefixes = [...]string{"/", "/mnt/data-portal", ""}
     DataPortalMountPrefix = ""
+    // Address nodes mount a data-portal running on the same host at, empty uses the portal's address
+    LocalDataPortalAddress = "127.0.0.1"
+    // Whether shares are mounted from the Anvil of HS_ENDPOINT when no data-portal mounts them
+    AnvilMountFallback = false
+    // Never list the exports of data-portals with showmount, for environments without the MOUNT protocol
+    DisableShowmount = false
     CommandExecTimeout = 300 * time.Second  // Seconds
+    // Time allowed for each unmount attempt before escalating to a forced and then a lazy unmount
+    UnmountTimeout = 60 * time.Second
+    // Time allowed to statfs a mounted backing share before it is considered hung and remounted
+    MountHealthCheckTimeout = 5 * time.Second
+    // Mount options of backing shares from HS_BACKING_SHARE_MOUNT_POLICY, the NFS client defaults when empty
+    BackingShareMountPolicy []string
+    // How long snapshots wait for nodes to freeze a file-backed volume, and the longest a node keeps it frozen
+    FreezeTimeout = 30 * time.Second
+    // How long the plugin retries listening on CSI_ENDPOINT while another server still serves on it
+    SocketBindTimeout = 30 * time.Second
+    // How long after its last use an unused backing share is unmounted, 0 unmounts it immediately
+    BackingShareUnmountDelay = 30 * time.Second
+
+    // How often the controller verifies CSI-owned backing files, 0 disables the scrubber
+    BackingFileScrubInterval time.Duration
+    // How long the files of deleted file-backed volumes are kept in the trash of their backing share, 0 deletes them
+    FileTrashRetention time.Duration
+    // How often unused space in file-backed volumes is returned to the backing share, 0 disables it
+    ReclaimSpaceInterval time.Duration
+    // Repetitive log messages are logged at most LogSampleBurst times per LogSampleInterval, 0 disables sampling
+    LogSampleInterval time.Duration
+    LogSampleBurst    = 10
+    // Command or http(s) URL approving each volume created or deleted by the controller, empty allows all
+    VolumePolicyHook = ""
+    // Snapshot hook commands and webhook URLs VolumeSnapshotClasses may use, empty allows none
+    SnapshotHookAllowlist []string
+    // How a controller replica checks it is the leader before running background tasks, empty means always
+    LeaderCheck = ""
+    // Most publish and unpublish operations a node runs at once, 0 means unlimited
+    NodePublishConcurrency = 0
+    // Most DeleteVolume operations the controller runs at once, 0 means unlimited
+    DeleteVolumeConcurrency = 16
+    // Most snapshot deletions the client runs at once when deleting several snapshots, 0 means unlimited
+    SnapshotDeleteConcurrency = 8
+    // Most loop devices the plugin attaches on a node, 0 means unlimited
+    LoopDeviceBudget = 0
+    // How many times attaching a loop device is retried, and the time between attempts
+    LosetupRetries       = 3
+    LosetupRetryInterval = 1 * time.Second
+    // Size of file-backed volumes created without a capacity range
+    DefaultBackingFileSizeBytes int64 = 1073741824
+    // Bounds on the size of created volumes, 0 means no bound
+    MinVolumeSizeBytes int64
+    MaxVolumeSizeBytes int64
+    // What CreateVolume and GetCapacity do when the free capacity of the cluster cannot be read,
+    // one of strict, cached or allow
+    CapacityCheckPolicy = "strict"
+    // How long nodes cache the list of data-portals, 0 disables the cache
+    DataPortalCacheTTL = 60 * time.Second
+    // Whether nodes mount the backing shares of their recorded volumes when the plugin starts
+    NodeMountWarmup = false
+    // Whether nodes run without Hammerspace credentials, mounting through the data-portals recorded
+    // in the volume context by the controller
+    NodeCredentialless = false
+    // Percentages of capacity at which the usage of a published volume is reported
+    UsageThresholds = []int{80, 90, 95}
+    // Whether nodes post a Kubernetes event on the PersistentVolume when its usage crosses a threshold
+    UsageEvents = false
+    // Address to serve metrics on, empty disables the metrics endpoint
+    MetricsAddress = ""
+    // Whether the support bundle is served to local clients alongside the metrics
+    SupportBundleEnabled = false
+    // Unix socket to serve the csi-addons services on, empty disables them
+    CSIAddonsEndpoint = ""
+    // Directory on hosts where the node plugin records staged and published volumes, empty disables persistence
+    NodeStateDir = "/var/lib/hammerspace-csi"
+    // Root dir of the kubelet, which nodes publish volumes below, empty disables the mount checks
+    KubeletRootDir = "/var/lib/kubelet"
+    // Transport settings of the Hammerspace API client, 0 timeouts mean no timeout
+    HTTPMaxIdleConns          = 10
+    HTTPMaxIdleConnsPerHost   = 10
+    HTTPIdleConnTimeout       = 30 * time.Second
+    HTTPTLSHandshakeTimeout   = 10 * time.Second
+    HTTPResponseHeaderTimeout time.Duration
+    HTTP2                     = false
+    // Time allowed for reads from the Hammerspace API and for writes, which may start a task, 0 means no limit
+    APIReadTimeout  = 60 * time.Second
+    APIWriteTimeout = 120 * time.Second
+    // Comment set on created shares when the comment volume parameter is not given
+    DefaultShareComment = "Created by CSI driver"
+    // Extended info set on every created share, and as metadata tags on every created file
+    DefaultExtendedInfo = map[string]string{}
+    // Objectives each tier volume parameter value expands to
+    ObjectiveTiers = map[string][]string{}
+    // Faults injected for chaos testing, keyed by fault, none when empty
+    FaultInjection = map[string]FaultRule{}
+    // Controller capabilities which are not advertised, by name, none when empty
+    DisabledControllerCapabilities = map[string]bool{}
 
 
     UseAnvil      bool
@@ -50,11 +185,13 @@ var (
 
 // Extended info to be set on every share created by the driver
 func GetCommonExtendedInfo() (map[string]string) {
-    extendedInfo := map[string]string{
-        "csi_created_by_plugin_name":    CsiPluginName,
-        "csi_created_by_plugin_version": Version,
-        "csi_created_by_plugin_git_hash": Githash,
-        "csi_created_by_csi_version": CsiVersion,
+    extendedInfo := map[string]string{}
+    for k, v := range DefaultExtendedInfo {
+        extendedInfo[k] = v
     }
+    extendedInfo["csi_created_by_plugin_name"] = CsiPluginName
+    extendedInfo["csi_created_by_plugin_version"] = Version
+    extendedInfo["csi_created_by_plugin_git_hash"] = Githash
+    extendedInfo["csi_created_by_csi_version"] = CsiVersion
     return extendedInfo
 }
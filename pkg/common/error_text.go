@@ -31,6 +31,10 @@ const (
     ConflictingCapabilities       = "Cannot request a volume to be both raw and a filesystem"
     InvalidDeleteDelay            = "deleteDelay parameter must be an Integer. Value received '%s'"
     InvalidComment                = "Failed to set comment, invalid value"
+    InvalidDeleteMode             = "deleteMode parameter must be one of 'retain', 'delete-export-only' or 'purge'. Value received '%s'"
+    DeleteModeUnsupportedFileBacked = "deleteMode '%s' is only supported for share-backed volumes"
+    InvalidDeleteSnapshots        = "deleteSnapshots must be a bool. Value received '%s'"
+    DeleteSnapshotsUnsupportedFileBacked = "deleteSnapshots is only supported for share-backed volumes"
     InvalidShareNameSize          = "Share name cannot be longer than 80 characters"
     InvalidCommentSize            = "Share comment cannot be longer than 255 characters"
     EmptySnapshotId               = "Snapshot ID cannot be empty"
@@ -44,11 +48,72 @@ const (
     InvalidRootSquash                = "rootSquash must be a bool. Value received '%s'"
     InvalidAdditionalMetadataTags    = "Extended Info must be of format key=value, received '%s'"
     InvalidObjectiveNameDoesNotExist = "Cannot find objective with the name %s"
+    InvalidClientMountOptionRange    = "Invalid client mount option '%s', value must be between %d and %d"
+    InvalidClientMountOptionValue    = "Invalid client mount option '%s', value must be a non-negative integer"
+    InvalidClientMountOptionDuplicate = "Client mount option '%s' specified more than once"
+    InvalidClientMountOptionManaged  = "Client mount option '%s' is managed by the driver and cannot be set"
+    InvalidClientMountOptionConflict = "Client mount option '%s' cannot be combined with %s"
+    ClientMountOptionUnsupportedKernel = "Client mount option '%s' is not supported by kernel %s, requires %d.%d or newer"
+    InvalidMountPolicy               = "mountPolicy must be of the format <hard|soft>[,timeo=N][,retrans=N], received '%s'"
+    UnsafeSoftMountPolicy            = "soft mounts require timeo of at least %d and retrans of at least 1"
+    ConflictingMountPolicy           = "clientMountOptions cannot set '%s' when mountPolicy is specified"
+    InvalidTransport                 = "transport parameter must be 'tcp' or 'rdma'. Value received '%s'"
+    InvalidRDMAPort                  = "rdmaPort parameter must be a valid port number. Value received '%s'"
+    TransportUnsupportedFileBacked   = "transport '%s' is only supported for share-backed volumes"
+    InvalidBlockPublishMode          = "blockPublishMode parameter must be 'bind' or 'device'. Value received '%s'"
+    InvalidFsckOnStage               = "fsckOnStage parameter must be 'check' or 'repair'. Value received '%s'"
+    InvalidMaxVolumesPerBackingShare = "maxVolumesPerBackingShare parameter must be a positive integer. Value received '%s'"
+    InvalidVolumeID                  = "Volume ID '%s' is neither a share path nor the path of a file in a backing share"
+    InvalidMaxOvercommitRatio        = "maxOvercommitRatio parameter must be a positive number. Value received '%s'"
+    BackingShareOvercommitted        = "Backing share %s would hold %d bytes of volumes, more than %.2f times its capacity of %d bytes"
+    InvalidFreezeFilesystem          = "freezeFilesystem snapshot parameter must be a bool. Value received '%s'"
+    CloneModeMismatch                = "Volumes can only be cloned from a volume of the same kind, %s is %s-backed"
+    CloneSmallerThanSource           = "Requested capacity %d is smaller than the source volume capacity %d"
+    ReclaimSpaceUnsupported          = "Space can only be reclaimed from file-backed filesystem volumes, %s"
+    ReclaimSpaceVolumePublished      = "Volume %s is published on %v, its space is reclaimed by the nodes"
+    ReclaimSpaceInProgress           = "Space of volume %s is being reclaimed by the controller"
+    InvalidSnapshotWebhook           = "snapshotWebhook snapshot parameter must be an http or https URL. Value received '%s'"
+    SnapshotHookNotAllowed           = "Snapshot hook '%s' is not in HS_SNAPSHOT_HOOK_ALLOWLIST"
+    VolumePolicyDenied               = "%s denied by the volume policy hook: %s"
+    VolumePolicyUnavailable          = "Could not check %s with the volume policy hook, %v"
+    InvalidObjectivesReplace         = "objectivesReplace must be a bool. Value received '%s'"
+    ConflictingObjectiveRemove       = "Objective %s cannot be both set and removed"
+    InvalidObjectiveTier             = "Unknown tier '%s', the tiers configured in HS_OBJECTIVE_TIERS are %v"
+    ImmutableParameters              = "parameters %v cannot be modified, only %v can be changed on an existing volume"
+    ModifyUnsupportedFileBacked      = "parameters %v can only be modified on share-backed volumes"
+    MoveUnsupportedShareBacked       = "Only file-backed volumes can be moved to another backing share"
+    MoveDestinationNotBackingShare   = "Share %s is not a backing share of file-backed volumes"
+    InvalidParameters                = "Invalid parameters: %s"
+    UnknownParameters                = "unknown parameters %s"
+    UnknownParameterSuggestion       = "%s (did you mean %s?)"
+    InvalidStrictParameters          = "strictParameters must be a bool. Value received '%s'"
+    InvalidStrictObjectives          = "strictObjectives must be a bool. Value received '%s'"
+    InvalidTopologySegment           = "Topology segment %s must be true or false. Value received '%s'"
+    InvalidSecrets                   = "CSI secrets must set both %s and %s to use other Hammerspace credentials"
+    InvalidSecretTLSVerify           = "tlsVerify secret must be a bool. Value received '%s'"
+    InvalidMaxEntries                = "max_entries must not be negative. Value received %d"
+    InvalidStartingToken             = "starting_token %s was not returned by ListVolumes"
+    ListBackingShareFailed           = "Could not list the volumes in backing share %s, %v"
+    InvalidSize                      = "%s is not a size, such as 1073741824, 1Gi or 500M"
+    UnknownControllerCapability      = "%s is not a capability of the controller, which are %s"
+    ControllerCapabilityDisabled     = "The %s controller capability is disabled"
+    InvalidExportPathPrefix          = "exportPathPrefix must be an absolute path without . or .. elements. Value received '%s'"
 
     VolumeExistsSizeMismatch = "Requested volume exists, but has a different size. Existing: %d, Requested: %d"
+    ShareNotOwned            = "Share %s exists but was not created by this plugin, refusing to use it for volume %s"
+    ShareNameConflict        = "Share %s exists but belongs to volume %s, not to volume %s"
+    ShareDeleteNotOwned      = "Share %s was not created by this plugin, refusing to delete it. Set its %s extended info to true to allow deleting it"
+    VolumeSizeBelowMinimum   = "Requested volume size limit %d is below the minimum volume size %d"
+    VolumeSizeAboveMaximum   = "Requested volume size %d is above the maximum volume size %d"
 
     VolumeDeleteHasSnapshots = "Volumes with snapshots cannot be deleted, delete snapshots first"
     VolumeBeingDeleted       = "The specified volume is currently being deleted"
+    VolumeNotDeleted         = "Volume %s has not been deleted, there is nothing to restore"
+    DeletedVolumeNotFound    = "No deleted volume %s can be restored, it was never deleted by this plugin or has been purged"
+    RestoreNotOwned          = "Share %s was not created by this plugin, refusing to restore it"
+
+    MissingBinaries    = "Required binaries not found: %s"
+    FeatureNotLicensed = "The Hammerspace cluster is not licensed for %s"
 
     // Not Found errors
     VolumeNotFound              = "Volume does not exist"
@@ -57,14 +122,49 @@ const (
     BackingShareNotFound        = "Could not find specified backing share"
     SourceSnapshotNotFound      = "Could not find source snapshots"
     SourceSnapshotShareNotFound = "Could not find the share for the source snapshot"
+    SourceVolumeNotFound        = "Could not find the source volume"
+    VolumeNotPublishedAt        = "Volume %s is not published at %s"
 
     // Internal errors
     UnexpectedHSStatusCode    = "Unexpected HTTP response from Hammerspace API: recieved status code %d, expected %d"
     OutOfCapacity             = "Requested capacity %d exceeds available %d"
+    SnapshotsNotDeleted       = "%d of %d snapshots could not be deleted, %s"
     LoopDeviceAttachFailed    = "Failed setting up loop device: device=%s, filePath=%s"
+    ObjectivesNotApplied      = "Could not apply the objectives of volume %s, %v"
+    FilesystemCheckFailed     = "Filesystem check of volume %s found errors which were not repaired, %v"
+    FileMetadataNotSet        = "Could not set %s on %s"
+    InjectedMountFailure      = "Injected failure of the mount of %s"
+    InjectedAPIError          = "injected fault"
+    UnsafeFileDelete          = "Refusing to delete the file of volume %s, %v"
+    LoopDeviceBudgetExhausted = "Node has attached %d loop devices, its budget is %d"
     TargetPathUnknownFiletype = "Target path exists but is not a block device nor directory"
+    UnmountFailed             = "Could not unmount %s, %v"
+    UnmountBusy               = "Could not unmount %s, it is busy, %s"
+    UnresponsiveMountInUse    = "Backing share %s is not responding but loop device %s is attached to a file on it, %v"
+    FreezeTimedOut            = "Timed out waiting for the filesystem of volume %s to be frozen on nodes: %s"
+    SnapshotHookFailed        = "Snapshot was not taken, %v"
+    CloneInProgress           = "Clone of %s to %s is in progress"
+    CloneFailed               = "Clone of %s failed, %v"
+    MoveFailed                = "Move of %s failed, %v"
+    MoveDestinationExists     = "Cannot move the volume, %s already exists"
+    VolumeMovePublished       = "Volume %s is published on nodes %v, it can only be moved once it is unpublished"
+    VolumeTaskInProgress      = "Volume %s is being restored or cloned, retry once the task completes"
+    OperationQueueTimeout     = "Gave up waiting to %s after %v, too many operations in progress: %v"
     UnknownError              = "Unknown internal error"
+    LoginFailed               = "Could not login to the Hammerspace API, %v"
+    TenantLoginFailed         = "Could not login to the Hammerspace API at %s as %s with the credentials in the CSI secrets, %v"
+    EndpointMismatch          = "StorageClass endpoint %s differs from the endpoint %s in the CSI secrets"
+    EndpointWithoutSecrets    = "Volumes on the Anvil at %s need Hammerspace credentials in the CSI secrets"
+    EndpointNotInSecrets      = "The provisioner secrets must also set endpoint to %s, DeleteVolume and snapshot requests only receive the secrets"
+    LoginCoolDown             = "Not retrying login to the Hammerspace API for %v, the last attempt failed: %v"
+    APIRequestTimeout         = "Hammerspace API request %s %s did not complete within %v"
+    ClusterCapacityUnavailable = "The free capacity of the cluster could not be read"
+    NoVolumeMountInfo          = "The volume context of %s lists no data-portals, which nodes without Hammerspace credentials need to mount it"
+    TopologyNotServed          = "None of the requested topology segments is served, volumes are only accessible by the %s segment"
+    KubeletRootDirMissing      = "Kubelet root dir %s is not in the plugin container%s. Set HS_KUBELET_ROOT_DIR to the --root-dir of the kubelet and mount it in the container at the same path, or set it empty to disable the mount checks"
+    MountNotShared             = "Mounts below %s would not propagate to the host, it is on %s, mounted with %s propagation. Mount it from the host with mountPropagation: Bidirectional, and make the host mount rshared"
+    TargetOutsideKubeletRootDir = "Target path %s is not below the kubelet root dir %s, set HS_KUBELET_ROOT_DIR to the --root-dir of the kubelet"
 
     // CSI v0
-    BlockVolumesUnsupported = "Block volumes are unsupported in CSI v0.3"
+    BlockVolumesUnsupported = "Block volumes are unsupported in CSI v0.3 unless HS_CSI_V0_BLOCK_VOLUMES is set"
 )
@@ -0,0 +1,113 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+    "fmt"
+    "math/rand"
+    "strconv"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+)
+
+// Faults injected for chaos testing when HS_FAULT_INJECTION is set, so that the resilience of
+// applications and the retries of the plugin can be exercised in staging. Each fault is given as
+// <fault>:<rate>[:<value>], the rate being the fraction of operations it is injected into.
+const (
+    FaultAPIDelay     = "api-delay"     // Delay requests to the Hammerspace API by value, a duration
+    FaultAPIError     = "api-error"     // Answer requests to the Hammerspace API with value, a 5xx status code
+    FaultMountFailure = "mount-failure" // Fail NFS and filesystem mounts
+
+    MetricInjectedFaults = "hs_csi_injected_faults_total"
+
+    defaultInjectedAPIError = 503
+)
+
+func init() {
+    RegisterMetric(MetricInjectedFaults, MetricTypeCounter, "Faults injected for chaos testing")
+}
+
+// FaultRule is a fault to inject and the fraction of operations to inject it into
+type FaultRule struct {
+    Rate       float64
+    Delay      time.Duration // for FaultAPIDelay
+    StatusCode int           // for FaultAPIError
+}
+
+var faultRand = rand.Float64
+
+// ParseFaultInjection parses a comma separated list of <fault>:<rate>[:<value>],
+// Ex: api-delay:0.1:2s,api-error:0.05:500,mount-failure:0.2
+func ParseFaultInjection(spec string) (map[string]FaultRule, error) {
+    rules := map[string]FaultRule{}
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        fields := strings.Split(entry, ":")
+        if len(fields) < 2 || len(fields) > 3 {
+            return nil, fmt.Errorf("'%s' is not of the form <fault>:<rate>[:<value>]", entry)
+        }
+        rate, err := strconv.ParseFloat(fields[1], 64)
+        if err != nil || rate < 0 || rate > 1 {
+            return nil, fmt.Errorf("rate of %s must be between 0 and 1, received '%s'", fields[0], fields[1])
+        }
+        rule := FaultRule{Rate: rate}
+        value := ""
+        if len(fields) == 3 {
+            value = fields[2]
+        }
+        switch fields[0] {
+        case FaultAPIDelay:
+            rule.Delay, err = time.ParseDuration(value)
+            if err != nil || rule.Delay <= 0 {
+                return nil, fmt.Errorf("%s requires a positive duration, received '%s'", FaultAPIDelay, value)
+            }
+        case FaultAPIError:
+            rule.StatusCode = defaultInjectedAPIError
+            if value != "" {
+                rule.StatusCode, err = strconv.Atoi(value)
+                if err != nil || rule.StatusCode < 500 || rule.StatusCode > 599 {
+                    return nil, fmt.Errorf("%s requires a 5xx status code, received '%s'", FaultAPIError, value)
+                }
+            }
+        case FaultMountFailure:
+            if value != "" {
+                return nil, fmt.Errorf("%s takes no value, received '%s'", FaultMountFailure, value)
+            }
+        default:
+            return nil, fmt.Errorf("unknown fault '%s', expected %s, %s or %s",
+                fields[0], FaultAPIDelay, FaultAPIError, FaultMountFailure)
+        }
+        rules[fields[0]] = rule
+    }
+    return rules, nil
+}
+
+// InjectFault returns the rule of a fault if it should be injected into the current operation
+func InjectFault(fault string) (FaultRule, bool) {
+    rule, exists := FaultInjection[fault]
+    if !exists || rule.Rate <= 0 || faultRand() >= rule.Rate {
+        return FaultRule{}, false
+    }
+    log.WithField("fault", fault).Warn("injecting fault")
+    IncMetric(MetricInjectedFaults, map[string]string{"fault": fault})
+    return rule, true
+}
@@ -19,6 +19,7 @@ package common
 import (
     "bytes"
     "fmt"
+    "io/ioutil"
     "os"
     "os/exec"
     "path/filepath"
@@ -35,8 +36,12 @@ import (
 )
 
 func execCommandHelper(command string, args ...string) ([]byte, error) {
+    return execCommandWithTimeout(CommandExecTimeout, command, args...)
+}
+
+func execCommandWithTimeout(timeout time.Duration, command string, args ...string) ([]byte, error) {
     cmd := exec.Command(command, args...)
-    log.Debugf("Executing command: %v", cmd)
+    SampledDebugf("Executing command: %v", cmd)
     var b bytes.Buffer
     cmd.Stdout = &b
     cmd.Stderr = &b
@@ -50,39 +55,95 @@ func execCommandHelper(command string, args ...string) ([]byte, error) {
         done <- cmd.Wait()
     }()
     select {
-    case <-time.After(CommandExecTimeout):
-        log.Warnf("Command '%s' with args '%v' did not completed after %d seconds",
-            command, args, CommandExecTimeout)
+    case <-time.After(timeout):
+        log.Warnf("Command '%s' with args '%v' did not completed after %v",
+            command, args, timeout)
         if err := cmd.Process.Kill(); err != nil {
             log.Error("failed to kill process: ", err)
         }
-        return nil, fmt.Errorf("process killed as timeout reached")
+        return nil, ErrCommandTimeout
     case err := <-done:
         if err != nil {
             log.Errorf("process finished with error = %v", err)
-            return nil, err
+            return b.Bytes(), err
         }
     }
     return b.Bytes(), nil
 }
 
+var LookPath = exec.LookPath
+
 var ExecCommand = execCommandHelper
+var ExecCommandWithTimeout = execCommandWithTimeout
+
+// ErrCommandTimeout is returned by ExecCommandWithTimeout for commands killed after the timeout
+var ErrCommandTimeout = fmt.Errorf("process killed as timeout reached")
+
+// Loop device ioctl of /dev/loop-control
+const loopCtlGetFree = 0x4C82
+
+// LoadLoopModule loads the loop module if the host has no loop control device, creating
+// LoopMaxDevices loop devices if set
+func LoadLoopModule() error {
+    if _, err := os.Stat(LoopControlDevice); err == nil {
+        return nil
+    }
+    args := []string{"loop"}
+    if LoopMaxDevices > 0 {
+        args = append(args, fmt.Sprintf("max_loop=%d", LoopMaxDevices))
+    }
+    output, err := ExecCommand("modprobe", args...)
+    if err != nil {
+        return fmt.Errorf("could not load the loop module, %s, %v", output, err)
+    }
+    log.Infof("loaded the loop module, %s", strings.Join(args, " "))
+    return nil
+}
 
-// EnsureFreeLoopbackDeviceFile finds the next available loop device under /dev/loop*
-// If no free loop devices exist, a new one is created
+// getLoopDeviceNumber returns the device number of loop device n, as allocated by the kernel
+func getLoopDeviceNumber(n uint64) (uint64, error) {
+    devFile := filepath.Join(SysBlockDir, fmt.Sprintf("loop%d", n), "dev")
+    contents, err := ioutil.ReadFile(devFile)
+    if err != nil {
+        return 0, err
+    }
+    var major, minor uint32
+    if _, err := fmt.Sscanf(strings.TrimSpace(string(contents)), "%d:%d", &major, &minor); err != nil {
+        return 0, fmt.Errorf("could not parse %s, %v", devFile, err)
+    }
+    return unix.Mkdev(major, minor), nil
+}
+
+// EnsureFreeLoopbackDeviceFile asks the kernel for a free loop device through the loop control
+// device, which allocates a new one if none is free. Hosts whose /dev is not updated by the kernel,
+// such as containers with a static /dev, may not have a node for the new device, so it is created
+// with the device number the kernel assigned.
 func EnsureFreeLoopbackDeviceFile() (uint64, error) {
-    LOOP_CTL_GET_FREE := uintptr(0x4C82)
-    LoopControlPath := "/dev/loop-control"
-    ctrl, err := os.OpenFile(LoopControlPath, os.O_RDWR, 0660)
+    ctrl, err := os.OpenFile(LoopControlDevice, os.O_RDWR, 0660)
     if err != nil {
-        return 0, fmt.Errorf("could not open %s: %v", LoopControlPath, err)
+        return 0, fmt.Errorf("could not open %s: %v", LoopControlDevice, err)
     }
     defer ctrl.Close()
-    dev, _, errno := unix.Syscall(unix.SYS_IOCTL, ctrl.Fd(), LOOP_CTL_GET_FREE, 0)
-    if dev < 0 {
-        return 0, fmt.Errorf("could not get free device: %v", errno)
+    dev, _, errno := unix.Syscall(unix.SYS_IOCTL, ctrl.Fd(), loopCtlGetFree, 0)
+    if errno != 0 {
+        return 0, fmt.Errorf("could not get free loop device: %v", errno)
+    }
+    n := uint64(dev)
+
+    devicePath := fmt.Sprintf("/dev/loop%d", n)
+    if _, err := os.Stat(devicePath); err == nil || !os.IsNotExist(err) {
+        return n, nil
+    }
+    rdev, err := getLoopDeviceNumber(n)
+    if err != nil {
+        return 0, fmt.Errorf("could not find device number of loop device %d: %v", n, err)
+    }
+    err = unix.Mknod(devicePath, unix.S_IFBLK|0660, int(rdev))
+    if err != nil && !os.IsExist(err) {
+        return 0, fmt.Errorf("could not create %s: %v", devicePath, err)
     }
-    return uint64(dev), nil
+    log.Infof("created device node %s", devicePath)
+    return n, nil
 }
 
 func MountFilesystem(sourcefile, destfile, fsType string, mountFlags []string) error {
@@ -98,6 +159,9 @@ func MountFilesystem(sourcefile, destfile, fsType string, mountFlags []string) e
         }
     }
 
+    if _, inject := InjectFault(FaultMountFailure); inject {
+        return status.Errorf(codes.Internal, InjectedMountFailure, sourcefile)
+    }
     err := mounter.Mount(sourcefile, destfile, fsType, mountFlags)
     if err != nil {
         if os.IsPermission(err) {
@@ -128,6 +192,32 @@ func ExpandFilesystem(device, fsType string) error {
     return nil
 }
 
+// GrowMountedFilesystem grows the filesystem mounted at mountPath to fill its device. The tools
+// leave filesystems which already fill their device unchanged.
+func GrowMountedFilesystem(mountPath, fsType string) error {
+    device, mounted, err := GetMountSource(mountPath)
+    if err != nil {
+        return err
+    }
+    if !mounted {
+        return fmt.Errorf("%s is not mounted", mountPath)
+    }
+    log.Infof("Growing '%s' filesystem on %s mounted at %s", fsType, device, mountPath)
+
+    var output []byte
+    if fsType == "xfs" {
+        // xfs can only be grown through its mount point
+        output, err = ExecCommand("xfs_growfs", mountPath)
+    } else {
+        output, err = ExecCommand("resize2fs", device)
+    }
+    if err != nil {
+        log.Errorf("Could not grow filesystem at %s: %s: %s", mountPath, err.Error(), output)
+        return err
+    }
+    return nil
+}
+
 func BindMountDevice(sourcefile, destfile string) error {
     mounter := mount.New("")
     if exists, _ := mounter.ExistsPath(destfile); !exists {
@@ -154,6 +244,59 @@ func BindMountDevice(sourcefile, destfile string) error {
     return nil
 }
 
+// MakeBlockDeviceNode creates a block device node at destfile for the device at sourcefile. Unlike a
+// bind mount, tools inspecting the target path see a device node rather than a mounted regular file.
+func MakeBlockDeviceNode(sourcefile, destfile string) error {
+    s := unix.Stat_t{}
+    if err := unix.Stat(sourcefile, &s); err != nil {
+        log.Errorf("could not stat device %s, %v", sourcefile, err)
+        return status.Error(codes.Internal, err.Error())
+    }
+    if s.Mode&unix.S_IFMT != unix.S_IFBLK {
+        return status.Errorf(codes.Internal, "%s is not a block device", sourcefile)
+    }
+
+    if err := os.MkdirAll(filepath.Dir(destfile), os.FileMode(0750)); err != nil {
+        log.Errorf("could not make destination path for device node, %v", err)
+        return status.Error(codes.Internal, err.Error())
+    }
+    // Replace the empty file created for the target path
+    if fi, err := os.Lstat(destfile); err == nil {
+        if !fi.Mode().IsRegular() || fi.Size() != 0 {
+            return status.Errorf(codes.Internal, "target path %s already exists", destfile)
+        }
+        if err := os.Remove(destfile); err != nil {
+            return status.Error(codes.Internal, err.Error())
+        }
+    }
+
+    err := unix.Mknod(destfile, unix.S_IFBLK|0660, int(s.Rdev))
+    if err != nil {
+        if os.IsPermission(err) {
+            return status.Error(codes.PermissionDenied, err.Error())
+        }
+        return status.Error(codes.Internal, err.Error())
+    }
+    return nil
+}
+
+// FreezeFilesystem suspends writes to the filesystem mounted at mountPath until ThawFilesystem is called
+func FreezeFilesystem(mountPath string) error {
+    output, err := ExecCommandWithTimeout(FreezeTimeout, "fsfreeze", "-f", mountPath)
+    if err != nil {
+        return fmt.Errorf("%s, %v", output, err)
+    }
+    return nil
+}
+
+func ThawFilesystem(mountPath string) error {
+    output, err := ExecCommandWithTimeout(FreezeTimeout, "fsfreeze", "-u", mountPath)
+    if err != nil {
+        return fmt.Errorf("%s, %v", output, err)
+    }
+    return nil
+}
+
 func GetDeviceMinorNumber(device string) (uint32, error) {
     s := unix.Stat_t{}
     if err := unix.Stat(device, &s); err != nil {
@@ -174,12 +317,58 @@ func MakeEmptyRawFile(pathname string, size int64) error {
     return nil
 }
 
+// FindMissingBinaries returns the binaries which cannot be found on the PATH
+func FindMissingBinaries(binaries []string) []string {
+    missing := []string{}
+    for _, binary := range binaries {
+        if _, err := LookPath(binary); err != nil {
+            missing = append(missing, binary)
+        }
+    }
+    return missing
+}
+
+// TrimFilesystem discards the unused blocks of the filesystem mounted at mountPath
+func TrimFilesystem(mountPath string) (string, error) {
+    output, err := ExecCommand("fstrim", "-v", mountPath)
+    if err != nil {
+        return "", fmt.Errorf("could not trim filesystem at %s, %s, %v", mountPath, output, err)
+    }
+    return strings.TrimSpace(string(output)), nil
+}
+
+// DigHoles deallocates the zero-filled ranges of a file which is not in use
+func DigHoles(pathname string) error {
+    output, err := ExecCommand("fallocate", "--dig-holes", pathname)
+    if err != nil {
+        return fmt.Errorf("could not deallocate unused space of %s, %s, %v", pathname, output, err)
+    }
+    return nil
+}
+
+// GrowRawFile grows a raw file which is not attached to a loop device
+func GrowRawFile(pathname string, size int64) error {
+    log.Infof("growing file '%s' to %d bytes", pathname, size)
+    output, err := ExecCommand("qemu-img", "resize", "-fraw", pathname, strconv.FormatInt(size, 10))
+    if err != nil {
+        log.Errorf("%s, %v", output, err.Error())
+        return err
+    }
+    return nil
+}
+
+// ExpandDeviceFileSize grows a file attached to a loop device, then refreshes the size of
+// the loop device so the new space is visible through it
 func ExpandDeviceFileSize(pathname string, size int64) error {
     log.Infof("resizing device file '%s'", pathname)
     sizeStr := strconv.FormatInt(size, 10)
     loopdev, err := determineLoopDeviceFromBackingFile(pathname)
     if err != nil {
-        //        log.Errorf("DFERR: loopdev: '%s', error: '%v'", loopdev, err.Error())
+        return err
+    }
+    output, err := ExecCommand("qemu-img", "resize", "-fraw", pathname, sizeStr)
+    if err != nil {
+        log.Errorf("%s, %v", output, err.Error())
         return err
     }
     // Refresh the loop device size with losetup -c
@@ -189,14 +378,32 @@ func ExpandDeviceFileSize(pathname string, size int64) error {
         log.Errorf("Resizing loop device '%s' failed with output '%s': '%v'", loopdev, loresize, err.Error())
         return err
     }
-    output, err := ExecCommand("qemu-img", "resize", "-fraw", pathname, sizeStr)
-    if err != nil {
-        log.Errorf("%s, %v", output, err.Error())
-        return err
-    }
     return nil
 }
 
+// CheckFilesystem checks the unmounted filesystem in the file at filePath, repairing the errors
+// which are safe to repair automatically when repair is set. It returns an error if errors remain.
+func CheckFilesystem(filePath, fsType string, repair bool) error {
+    command, args := "e2fsck", []string{"-n", filePath}
+    if repair {
+        args = []string{"-p", filePath}
+    }
+    if fsType == "xfs" {
+        command, args = "xfs_repair", []string{"-n", "-f", filePath}
+        if repair {
+            args = []string{"-f", filePath}
+        }
+    }
+    log.Infof("checking '%s' filesystem in file '%s', repair %v", fsType, filePath, repair)
+    _, err := ExecCommand(command, args...)
+    if exitErr, ok := err.(*exec.ExitError); ok && command == "e2fsck" && exitErr.ExitCode() == 1 {
+        // e2fsck exits with 1 when it corrected errors
+        log.Warnf("repaired errors in the filesystem in file '%s'", filePath)
+        return nil
+    }
+    return err
+}
+
 func FormatDevice(device, fsType string) error {
     log.Infof("formatting file '%s' with '%s' filesystem", device, fsType)
     args := []string{device}
@@ -216,6 +423,35 @@ func FormatDevice(device, fsType string) error {
     return nil
 }
 
+// ResolveFileUnder returns the canonical path of the file at relPath under root, checking that it
+// does not leave root through ".." components or symbolic links and that it is a regular file
+func ResolveFileUnder(root, relPath string) (string, error) {
+    realRoot, err := filepath.EvalSymlinks(root)
+    if err != nil {
+        return "", err
+    }
+    joined := filepath.Join(realRoot, relPath)
+    if !strings.HasPrefix(joined, realRoot+string(filepath.Separator)) {
+        return "", fmt.Errorf("%s is not under %s", relPath, root)
+    }
+    realDir, err := filepath.EvalSymlinks(filepath.Dir(joined))
+    if err != nil {
+        return "", err
+    }
+    if realDir != realRoot && !strings.HasPrefix(realDir, realRoot+string(filepath.Separator)) {
+        return "", fmt.Errorf("%s resolves to %s, which is not under %s", relPath, realDir, root)
+    }
+    resolved := filepath.Join(realDir, filepath.Base(joined))
+    fi, err := os.Lstat(resolved)
+    if err != nil {
+        return "", err
+    }
+    if !fi.Mode().IsRegular() {
+        return "", fmt.Errorf("%s is not a regular file", resolved)
+    }
+    return resolved, nil
+}
+
 func DeleteFile(pathname string) error {
     log.Infof("deleting file '%s'", pathname)
     err := os.Remove(pathname)
@@ -246,6 +482,9 @@ func MountShare(sourcePath, targetPath string, mountFlags []string) error {
 
     mo := mountFlags
 
+    if _, inject := InjectFault(FaultMountFailure); inject {
+        return status.Errorf(codes.Internal, InjectedMountFailure, sourcePath)
+    }
     mounter := mount.New("")
     err = mounter.Mount(sourcePath, targetPath, "nfs", mo)
     if err != nil {
@@ -261,42 +500,114 @@ func MountShare(sourcePath, targetPath string, mountFlags []string) error {
     return nil
 }
 
+// StagingPath returns the path on this host of a path in the shares mounted under ShareStagingDir
+func StagingPath(elem ...string) string {
+    return filepath.Join(append([]string{ShareStagingDir}, elem...)...)
+}
+
 func determineBackingFileFromLoopDevice(lodevice string) (string, error) {
-    output, err := ExecCommand("losetup", "-a")
+    backingFiles, err := GetLoopBackingFiles()
     if err != nil {
         return "", status.Errorf(codes.Internal,
             "could not determine backing file for loop device, %v", err)
     }
-    devices := strings.Split(string(output), "\n")
-    for _, d := range devices {
-        if d != "" {
-            device := strings.Split(d, " ")
-            if lodevice == strings.Trim(device[0], ":()") {
-                return strings.Trim(device[len(device)-1], ":()"), nil
-            }
-        }
+    if backingFile, exists := backingFiles[lodevice]; exists {
+        return backingFile, nil
     }
     return "", status.Errorf(codes.Internal,
         "could not determine backing file for loop device")
 }
 
-// Note that this function does not work in Alpine image due to
-// losetup cutting the output off at 79 characters
+// DetermineLoopDeviceFromBackingFile returns the loop device a file is attached to
+func DetermineLoopDeviceFromBackingFile(backingfile string) (string, error) {
+    return determineLoopDeviceFromBackingFile(backingfile)
+}
+
+// BlockDeviceStats are the cumulative I/O statistics of a block device, see the kernel's
+// Documentation/block/stat.rst
+type BlockDeviceStats struct {
+    SizeBytes    int64
+    ReadIOs      int64
+    ReadBytes    int64
+    WriteIOs     int64
+    WriteBytes   int64
+    InFlight     int64
+    IOTimeMillis int64
+}
+
+// ReadBlockDeviceStats reads the size and I/O statistics of a block device such as /dev/loop0 from sysfs
+func ReadBlockDeviceStats(device string) (*BlockDeviceStats, error) {
+    deviceDir := filepath.Join(SysBlockDir, filepath.Base(device))
+    data, err := ioutil.ReadFile(filepath.Join(deviceDir, "stat"))
+    if err != nil {
+        return nil, err
+    }
+    fields := strings.Fields(string(data))
+    if len(fields) < 11 {
+        return nil, fmt.Errorf("unexpected format of %s statistics, %s", device, data)
+    }
+    values := make([]int64, 11)
+    for i := range values {
+        values[i], err = strconv.ParseInt(fields[i], 10, 64)
+        if err != nil {
+            return nil, fmt.Errorf("unexpected format of %s statistics, %s", device, data)
+        }
+    }
+    data, err = ioutil.ReadFile(filepath.Join(deviceDir, "size"))
+    if err != nil {
+        return nil, err
+    }
+    sectors, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+    if err != nil {
+        return nil, fmt.Errorf("unexpected size of %s, %s", device, data)
+    }
+
+    // Sizes and sector counts in sysfs are always in 512 byte units
+    return &BlockDeviceStats{
+        SizeBytes:    sectors * 512,
+        ReadIOs:      values[0],
+        ReadBytes:    values[2] * 512,
+        WriteIOs:     values[4],
+        WriteBytes:   values[6] * 512,
+        InFlight:     values[8],
+        IOTimeMillis: values[9],
+    }, nil
+}
+
+// GetLoopBackingFiles returns the backing file of each attached loop device, keyed by device,
+// read from sysfs rather than parsed from losetup's output
+func GetLoopBackingFiles() (map[string]string, error) {
+    deviceDirs, err := filepath.Glob(filepath.Join(SysBlockDir, "loop*"))
+    if err != nil {
+        return nil, err
+    }
+    backingFiles := map[string]string{}
+    for _, deviceDir := range deviceDirs {
+        // Only attached loop devices have a backing file
+        data, err := ioutil.ReadFile(filepath.Join(deviceDir, "loop", "backing_file"))
+        if os.IsNotExist(err) {
+            continue
+        } else if err != nil {
+            return nil, err
+        }
+        backingFile := strings.TrimSuffix(strings.TrimSpace(string(data)), " (deleted)")
+        backingFiles["/dev/"+filepath.Base(deviceDir)] = backingFile
+    }
+    return backingFiles, nil
+}
+
 func determineLoopDeviceFromBackingFile(backingfile string) (string, error) {
-    log.Infof("determine loop device from backing file: '%s'", backingfile)
-    output, err := ExecCommand("losetup", "-a")
+    SampledInfof("determine loop device from backing file: '%s'", backingfile)
+    backingFiles, err := GetLoopBackingFiles()
     if err != nil {
         return "", status.Errorf(codes.Internal,
             "could not determine loop device for backing file, %v", err)
     }
-    devices := strings.Split(string(output), "\n")
-    for _, d := range devices {
-        if d != "" {
-            device := strings.Split(d, " ")
-            if backingfile == strings.Trim(device[2], ":()") {
-                log.Infof("matched loop dev: '%s'", strings.Trim(device[0], ":()"))
-                return strings.Trim(device[0], ":()"), nil
-            }
+    backingfile = filepath.Clean(backingfile)
+    for device, f := range backingFiles {
+        if f == backingfile {
+            SampledInfof("matched loop dev: '%s'", device)
+            return device, nil
         }
     }
     return "", status.Errorf(codes.Internal,
@@ -340,11 +651,158 @@ func IsShareMounted(targetPath string) (bool, error) {
     return true, nil
 }
 
-func UnmountFilesystem(targetPath string) error {
-    mounter := mount.New("")
+// getMountSource looks up targetPath in the mount table. Unlike IsShareMounted it does not stat
+// the target, which blocks indefinitely on hard mounts whose server is unreachable.
+// UnescapeMountPath decodes the octal escapes, such as \040 for a space, with which the kernel
+// writes whitespace and backslashes in paths in /proc/mounts
+func UnescapeMountPath(p string) string {
+    if !strings.Contains(p, "\\") {
+        return p
+    }
+    var b strings.Builder
+    for i := 0; i < len(p); i++ {
+        if p[i] == '\\' && i+4 <= len(p) {
+            if c, err := strconv.ParseUint(p[i+1:i+4], 8, 8); err == nil {
+                b.WriteByte(byte(c))
+                i += 3
+                continue
+            }
+        }
+        b.WriteByte(p[i])
+    }
+    return b.String()
+}
+
+func getMountSource(targetPath string) (string, bool, error) {
+    mounts, err := mount.New("").List()
+    if err != nil {
+        return "", false, err
+    }
+    source, mounted := "", false
+    // Later entries are mounted on top of earlier ones
+    for _, m := range mounts {
+        if UnescapeMountPath(m.Path) == targetPath {
+            source, mounted = UnescapeMountPath(m.Device), true
+        }
+    }
+    return source, mounted, nil
+}
+
+var GetMountSource = getMountSource
 
-    isMounted, err := IsShareMounted(targetPath)
+// StatWithTimeout stats a path, giving up after timeout. A stat of a hung NFS mount never returns,
+// in which case the goroutine is abandoned and ErrStatTimeout is returned.
+func StatWithTimeout(p string, timeout time.Duration) (os.FileInfo, error) {
+    type statResult struct {
+        fi  os.FileInfo
+        err error
+    }
+    done := make(chan statResult, 1)
+    go func() {
+        fi, err := os.Stat(p)
+        done <- statResult{fi, err}
+    }()
+    select {
+    case r := <-done:
+        return r.fi, r.err
+    case <-time.After(timeout):
+        return nil, ErrStatTimeout
+    }
+}
+
+var ErrStatTimeout = fmt.Errorf("stat did not complete in time")
+
+// StatfsWithTimeout statfs's a mounted filesystem, giving up after timeout like StatWithTimeout.
+// Unlike a stat it is always answered by the server, not from the attribute cache.
+func StatfsWithTimeout(p string, timeout time.Duration) error {
+    done := make(chan error, 1)
+    go func() {
+        var st unix.Statfs_t
+        done <- unix.Statfs(p, &st)
+    }()
+    select {
+    case err := <-done:
+        return err
+    case <-time.After(timeout):
+        return ErrStatTimeout
+    }
+}
+
+// LockFile takes a lock on the file at pathname, creating it, which is shared unless exclusive is
+// set. The lock is held until the returned file is closed. Open file description locks are used,
+// so that locks taken by one process through different calls conflict with each other, and on NFS
+// they are kept by the server rather than the cache of each client. ErrFileLocked is returned if
+// a conflicting lock is held.
+func LockFile(pathname string, exclusive bool) (*os.File, error) {
+    if err := os.MkdirAll(filepath.Dir(pathname), 0755); err != nil {
+        return nil, err
+    }
+    f, err := os.OpenFile(pathname, os.O_RDWR|os.O_CREATE, 0644)
+    if err != nil {
+        return nil, err
+    }
+    lock := unix.Flock_t{Type: unix.F_RDLCK, Whence: 0}
+    if exclusive {
+        lock.Type = unix.F_WRLCK
+    }
+    err = unix.FcntlFlock(f.Fd(), unix.F_OFD_SETLK, &lock)
+    if err == unix.EAGAIN || err == unix.EACCES {
+        f.Close()
+        return nil, ErrFileLocked
+    } else if err != nil {
+        f.Close()
+        return nil, err
+    }
+    return f, nil
+}
+
+var ErrFileLocked = fmt.Errorf("file is locked")
 
+// UnmountWithEscalation unmounts targetPath, escalating from a regular to a forced and then a lazy
+// unmount when an attempt does not finish within UnmountTimeout or the mount is stale. A mount
+// which is busy is left in place and FailedPrecondition returned, a forced or lazy unmount would
+// pull it from under the processes using it. If a lazy unmount was needed, the loop device the
+// mount was made from is detached so it does not pin a dead share.
+func UnmountWithEscalation(targetPath string) error {
+    source, mounted, err := GetMountSource(targetPath)
+    if err != nil {
+        return status.Errorf(codes.Internal, UnmountFailed, targetPath, err)
+    }
+    if !mounted {
+        return nil
+    }
+
+    attempts := [][]string{{targetPath}, {"-f", targetPath}, {"-l", targetPath}}
+    for i, args := range attempts {
+        output, err := ExecCommandWithTimeout(UnmountTimeout, "umount", args...)
+        if err != nil {
+            log.Warnf("umount %s failed, %s, %v", strings.Join(args, " "), output, err)
+            // The mount may have gone away even though umount reported an error
+            if _, stillMounted, listErr := GetMountSource(targetPath); listErr != nil || stillMounted {
+                reason := strings.ToLower(string(output))
+                if strings.Contains(reason, "busy") {
+                    return status.Errorf(codes.FailedPrecondition, UnmountBusy, targetPath, strings.TrimSpace(string(output)))
+                }
+                if err == ErrCommandTimeout || strings.Contains(reason, "stale") {
+                    continue
+                }
+                return status.Errorf(codes.Internal, UnmountFailed, targetPath, err)
+            }
+        }
+        if i == len(attempts)-1 && strings.HasPrefix(source, "/dev/loop") {
+            log.Infof("detaching loop device %s after lazy unmount of %s", source, targetPath)
+            output, err = ExecCommandWithTimeout(UnmountTimeout, "losetup", "-d", source)
+            if err != nil {
+                log.Warnf("could not detach loop device %s, %s, %v", source, output, err)
+            }
+        }
+        return nil
+    }
+    return status.Errorf(codes.Internal, UnmountFailed, targetPath, "all unmount attempts failed")
+}
+
+func UnmountFilesystem(targetPath string) error {
+    _, isMounted, err := GetMountSource(targetPath)
     if err != nil {
         log.Error(err.Error())
         return status.Error(codes.Internal, err.Error())
@@ -353,10 +811,10 @@ func UnmountFilesystem(targetPath string) error {
         return nil
     }
 
-    err = mounter.Unmount(targetPath)
+    err = UnmountWithEscalation(targetPath)
     if err != nil {
         log.Error(err.Error())
-        return status.Error(codes.Internal, err.Error())
+        return err
     }
     // delete target path
     err = os.Remove(targetPath)
@@ -366,31 +824,3 @@ func UnmountFilesystem(targetPath string) error {
     }
     return nil
 }
-
-func SetMetadataTags(localPath string, tags map[string]string) error {
-    // hs attribute set localpath -e "CSI_DETAILS_TABLE{'<version-string>','<plugin-name-string>','<plugin-version-string>','<plugin-git-hash-string>'}"
-    _, err := ExecCommand("hs",
-        "attribute",
-        "set", "CSI_DETAILS",
-        fmt.Sprintf("-e \"CSI_DETAILS_TABLE{'%s','%s','%s','%s'}\"", CsiVersion, CsiPluginName, Version, Githash),
-        localPath)
-    if err != nil {
-        log.Warn("Failed to set CSI_DETAILS metadata " + err.Error())
-    }
-
-    for tag_key, tag_value := range tags {
-        output, err := ExecCommand("hs",
-            "-v", "tag",
-            "set", "-e", fmt.Sprintf("'%s'", tag_value), tag_key, localPath,
-        )
-
-        // FIXME: The HS client returns exit code 0 even on failure, so we can't detect errors
-        if err != nil {
-            log.Error("Failed to set tag " + err.Error())
-            break
-        }
-        log.Debugf("HS command output: %s", output)
-    }
-
-    return err
-}
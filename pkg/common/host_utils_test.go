@@ -1,8 +1,21 @@
 package common
 
 import (
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "math/rand"
+    "os"
+    "os/exec"
+    "path/filepath"
     "testing"
     "reflect"
+    "strings"
+    "time"
+
+    unix "golang.org/x/sys/unix"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
 )
 
 func TestGetNFSExports(t *testing.T) {
@@ -59,14 +72,33 @@ func TestGetNFSExports(t *testing.T) {
 }
 
 
-func TestDetermineBackingFileFromLoopDevice(t *testing.T) {
-    ExecCommand = func(command string, args ...string) ([]byte, error) {
-        return []byte(`
-/dev/loop0: 0 /tmp/test
-/dev/loop1: 0 /tmp/test
-/dev/loop2: 0 /tmp//test-csi-block/sanity-node-full-E067A84C-D67CAA8E
-`), nil
+// fakeLoopDevices points SysBlockDir at a temporary directory with loop devices attached to the
+// given backing files, and returns a function restoring it
+func fakeLoopDevices(t *testing.T, backingFiles map[string]string) func() {
+    sysBlockDir, err := ioutil.TempDir("", "sys-block")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    for device, backingFile := range backingFiles {
+        loopDir := filepath.Join(sysBlockDir, filepath.Base(device), "loop")
+        os.MkdirAll(loopDir, 0755)
+        ioutil.WriteFile(filepath.Join(loopDir, "backing_file"), []byte(backingFile+"\n"), 0644)
+    }
+    SysBlockDir = sysBlockDir
+    return func() {
+        SysBlockDir = "/sys/block"
+        os.RemoveAll(sysBlockDir)
     }
+}
+
+func TestDetermineBackingFileFromLoopDevice(t *testing.T) {
+    defer fakeLoopDevices(t, map[string]string{
+        "/dev/loop0": "/tmp/test",
+        "/dev/loop1": "/tmp/test",
+        "/dev/loop2": "/tmp/test-csi-block/sanity-node-full-E067A84C-D67CAA8E",
+        "/dev/loop3": "/tmp/backing share (1)/volume with spaces",
+    })()
     expected := "/tmp/test"
     actual, err := determineBackingFileFromLoopDevice("/dev/loop0")
     if err != nil {
@@ -78,6 +110,178 @@ func TestDetermineBackingFileFromLoopDevice(t *testing.T) {
         t.Logf("Actual: %v", actual)
         t.FailNow()
     }
+
+    expected = "/tmp/backing share (1)/volume with spaces"
+    actual, err = determineBackingFileFromLoopDevice("/dev/loop3")
+    if err != nil || actual != expected {
+        t.Logf("Expected %s, received %s, %v", expected, actual, err)
+        t.FailNow()
+    }
+    device, err := determineLoopDeviceFromBackingFile(expected)
+    if err != nil || device != "/dev/loop3" {
+        t.Logf("Expected /dev/loop3, received %s, %v", device, err)
+        t.FailNow()
+    }
+}
+
+func TestGetLoopDeviceNumber(t *testing.T) {
+    defer fakeLoopDevices(t, map[string]string{})()
+    // With max_part set the kernel spaces the minors of loop devices
+    os.MkdirAll(filepath.Join(SysBlockDir, "loop3"), 0755)
+    ioutil.WriteFile(filepath.Join(SysBlockDir, "loop3", "dev"), []byte("7:48\n"), 0644)
+
+    rdev, err := getLoopDeviceNumber(3)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if unix.Major(rdev) != 7 || unix.Minor(rdev) != 48 {
+        t.Logf("Expected 7:48, received %d:%d", unix.Major(rdev), unix.Minor(rdev))
+        t.FailNow()
+    }
+    if _, err := getLoopDeviceNumber(4); err == nil {
+        t.Logf("Expected error for missing loop device")
+        t.FailNow()
+    }
+}
+
+func TestLoadLoopModule(t *testing.T) {
+    commands := [][]string{}
+    ExecCommand = func(command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        return []byte(""), nil
+    }
+    defer func() {
+        LoopControlDevice = "/dev/loop-control"
+        LoopMaxDevices = 0
+    }()
+
+    LoopControlDevice = "/nonexistent/loop-control"
+    LoopMaxDevices = 64
+    if err := LoadLoopModule(); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    LoopMaxDevices = 0
+    LoadLoopModule()
+    // Nothing to load when the control device exists
+    LoopControlDevice = os.DevNull
+    LoadLoopModule()
+
+    expected := [][]string{{"modprobe", "loop", "max_loop=64"}, {"modprobe", "loop"}}
+    if !reflect.DeepEqual(commands, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", commands)
+        t.FailNow()
+    }
+}
+
+func TestUnescapeMountPath(t *testing.T) {
+    tests := map[string]string{
+        "/tmp/backing":                   "/tmp/backing",
+        "/tmp/backing\\040share":         "/tmp/backing share",
+        "/tmp/a\\011b\\012c\\134d":       "/tmp/a\tb\nc\\d",
+        "/tmp/(parens)\\040and\\040more": "/tmp/(parens) and more",
+        "/tmp/trailing\\04":              "/tmp/trailing\\04",
+    }
+    for escaped, expected := range tests {
+        if actual := UnescapeMountPath(escaped); actual != expected {
+            t.Logf("Expected: %q", expected)
+            t.Logf("Actual: %q", actual)
+            t.FailNow()
+        }
+    }
+}
+
+func TestStagingPath(t *testing.T) {
+    tests := map[string][]string{
+        "/tmp/backing":                  {"/backing"},
+        "/tmp/backing share/volume (1)": {"/backing share", "volume (1)"},
+        "/tmp/metadata-mounts/pvc-1":    {"metadata-mounts", "/pvc-1"},
+    }
+    for expected, elem := range tests {
+        if actual := StagingPath(elem...); actual != expected {
+            t.Logf("Expected: %s", expected)
+            t.Logf("Actual: %s", actual)
+            t.FailNow()
+        }
+    }
+}
+
+func TestResolveFileUnder(t *testing.T) {
+    root, err := ioutil.TempDir("", "staging")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(root)
+    outside, err := ioutil.TempDir("", "outside")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(outside)
+    os.MkdirAll(filepath.Join(root, "backing", "dir"), 0755)
+    ioutil.WriteFile(filepath.Join(root, "backing", "vol1"), []byte{}, 0644)
+    ioutil.WriteFile(filepath.Join(outside, "important"), []byte{}, 0644)
+    os.Symlink(filepath.Join(outside, "important"), filepath.Join(root, "backing", "link"))
+    os.Symlink(outside, filepath.Join(root, "backing", "escape"))
+
+    resolved, err := ResolveFileUnder(filepath.Join(root, "backing"), "vol1")
+    realRoot, _ := filepath.EvalSymlinks(root)
+    if err != nil || resolved != filepath.Join(realRoot, "backing", "vol1") {
+        t.Logf("Expected %s, received %s, %v", filepath.Join(realRoot, "backing", "vol1"), resolved, err)
+        t.FailNow()
+    }
+    for _, relPath := range []string{"../backing", "../../" + filepath.Base(outside) + "/important",
+        "link", "escape/important", "dir", "missing"} {
+        if resolved, err := ResolveFileUnder(filepath.Join(root, "backing"), relPath); err == nil {
+            t.Logf("Expected error for %s, received %s", relPath, resolved)
+            t.FailNow()
+        }
+    }
+}
+
+func TestParseFaultInjection(t *testing.T) {
+    rules, err := ParseFaultInjection("api-delay:0.1:2s, api-error:0.05, mount-failure:1")
+    expected := map[string]FaultRule{
+        FaultAPIDelay:     {Rate: 0.1, Delay: 2 * time.Second},
+        FaultAPIError:     {Rate: 0.05, StatusCode: 503},
+        FaultMountFailure: {Rate: 1},
+    }
+    if err != nil || !reflect.DeepEqual(rules, expected) {
+        t.Logf("Expected %v, received %v, %v", expected, rules, err)
+        t.FailNow()
+    }
+    for _, invalid := range []string{"api-delay:0.1", "api-error:0.1:404", "mount-failure:2",
+        "mount-failure:0.1:1s", "disk-full:0.1", "api-error"} {
+        if _, err := ParseFaultInjection(invalid); err == nil {
+            t.Logf("Expected error for %s", invalid)
+            t.FailNow()
+        }
+    }
+
+    defer func() {
+        FaultInjection = map[string]FaultRule{}
+        faultRand = rand.Float64
+    }()
+    FaultInjection = rules
+    faultRand = func() float64 { return 0.07 }
+    if _, inject := InjectFault(FaultAPIDelay); !inject {
+        t.Logf("Expected %s to be injected", FaultAPIDelay)
+        t.FailNow()
+    }
+    if _, inject := InjectFault(FaultAPIError); inject {
+        t.Logf("Expected %s not to be injected", FaultAPIError)
+        t.FailNow()
+    }
+    targetPath, _ := ioutil.TempDir("", "mount")
+    defer os.RemoveAll(targetPath)
+    if err := MountShare("127.0.0.1:/share", targetPath, nil); err == nil ||
+        !strings.Contains(err.Error(), "Injected failure") {
+        t.Logf("Expected an injected mount failure, received %v", err)
+        t.FailNow()
+    }
 }
 
 func TestExecCommandHelper(t *testing.T) {
@@ -100,4 +304,293 @@ func TestExecCommandHelper(t *testing.T) {
         t.FailNow()
     }
 
-}
\ No newline at end of file
+}
+func TestUnmountWithEscalation(t *testing.T) {
+    mounted := true
+    GetMountSource = func(targetPath string) (string, bool, error) {
+        return "/dev/loop3", mounted, nil
+    }
+    commands := [][]string{}
+    ExecCommandWithTimeout = func(timeout time.Duration, command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        if command == "umount" && args[0] != "-l" {
+            return nil, ErrCommandTimeout
+        }
+        mounted = false
+        return nil, nil
+    }
+    defer func() {
+        GetMountSource = getMountSource
+        ExecCommandWithTimeout = execCommandWithTimeout
+    }()
+
+    err := UnmountWithEscalation("/target")
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    expected := [][]string{
+        {"umount", "/target"},
+        {"umount", "-f", "/target"},
+        {"umount", "-l", "/target"},
+        {"losetup", "-d", "/dev/loop3"},
+    }
+    if !reflect.DeepEqual(commands, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", commands)
+        t.FailNow()
+    }
+
+    // Nothing to do once unmounted
+    commands = [][]string{}
+    err = UnmountWithEscalation("/target")
+    if err != nil || len(commands) != 0 {
+        t.Logf("Expected no commands for an unmounted path, %v %v", commands, err)
+        t.FailNow()
+    }
+
+    // Stale mounts escalate too
+    mounted = true
+    commands = [][]string{}
+    ExecCommandWithTimeout = func(timeout time.Duration, command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        if command == "umount" && args[0] == "/target" {
+            return []byte("umount.nfs: /target: Stale file handle"), fmt.Errorf("exit status 32")
+        }
+        mounted = false
+        return nil, nil
+    }
+    err = UnmountWithEscalation("/target")
+    if err != nil || len(commands) != 2 || commands[1][1] != "-f" {
+        t.Logf("Expected a forced unmount of a stale mount, %v %v", commands, err)
+        t.FailNow()
+    }
+
+    // Busy mounts are left in place
+    mounted = true
+    commands = [][]string{}
+    ExecCommandWithTimeout = func(timeout time.Duration, command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        return []byte("umount: /target: target is busy."), fmt.Errorf("exit status 32")
+    }
+    err = UnmountWithEscalation("/target")
+    if status.Code(err) != codes.FailedPrecondition || len(commands) != 1 {
+        t.Logf("Expected FailedPrecondition without escalating, %v %v", commands, err)
+        t.FailNow()
+    }
+}
+
+func TestGrowMountedFilesystem(t *testing.T) {
+    GetMountSource = func(targetPath string) (string, bool, error) {
+        return "/dev/loop2", true, nil
+    }
+    defer func() {
+        GetMountSource = getMountSource
+    }()
+    commands := [][]string{}
+    ExecCommand = func(command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        return []byte(""), nil
+    }
+
+    for _, fsType := range []string{"ext4", "xfs"} {
+        if err := GrowMountedFilesystem("/target", fsType); err != nil {
+            t.Logf("Unexpected error, %v", err)
+            t.FailNow()
+        }
+    }
+    expected := [][]string{{"resize2fs", "/dev/loop2"}, {"xfs_growfs", "/target"}}
+    if !reflect.DeepEqual(commands, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", commands)
+        t.FailNow()
+    }
+}
+
+func TestCheckFilesystem(t *testing.T) {
+    defer func(execCommand func(string, ...string) ([]byte, error)) { ExecCommand = execCommand }(ExecCommand)
+    var exitCode int
+    commands := [][]string{}
+    ExecCommand = func(command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        if exitCode == 0 {
+            return []byte(""), nil
+        }
+        return nil, exec.Command("sh", "-c", fmt.Sprintf("exit %d", exitCode)).Run()
+    }
+
+    for _, fsType := range []string{"ext4", "xfs"} {
+        for _, repair := range []bool{false, true} {
+            if err := CheckFilesystem("/backing/pvc-1", fsType, repair); err != nil {
+                t.Logf("Unexpected error, %v", err)
+                t.FailNow()
+            }
+        }
+    }
+    expected := [][]string{
+        {"e2fsck", "-n", "/backing/pvc-1"},
+        {"e2fsck", "-p", "/backing/pvc-1"},
+        {"xfs_repair", "-n", "-f", "/backing/pvc-1"},
+        {"xfs_repair", "-f", "/backing/pvc-1"},
+    }
+    if !reflect.DeepEqual(commands, expected) {
+        t.Logf("Expected commands %v, received %v", expected, commands)
+        t.FailNow()
+    }
+
+    // e2fsck exits with 1 once it corrected errors, other tools and codes report remaining errors
+    exitCode = 1
+    if err := CheckFilesystem("/backing/pvc-1", "ext4", true); err != nil {
+        t.Logf("Expected corrected errors to succeed, %v", err)
+        t.FailNow()
+    }
+    if err := CheckFilesystem("/backing/pvc-1", "xfs", true); err == nil {
+        t.Logf("Expected errors found by xfs_repair to fail")
+        t.FailNow()
+    }
+    exitCode = 4
+    if err := CheckFilesystem("/backing/pvc-1", "ext4", false); err == nil {
+        t.Logf("Expected uncorrected errors to fail")
+        t.FailNow()
+    }
+}
+
+func TestReclaimSpaceCommands(t *testing.T) {
+    commands := [][]string{}
+    ExecCommand = func(command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        return []byte("/target: 1 GiB (1073741824 bytes) trimmed\n"), nil
+    }
+
+    output, err := TrimFilesystem("/target")
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if output != "/target: 1 GiB (1073741824 bytes) trimmed" {
+        t.Logf("Unexpected output, %s", output)
+        t.FailNow()
+    }
+    if err := DigHoles("/backing/share/volume"); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    expected := [][]string{{"fstrim", "-v", "/target"}, {"fallocate", "--dig-holes", "/backing/share/volume"}}
+    if !reflect.DeepEqual(commands, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", commands)
+        t.FailNow()
+    }
+}
+
+func TestFindMissingBinaries(t *testing.T) {
+    LookPath = func(file string) (string, error) {
+        if file == "losetup" {
+            return "", errors.New("executable file not found in $PATH")
+        }
+        return "/usr/sbin/" + file, nil
+    }
+    defer func() { LookPath = exec.LookPath }()
+
+    missing := FindMissingBinaries([]string{"mount.nfs", "losetup", "umount"})
+    expected := []string{"losetup"}
+    if !reflect.DeepEqual(missing, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", missing)
+        t.FailNow()
+    }
+}
+
+func TestExpandDeviceFileSize(t *testing.T) {
+    defer fakeLoopDevices(t, map[string]string{"/dev/loop3": "/tmp/backing/volume"})()
+    commands := [][]string{}
+    ExecCommand = func(command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        return []byte(""), nil
+    }
+
+    if err := ExpandDeviceFileSize("/tmp/backing/volume", 2147483648); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    // The file must be grown before the loop device size is refreshed
+    expected := [][]string{
+        {"qemu-img", "resize", "-fraw", "/tmp/backing/volume", "2147483648"},
+        {"losetup", "-c", "/dev/loop3"},
+    }
+    if !reflect.DeepEqual(commands, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", commands)
+        t.FailNow()
+    }
+}
+
+func TestReadBlockDeviceStats(t *testing.T) {
+    sysBlockDir, err := ioutil.TempDir("", "sys-block")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(sysBlockDir)
+    SysBlockDir = sysBlockDir
+    defer func() { SysBlockDir = "/sys/block" }()
+
+    os.MkdirAll(sysBlockDir+"/loop3", 0755)
+    ioutil.WriteFile(sysBlockDir+"/loop3/stat",
+        []byte("     120        0     2048       35      300       10     4096      410        2      520      445        0        0        0        0\n"), 0644)
+    ioutil.WriteFile(sysBlockDir+"/loop3/size", []byte("2097152\n"), 0644)
+
+    stats, err := ReadBlockDeviceStats("/dev/loop3")
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    expected := &BlockDeviceStats{
+        SizeBytes:    1073741824,
+        ReadIOs:      120,
+        ReadBytes:    1048576,
+        WriteIOs:     300,
+        WriteBytes:   2097152,
+        InFlight:     2,
+        IOTimeMillis: 520,
+    }
+    if !reflect.DeepEqual(stats, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", stats)
+        t.FailNow()
+    }
+}
+
+func TestGetLoopBackingFiles(t *testing.T) {
+    sysBlockDir, err := ioutil.TempDir("", "sys-block")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(sysBlockDir)
+    SysBlockDir = sysBlockDir
+    defer func() { SysBlockDir = "/sys/block" }()
+
+    // loop0 is attached, loop1 is attached to a deleted file and loop2 is detached
+    os.MkdirAll(sysBlockDir+"/loop0/loop", 0755)
+    ioutil.WriteFile(sysBlockDir+"/loop0/loop/backing_file", []byte("/tmp/backing/volume-1\n"), 0644)
+    os.MkdirAll(sysBlockDir+"/loop1/loop", 0755)
+    ioutil.WriteFile(sysBlockDir+"/loop1/loop/backing_file", []byte("/tmp/backing/volume-2 (deleted)\n"), 0644)
+    os.MkdirAll(sysBlockDir+"/loop2", 0755)
+    os.MkdirAll(sysBlockDir+"/sda", 0755)
+
+    backingFiles, err := GetLoopBackingFiles()
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    expected := map[string]string{
+        "/dev/loop0": "/tmp/backing/volume-1",
+        "/dev/loop1": "/tmp/backing/volume-2",
+    }
+    if !reflect.DeepEqual(backingFiles, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", backingFiles)
+        t.FailNow()
+    }
+}
@@ -16,34 +16,71 @@ limitations under the License.
 
 package common
 
+import (
+    "bytes"
+    "strconv"
+)
+
 // Structures to hold information about a plugin created volume
 type HSVolumeParameters struct {
-    DeleteDelay            int64
-    ExportOptions          []ShareExportOptions
-    Objectives             []string
-    BlockBackingShareName  string
-    MountBackingShareName  string
-    VolumeNameFormat       string
-    FSType                 string
-    Comment                string
-    AdditionalMetadataTags map[string]string
+    DeleteDelay               int64
+    DeleteMode                string
+    DeleteSnapshots           bool
+    ExportOptions             []ShareExportOptions
+    Objectives                []string
+    ObjectivesRemove          []string
+    ObjectivesReplace         bool
+    BackingShareObjectives    []string
+    BlockBackingShareName     string
+    MountBackingShareName     string
+    VolumeNameFormat          string
+    FSType                    string
+    Comment                   string
+    AdditionalMetadataTags    map[string]string
+    ClientMountOptions        []string
+    MountPolicy               []string
+    Transport                 string
+    RDMAPort                  int
+    BlockPublishMode          string
+    FsckOnStage               string
+    StrictObjectives          bool
+    MaxVolumesPerBackingShare int
+    MaxOvercommitRatio        float64
+    ExportPathPrefix          string
 }
 
 type HSVolume struct {
     DeleteDelay            int64
+    DeleteMode             string
+    DeleteSnapshots        bool // Whether deleting the volume deletes its snapshots
     ExportOptions          []ShareExportOptions
     Objectives             []string
+    ObjectivesRemove       []string
+    ObjectivesReplace      bool
+    BackingShareObjectives []string // Defaults inherited by the volumes in the backing share
     BlockBackingShareName  string
     MountBackingShareName  string
     Size                   int64
     Name                   string
+    RequestName            string // Name of the volume in the CreateVolume request
     Path                   string
     VolumeMode             string
     SourceSnapPath         string
     FSType                 string
     Comment                string
     SourceSnapShareName    string
+    SourceVolumePath       string
     AdditionalMetadataTags map[string]string
+    ClientMountOptions     []string
+    MountPolicy            []string
+    Transport              string
+    RDMAPort               int
+    BlockPublishMode       string
+    FsckOnStage            string
+    StrictObjectives       bool // Whether CreateVolume fails if the objectives cannot be applied
+    MaxOvercommitRatio     float64
+    ExportPathPrefix       string // Namespace path the shares created for the volume are exported under
+    ExportPath             string // Export path of the share of a share-backed volume
 }
 
 ///// Request and Response objects for interacting with the HS API
@@ -51,7 +88,8 @@ type HSVolume struct {
 // We must create separate req and response objects since the API does not allow
 // specifying unused fields
 type ClusterResponse struct {
-    Capacity map[string]string `json:"capacity"`
+    Capacity        map[string]APICount `json:"capacity"`
+    SoftwareVersion string              `json:"softwareVersion"`
 }
 
 type ShareRequest struct {
@@ -70,6 +108,7 @@ type ShareUpdateRequest struct {
 }
 
 type ShareResponse struct {
+    Uoid          map[string]string    `json:"uoid"`
     Name          string               `json:"name"`
     ExportPath    string               `json:"path"`
     Comment       string               `json:"comment"`
@@ -83,19 +122,45 @@ type ShareResponse struct {
 }
 
 type ShareSpaceResponse struct {
-    Used      string `json:"used"`
-    Total     string `json:"total"`
-    Available string `json:"available"`
+    Used      APICount `json:"used"`
+    Total     APICount `json:"total"`
+    Available APICount `json:"available"`
     percent   int
 }
 
 type ShareInodesResponse struct {
-    Used      string `json:"used"`
-    Total     string `json:"total"`
-    Available string `json:"available"`
+    Used      APICount `json:"used"`
+    Total     APICount `json:"total"`
+    Available APICount `json:"available"`
     percent   int
 }
 
+// APICount is a number of bytes or inodes reported by the API, which sends them as numbers or
+// strings, and as an empty string when unset
+type APICount int64
+
+func (c *APICount) UnmarshalJSON(data []byte) error {
+    data = bytes.Trim(data, `"`)
+    if len(data) == 0 || string(data) == "null" {
+        *c = 0
+        return nil
+    }
+    value, err := strconv.ParseInt(string(data), 10, 64)
+    if err != nil {
+        return err
+    }
+    *c = APICount(value)
+    return nil
+}
+
+func (s ShareSpaceResponse) UsedBytes() int64      { return int64(s.Used) }
+func (s ShareSpaceResponse) TotalBytes() int64     { return int64(s.Total) }
+func (s ShareSpaceResponse) AvailableBytes() int64 { return int64(s.Available) }
+
+func (i ShareInodesResponse) UsedCount() int64      { return int64(i.Used) }
+func (i ShareInodesResponse) TotalCount() int64     { return int64(i.Total) }
+func (i ShareInodesResponse) AvailableCount() int64 { return int64(i.Available) }
+
 type ShareExportOptions struct {
     Subnet            string `json:"subnet"`
     AccessPermissions string `json:"accessPermissions"` // Must be "RO" or "RW"
@@ -116,6 +181,7 @@ type Task struct {
     Action    string        `json:"name"`
     Status    string        `json:"status"`
     ExitValue string        `json:"exitValue"`
+    Progress  float64       `json:"progress"` // Percent complete, reported by long running tasks
     ParamsMap TaskParamsMap `json:"paramsMap"`
 }
 
@@ -138,6 +204,12 @@ type FileSnapshot struct {
     Time           string `json:"time"`
 }
 
+type LicenseResponse struct {
+    Name     string   `json:"name"`
+    Features []string `json:"features"`
+    Expired  bool     `json:"expired"`
+}
+
 type Cluster struct {
     Name              string              `json:"name"`
     PortalFloatingIps []PortalFloatingIps `json:"portalFloatingIps"`
@@ -149,10 +221,16 @@ type PortalFloatingIps struct {
     PrefixLength int    `json:"prefixLength"`
 }
 
+// Types of the data-portals which shares can be mounted through
+const (
+    DataPortalTypeNFSv3 = "NFS_V3"
+    DataPortalTypeNFSv4 = "NFS_V4"
+)
+
 type DataPortal struct {
     OperState      string            `json:"operState"`      // We want 'UP'
     AdminState     string            `json:"adminState"`     // We want 'UP'
-    DataPortalType string            `json:"dataPortalType"` // We want NFS_V3
+    DataPortalType string            `json:"dataPortalType"` // We want NFS_V3 or NFS_V4
     Exported       []string          `json:"exported"`
     Node           DataPortalNode    `json:"node"`
     Uoid           map[string]string `json:"uoid"`
@@ -0,0 +1,33 @@
+package common
+
+import (
+    "encoding/json"
+    "testing"
+)
+
+func TestAPICount(t *testing.T) {
+    var space ShareSpaceResponse
+    err := json.Unmarshal([]byte(`{"used": "1024", "total": 4096, "available": ""}`), &space)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if space.UsedBytes() != 1024 || space.TotalBytes() != 4096 || space.AvailableBytes() != 0 {
+        t.Logf("Unexpected space %+v", space)
+        t.FailNow()
+    }
+    if err := json.Unmarshal([]byte(`{"used": "many"}`), &space); err == nil {
+        t.Logf("Expected an error")
+        t.FailNow()
+    }
+
+    var cluster ClusterResponse
+    if err := json.Unmarshal([]byte(`{"capacity": {"free": "2048"}}`), &cluster); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if cluster.Capacity["free"] != 2048 {
+        t.Logf("Unexpected capacity %v", cluster.Capacity)
+        t.FailNow()
+    }
+}
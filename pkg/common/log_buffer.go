@@ -0,0 +1,81 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+    "strings"
+    "sync"
+
+    log "github.com/sirupsen/logrus"
+)
+
+// The last lines logged are kept in memory for support bundles, so they can be collected from
+// the plugin itself whatever happens to the container logs
+const RecentLogLines = 5000
+
+// LineBuffer keeps the last lines added to it
+type LineBuffer struct {
+    lock  sync.Mutex
+    lines []string
+    next  int
+    full  bool
+}
+
+func NewLineBuffer(size int) *LineBuffer {
+    return &LineBuffer{lines: make([]string, size)}
+}
+
+// Add adds a line, dropping the oldest if the buffer is full
+func (b *LineBuffer) Add(line string) {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+    if len(b.lines) == 0 {
+        return
+    }
+    b.lines[b.next] = line
+    b.next = (b.next + 1) % len(b.lines)
+    if b.next == 0 {
+        b.full = true
+    }
+}
+
+// Lines returns the lines kept, oldest first
+func (b *LineBuffer) Lines() []string {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+    if !b.full {
+        return append([]string{}, b.lines[:b.next]...)
+    }
+    return append(append([]string{}, b.lines[b.next:]...), b.lines[:b.next]...)
+}
+
+// Levels and Fire make the buffer a logrus hook keeping the formatted entries
+func (b *LineBuffer) Levels() []log.Level {
+    return log.AllLevels
+}
+
+func (b *LineBuffer) Fire(entry *log.Entry) error {
+    line, err := entry.String()
+    if err != nil {
+        return err
+    }
+    b.Add(strings.TrimRight(line, "\n"))
+    return nil
+}
+
+// RecentLogs holds the last lines logged once added as a hook to the logger
+var RecentLogs = NewLineBuffer(RecentLogLines)
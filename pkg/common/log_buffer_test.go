@@ -0,0 +1,43 @@
+package common
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+
+    log "github.com/sirupsen/logrus"
+)
+
+func TestLineBuffer(t *testing.T) {
+    b := NewLineBuffer(3)
+    if lines := b.Lines(); len(lines) != 0 {
+        t.Logf("Expected no lines, received %v", lines)
+        t.FailNow()
+    }
+    b.Add("1")
+    b.Add("2")
+    if lines := b.Lines(); !reflect.DeepEqual(lines, []string{"1", "2"}) {
+        t.Logf("Unexpected lines %v", lines)
+        t.FailNow()
+    }
+    b.Add("3")
+    b.Add("4")
+    if lines := b.Lines(); !reflect.DeepEqual(lines, []string{"2", "3", "4"}) {
+        t.Logf("Expected the oldest line to be dropped, received %v", lines)
+        t.FailNow()
+    }
+}
+
+func TestLineBufferHook(t *testing.T) {
+    logger := log.New()
+    logger.SetFormatter(&log.JSONFormatter{})
+    b := NewLineBuffer(10)
+    logger.AddHook(b)
+    logger.Infof("mounted %s", "/share")
+
+    lines := b.Lines()
+    if len(lines) != 1 || !strings.Contains(lines[0], `"msg":"mounted /share"`) || strings.HasSuffix(lines[0], "\n") {
+        t.Logf("Expected the formatted entry, received %v", lines)
+        t.FailNow()
+    }
+}
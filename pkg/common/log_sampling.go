@@ -0,0 +1,98 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+    "fmt"
+    "sync"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+)
+
+// Repetitive messages on hot paths, such as mount checks and data-portal probing, are sampled:
+// each message format is logged at most LogSampleBurst times per LogSampleInterval, and the
+// number of dropped messages is reported with the first message logged in the next interval.
+
+type logSample struct {
+    windowStart time.Time
+    logged      int
+    suppressed  int
+}
+
+var (
+    logSamplesLock sync.Mutex
+    logSamples     = map[string]*logSample{}
+    logSampleNow   = time.Now
+)
+
+// shouldLogSample returns whether a message with the given format should be logged, and how
+// many messages with the format were suppressed since the last one logged
+func shouldLogSample(format string) (bool, int) {
+    if LogSampleInterval <= 0 {
+        return true, 0
+    }
+    logSamplesLock.Lock()
+    defer logSamplesLock.Unlock()
+
+    now := logSampleNow()
+    sample, exists := logSamples[format]
+    if !exists || now.Sub(sample.windowStart) >= LogSampleInterval {
+        suppressed := 0
+        if exists {
+            suppressed = sample.suppressed
+        }
+        logSamples[format] = &logSample{windowStart: now, logged: 1}
+        return true, suppressed
+    }
+    if sample.logged < LogSampleBurst {
+        sample.logged++
+        return true, 0
+    }
+    sample.suppressed++
+    return false, 0
+}
+
+func logSampled(level log.Level, format string, args ...interface{}) {
+    if !log.IsLevelEnabled(level) {
+        return
+    }
+    ok, suppressed := shouldLogSample(format)
+    if !ok {
+        return
+    }
+    message := fmt.Sprintf(format, args...)
+    if suppressed > 0 {
+        message = fmt.Sprintf("%s (suppressed %d similar messages)", message, suppressed)
+    }
+    log.StandardLogger().Log(level, message)
+}
+
+// SampledInfof logs at info level, subject to sampling
+func SampledInfof(format string, args ...interface{}) {
+    logSampled(log.InfoLevel, format, args...)
+}
+
+// SampledDebugf logs at debug level, subject to sampling
+func SampledDebugf(format string, args ...interface{}) {
+    logSampled(log.DebugLevel, format, args...)
+}
+
+// SampledWarnf logs at warning level, subject to sampling
+func SampledWarnf(format string, args ...interface{}) {
+    logSampled(log.WarnLevel, format, args...)
+}
@@ -0,0 +1,47 @@
+package common
+
+import (
+    "testing"
+    "time"
+)
+
+func TestShouldLogSample(t *testing.T) {
+    now := time.Now()
+    logSampleNow = func() time.Time { return now }
+    LogSampleInterval = time.Minute
+    LogSampleBurst = 2
+    defer func() {
+        logSampleNow = time.Now
+        LogSampleInterval = 0
+        LogSampleBurst = 10
+    }()
+
+    format := "backing share already mounted, %s"
+    for i, expected := range []bool{true, true, false, false} {
+        if ok, _ := shouldLogSample(format); ok != expected {
+            t.Logf("Message %d: expected logged %v, got %v", i, expected, ok)
+            t.FailNow()
+        }
+    }
+    // Other messages are sampled separately
+    if ok, _ := shouldLogSample("found export %s"); !ok {
+        t.Logf("Expected the first message of another format to be logged")
+        t.FailNow()
+    }
+
+    // The next interval reports the suppressed messages
+    now = now.Add(time.Minute)
+    ok, suppressed := shouldLogSample(format)
+    if !ok || suppressed != 2 {
+        t.Logf("Expected to log and report 2 suppressed messages, got %v, %d", ok, suppressed)
+        t.FailNow()
+    }
+
+    LogSampleInterval = 0
+    for i := 0; i < 5; i++ {
+        if ok, _ := shouldLogSample(format); !ok {
+            t.Logf("Expected every message to be logged when sampling is disabled")
+            t.FailNow()
+        }
+    }
+}
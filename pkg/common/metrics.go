@@ -0,0 +1,170 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+    "bytes"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+
+    log "github.com/sirupsen/logrus"
+)
+
+const (
+    MetricTypeCounter = "counter"
+    MetricTypeGauge   = "gauge"
+)
+
+type metric struct {
+    metricType string
+    help       string
+    values     map[string]float64 // keyed by rendered label set
+}
+
+// A minimal metrics registry rendered in the Prometheus text exposition format.
+// We avoid pulling in a full metrics client since only a handful of values are exported.
+var (
+    metricsLock sync.Mutex
+    metrics     = map[string]*metric{}
+)
+
+// RegisterMetric declares a metric so that it is rendered with HELP and TYPE lines
+func RegisterMetric(name, metricType, help string) {
+    metricsLock.Lock()
+    defer metricsLock.Unlock()
+    if _, exists := metrics[name]; !exists {
+        metrics[name] = &metric{
+            metricType: metricType,
+            help:       help,
+            values:     map[string]float64{},
+        }
+    }
+}
+
+func formatMetricLabels(labels map[string]string) string {
+    if len(labels) == 0 {
+        return ""
+    }
+    keys := make([]string, 0, len(labels))
+    for k := range labels {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    pairs := make([]string, len(keys))
+    for i, k := range keys {
+        value := strings.Replace(labels[k], `\`, `\\`, -1)
+        value = strings.Replace(value, `"`, `\"`, -1)
+        value = strings.Replace(value, "\n", `\n`, -1)
+        pairs[i] = fmt.Sprintf("%s=\"%s\"", k, value)
+    }
+    return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func getOrCreateMetric(name string) *metric {
+    m, exists := metrics[name]
+    if !exists {
+        m = &metric{
+            metricType: MetricTypeGauge,
+            values:     map[string]float64{},
+        }
+        metrics[name] = m
+    }
+    return m
+}
+
+// AddMetric adds value to the metric with the given labels
+func AddMetric(name string, labels map[string]string, value float64) {
+    metricsLock.Lock()
+    defer metricsLock.Unlock()
+    getOrCreateMetric(name).values[formatMetricLabels(labels)] += value
+}
+
+// IncMetric increments the metric with the given labels by one
+func IncMetric(name string, labels map[string]string) {
+    AddMetric(name, labels, 1)
+}
+
+// SetMetric sets the metric with the given labels to value
+func SetMetric(name string, labels map[string]string, value float64) {
+    metricsLock.Lock()
+    defer metricsLock.Unlock()
+    getOrCreateMetric(name).values[formatMetricLabels(labels)] = value
+}
+
+// DeleteMetric removes the metric with the given labels, for values of objects which no longer exist
+func DeleteMetric(name string, labels map[string]string) {
+    metricsLock.Lock()
+    defer metricsLock.Unlock()
+    if m, exists := metrics[name]; exists {
+        delete(m.values, formatMetricLabels(labels))
+    }
+}
+
+// RenderMetrics returns all metrics in the Prometheus text exposition format
+func RenderMetrics() string {
+    metricsLock.Lock()
+    defer metricsLock.Unlock()
+
+    names := make([]string, 0, len(metrics))
+    for name := range metrics {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    var b bytes.Buffer
+    for _, name := range names {
+        m := metrics[name]
+        if m.help != "" {
+            fmt.Fprintf(&b, "# HELP %s %s\n", name, m.help)
+        }
+        fmt.Fprintf(&b, "# TYPE %s %s\n", name, m.metricType)
+        labelSets := make([]string, 0, len(m.values))
+        for l := range m.values {
+            labelSets = append(labelSets, l)
+        }
+        sort.Strings(labelSets)
+        for _, l := range labelSets {
+            fmt.Fprintf(&b, "%s%s %v\n", name, l, m.values[l])
+        }
+    }
+    return b.String()
+}
+
+var httpHandlers = map[string]http.HandlerFunc{}
+
+// RegisterHTTPHandler adds a handler served alongside the metrics, it must be called before ServeMetrics
+func RegisterHTTPHandler(pattern string, handler http.HandlerFunc) {
+    httpHandlers[pattern] = handler
+}
+
+// ServeMetrics exposes the metrics at /metrics on the given address, along with the handlers
+// registered with RegisterHTTPHandler. It blocks until the server exits.
+func ServeMetrics(address string) error {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+        fmt.Fprint(w, RenderMetrics())
+    })
+    for pattern, handler := range httpHandlers {
+        mux.HandleFunc(pattern, handler)
+    }
+    log.Infof("serving metrics at %s/metrics", address)
+    return http.ListenAndServe(address, mux)
+}
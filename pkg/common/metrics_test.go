@@ -0,0 +1,40 @@
+package common
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestRenderMetrics(t *testing.T) {
+    RegisterMetric("test_counter_total", MetricTypeCounter, "A test counter")
+    IncMetric("test_counter_total", map[string]string{"share": "b"})
+    IncMetric("test_counter_total", map[string]string{"share": "a"})
+    AddMetric("test_counter_total", map[string]string{"share": "a"}, 2)
+    SetMetric("test_gauge", nil, 5)
+
+    expected := `# HELP test_counter_total A test counter
+# TYPE test_counter_total counter
+test_counter_total{share="a"} 3
+test_counter_total{share="b"} 1
+`
+    actual := RenderMetrics()
+    if !strings.Contains(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", actual)
+        t.FailNow()
+    }
+    if !strings.Contains(actual, "# TYPE test_gauge gauge\ntest_gauge 5\n") {
+        t.Logf("Gauge not rendered: %v", actual)
+        t.FailNow()
+    }
+}
+
+func TestFormatMetricLabels(t *testing.T) {
+    expected := `{a="1",b="quote\"d"}`
+    actual := formatMetricLabels(map[string]string{"b": "quote\"d", "a": "1"})
+    if actual != expected {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", actual)
+        t.FailNow()
+    }
+}
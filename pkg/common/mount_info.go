@@ -0,0 +1,90 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+)
+
+// Mount table of the plugin's mount namespace, with the propagation of each mount
+var MountInfoFile = "/proc/self/mountinfo"
+
+const (
+    MountPropagationShared  = "shared"
+    MountPropagationSlave   = "slave"
+    MountPropagationPrivate = "private"
+)
+
+// MountInfo is an entry of the mount table
+type MountInfo struct {
+    MountPoint  string
+    Propagation string // MountPropagationShared, MountPropagationSlave or MountPropagationPrivate
+}
+
+// ParseMountInfo parses the contents of a mountinfo file, whose lines are
+//   <id> <parent id> <major:minor> <root> <mount point> <options> [<optional field>...] - <fs type> ...
+// A shared:N optional field makes the mount shared, otherwise a master:N field makes it a slave.
+func ParseMountInfo(data string) []MountInfo {
+    mounts := []MountInfo{}
+    for _, line := range strings.Split(data, "\n") {
+        fields := strings.Fields(line)
+        if len(fields) < 7 {
+            continue
+        }
+        mount := MountInfo{MountPoint: UnescapeMountPath(fields[4]), Propagation: MountPropagationPrivate}
+        for _, field := range fields[6:] {
+            if field == "-" {
+                break
+            }
+            if strings.HasPrefix(field, "shared:") {
+                mount.Propagation = MountPropagationShared
+            } else if strings.HasPrefix(field, "master:") && mount.Propagation != MountPropagationShared {
+                mount.Propagation = MountPropagationSlave
+            }
+        }
+        mounts = append(mounts, mount)
+    }
+    return mounts
+}
+
+// ReadMountInfo returns the mount table of the plugin
+func ReadMountInfo() ([]MountInfo, error) {
+    data, err := ioutil.ReadFile(MountInfoFile)
+    if err != nil {
+        return nil, err
+    }
+    return ParseMountInfo(string(data)), nil
+}
+
+// FindMount returns the mount holding p, the last mounted on p or its closest parent, nil if none
+func FindMount(mounts []MountInfo, p string) *MountInfo {
+    p = filepath.Clean(p)
+    var found *MountInfo
+    for i, m := range mounts {
+        rel, err := filepath.Rel(m.MountPoint, p)
+        if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+            continue
+        }
+        // Later entries are mounted on top of earlier ones
+        if found == nil || len(m.MountPoint) >= len(found.MountPoint) {
+            found = &mounts[i]
+        }
+    }
+    return found
+}
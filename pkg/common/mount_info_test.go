@@ -0,0 +1,52 @@
+package common
+
+import (
+    "testing"
+)
+
+const testMountInfo = `22 1 0:21 / / rw,relatime - overlay overlay rw
+23 22 0:22 / /proc rw,nosuid - proc proc rw
+24 22 8:1 /var/lib/kubelet /var/lib/kubelet rw,relatime shared:5 - ext4 /dev/sda1 rw
+25 22 8:1 /tmp /tmp rw,relatime master:6 - ext4 /dev/sda1 rw
+26 24 0:40 / /var/lib/kubelet/pods/a/volumes/x rw shared:7 master:8 - nfs 10.0.0.1:/pvc-a rw
+27 22 8:1 /data/my\040dir /data/my\040dir rw - ext4 /dev/sda1 rw
+`
+
+func TestParseMountInfo(t *testing.T) {
+    mounts := ParseMountInfo(testMountInfo)
+    if len(mounts) != 6 {
+        t.Logf("Expected 6 mounts, received %v", mounts)
+        t.FailNow()
+    }
+    expected := map[string]string{
+        "/":                                 MountPropagationPrivate,
+        "/var/lib/kubelet":                  MountPropagationShared,
+        "/tmp":                              MountPropagationSlave,
+        "/var/lib/kubelet/pods/a/volumes/x": MountPropagationShared,
+        "/data/my dir":                      MountPropagationPrivate,
+    }
+    for _, m := range mounts {
+        if propagation, exists := expected[m.MountPoint]; exists && propagation != m.Propagation {
+            t.Logf("Expected %s to be %s, received %s", m.MountPoint, propagation, m.Propagation)
+            t.FailNow()
+        }
+    }
+
+    tests := map[string]string{
+        "/var/lib/kubelet":                   "/var/lib/kubelet",
+        "/var/lib/kubelet/pods":              "/var/lib/kubelet",
+        "/var/lib/kubelet-other":             "/",
+        "/tmp/backing-share":                 "/tmp",
+        "/var/lib/kubelet/pods/a/volumes/x/": "/var/lib/kubelet/pods/a/volumes/x",
+    }
+    for p, mountPoint := range tests {
+        if mount := FindMount(mounts, p); mount == nil || mount.MountPoint != mountPoint {
+            t.Logf("Expected %s to be on %s, received %v", p, mountPoint, mount)
+            t.FailNow()
+        }
+    }
+    if mount := FindMount(nil, "/tmp"); mount != nil {
+        t.Logf("Expected no mount, received %v", mount)
+        t.FailNow()
+    }
+}
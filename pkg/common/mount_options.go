@@ -0,0 +1,283 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strconv"
+    "strings"
+
+    unix "golang.org/x/sys/unix"
+)
+
+const (
+    MaxNconnect     = 16
+    MaxNFSBlockSize = 1048576
+    MinNFSBlockSize = 1024
+)
+
+// Defaults applied by the mountPolicy parameter, these match the Linux NFS client defaults for TCP
+const (
+    MountPolicyHard     = "hard"
+    MountPolicySoft     = "soft"
+    DefaultMountTimeo   = 600 // tenths of a second
+    DefaultMountRetrans = 2
+    MinSoftMountTimeo   = 50 // soft mounts with shorter timeouts risk spurious I/O errors
+    MaxMountTimeo       = 6000
+    MaxMountRetrans     = 10
+)
+
+// Kernel version in which each option first became available on the NFS client
+var mountOptionMinKernel = map[string][2]int{
+    "nconnect": {5, 3},
+}
+
+// Mount options which are managed by the driver and may not be overridden
+var driverManagedMountOptions = []string{"vers", "nfsvers", "ro", "rw", "proto"}
+
+func splitMountOption(option string) (string, string, bool) {
+    tokens := strings.SplitN(option, "=", 2)
+    if len(tokens) == 1 {
+        return tokens[0], "", false
+    }
+    return tokens[0], tokens[1], true
+}
+
+// ParseClientMountOptions parses a comma separated list of NFS client mount options and
+// validates the performance related options, returning a list suitable for passing to mount
+func ParseClientMountOptions(optionsParam string) ([]string, error) {
+    options := []string{}
+    seen := map[string]bool{}
+    for _, o := range strings.Split(optionsParam, ",") {
+        o = strings.TrimSpace(o)
+        if o == "" {
+            continue
+        }
+        key, value, hasValue := splitMountOption(o)
+        if seen[key] {
+            return nil, fmt.Errorf(InvalidClientMountOptionDuplicate, key)
+        }
+        seen[key] = true
+        for _, managed := range driverManagedMountOptions {
+            if key == managed {
+                return nil, fmt.Errorf(InvalidClientMountOptionManaged, key)
+            }
+        }
+
+        switch key {
+        case "nconnect":
+            n, err := strconv.Atoi(value)
+            if !hasValue || err != nil || n < 1 || n > MaxNconnect {
+                return nil, fmt.Errorf(InvalidClientMountOptionRange, o, 1, MaxNconnect)
+            }
+        case "rsize", "wsize":
+            n, err := strconv.Atoi(value)
+            if !hasValue || err != nil || n < MinNFSBlockSize || n > MaxNFSBlockSize {
+                return nil, fmt.Errorf(InvalidClientMountOptionRange, o, MinNFSBlockSize, MaxNFSBlockSize)
+            }
+        case "actimeo", "acregmin", "acregmax", "acdirmin", "acdirmax", "timeo", "retrans":
+            n, err := strconv.Atoi(value)
+            if !hasValue || err != nil || n < 0 {
+                return nil, fmt.Errorf(InvalidClientMountOptionValue, o)
+            }
+        }
+        options = append(options, o)
+    }
+
+    if seen["noac"] && (seen["actimeo"] || seen["acregmin"] || seen["acregmax"] || seen["acdirmin"] || seen["acdirmax"]) {
+        return nil, fmt.Errorf(InvalidClientMountOptionConflict, "noac", "attribute cache timeouts")
+    }
+    return options, nil
+}
+
+func parseKernelRelease(release string) (int, int, error) {
+    tokens := strings.SplitN(release, ".", 3)
+    if len(tokens) < 2 {
+        return 0, 0, fmt.Errorf("could not parse kernel release %s", release)
+    }
+    major, err := strconv.Atoi(tokens[0])
+    if err != nil {
+        return 0, 0, fmt.Errorf("could not parse kernel release %s", release)
+    }
+    minorStr := tokens[1]
+    for i, c := range minorStr {
+        if c < '0' || c > '9' {
+            minorStr = minorStr[:i]
+            break
+        }
+    }
+    minor, err := strconv.Atoi(minorStr)
+    if err != nil {
+        return 0, 0, fmt.Errorf("could not parse kernel release %s", release)
+    }
+    return major, minor, nil
+}
+
+func getKernelRelease() (string, error) {
+    var uname unix.Utsname
+    if err := unix.Uname(&uname); err != nil {
+        return "", err
+    }
+    return strings.TrimRight(string(uname.Release[:]), "\x00"), nil
+}
+
+var GetKernelRelease = getKernelRelease
+
+// ValidateMountOptionsForKernel ensures the running kernel supports the given mount options
+func ValidateMountOptionsForKernel(options []string) error {
+    release, err := GetKernelRelease()
+    if err != nil {
+        return err
+    }
+    major, minor, err := parseKernelRelease(release)
+    if err != nil {
+        return err
+    }
+    for _, o := range options {
+        key, _, _ := splitMountOption(o)
+        if required, exists := mountOptionMinKernel[key]; exists {
+            if major < required[0] || (major == required[0] && minor < required[1]) {
+                return fmt.Errorf(ClientMountOptionUnsupportedKernel, key, release, required[0], required[1])
+            }
+        }
+    }
+    return nil
+}
+
+// GetKernelNFSVersions returns the NFS major versions the kernel supports, either through
+// filesystems already registered or through modules which mount.nfs can load
+func GetKernelNFSVersions() ([]string, error) {
+    data, err := ioutil.ReadFile(ProcFilesystems)
+    if err != nil {
+        return nil, err
+    }
+    registered := map[string]bool{}
+    for _, line := range strings.Split(string(data), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) > 0 {
+            registered[fields[len(fields)-1]] = true
+        }
+    }
+    versions := []string{}
+    for _, v := range []struct{ version, fsType, module string }{
+        {"3", "nfs", "nfsv3"},
+        {"4", "nfs4", "nfsv4"},
+    } {
+        if registered[v.fsType] {
+            versions = append(versions, v.version)
+        } else if _, err := ExecCommand("modinfo", v.module); err == nil {
+            versions = append(versions, v.version)
+        }
+    }
+    if len(versions) == 0 {
+        return nil, fmt.Errorf("kernel does not support NFS")
+    }
+    return versions, nil
+}
+
+// CheckLoopDeviceSupport ensures loop devices can be created for file-backed volumes
+func CheckLoopDeviceSupport() error {
+    if _, err := os.Stat(LoopControlDevice); err == nil {
+        return nil
+    }
+    output, err := ExecCommand("modinfo", "loop")
+    if err != nil {
+        return fmt.Errorf("%s does not exist and the loop module is not available, %s, %v",
+            LoopControlDevice, output, err)
+    }
+    return nil
+}
+
+// IsRDMAAvailable returns true if the host has at least one RDMA capable device
+func IsRDMAAvailable() bool {
+    devices, err := ioutil.ReadDir(RDMADeviceDir)
+    if err != nil {
+        return false
+    }
+    return len(devices) > 0
+}
+
+// GetTransportMountOptions returns the mount options selecting the given NFS transport
+func GetTransportMountOptions(transport string, port int) []string {
+    if transport == TransportRDMA {
+        return []string{"proto=rdma", fmt.Sprintf("port=%d", port)}
+    }
+    return []string{}
+}
+
+// GetReadOnlyReplicaMountOptions returns the mount options of a read-only filesystem of the given
+// type which another node may have mounted read-write. The journal or log is not replayed, that
+// would write to a device attached read-only, and for xfs the duplicate UUID is accepted.
+func GetReadOnlyReplicaMountOptions(fsType string) []string {
+    if fsType == "xfs" {
+        return []string{"ro", "norecovery", "nouuid"}
+    }
+    return []string{"ro", "noload"}
+}
+
+// ParseMountPolicy parses the mountPolicy parameter, of the form <hard|soft>[,timeo=N][,retrans=N],
+// returning the mount options to apply with the documented defaults filled in
+func ParseMountPolicy(policyParam string) ([]string, error) {
+    tokens := strings.Split(policyParam, ",")
+    policy := strings.TrimSpace(tokens[0])
+    if policy != MountPolicyHard && policy != MountPolicySoft {
+        return nil, fmt.Errorf(InvalidMountPolicy, policyParam)
+    }
+    timeo := DefaultMountTimeo
+    retrans := DefaultMountRetrans
+    for _, t := range tokens[1:] {
+        t = strings.TrimSpace(t)
+        key, value, _ := splitMountOption(t)
+        n, err := strconv.Atoi(value)
+        switch key {
+        case "timeo":
+            if err != nil || n < 1 || n > MaxMountTimeo {
+                return nil, fmt.Errorf(InvalidClientMountOptionRange, t, 1, MaxMountTimeo)
+            }
+            timeo = n
+        case "retrans":
+            if err != nil || n < 0 || n > MaxMountRetrans {
+                return nil, fmt.Errorf(InvalidClientMountOptionRange, t, 0, MaxMountRetrans)
+            }
+            retrans = n
+        default:
+            return nil, fmt.Errorf(InvalidMountPolicy, policyParam)
+        }
+    }
+    if policy == MountPolicySoft && (timeo < MinSoftMountTimeo || retrans < 1) {
+        return nil, fmt.Errorf(UnsafeSoftMountPolicy, MinSoftMountTimeo)
+    }
+    return []string{
+        policy,
+        fmt.Sprintf("timeo=%d", timeo),
+        fmt.Sprintf("retrans=%d", retrans),
+    }, nil
+}
+
+// MountPolicyConflicts returns the first option which would override the mount policy
+func MountPolicyConflicts(options []string) string {
+    for _, o := range options {
+        key, _, _ := splitMountOption(o)
+        switch key {
+        case "hard", "soft", "softerr", "timeo", "retrans":
+            return key
+        }
+    }
+    return ""
+}
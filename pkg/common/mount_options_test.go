@@ -0,0 +1,140 @@
+package common
+
+import (
+    "errors"
+    "io/ioutil"
+    "os"
+    "reflect"
+    "testing"
+)
+
+func TestParseClientMountOptions(t *testing.T) {
+    expected := []string{"nconnect=8", "rsize=1048576", "wsize=1048576", "actimeo=30"}
+    actual, err := ParseClientMountOptions("nconnect=8, rsize=1048576,wsize=1048576,,actimeo=30")
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", actual)
+        t.FailNow()
+    }
+
+    invalid := []string{
+        "nconnect=0",
+        "nconnect=17",
+        "nconnect",
+        "rsize=512",
+        "wsize=abc",
+        "actimeo=-1",
+        "nfsvers=3",
+        "noac,actimeo=10",
+        "nconnect=2,nconnect=4",
+    }
+    for _, o := range invalid {
+        _, err = ParseClientMountOptions(o)
+        if err == nil {
+            t.Logf("Expected error for %s", o)
+            t.FailNow()
+        }
+    }
+}
+
+func TestValidateMountOptionsForKernel(t *testing.T) {
+    GetKernelRelease = func() (string, error) {
+        return "4.18.0-305.el8.x86_64", nil
+    }
+    defer func() { GetKernelRelease = getKernelRelease }()
+    if err := ValidateMountOptionsForKernel([]string{"nconnect=4"}); err == nil {
+        t.Logf("Expected error")
+        t.FailNow()
+    }
+    if err := ValidateMountOptionsForKernel([]string{"rsize=65536"}); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+
+    GetKernelRelease = func() (string, error) {
+        return "5.15.0-91-generic", nil
+    }
+    if err := ValidateMountOptionsForKernel([]string{"nconnect=4"}); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+}
+
+func TestGetReadOnlyReplicaMountOptions(t *testing.T) {
+    for fsType, expected := range map[string][]string{
+        "ext4": {"ro", "noload"},
+        "xfs":  {"ro", "norecovery", "nouuid"},
+    } {
+        if options := GetReadOnlyReplicaMountOptions(fsType); !reflect.DeepEqual(options, expected) {
+            t.Logf("Expected %v for %s, received %v", expected, fsType, options)
+            t.FailNow()
+        }
+    }
+}
+
+func TestParseMountPolicy(t *testing.T) {
+    expected := []string{"hard", "timeo=600", "retrans=2"}
+    actual, err := ParseMountPolicy("hard")
+    if err != nil || !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v, %v", actual, err)
+        t.FailNow()
+    }
+
+    expected = []string{"soft", "timeo=100", "retrans=3"}
+    actual, err = ParseMountPolicy("soft, timeo=100,retrans=3")
+    if err != nil || !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v, %v", actual, err)
+        t.FailNow()
+    }
+
+    for _, p := range []string{"", "medium", "soft,timeo=10", "soft,retrans=0", "hard,timeo=abc", "hard,nconnect=2"} {
+        _, err = ParseMountPolicy(p)
+        if err == nil {
+            t.Logf("Expected error for %s", p)
+            t.FailNow()
+        }
+    }
+}
+
+func TestGetKernelNFSVersions(t *testing.T) {
+    procFilesystems, err := ioutil.TempFile("", "filesystems")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.Remove(procFilesystems.Name())
+    procFilesystems.WriteString("nodev\tsysfs\n\text4\nnodev\tnfs\n")
+    procFilesystems.Close()
+    ProcFilesystems = procFilesystems.Name()
+    defer func() { ProcFilesystems = "/proc/filesystems" }()
+
+    // nfs4 is not registered, but its module can be loaded
+    ExecCommand = func(command string, args ...string) ([]byte, error) {
+        if command == "modinfo" && args[0] == "nfsv4" {
+            return []byte(""), nil
+        }
+        return []byte(""), errors.New("module not found")
+    }
+    versions, err := GetKernelNFSVersions()
+    expected := []string{"3", "4"}
+    if err != nil || !reflect.DeepEqual(versions, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v, %v", versions, err)
+        t.FailNow()
+    }
+
+    ExecCommand = func(command string, args ...string) ([]byte, error) {
+        return []byte(""), errors.New("module not found")
+    }
+    ProcFilesystems = "/nonexistent/filesystems"
+    if _, err := GetKernelNFSVersions(); err == nil {
+        t.Logf("Expected an error when /proc/filesystems cannot be read")
+        t.FailNow()
+    }
+}
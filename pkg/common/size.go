@@ -0,0 +1,37 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+    "fmt"
+    "strings"
+
+    "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Sizes given in settings and parameters are Kubernetes resource quantities, such as 1073741824,
+// 1Gi, 500M or 1.5Ti, so they are written as in PersistentVolumeClaims, and are parsed as
+// Kubernetes parses them. Fractional bytes are rounded up.
+
+// ParseSize returns the number of bytes of a quantity
+func ParseSize(quantity string) (int64, error) {
+    q, err := resource.ParseQuantity(strings.TrimSpace(quantity))
+    if err != nil || q.Sign() < 0 {
+        return 0, fmt.Errorf(InvalidSize, quantity)
+    }
+    return q.Value(), nil
+}
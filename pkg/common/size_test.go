@@ -0,0 +1,30 @@
+package common
+
+import (
+    "testing"
+)
+
+func TestParseSize(t *testing.T) {
+    for quantity, expected := range map[string]int64{
+        "1073741824": 1073741824,
+        "1Gi":        1 << 30,
+        "1.5Ki":      1536,
+        "500M":       500000000,
+        "2k":         2000,
+        "1e3":        1000,
+        "0.1":        1,
+        " 10Ti ":     10 << 40,
+    } {
+        size, err := ParseSize(quantity)
+        if err != nil || size != expected {
+            t.Logf("Expected %d for %q, received %d, %v", expected, quantity, size, err)
+            t.FailNow()
+        }
+    }
+    for _, quantity := range []string{"", "1GB", "-1Gi", "1/2Gi", "5e"} {
+        if _, err := ParseSize(quantity); err == nil {
+            t.Logf("Expected an error for %q", quantity)
+            t.FailNow()
+        }
+    }
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+    "fmt"
+    "net"
+    "os"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+)
+
+const (
+    socketDialTimeout = time.Second
+    socketRetryDelay  = time.Second
+)
+
+// removeStaleSocket removes the unix socket at endpoint if no server accepts connections on it.
+// It returns an error if another server is serving on it, or if something other than a socket
+// is at that path.
+func removeStaleSocket(endpoint string) error {
+    info, err := os.Lstat(endpoint)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+    if info.Mode()&os.ModeSocket == 0 {
+        return fmt.Errorf("%s exists and is not a socket", endpoint)
+    }
+
+    conn, err := net.DialTimeout("unix", endpoint, socketDialTimeout)
+    if err == nil {
+        conn.Close()
+        return fmt.Errorf("another server is listening on %s", endpoint)
+    }
+    log.Infof("removing stale socket %s, %v", endpoint, err)
+    err = os.Remove(endpoint)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    return err
+}
+
+// ListenUnixSocket listens on the unix socket at endpoint, taking over a socket left behind by a
+// server which is no longer running. While another server still serves on the socket, e.g. the
+// previous container of a restarting pod, it retries until timeout has passed.
+func ListenUnixSocket(endpoint string, timeout time.Duration) (net.Listener, error) {
+    deadline := time.Now().Add(timeout)
+    for {
+        err := removeStaleSocket(endpoint)
+        if err == nil {
+            var l net.Listener
+            l, err = net.Listen("unix", endpoint)
+            if err == nil {
+                return l, nil
+            }
+        }
+        if time.Now().Add(socketRetryDelay).After(deadline) {
+            return nil, fmt.Errorf("could not listen on %s within %v, %v", endpoint, timeout, err)
+        }
+        log.Warnf("could not listen on %s, retrying, %v", endpoint, err)
+        time.Sleep(socketRetryDelay)
+    }
+}
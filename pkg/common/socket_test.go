@@ -0,0 +1,60 @@
+package common
+
+import (
+    "io/ioutil"
+    "net"
+    "os"
+    "path"
+    "testing"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+    dir, err := ioutil.TempDir("", "socket")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(dir)
+    endpoint := path.Join(dir, "csi.sock")
+
+    // No socket yet
+    l, err := ListenUnixSocket(endpoint, 0)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+
+    // Another server is listening
+    if _, err := ListenUnixSocket(endpoint, 0); err == nil {
+        t.Logf("Expected an error while another server listens on the socket")
+        t.FailNow()
+    }
+
+    // Stale socket left behind by a server which exited without cleaning up
+    l.(*net.UnixListener).SetUnlinkOnClose(false)
+    l.Close()
+    if _, err := os.Stat(endpoint); err != nil {
+        t.Logf("Expected the stale socket to exist, %v", err)
+        t.FailNow()
+    }
+    l, err = ListenUnixSocket(endpoint, 0)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    l.Close()
+
+    // Not a socket
+    if err := ioutil.WriteFile(endpoint, []byte("data"), 0644); err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    if _, err := ListenUnixSocket(endpoint, 0); err == nil {
+        t.Logf("Expected an error for a path which is not a socket")
+        t.FailNow()
+    }
+    if _, err := os.Stat(endpoint); err != nil {
+        t.Logf("Expected the file to be left in place, %v", err)
+        t.FailNow()
+    }
+}
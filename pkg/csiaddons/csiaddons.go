@@ -0,0 +1,285 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package csiaddons serves the parts of the csi-addons specification
+// (https://github.com/csi-addons/spec) implemented by the plugin: the identity service and the
+// ReclaimSpace operations. The messages are declared by hand, with the field numbers of the
+// specification, as its generated code requires a newer gRPC than the CSI 1.2 libraries build
+// with. Only the fields the plugin reads or returns are declared, the others are skipped when
+// decoding like any unknown field.
+package csiaddons
+
+import (
+    "context"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "github.com/golang/protobuf/proto"
+    "github.com/golang/protobuf/ptypes/wrappers"
+    "google.golang.org/grpc"
+)
+
+// Types of Capability_Service
+const (
+    ServiceUnknown    int32 = 0
+    ControllerService int32 = 1
+    NodeService       int32 = 2
+)
+
+// Types of Capability_ReclaimSpace
+const (
+    ReclaimSpaceUnknown int32 = 0
+    ReclaimSpaceOffline int32 = 1 // ControllerReclaimSpace, for volumes not in use
+    ReclaimSpaceOnline  int32 = 2 // NodeReclaimSpace, for published volumes
+)
+
+type GetIdentityRequest struct{}
+
+func (m *GetIdentityRequest) Reset()         { *m = GetIdentityRequest{} }
+func (m *GetIdentityRequest) String() string { return proto.CompactTextString(m) }
+func (*GetIdentityRequest) ProtoMessage()    {}
+
+type GetIdentityResponse struct {
+    Name          string            `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+    VendorVersion string            `protobuf:"bytes,2,opt,name=vendor_version,json=vendorVersion,proto3" json:"vendor_version,omitempty"`
+    Manifest      map[string]string `protobuf:"bytes,3,rep,name=manifest,proto3" json:"manifest,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *GetIdentityResponse) Reset()         { *m = GetIdentityResponse{} }
+func (m *GetIdentityResponse) String() string { return proto.CompactTextString(m) }
+func (*GetIdentityResponse) ProtoMessage()    {}
+
+type GetCapabilitiesRequest struct{}
+
+func (m *GetCapabilitiesRequest) Reset()         { *m = GetCapabilitiesRequest{} }
+func (m *GetCapabilitiesRequest) String() string { return proto.CompactTextString(m) }
+func (*GetCapabilitiesRequest) ProtoMessage()    {}
+
+type GetCapabilitiesResponse struct {
+    Capabilities []*Capability `protobuf:"bytes,1,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
+}
+
+func (m *GetCapabilitiesResponse) Reset()         { *m = GetCapabilitiesResponse{} }
+func (m *GetCapabilitiesResponse) String() string { return proto.CompactTextString(m) }
+func (*GetCapabilitiesResponse) ProtoMessage()    {}
+
+// Capability is a oneof in the specification, only one of its fields is set
+type Capability struct {
+    Service      *Capability_Service      `protobuf:"bytes,1,opt,name=service,proto3" json:"service,omitempty"`
+    ReclaimSpace *Capability_ReclaimSpace `protobuf:"bytes,2,opt,name=reclaim_space,json=reclaimSpace,proto3" json:"reclaim_space,omitempty"`
+}
+
+func (m *Capability) Reset()         { *m = Capability{} }
+func (m *Capability) String() string { return proto.CompactTextString(m) }
+func (*Capability) ProtoMessage()    {}
+
+type Capability_Service struct {
+    Type int32 `protobuf:"varint,1,opt,name=type,proto3" json:"type,omitempty"`
+}
+
+func (m *Capability_Service) Reset()         { *m = Capability_Service{} }
+func (m *Capability_Service) String() string { return proto.CompactTextString(m) }
+func (*Capability_Service) ProtoMessage()    {}
+
+type Capability_ReclaimSpace struct {
+    Type int32 `protobuf:"varint,1,opt,name=type,proto3" json:"type,omitempty"`
+}
+
+func (m *Capability_ReclaimSpace) Reset()         { *m = Capability_ReclaimSpace{} }
+func (m *Capability_ReclaimSpace) String() string { return proto.CompactTextString(m) }
+func (*Capability_ReclaimSpace) ProtoMessage()    {}
+
+type ProbeRequest struct{}
+
+func (m *ProbeRequest) Reset()         { *m = ProbeRequest{} }
+func (m *ProbeRequest) String() string { return proto.CompactTextString(m) }
+func (*ProbeRequest) ProtoMessage()    {}
+
+type ProbeResponse struct {
+    Ready *wrappers.BoolValue `protobuf:"bytes,1,opt,name=ready,proto3" json:"ready,omitempty"`
+}
+
+func (m *ProbeResponse) Reset()         { *m = ProbeResponse{} }
+func (m *ProbeResponse) String() string { return proto.CompactTextString(m) }
+func (*ProbeResponse) ProtoMessage()    {}
+
+type StorageConsumption struct {
+    UsageBytes int64 `protobuf:"varint,1,opt,name=usage_bytes,json=usageBytes,proto3" json:"usage_bytes,omitempty"`
+}
+
+func (m *StorageConsumption) Reset()         { *m = StorageConsumption{} }
+func (m *StorageConsumption) String() string { return proto.CompactTextString(m) }
+func (*StorageConsumption) ProtoMessage()    {}
+
+// Secrets are left out of the JSON the plugin logs requests as
+type ControllerReclaimSpaceRequest struct {
+    VolumeId   string            `protobuf:"bytes,1,opt,name=volume_id,json=volumeId,proto3" json:"volume_id,omitempty"`
+    Parameters map[string]string `protobuf:"bytes,2,rep,name=parameters,proto3" json:"parameters,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+    Secrets    map[string]string `protobuf:"bytes,3,rep,name=secrets,proto3" json:"-" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *ControllerReclaimSpaceRequest) Reset()         { *m = ControllerReclaimSpaceRequest{} }
+func (m *ControllerReclaimSpaceRequest) String() string { return proto.CompactTextString(m) }
+func (*ControllerReclaimSpaceRequest) ProtoMessage()    {}
+
+type ControllerReclaimSpaceResponse struct {
+    PreUsage  *StorageConsumption `protobuf:"bytes,1,opt,name=pre_usage,json=preUsage,proto3" json:"pre_usage,omitempty"`
+    PostUsage *StorageConsumption `protobuf:"bytes,2,opt,name=post_usage,json=postUsage,proto3" json:"post_usage,omitempty"`
+}
+
+func (m *ControllerReclaimSpaceResponse) Reset()         { *m = ControllerReclaimSpaceResponse{} }
+func (m *ControllerReclaimSpaceResponse) String() string { return proto.CompactTextString(m) }
+func (*ControllerReclaimSpaceResponse) ProtoMessage()    {}
+
+type NodeReclaimSpaceRequest struct {
+    VolumeId          string                `protobuf:"bytes,1,opt,name=volume_id,json=volumeId,proto3" json:"volume_id,omitempty"`
+    VolumePath        string                `protobuf:"bytes,2,opt,name=volume_path,json=volumePath,proto3" json:"volume_path,omitempty"`
+    StagingTargetPath string                `protobuf:"bytes,3,opt,name=staging_target_path,json=stagingTargetPath,proto3" json:"staging_target_path,omitempty"`
+    VolumeCapability  *csi.VolumeCapability `protobuf:"bytes,4,opt,name=volume_capability,json=volumeCapability,proto3" json:"volume_capability,omitempty"`
+    Secrets           map[string]string     `protobuf:"bytes,5,rep,name=secrets,proto3" json:"-" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *NodeReclaimSpaceRequest) Reset()         { *m = NodeReclaimSpaceRequest{} }
+func (m *NodeReclaimSpaceRequest) String() string { return proto.CompactTextString(m) }
+func (*NodeReclaimSpaceRequest) ProtoMessage()    {}
+
+type NodeReclaimSpaceResponse struct {
+    PreUsage  *StorageConsumption `protobuf:"bytes,1,opt,name=pre_usage,json=preUsage,proto3" json:"pre_usage,omitempty"`
+    PostUsage *StorageConsumption `protobuf:"bytes,2,opt,name=post_usage,json=postUsage,proto3" json:"post_usage,omitempty"`
+}
+
+func (m *NodeReclaimSpaceResponse) Reset()         { *m = NodeReclaimSpaceResponse{} }
+func (m *NodeReclaimSpaceResponse) String() string { return proto.CompactTextString(m) }
+func (*NodeReclaimSpaceResponse) ProtoMessage()    {}
+
+// IdentityServer is the server API for the identity.Identity service
+type IdentityServer interface {
+    GetIdentity(context.Context, *GetIdentityRequest) (*GetIdentityResponse, error)
+    GetCapabilities(context.Context, *GetCapabilitiesRequest) (*GetCapabilitiesResponse, error)
+    Probe(context.Context, *ProbeRequest) (*ProbeResponse, error)
+}
+
+// ReclaimSpaceControllerServer is the server API for the reclaimspace.ReclaimSpaceController service
+type ReclaimSpaceControllerServer interface {
+    ControllerReclaimSpace(context.Context, *ControllerReclaimSpaceRequest) (*ControllerReclaimSpaceResponse, error)
+}
+
+// ReclaimSpaceNodeServer is the server API for the reclaimspace.ReclaimSpaceNode service
+type ReclaimSpaceNodeServer interface {
+    NodeReclaimSpace(context.Context, *NodeReclaimSpaceRequest) (*NodeReclaimSpaceResponse, error)
+}
+
+func RegisterIdentityServer(s *grpc.Server, srv IdentityServer) {
+    s.RegisterService(&identityServiceDesc, srv)
+}
+
+func RegisterReclaimSpaceControllerServer(s *grpc.Server, srv ReclaimSpaceControllerServer) {
+    s.RegisterService(&reclaimSpaceControllerServiceDesc, srv)
+}
+
+func RegisterReclaimSpaceNodeServer(s *grpc.Server, srv ReclaimSpaceNodeServer) {
+    s.RegisterService(&reclaimSpaceNodeServiceDesc, srv)
+}
+
+// unaryHandler returns the gRPC handler of a method decoding its request into a new request and
+// calling call with it
+func unaryHandler(fullMethod string, newRequest func() interface{},
+    call func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error)) func(
+    srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (
+    interface{}, error) {
+
+    return func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+        interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+
+        in := newRequest()
+        if err := dec(in); err != nil {
+            return nil, err
+        }
+        if interceptor == nil {
+            return call(srv, ctx, in)
+        }
+        info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}
+        return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+            return call(srv, ctx, req)
+        })
+    }
+}
+
+var identityServiceDesc = grpc.ServiceDesc{
+    ServiceName: "identity.Identity",
+    HandlerType: (*IdentityServer)(nil),
+    Methods: []grpc.MethodDesc{
+        {
+            MethodName: "GetIdentity",
+            Handler: unaryHandler("/identity.Identity/GetIdentity",
+                func() interface{} { return new(GetIdentityRequest) },
+                func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+                    return srv.(IdentityServer).GetIdentity(ctx, req.(*GetIdentityRequest))
+                }),
+        },
+        {
+            MethodName: "GetCapabilities",
+            Handler: unaryHandler("/identity.Identity/GetCapabilities",
+                func() interface{} { return new(GetCapabilitiesRequest) },
+                func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+                    return srv.(IdentityServer).GetCapabilities(ctx, req.(*GetCapabilitiesRequest))
+                }),
+        },
+        {
+            MethodName: "Probe",
+            Handler: unaryHandler("/identity.Identity/Probe",
+                func() interface{} { return new(ProbeRequest) },
+                func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+                    return srv.(IdentityServer).Probe(ctx, req.(*ProbeRequest))
+                }),
+        },
+    },
+    Streams:  []grpc.StreamDesc{},
+    Metadata: "identity/identity.proto",
+}
+
+var reclaimSpaceControllerServiceDesc = grpc.ServiceDesc{
+    ServiceName: "reclaimspace.ReclaimSpaceController",
+    HandlerType: (*ReclaimSpaceControllerServer)(nil),
+    Methods: []grpc.MethodDesc{
+        {
+            MethodName: "ControllerReclaimSpace",
+            Handler: unaryHandler("/reclaimspace.ReclaimSpaceController/ControllerReclaimSpace",
+                func() interface{} { return new(ControllerReclaimSpaceRequest) },
+                func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+                    return srv.(ReclaimSpaceControllerServer).ControllerReclaimSpace(ctx, req.(*ControllerReclaimSpaceRequest))
+                }),
+        },
+    },
+    Streams:  []grpc.StreamDesc{},
+    Metadata: "reclaimspace/reclaimspace.proto",
+}
+
+var reclaimSpaceNodeServiceDesc = grpc.ServiceDesc{
+    ServiceName: "reclaimspace.ReclaimSpaceNode",
+    HandlerType: (*ReclaimSpaceNodeServer)(nil),
+    Methods: []grpc.MethodDesc{
+        {
+            MethodName: "NodeReclaimSpace",
+            Handler: unaryHandler("/reclaimspace.ReclaimSpaceNode/NodeReclaimSpace",
+                func() interface{} { return new(NodeReclaimSpaceRequest) },
+                func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+                    return srv.(ReclaimSpaceNodeServer).NodeReclaimSpace(ctx, req.(*NodeReclaimSpaceRequest))
+                }),
+        },
+    },
+    Streams:  []grpc.StreamDesc{},
+    Metadata: "reclaimspace/reclaimspace.proto",
+}
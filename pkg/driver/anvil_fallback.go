@@ -0,0 +1,70 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// When no data-portal mounts a share, the Anvil of the API endpoint often exports it too. With
+// HS_ANVIL_MOUNT_FALLBACK set the share is then mounted from the Anvil over NFS 4.2, as a last
+// resort since all I/O goes through the metadata server. Nodes without credentials have no
+// endpoint and never fall back.
+const (
+    MetricAnvilMountFallbacks = "hs_csi_anvil_mount_fallbacks_total"
+)
+
+func init() {
+    common.RegisterMetric(MetricAnvilMountFallbacks, common.MetricTypeCounter,
+        "Mounts of shares from the Anvil after no data-portal mounted them, by result")
+}
+
+// getAnvilExport returns the export of a share on the Anvil at addr, which exports shares at
+// their export path whatever the mount prefix of the data-portals
+func getAnvilExport(addr, shareExportPath string) string {
+    return fmt.Sprintf("%s:%s", addr, shareExportPath)
+}
+
+// mountShareAtAnvil mounts the share exported at shareExportPath from the Anvil of the API endpoint
+func (d *CSIDriver) mountShareAtAnvil(
+    shareExportPath, targetPath string, mountFlags []string, trace *publishTrace) error {
+
+    addr, err := d.hsclient.GetAnvilPortal()
+    if err == nil && addr == "" {
+        err = fmt.Errorf("the API endpoint has no host")
+    }
+    if err != nil {
+        return err
+    }
+    export := getAnvilExport(addr, shareExportPath)
+    log.Warnf("no data-portal mounted %s, mounting %s from the Anvil", shareExportPath, export)
+
+    mo := append(append([]string{}, mountFlags...), nfsVersionMountOptions[NFSVersion42]...)
+    endStage := trace.stage(publishStageNFSMount)
+    err = common.MountShare(export, targetPath, mo)
+    endStage()
+    result := "success"
+    if err != nil {
+        result = "failure"
+    }
+    common.IncMetric(MetricAnvilMountFallbacks, map[string]string{"result": result})
+    return err
+}
@@ -0,0 +1,19 @@
+package driver
+
+import (
+    "testing"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+)
+
+func TestGetAnvilExport(t *testing.T) {
+    hsclient := &client.HammerspaceClient{}
+    if addr, _ := hsclient.GetAnvilPortal(); addr != "" {
+        t.Logf("Expected no Anvil without an endpoint, received %s", addr)
+        t.FailNow()
+    }
+    if export := getAnvilExport("anvil.example.com", "/pvc-a"); export != "anvil.example.com:/pvc-a" {
+        t.Logf("Expected the share exported at its path on the Anvil, received %s", export)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "io/ioutil"
+    "strconv"
+    "strings"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Backing files are sparse, so the space available in a backing share says little about how much
+// the volumes in it may grow to. The controller records the sum of the logical sizes of the
+// volumes in the extended info of their backing share, and with maxOvercommitRatio refuses to
+// create or expand volumes past that multiple of the share's capacity. Accounting is updated with
+// the lock on the backing share held.
+const (
+    ExtendedInfoAllocatedBytes     = "csi_allocated_bytes"
+    ExtendedInfoMaxOvercommitRatio = "csi_max_overcommit_ratio"
+)
+
+// isBackingShare returns whether a share was created by the plugin to hold the files of
+// file-backed volumes. Backing shares created before they were marked are known by their recorded
+// allocation.
+func isBackingShare(share *common.ShareResponse) bool {
+    if share.ExtendedInfo[ExtendedInfoCreatedBy] != common.CsiPluginName {
+        return false
+    }
+    if marked, _ := strconv.ParseBool(share.ExtendedInfo[ExtendedInfoBackingShare]); marked {
+        return true
+    }
+    _, allocated := share.ExtendedInfo[ExtendedInfoAllocatedBytes]
+    return allocated
+}
+
+// listBackingFiles returns the logical size of the files of volumes in the mounted backing share
+// at backingDir by their name. The directories the plugin keeps its state in are hidden, and so
+// are the files of clones in progress, which are not volumes yet.
+func listBackingFiles(backingDir string) (map[string]int64, error) {
+    files, err := readBackingFiles(backingDir)
+    if err != nil {
+        return nil, err
+    }
+    for name := range files {
+        if strings.HasSuffix(name, cloneTempSuffix) {
+            delete(files, name)
+        }
+    }
+    return files, nil
+}
+
+// readBackingFiles returns the logical size of the files in the mounted backing share at
+// backingDir by their name, including those of clones in progress
+func readBackingFiles(backingDir string) (map[string]int64, error) {
+    files, err := ioutil.ReadDir(backingDir)
+    if err != nil {
+        return nil, err
+    }
+    sizes := map[string]int64{}
+    for _, f := range files {
+        if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+            continue
+        }
+        sizes[f.Name()] = f.Size()
+    }
+    return sizes, nil
+}
+
+// sumBackingFileSizes returns the logical size of the files in the mounted backing share at
+// backingDir, including those in its trash and of clones in progress
+func sumBackingFileSizes(backingDir string) (int64, error) {
+    files, err := readBackingFiles(backingDir)
+    if err != nil {
+        return 0, err
+    }
+    total, err := sumTrashSizes(backingDir)
+    if err != nil {
+        return 0, err
+    }
+    for _, size := range files {
+        total += size
+    }
+    return total, nil
+}
+
+// getBackingShareAllocation returns the logical size of the volumes in a backing share. Shares
+// created before allocations were recorded are mounted and their files summed.
+func (d *CSIDriver) getBackingShareAllocation(backingShare *common.ShareResponse) (int64, error) {
+    if value, exists := backingShare.ExtendedInfo[ExtendedInfoAllocatedBytes]; exists {
+        allocated, err := strconv.ParseInt(value, 10, 64)
+        if err == nil {
+            return allocated, nil
+        }
+        log.Warnf("ignoring invalid %s of backing share %s, %s", ExtendedInfoAllocatedBytes, backingShare.Name, value)
+    }
+
+    defer d.scheduleBackingShareUnmount(backingShare.Name)
+    err := d.EnsureBackingShareMounted(backingShare.Name)
+    if err != nil {
+        return 0, err
+    }
+    return sumBackingFileSizes(common.StagingPath(backingShare.ExportPath))
+}
+
+// getBackingShareOvercommitRatio returns the ratio recorded on a backing share, 0 if there is none
+func getBackingShareOvercommitRatio(backingShare *common.ShareResponse) float64 {
+    ratio, _ := strconv.ParseFloat(backingShare.ExtendedInfo[ExtendedInfoMaxOvercommitRatio], 64)
+    return ratio
+}
+
+// checkBackingShareOvercommit returns an OutOfRange error if adding size bytes of volumes to a
+// backing share holding allocated bytes would exceed ratio times its capacity
+func checkBackingShareOvercommit(backingShare *common.ShareResponse, allocated, size int64, ratio float64) error {
+    if ratio <= 0 {
+        return nil
+    }
+    total := backingShare.Space.TotalBytes()
+    if total <= 0 {
+        return nil
+    }
+    if float64(allocated+size) > ratio*float64(total) {
+        return status.Errorf(codes.OutOfRange, common.BackingShareOvercommitted,
+            backingShare.Name, allocated+size, ratio, total)
+    }
+    return nil
+}
+
+// recordBackingShareAllocation records the logical size of the volumes in a backing share, and the
+// overcommit ratio to apply when they are expanded. Failing to record is only logged, the volume
+// operation itself succeeded.
+func (d *CSIDriver) recordBackingShareAllocation(backingShare *common.ShareResponse, allocated int64, ratio float64) {
+    if allocated < 0 {
+        allocated = 0
+    }
+    extendedInfo := map[string]string{
+        ExtendedInfoAllocatedBytes:     strconv.FormatInt(allocated, 10),
+        ExtendedInfoMaxOvercommitRatio: strconv.FormatFloat(ratio, 'f', -1, 64),
+    }
+    err := d.hsclient.UpdateShareExtendedInfo(backingShare.Name, extendedInfo)
+    if err != nil {
+        log.Warnf("could not record allocation of backing share %s, %v", backingShare.Name, err)
+        return
+    }
+    if backingShare.ExtendedInfo == nil {
+        backingShare.ExtendedInfo = map[string]string{}
+    }
+    for k, v := range extendedInfo {
+        backingShare.ExtendedInfo[k] = v
+    }
+}
+
+// releaseBackingShareAllocation removes the size of deleted backing files from the allocation of
+// their share
+func (d *CSIDriver) releaseBackingShareAllocation(backingShareName string, size int64) {
+    if size <= 0 {
+        return
+    }
+    backingShare, err := d.hsclient.GetShare(backingShareName)
+    if err != nil || backingShare == nil {
+        log.Warnf("could not update allocation of backing share %s, %v", backingShareName, err)
+        return
+    }
+    value, exists := backingShare.ExtendedInfo[ExtendedInfoAllocatedBytes]
+    if !exists {
+        return
+    }
+    allocated, _ := strconv.ParseInt(value, 10, 64)
+    d.recordBackingShareAllocation(backingShare, allocated-size, getBackingShareOvercommitRatio(backingShare))
+}
@@ -0,0 +1,53 @@
+package driver
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestSumBackingFileSizes(t *testing.T) {
+    backingDir, err := ioutil.TempDir("", "backing")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(backingDir)
+    os.MkdirAll(filepath.Join(backingDir, freezeDirName), 0755)
+    ioutil.WriteFile(filepath.Join(backingDir, "vol1"), []byte{}, 0644)
+    os.Truncate(filepath.Join(backingDir, "vol1"), 1000)
+    ioutil.WriteFile(filepath.Join(backingDir, "vol2"), []byte{}, 0644)
+    os.Truncate(filepath.Join(backingDir, "vol2"), 24)
+
+    total, err := sumBackingFileSizes(backingDir)
+    if err != nil || total != 1024 {
+        t.Logf("Expected 1024 bytes, got %d, %v", total, err)
+        t.FailNow()
+    }
+}
+
+func TestCheckBackingShareOvercommit(t *testing.T) {
+    backingShare := &common.ShareResponse{
+        Name:  "backing",
+        Space: common.ShareSpaceResponse{Total: 1000},
+    }
+    if err := checkBackingShareOvercommit(backingShare, 1000, 1000, 2); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    err := checkBackingShareOvercommit(backingShare, 1000, 1001, 2)
+    if status.Code(err) != codes.OutOfRange {
+        t.Logf("Expected OutOfRange, got %v", err)
+        t.FailNow()
+    }
+    if err := checkBackingShareOvercommit(backingShare, 1000, 1001, 0); err != nil {
+        t.Logf("Expected no limit without a ratio, %v", err)
+        t.FailNow()
+    }
+}
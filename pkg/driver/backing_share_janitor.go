@@ -0,0 +1,74 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "sync"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Unpublishing or deleting a file-backed volume leaves its backing share mounted if other volumes
+// use it. Rather than check inline, which delays the RPC, the check and unmount run in the
+// background common.BackingShareUnmountDelay after the last release of the share. A share which
+// is used again meanwhile stays mounted, saving a remount.
+type backingShareJanitor struct {
+    lock   sync.Mutex
+    timers map[string]*time.Timer // backing share name -> pending unmount
+}
+
+// scheduleBackingShareUnmount unmounts the backing share later if it is then unused. Without a
+// delay it is unmounted immediately, the caller must then hold the lock on the backing share.
+func (d *CSIDriver) scheduleBackingShareUnmount(backingShareName string) {
+    if common.BackingShareUnmountDelay <= 0 {
+        if _, err := d.UnmountBackingShareIfUnused(backingShareName); err != nil {
+            log.Errorf("could not unmount backing share %s, %v", backingShareName, err)
+        }
+        return
+    }
+
+    d.unmountJanitor.lock.Lock()
+    defer d.unmountJanitor.lock.Unlock()
+    if d.unmountJanitor.timers == nil {
+        d.unmountJanitor.timers = map[string]*time.Timer{}
+    }
+    if timer, exists := d.unmountJanitor.timers[backingShareName]; exists {
+        timer.Reset(common.BackingShareUnmountDelay)
+        return
+    }
+    d.unmountJanitor.timers[backingShareName] = time.AfterFunc(common.BackingShareUnmountDelay, func() {
+        d.unmountUnusedBackingShare(backingShareName)
+    })
+}
+
+func (d *CSIDriver) unmountUnusedBackingShare(backingShareName string) {
+    d.unmountJanitor.lock.Lock()
+    delete(d.unmountJanitor.timers, backingShareName)
+    d.unmountJanitor.lock.Unlock()
+
+    defer d.releaseVolumeLock(backingShareName)
+    d.getVolumeLock(backingShareName)
+    unmounted, err := d.UnmountBackingShareIfUnused(backingShareName)
+    if err != nil {
+        log.Errorf("could not unmount backing share %s, %v", backingShareName, err)
+    } else if unmounted {
+        log.Infof("unmounted unused backing share %s", backingShareName)
+    }
+}
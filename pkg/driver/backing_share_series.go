@@ -0,0 +1,97 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "io/ioutil"
+    "strings"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// With maxVolumesPerBackingShare, file-backed volumes are spread over a series of backing shares
+// named <backing share>, <backing share>-2, <backing share>-3 and so on. Each share of the series
+// holds at most the maximum number of volumes, the next share is created once all are full.
+
+// getBackingShareSeriesName returns the name of the index'th backing share in the series, starting at 1
+func getBackingShareSeriesName(baseName string, index int) string {
+    if index <= 1 {
+        return baseName
+    }
+    return fmt.Sprintf("%s-%d", baseName, index)
+}
+
+// listBackingShareVolumes returns the names of the volumes in the mounted backing share at
+// backingDir, including clones which are still being copied
+func listBackingShareVolumes(backingDir string) ([]string, error) {
+    files, err := ioutil.ReadDir(backingDir)
+    if err != nil {
+        return nil, err
+    }
+    volumes := []string{}
+    for _, f := range files {
+        // Skip the directories used to coordinate with the nodes, such as .csi-freeze
+        if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+            continue
+        }
+        volumes = append(volumes, strings.TrimSuffix(f.Name(), cloneTempSuffix))
+    }
+    return volumes, nil
+}
+
+// selectBackingShare returns the share of the series to create volumeName in. This is the share
+// already holding the volume if there is one, otherwise the first share with room for another volume.
+// It must be called with the lock on the series held.
+func (d *CSIDriver) selectBackingShare(baseName, volumeName string, maxVolumes int) (string, error) {
+    selected := ""
+    for index := 1; ; index++ {
+        name := getBackingShareSeriesName(baseName, index)
+        backingShare, err := d.hsclient.GetShare(name)
+        if err != nil {
+            return "", status.Error(codes.Internal, err.Error())
+        }
+        if backingShare == nil {
+            if selected == "" {
+                log.Infof("backing shares %s to %s are full, using %s", baseName,
+                    getBackingShareSeriesName(baseName, index-1), name)
+                selected = name
+            }
+            return selected, nil
+        }
+
+        err = d.EnsureBackingShareMounted(name)
+        if err != nil {
+            return "", err
+        }
+        volumes, err := listBackingShareVolumes(common.StagingPath(backingShare.ExportPath))
+        d.scheduleBackingShareUnmount(name)
+        if err != nil {
+            return "", status.Error(codes.Internal, err.Error())
+        }
+        if IsValueInList(volumeName, volumes) {
+            return name, nil
+        }
+        if selected == "" && len(volumes) < maxVolumes {
+            selected = name
+        }
+    }
+}
@@ -0,0 +1,46 @@
+package driver
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "testing"
+)
+
+func TestGetBackingShareSeriesName(t *testing.T) {
+    for index, expected := range map[int]string{1: "backing", 2: "backing-2", 10: "backing-10"} {
+        if actual := getBackingShareSeriesName("backing", index); actual != expected {
+            t.Logf("Expected: %s", expected)
+            t.Logf("Actual: %s", actual)
+            t.FailNow()
+        }
+    }
+}
+
+func TestListBackingShareVolumes(t *testing.T) {
+    backingDir, err := ioutil.TempDir("", "backing")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(backingDir)
+    os.MkdirAll(filepath.Join(backingDir, freezeDirName), 0755)
+    for _, f := range []string{"vol1", "vol2", "vol3" + cloneTempSuffix} {
+        ioutil.WriteFile(filepath.Join(backingDir, f), []byte{}, 0644)
+    }
+
+    volumes, err := listBackingShareVolumes(backingDir)
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    sort.Strings(volumes)
+    expected := []string{"vol1", "vol2", "vol3"}
+    if !reflect.DeepEqual(volumes, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", volumes)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "strconv"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "google.golang.org/grpc/status"
+)
+
+// Attributes logged with every gRPC call, so calls can be filtered the same way whatever the RPC
+const (
+    CallAttributeCode          = "code"
+    CallAttributeVolumeID      = "volumeId"
+    CallAttributeSnapshotID    = "snapshotId"
+    CallAttributeVolumeMode    = "volumeMode"
+    CallAttributeFSType        = "fsType"
+    CallAttributeBackingShare  = "backingShare"
+    CallAttributeRequiredBytes = "requiredBytes"
+    CallAttributeLimitBytes    = "limitBytes"
+)
+
+// setCapabilityAttributes records the volume mode and filesystem of a capability. fsType is
+// the filesystem from the volume parameters, used when the capability does not specify one.
+func setCapabilityAttributes(attributes map[string]string, capability *csi.VolumeCapability, fsType string) {
+    if capability.GetBlock() != nil {
+        attributes[CallAttributeVolumeMode] = "Block"
+    } else if mount := capability.GetMount(); mount != nil {
+        attributes[CallAttributeVolumeMode] = "Filesystem"
+        if mount.GetFsType() != "" {
+            fsType = mount.GetFsType()
+        } else if fsType == "" {
+            fsType = "nfs"
+        }
+        attributes[CallAttributeFSType] = fsType
+    }
+}
+
+// setCapacityAttributes records a capacity range, which is optional in all requests carrying one
+func setCapacityAttributes(attributes map[string]string, capacityRange *csi.CapacityRange) {
+    if capacityRange == nil {
+        return
+    }
+    attributes[CallAttributeRequiredBytes] = strconv.FormatInt(capacityRange.GetRequiredBytes(), 10)
+    attributes[CallAttributeLimitBytes] = strconv.FormatInt(capacityRange.GetLimitBytes(), 10)
+}
+
+// setBackingShareParamAttributes records the backing share from the volume parameters, once the
+// volume mode and filesystem are known
+func setBackingShareParamAttributes(attributes map[string]string, params map[string]string) {
+    if attributes[CallAttributeVolumeMode] == "Block" {
+        attributes[CallAttributeBackingShare] = params["blockBackingShareName"]
+    } else if attributes[CallAttributeVolumeMode] == "Filesystem" && attributes[CallAttributeFSType] != "nfs" {
+        attributes[CallAttributeBackingShare] = params["mountBackingShareName"]
+    }
+}
+
+// setVolumeIDAttributes records a volume ID, and the backing share for file-backed volumes whose
+// IDs are the path of their file in the backing share
+func setVolumeIDAttributes(attributes map[string]string, volumeID string) {
+    if volumeID == "" {
+        return
+    }
+    attributes[CallAttributeVolumeID] = volumeID
+    if id, err := ParseVolumeID(volumeID); err == nil && id.IsFileBacked() {
+        attributes[CallAttributeBackingShare] = id.BackingShare
+    }
+}
+
+// getCallAttributes returns the attributes of a gRPC call from its request and outcome
+func getCallAttributes(request interface{}, err error) map[string]string {
+    attributes := map[string]string{
+        CallAttributeCode: status.Code(err).String(),
+    }
+    switch req := request.(type) {
+    case *csi.CreateVolumeRequest:
+        params := req.GetParameters()
+        for _, capability := range req.GetVolumeCapabilities() {
+            setCapabilityAttributes(attributes, capability, params["fsType"])
+        }
+        setCapacityAttributes(attributes, req.GetCapacityRange())
+        setBackingShareParamAttributes(attributes, params)
+    case *csi.DeleteVolumeRequest:
+        setVolumeIDAttributes(attributes, req.GetVolumeId())
+    case *csi.ControllerExpandVolumeRequest:
+        setVolumeIDAttributes(attributes, req.GetVolumeId())
+        setCapabilityAttributes(attributes, req.GetVolumeCapability(), "")
+        setCapacityAttributes(attributes, req.GetCapacityRange())
+    case *csi.ValidateVolumeCapabilitiesRequest:
+        setVolumeIDAttributes(attributes, req.GetVolumeId())
+        for _, capability := range req.GetVolumeCapabilities() {
+            setCapabilityAttributes(attributes, capability, req.GetParameters()["fsType"])
+        }
+    case *csi.CreateSnapshotRequest:
+        setVolumeIDAttributes(attributes, req.GetSourceVolumeId())
+    case *csi.DeleteSnapshotRequest:
+        attributes[CallAttributeSnapshotID] = req.GetSnapshotId()
+    case *csi.GetCapacityRequest:
+        params := req.GetParameters()
+        for _, capability := range req.GetVolumeCapabilities() {
+            setCapabilityAttributes(attributes, capability, params["fsType"])
+        }
+        setBackingShareParamAttributes(attributes, params)
+    }
+    for key, value := range attributes {
+        if value == "" {
+            delete(attributes, key)
+        }
+    }
+    return attributes
+}
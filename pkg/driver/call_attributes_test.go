@@ -0,0 +1,72 @@
+package driver
+
+import (
+    "reflect"
+    "testing"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestGetCallAttributes(t *testing.T) {
+    // CapacityRange is optional and must not be dereferenced
+    request := &csi.CreateVolumeRequest{
+        Name: "test-volume",
+        VolumeCapabilities: []*csi.VolumeCapability{{
+            AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+        }},
+        Parameters: map[string]string{"fsType": "xfs", "mountBackingShareName": "backing"},
+    }
+    attributes := getCallAttributes(request, status.Error(codes.OutOfRange, "out of capacity"))
+    expected := map[string]string{
+        CallAttributeCode:         "OutOfRange",
+        CallAttributeVolumeMode:   "Filesystem",
+        CallAttributeFSType:       "xfs",
+        CallAttributeBackingShare: "backing",
+    }
+    if !reflect.DeepEqual(attributes, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", attributes)
+        t.FailNow()
+    }
+
+    attributes = getCallAttributes(&csi.ControllerExpandVolumeRequest{
+        VolumeId:      "/backing/test-volume",
+        CapacityRange: &csi.CapacityRange{RequiredBytes: 1073741824},
+    }, nil)
+    expected = map[string]string{
+        CallAttributeCode:          "OK",
+        CallAttributeVolumeID:      "/backing/test-volume",
+        CallAttributeBackingShare:  "backing",
+        CallAttributeRequiredBytes: "1073741824",
+        CallAttributeLimitBytes:    "0",
+    }
+    if !reflect.DeepEqual(attributes, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", attributes)
+        t.FailNow()
+    }
+}
+
+func TestStripSecrets(t *testing.T) {
+    request := &csi.DeleteVolumeRequest{
+        VolumeId: "/pvc-1",
+        Secrets:  map[string]string{"username": "tenant", "password": "secret"},
+    }
+    stripped, _ := stripSecrets(request).(map[string]interface{})
+    expected := map[string]interface{}{"volume_id": "/pvc-1", "secrets": strippedSecrets}
+    if !reflect.DeepEqual(stripped, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", stripped)
+        t.FailNow()
+    }
+    if request.Secrets["password"] != "secret" {
+        t.Logf("Expected the request itself to keep its secrets")
+        t.FailNow()
+    }
+    if stripSecrets(nil) != nil {
+        t.Logf("Expected no reply to be logged as null")
+        t.FailNow()
+    }
+}
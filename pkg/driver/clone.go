@@ -0,0 +1,200 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "os"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+const cloneTempSuffix = ".csi-clone"
+
+// getSourceFileSize returns the size of the file of a file-backed volume to clone
+func (d *CSIDriver) getSourceFileSize(sourceVolumeID VolumeID) (int64, error) {
+    sourceFile, err := d.hsclient.GetFile(sourceVolumeID.Path)
+    if err != nil {
+        return 0, status.Error(codes.Internal, err.Error())
+    }
+    if sourceFile == nil {
+        return 0, status.Error(codes.NotFound, common.SourceVolumeNotFound)
+    }
+    return sourceFile.Size, nil
+}
+
+// getSourceShareSize returns the size of the share of a share-backed volume to clone
+func (d *CSIDriver) getSourceShareSize(sourceVolumeID VolumeID) (int64, error) {
+    sourceShare, err := d.hsclient.GetShare(sourceVolumeID.Name)
+    if err != nil {
+        return 0, status.Error(codes.Internal, err.Error())
+    }
+    if sourceShare == nil || sourceShare.ShareState == "REMOVED" {
+        return 0, status.Error(codes.NotFound, common.SourceVolumeNotFound)
+    }
+    return sourceShare.Size, nil
+}
+
+// cloneShare creates the share of a share-backed volume from a snapshot of the source volume's
+// share, taken for the clone and deleted once the share is created so it does not keep the source
+// from being deleted
+func (d *CSIDriver) cloneShare(hsVolume *common.HSVolume) error {
+    sourceVolumeID, _ := ParseVolumeID(hsVolume.SourceVolumePath)
+    snapshot, err := d.hsclient.SnapshotShare(sourceVolumeID.Name)
+    if err != nil {
+        return status.Errorf(codes.Internal, common.CloneFailed, hsVolume.SourceVolumePath, err)
+    }
+    defer func() {
+        if err := d.hsclient.DeleteShareSnapshot(sourceVolumeID.Name, snapshot); err != nil {
+            log.Warnf("could not delete snapshot %s of share %s taken to clone it, %v", snapshot, sourceVolumeID.Name, err)
+        }
+    }()
+
+    log.Infof("cloning %s to %s from snapshot %s", hsVolume.SourceVolumePath, hsVolume.Path, snapshot)
+    err = d.hsclient.CreateShareFromSnapshot(
+        hsVolume.Name,
+        hsVolume.ExportPath,
+        hsVolume.Size,
+        nil,
+        hsVolume.ExportOptions,
+        hsVolume.DeleteDelay,
+        hsVolume.Comment,
+        snapshot,
+        getShareBackedExtendedInfo(hsVolume),
+        d.trackRestoreProgress(hsVolume.Path),
+    )
+    d.forgetRestoreProgress(hsVolume.Path)
+    if err != nil {
+        return status.Errorf(codes.Internal, common.CloneFailed, hsVolume.SourceVolumePath, err)
+    }
+    return nil
+}
+
+// cloneTask is a background copy of a file-backed volume, possibly to another backing share
+type cloneTask struct {
+    sourceShareName string
+    destShareName   string
+    done            bool
+    err             error
+}
+
+// isBackingShareCloning reports whether a clone is copying to or from the backing share,
+// in which case it must stay mounted
+func (d *CSIDriver) isBackingShareCloning(backingShareName string) bool {
+    d.clonesLock.Lock()
+    defer d.clonesLock.Unlock()
+    for _, task := range d.clones {
+        if !task.done && (task.sourceShareName == backingShareName || task.destShareName == backingShareName) {
+            return true
+        }
+    }
+    return false
+}
+
+// isVolumeCloning reports whether the file-backed volume at volumePath is the destination of a
+// clone which has not been moved into place yet
+func (d *CSIDriver) isVolumeCloning(volumePath string) bool {
+    d.clonesLock.Lock()
+    defer d.clonesLock.Unlock()
+    _, exists := d.clones[volumePath]
+    return exists
+}
+
+// cloneDeviceFile copies the source volume's file into the backing share in the background. The
+// source may be in use, so a snapshot of it is taken and restored by the Anvil, rather than the
+// live file copied. The copy is made to a temporary file which is renamed into place once
+// complete, so the volume only appears at its path with all of its data. Until then an error is
+// returned for the CO to retry.
+func (d *CSIDriver) cloneDeviceFile(backingShare *common.ShareResponse, hsVolume *common.HSVolume) error {
+    deviceFile := common.StagingPath(hsVolume.Path)
+    tempFile := deviceFile + cloneTempSuffix
+
+    d.clonesLock.Lock()
+    task, exists := d.clones[hsVolume.Path]
+    if exists && task.done {
+        delete(d.clones, hsVolume.Path)
+    }
+    d.clonesLock.Unlock()
+
+    if exists && !task.done {
+        return status.Errorf(codes.Aborted, common.CloneInProgress, hsVolume.SourceVolumePath, hsVolume.Path)
+    }
+    if exists && task.err != nil {
+        // Start over on the next attempt
+        return status.Errorf(codes.Internal, common.CloneFailed, hsVolume.SourceVolumePath, task.err)
+    }
+    if exists {
+        // Mount to make the finished copy visible, the clone no longer holds the share mounted
+        err := d.EnsureBackingShareMounted(backingShare.Name)
+        if err != nil {
+            return err
+        }
+        log.Infof("clone of %s complete, moving it into place at %s", hsVolume.SourceVolumePath, hsVolume.Path)
+        if err := os.Rename(tempFile, deviceFile); err != nil {
+            return status.Errorf(codes.Internal, common.CloneFailed, hsVolume.SourceVolumePath, err)
+        }
+        return nil
+    }
+
+    sourceVolumeID, _ := ParseVolumeID(hsVolume.SourceVolumePath)
+    task = &cloneTask{
+        sourceShareName: sourceVolumeID.BackingShare,
+        destShareName:   backingShare.Name,
+    }
+    d.clonesLock.Lock()
+    d.clones[hsVolume.Path] = task
+    d.clonesLock.Unlock()
+
+    // Make sure the share is mounted before the caller's deferred unmount runs, the temporary file
+    // is removed through it if the copy fails
+    if err := d.EnsureBackingShareMounted(backingShare.Name); err != nil {
+        d.finishClone(task, err)
+        return err
+    }
+    snapshot, err := d.hsclient.SnapshotFile(hsVolume.SourceVolumePath)
+    if err != nil {
+        d.finishClone(task, err)
+        return status.Errorf(codes.Internal, common.CloneFailed, hsVolume.SourceVolumePath, err)
+    }
+
+    log.Infof("cloning %s to %s from snapshot %s", hsVolume.SourceVolumePath, hsVolume.Path, snapshot)
+    go func() {
+        err := d.hsclient.RestoreFileSnapToDestination(snapshot, hsVolume.Path+cloneTempSuffix)
+        if err != nil {
+            log.Errorf("failed to clone %s to %s, %v", hsVolume.SourceVolumePath, hsVolume.Path, err)
+            os.Remove(tempFile)
+        }
+        if err := d.hsclient.DeleteFileSnapshot(hsVolume.SourceVolumePath, snapshot); err != nil {
+            log.Warnf("could not delete snapshot %s of %s taken to clone it, %v", snapshot, hsVolume.SourceVolumePath, err)
+        }
+        d.finishClone(task, err)
+    }()
+    return status.Errorf(codes.Aborted, common.CloneInProgress, hsVolume.SourceVolumePath, hsVolume.Path)
+}
+
+func (d *CSIDriver) finishClone(task *cloneTask, err error) {
+    d.clonesLock.Lock()
+    task.done = true
+    task.err = err
+    d.clonesLock.Unlock()
+
+    d.scheduleBackingShareUnmount(task.sourceShareName)
+    d.scheduleBackingShareUnmount(task.destShareName)
+}
@@ -0,0 +1,154 @@
+package driver
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestCloneDeviceFile(t *testing.T) {
+    task := &cloneTask{sourceShareName: "hdd-backing", destShareName: "nvme-backing"}
+    d := &CSIDriver{sharedState: &sharedState{clones: map[string]*cloneTask{"/nvme-backing/clone": task}}}
+    backingShare := &common.ShareResponse{Name: "nvme-backing", ExportPath: "/nvme-backing"}
+    hsVolume := &common.HSVolume{Path: "/nvme-backing/clone", SourceVolumePath: "/hdd-backing/source"}
+
+    if !d.isBackingShareCloning("hdd-backing") || !d.isBackingShareCloning("nvme-backing") {
+        t.Logf("Expected source and destination shares to be in use by the clone")
+        t.FailNow()
+    }
+    if d.isBackingShareCloning("other-backing") {
+        t.Logf("Expected other shares not to be in use by the clone")
+        t.FailNow()
+    }
+
+    err := d.cloneDeviceFile(backingShare, hsVolume)
+    if status.Code(err) != codes.Aborted {
+        t.Logf("Expected clone to be in progress, %v", err)
+        t.FailNow()
+    }
+
+    task.done = true
+    task.err = errors.New("No space left on device")
+    err = d.cloneDeviceFile(backingShare, hsVolume)
+    if status.Code(err) != codes.Internal {
+        t.Logf("Expected clone failure to be reported, %v", err)
+        t.FailNow()
+    }
+    if _, exists := d.clones[hsVolume.Path]; exists || d.isBackingShareCloning("hdd-backing") {
+        t.Logf("Expected failed clone to be forgotten")
+        t.FailNow()
+    }
+}
+
+func TestCloneDeviceFileFromSnapshot(t *testing.T) {
+    mux := http.NewServeMux()
+    d := newFakeDriver(t, mux)
+    mux.HandleFunc(client.BasePath+"/shares/nvme-backing", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `{"name": "nvme-backing", "path": "/nvme-backing"}`)
+    })
+    snapshotted := ""
+    mux.HandleFunc(client.BasePath+"/file-snapshots/create", func(w http.ResponseWriter, r *http.Request) {
+        snapshotted = r.URL.Query().Get("filename-expression")
+        fmt.Fprintf(w, `["2024-01-01-00-00-00-source"]`)
+    })
+    restored := make(chan string, 1)
+    mux.HandleFunc(client.BasePath+"/file-snapshots/", func(w http.ResponseWriter, r *http.Request) {
+        restored <- r.URL.Path
+    })
+    snapshotDeleted := make(chan bool, 1)
+    mux.HandleFunc(client.BasePath+"/file-snapshots/delete", func(w http.ResponseWriter, r *http.Request) {
+        snapshotDeleted <- true
+    })
+    defer func(f func(string) (string, bool, error)) { common.GetMountSource = f }(common.GetMountSource)
+    common.GetMountSource = func(string) (string, bool, error) { return "", true, nil }
+    defer func(f func(string) error) { isMountResponsive = f }(isMountResponsive)
+    isMountResponsive = func(string) error { return nil }
+
+    backingShare := &common.ShareResponse{Name: "nvme-backing", ExportPath: "/nvme-backing"}
+    hsVolume := &common.HSVolume{Path: "/nvme-backing/clone", SourceVolumePath: "/hdd-backing/source"}
+    err := d.cloneDeviceFile(backingShare, hsVolume)
+    if status.Code(err) != codes.Aborted {
+        t.Logf("Expected clone to be in progress, %v", err)
+        t.FailNow()
+    }
+    // The live file is not copied, a snapshot of it is restored next to the clone
+    if snapshotted != "/hdd-backing/source" {
+        t.Logf("Expected the source to be snapshotted, received %s", snapshotted)
+        t.FailNow()
+    }
+    expected := client.BasePath + "/file-snapshots/2024-01-01-00-00-00-source/nvme-backing/clone" + cloneTempSuffix
+    if path := <-restored; path != expected {
+        t.Logf("Expected the snapshot to be restored to %s, received %s", expected, path)
+        t.FailNow()
+    }
+    <-snapshotDeleted
+}
+
+func TestCheckVolumeTasksDuringClone(t *testing.T) {
+    task := &cloneTask{sourceShareName: "hdd-backing", destShareName: "nvme-backing"}
+    d := &CSIDriver{sharedState: &sharedState{clones: map[string]*cloneTask{"/nvme-backing/clone": task}}}
+
+    id, _ := ParseVolumeID("/nvme-backing/clone")
+    if err := d.checkVolumeTasks(id); status.Code(err) != codes.Aborted {
+        t.Logf("Expected expansion of a cloning volume to be aborted, %v", err)
+        t.FailNow()
+    }
+    // A finished copy is not in place until CreateVolume is retried
+    task.done = true
+    if err := d.checkVolumeTasks(id); status.Code(err) != codes.Aborted {
+        t.Logf("Expected expansion of a clone not yet in place to be aborted, %v", err)
+        t.FailNow()
+    }
+
+    id, _ = ParseVolumeID("/nvme-backing/other")
+    if err := d.checkVolumeTasks(id); err != nil {
+        t.Logf("Expected other volumes to be expandable, %v", err)
+        t.FailNow()
+    }
+}
+
+func TestCloneShare(t *testing.T) {
+    mux := http.NewServeMux()
+    d := newFakeDriver(t, mux)
+
+    snapshotDeleted := false
+    var created common.ShareRequest
+    mux.HandleFunc(client.BasePath+"/share-snapshots/snapshot-create/source", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, "2024-01-01T00:00:00Z")
+    })
+    mux.HandleFunc(client.BasePath+"/share-snapshots/snapshot-delete/source/2024-01-01T00:00:00Z", func(w http.ResponseWriter, r *http.Request) {
+        snapshotDeleted = true
+    })
+    mux.HandleFunc(client.BasePath+"/shares", func(w http.ResponseWriter, r *http.Request) {
+        json.NewDecoder(r.Body).Decode(&created)
+        w.Header().Set("Location", d.hsclient.Endpoint()+client.BasePath+"/tasks/clone-1")
+        w.WriteHeader(202)
+    })
+    mux.HandleFunc(client.BasePath+"/tasks/clone-1", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `{"uuid": "clone-1", "status": "COMPLETED", "exitValue": "0", "progress": 100}`)
+    })
+    mux.HandleFunc(client.BasePath+"/shares/clone/objective-set", func(w http.ResponseWriter, r *http.Request) {})
+
+    hsVolume := &common.HSVolume{Name: "clone", Path: "/clone", ExportPath: "/clone", SourceVolumePath: "/source", DeleteDelay: -1}
+
+    if err := d.cloneShare(hsVolume); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if created.Name != "clone" || created.ExportPath != "/clone" {
+        t.Logf("Expected share clone to be created, received %v", created)
+        t.FailNow()
+    }
+    if !snapshotDeleted {
+        t.Logf("Expected snapshot taken for the clone to be deleted")
+        t.FailNow()
+    }
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// When the free capacity of the cluster cannot be read, common.CapacityCheckPolicy decides
+// whether CreateVolume and GetCapacity fail:
+//   strict   fail, the default
+//   cached   use the capacity last read from the cluster, fail if there is none
+//   allow    use the capacity last read from the cluster, or skip the capacity check of CreateVolume
+const (
+    CapacityCheckStrict = "strict"
+    CapacityCheckCached = "cached"
+    CapacityCheckAllow  = "allow"
+)
+
+type clusterCapacityCache struct {
+    lock    sync.Mutex
+    free    int64
+    fetched time.Time
+}
+
+// ValidateCapacityCheckPolicy returns an error if policy is not a supported capacity check policy
+func ValidateCapacityCheckPolicy(policy string) error {
+    switch policy {
+    case CapacityCheckStrict, CapacityCheckCached, CapacityCheckAllow:
+        return nil
+    }
+    return fmt.Errorf("capacity check policy must be '%s', '%s' or '%s', received '%s'",
+        CapacityCheckStrict, CapacityCheckCached, CapacityCheckAllow, policy)
+}
+
+// get returns the free capacity read with fetch. When it fails, the policy decides whether the
+// last capacity read is returned, and whether the check may be skipped, in which case checked
+// is false.
+func (c *clusterCapacityCache) get(fetch func() (int64, error), policy string) (free int64, checked bool, err error) {
+    free, err = fetch()
+
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    if err == nil {
+        c.free = free
+        c.fetched = time.Now()
+        return free, true, nil
+    }
+    if policy == CapacityCheckStrict || policy == "" {
+        return 0, false, status.Error(codes.Internal, err.Error())
+    }
+    if !c.fetched.IsZero() {
+        log.Warnf("could not read the free capacity of the cluster, using the capacity read %v ago, %v",
+            time.Since(c.fetched).Round(time.Second), err)
+        return c.free, true, nil
+    }
+    if policy == CapacityCheckAllow {
+        log.Warnf("could not read the free capacity of the cluster, skipping the capacity check, %v", err)
+        return 0, false, nil
+    }
+    return 0, false, status.Error(codes.Internal, err.Error())
+}
+
+// getClusterAvailableCapacity returns the free capacity of the cluster, subject to
+// common.CapacityCheckPolicy when it cannot be read
+func (d *CSIDriver) getClusterAvailableCapacity() (int64, bool, error) {
+    return d.clusterCapacity.get(d.hsclient.GetClusterAvailableCapacity, common.CapacityCheckPolicy)
+}
+
+// getReadClusterCapacity returns the free capacity of the cluster for GetCapacity, an Unavailable
+// error if the policy allows creating volumes without reading it, since there is no value to report
+func (d *CSIDriver) getReadClusterCapacity() (int64, error) {
+    available, checked, err := d.getClusterAvailableCapacity()
+    if err != nil {
+        return 0, err
+    }
+    if !checked {
+        return 0, status.Error(codes.Unavailable, common.ClusterCapacityUnavailable)
+    }
+    return available, nil
+}
+
+// isTopologyServed reports whether volumes are accessible from the nodes in a topology segment of
+// GetCapacity. Hammerspace storage is reached over NFS from data-portals and other nodes alike,
+// so every segment of the keys reported by NodeGetInfo shares the capacity of the cluster. Nodes
+// in segments of other keys are not served by this plugin.
+func isTopologyServed(topology *csi.Topology) (bool, error) {
+    for key, value := range topology.GetSegments() {
+        if key != common.TopologyKeyDataPortal {
+            return false, nil
+        }
+        if value != "true" && value != "false" {
+            return false, status.Errorf(codes.InvalidArgument, common.InvalidTopologySegment, key, value)
+        }
+    }
+    return true, nil
+}
@@ -0,0 +1,73 @@
+package driver
+
+import (
+    "errors"
+    "testing"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestClusterCapacityCache(t *testing.T) {
+    failing := func() (int64, error) { return 0, errors.New("connection refused") }
+
+    for _, policy := range []string{CapacityCheckStrict, CapacityCheckCached} {
+        c := &clusterCapacityCache{}
+        if _, _, err := c.get(failing, policy); status.Code(err) != codes.Internal {
+            t.Logf("Expected %s policy to fail without a cached capacity, %v", policy, err)
+            t.FailNow()
+        }
+    }
+    c := &clusterCapacityCache{}
+    free, checked, err := c.get(failing, CapacityCheckAllow)
+    if err != nil || checked {
+        t.Logf("Expected allow policy to skip the check, %d %v %v", free, checked, err)
+        t.FailNow()
+    }
+
+    c.get(func() (int64, error) { return 1000, nil }, CapacityCheckStrict)
+    if _, _, err := c.get(failing, CapacityCheckStrict); err == nil {
+        t.Logf("Expected strict policy to ignore the cached capacity")
+        t.FailNow()
+    }
+    for _, policy := range []string{CapacityCheckCached, CapacityCheckAllow} {
+        free, checked, err := c.get(failing, policy)
+        if err != nil || !checked || free != 1000 {
+            t.Logf("Expected %s policy to use the cached capacity, %d %v %v", policy, free, checked, err)
+            t.FailNow()
+        }
+    }
+
+    if ValidateCapacityCheckPolicy("lenient") == nil {
+        t.Logf("Expected unknown policy to be rejected")
+        t.FailNow()
+    }
+}
+
+func TestIsTopologyServed(t *testing.T) {
+    tests := []struct {
+        segments map[string]string
+        served   bool
+        code     codes.Code
+    }{
+        {segments: nil, served: true},
+        {segments: map[string]string{common.TopologyKeyDataPortal: "true"}, served: true},
+        {segments: map[string]string{common.TopologyKeyDataPortal: "false"}, served: true},
+        {segments: map[string]string{"topology.kubernetes.io/zone": "a"}, served: false},
+        {segments: map[string]string{common.TopologyKeyDataPortal: "maybe"}, code: codes.InvalidArgument},
+    }
+    for _, test := range tests {
+        var topology *csi.Topology
+        if test.segments != nil {
+            topology = &csi.Topology{Segments: test.segments}
+        }
+        served, err := isTopologyServed(topology)
+        if status.Code(err) != test.code || served != test.served {
+            t.Logf("Expected %v, %v for %v, received %v, %v", test.served, test.code, test.segments, served, err)
+            t.FailNow()
+        }
+    }
+}
@@ -19,13 +19,13 @@ package driver
 import (
 	"fmt"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jpillora/backoff"
 	timestamp "google.golang.org/protobuf/types/known/timestamppb"
-	"k8s.io/kubernetes/pkg/util/slice"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	log "github.com/sirupsen/logrus"
@@ -38,50 +38,110 @@ import (
 
 const (
 	MaxNameLength int = 128
-)
 
-var (
-	recentlyCreatedSnapshots = map[string]*csi.Snapshot{}
+	// Extended info identifying the plugin and CO volume a share was created for
+	ExtendedInfoCreatedBy  = "csi_created_by_plugin_name"
+	ExtendedInfoVolumeName = "csi_volume_name"
+	// Set on the backing shares holding the files of file-backed volumes
+	ExtendedInfoBackingShare = "csi_backing_share"
+	// Set to true by administrators on shares they adopted as volumes, allowing the plugin to delete them
+	ExtendedInfoAdopted = "csi_adopted"
+	// Set on shares of volumes created with deleteSnapshots, whose snapshots are deleted with them
+	ExtendedInfoDeleteSnapshots = "csi_delete_snapshots"
+	// Set on shares of volumes created with a deleteMode, how their share is deleted with them
+	ExtendedInfoDeleteMode = "csi_delete_mode"
 )
 
 func parseVolParams(params map[string]string) (common.HSVolumeParameters, error) {
 	vParams := common.HSVolumeParameters{}
+	errs := parameterErrors{}
+
+	strict := false
+	if strictParam, exists := params["strictParameters"]; exists {
+		var err error
+		strict, err = strconv.ParseBool(strictParam)
+		if err != nil {
+			errs.addf(common.InvalidStrictParameters, strictParam)
+		}
+	}
+	errs.checkUnknown(params, volumeParameterNames, strict)
 
 	if deleteDelayParam, exists := params["deleteDelay"]; exists {
 		var err error
 		vParams.DeleteDelay, err = strconv.ParseInt(deleteDelayParam, 10, 64)
 		if err != nil {
-			return vParams, status.Errorf(codes.InvalidArgument, common.InvalidDeleteDelay, deleteDelayParam)
+			errs.addf(common.InvalidDeleteDelay, deleteDelayParam)
 		}
 
 	} else {
 		vParams.DeleteDelay = -1
 	}
 
+	if deleteModeParam, exists := params["deleteMode"]; exists {
+		switch deleteModeParam {
+		case common.DeleteModePurge, common.DeleteModeExportOnly, common.DeleteModeRetain:
+			vParams.DeleteMode = deleteModeParam
+		default:
+			errs.addf(common.InvalidDeleteMode, deleteModeParam)
+		}
+	}
+
+	if deleteSnapshotsParam, exists := params["deleteSnapshots"]; exists {
+		var err error
+		vParams.DeleteSnapshots, err = strconv.ParseBool(deleteSnapshotsParam)
+		if err != nil {
+			errs.addf(common.InvalidDeleteSnapshots, deleteSnapshotsParam)
+		}
+	}
+
 	if commentParam, exists := params["comment"]; exists {
 		// Max comment length in system manager is 255
 		if len(commentParam) > 255 {
-			return vParams, status.Errorf(codes.InvalidArgument, common.InvalidCommentSize)
+			errs.addf(common.InvalidCommentSize)
 		} else {
 			vParams.Comment = commentParam
 		}
 	} else {
-		vParams.Comment = "Created by CSI driver"
+		vParams.Comment = common.DefaultShareComment
+	}
+
+	if tierParam, exists := params["tier"]; exists {
+		tierObjectives, known := common.ObjectiveTiers[tierParam]
+		if !known {
+			errs.addf(common.InvalidObjectiveTier, tierParam, getObjectiveTierNames())
+		}
+		vParams.Objectives = append(vParams.Objectives, tierObjectives...)
 	}
 
 	if objectivesParam, exists := params["objectives"]; exists {
-		if exists {
-			splitObjectives := strings.Split(objectivesParam, ",")
-			vParams.Objectives = make([]string, 0, len(splitObjectives))
-			for _, o := range splitObjectives {
-				trimmedObj := strings.TrimSpace(o)
-				if trimmedObj != "" {
-					vParams.Objectives = append(vParams.Objectives, trimmedObj)
-				}
+		for _, o := range parseObjectiveList(objectivesParam) {
+			if !IsValueInList(o, vParams.Objectives) {
+				vParams.Objectives = append(vParams.Objectives, o)
+			}
+		}
+	}
+
+	if objectivesRemoveParam, exists := params["objectivesRemove"]; exists {
+		vParams.ObjectivesRemove = parseObjectiveList(objectivesRemoveParam)
+		for _, o := range vParams.ObjectivesRemove {
+			if IsValueInList(o, vParams.Objectives) {
+				errs.addf(common.ConflictingObjectiveRemove, o)
 			}
 		}
 	}
 
+	if objectivesReplaceParam, exists := params["objectivesReplace"]; exists {
+		var err error
+		vParams.ObjectivesReplace, err = strconv.ParseBool(objectivesReplaceParam)
+		if err != nil {
+			errs.addf(common.InvalidObjectivesReplace, objectivesReplaceParam)
+		}
+	}
+
+	if backingShareObjectivesParam, exists := params["backingShareObjectives"]; exists {
+		vParams.BackingShareObjectives = parseObjectiveList(backingShareObjectivesParam)
+	}
+
 	vParams.BlockBackingShareName = params["blockBackingShareName"]
 	vParams.MountBackingShareName = params["mountBackingShareName"]
 	vParams.FSType = params["fsType"]
@@ -94,13 +154,14 @@ func parseVolParams(params map[string]string) (common.HSVolumeParameters, error)
 				options := strings.Split(o, ",")
 				//assert options is len 3
 				if len(options) != 3 {
-					return vParams, status.Errorf(codes.InvalidArgument, common.InvalidExportOptions, o)
+					errs.addf(common.InvalidExportOptions, o)
+					continue
 				}
 
 				rootSquashStr := strings.TrimSpace(options[2])
 				rootSquash, err := strconv.ParseBool(rootSquashStr)
 				if err != nil {
-					return vParams, status.Errorf(codes.InvalidArgument, common.InvalidRootSquash, rootSquashStr)
+					errs.addf(common.InvalidRootSquash, rootSquashStr)
 				}
 
 				vParams.ExportOptions[i] = common.ShareExportOptions{
@@ -114,12 +175,10 @@ func parseVolParams(params map[string]string) (common.HSVolumeParameters, error)
 
 	if volumeNameFormat, exists := params["volumeNameFormat"]; exists {
 		if strings.Count(volumeNameFormat, "%s") != 1 {
-			return vParams, status.Error(codes.InvalidArgument,
-				"volumeNameFormat must contain \"%s\" exactly once")
+			errs.addf("volumeNameFormat must contain \"%%s\" exactly once")
 		}
 		if strings.Contains(volumeNameFormat, "/") {
-			return vParams, status.Errorf(codes.InvalidArgument,
-				"volumeNameFormat must not contain forward slashes")
+			errs.addf("volumeNameFormat must not contain forward slashes")
 		}
 		vParams.VolumeNameFormat = volumeNameFormat
 	} else {
@@ -134,7 +193,8 @@ func parseVolParams(params map[string]string) (common.HSVolumeParameters, error)
 				extendedInfo := strings.Split(m, "=")
 				//assert options is len 2
 				if len(extendedInfo) != 2 {
-					return vParams, status.Errorf(codes.InvalidArgument, common.InvalidAdditionalMetadataTags, m)
+					errs.addf(common.InvalidAdditionalMetadataTags, m)
+					continue
 				}
 				key := strings.TrimSpace(extendedInfo[0])
 				value := strings.TrimSpace(extendedInfo[1])
@@ -144,7 +204,263 @@ func parseVolParams(params map[string]string) (common.HSVolumeParameters, error)
 		}
 	}
 
-	return vParams, nil
+	if clientMountOptionsParam, exists := params["clientMountOptions"]; exists {
+		mountOptions, err := common.ParseClientMountOptions(clientMountOptionsParam)
+		if err != nil {
+			errs.add(err)
+		}
+		vParams.ClientMountOptions = mountOptions
+	}
+
+	if mountPolicyParam, exists := params["mountPolicy"]; exists {
+		mountPolicy, err := common.ParseMountPolicy(mountPolicyParam)
+		if err != nil {
+			errs.add(err)
+		}
+		if conflict := common.MountPolicyConflicts(vParams.ClientMountOptions); conflict != "" {
+			errs.addf(common.ConflictingMountPolicy, conflict)
+		}
+		vParams.MountPolicy = mountPolicy
+	}
+
+	if transportParam, exists := params["transport"]; exists {
+		if transportParam != common.TransportTCP && transportParam != common.TransportRDMA {
+			errs.addf(common.InvalidTransport, transportParam)
+		}
+		vParams.Transport = transportParam
+	}
+
+	if rdmaPortParam, exists := params["rdmaPort"]; exists {
+		port, err := strconv.Atoi(rdmaPortParam)
+		if err != nil || port < 1 || port > 65535 {
+			errs.addf(common.InvalidRDMAPort, rdmaPortParam)
+		}
+		vParams.RDMAPort = port
+	} else if vParams.Transport == common.TransportRDMA {
+		vParams.RDMAPort = common.DefaultRDMAPort
+	}
+
+	if blockPublishModeParam, exists := params["blockPublishMode"]; exists {
+		switch blockPublishModeParam {
+		case common.BlockPublishModeBind, common.BlockPublishModeDevice:
+			vParams.BlockPublishMode = blockPublishModeParam
+		default:
+			errs.addf(common.InvalidBlockPublishMode, blockPublishModeParam)
+		}
+	}
+
+	if strictObjectivesParam, exists := params["strictObjectives"]; exists {
+		var err error
+		vParams.StrictObjectives, err = strconv.ParseBool(strictObjectivesParam)
+		if err != nil {
+			errs.addf(common.InvalidStrictObjectives, strictObjectivesParam)
+		}
+	}
+
+	if fsckParam, exists := params["fsckOnStage"]; exists {
+		switch fsckParam {
+		case common.FsckOnStageCheck, common.FsckOnStageRepair:
+			vParams.FsckOnStage = fsckParam
+		default:
+			errs.addf(common.InvalidFsckOnStage, fsckParam)
+		}
+	}
+
+	if maxVolumesParam, exists := params["maxVolumesPerBackingShare"]; exists {
+		maxVolumes, err := strconv.Atoi(maxVolumesParam)
+		if err != nil || maxVolumes < 1 {
+			errs.addf(common.InvalidMaxVolumesPerBackingShare, maxVolumesParam)
+		}
+		vParams.MaxVolumesPerBackingShare = maxVolumes
+	}
+
+	if ratioParam, exists := params["maxOvercommitRatio"]; exists {
+		ratio, err := strconv.ParseFloat(ratioParam, 64)
+		if err != nil || ratio <= 0 {
+			errs.addf(common.InvalidMaxOvercommitRatio, ratioParam)
+		}
+		vParams.MaxOvercommitRatio = ratio
+	}
+
+	if prefixParam, exists := params["exportPathPrefix"]; exists {
+		prefix := strings.TrimSuffix(prefixParam, "/")
+		if !strings.HasPrefix(prefixParam, "/") || (prefix != "" && path.Clean(prefix) != prefix) {
+			errs.addf(common.InvalidExportPathPrefix, prefixParam)
+		}
+		vParams.ExportPathPrefix = prefix
+	}
+
+	return vParams, errs.err()
+}
+
+func parseObjectiveList(objectivesParam string) []string {
+	splitObjectives := strings.Split(objectivesParam, ",")
+	objectives := make([]string, 0, len(splitObjectives))
+	for _, o := range splitObjectives {
+		trimmedObj := strings.TrimSpace(o)
+		if trimmedObj != "" {
+			objectives = append(objectives, trimmedObj)
+		}
+	}
+	return objectives
+}
+
+// applyObjectiveChanges brings the objectives set at path on a share in line with the volume parameters.
+// Objectives are added to those already applied unless replace is set, in which case any other
+// objectives are cleared. Objectives listed in remove are always unset.
+func (d *CSIDriver) applyObjectiveChanges(shareName, path string,
+	applied, objectives, remove []string, replace bool) error {
+
+	if replace && len(objectives) > 0 {
+		if err := d.hsclient.SetObjectives(shareName, path, objectives, true); err != nil {
+			return err
+		}
+	} else {
+		missing := []string{}
+		for _, o := range objectives {
+			if !IsValueInList(o, applied) {
+				missing = append(missing, o)
+			}
+		}
+		if len(missing) > 0 {
+			if err := d.hsclient.SetObjectives(shareName, path, missing, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	toRemove := []string{}
+	for _, o := range remove {
+		if IsValueInList(o, applied) || replace {
+			toRemove = append(toRemove, o)
+		}
+	}
+	if len(toRemove) > 0 {
+		return d.hsclient.RemoveObjectives(shareName, path, toRemove)
+	}
+	return nil
+}
+
+// ParseDefaultExtendedInfo parses a comma separated list of key=value extended info set on every
+// created share. Keys starting with csi_ are reserved for the plugin.
+func ParseDefaultExtendedInfo(list string) (map[string]string, error) {
+	extendedInfo := map[string]string{}
+	for _, entry := range strings.Split(list, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		kv := strings.Split(entry, "=")
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("'%s' is not of the form key=value", entry)
+		}
+		key := strings.TrimSpace(kv[0])
+		if strings.HasPrefix(key, "csi_") {
+			return nil, fmt.Errorf("key '%s' is reserved for the plugin", key)
+		}
+		extendedInfo[key] = strings.TrimSpace(kv[1])
+	}
+	return extendedInfo, nil
+}
+
+// ParseObjectiveTiers parses a semicolon separated list of tier=objectives, the objectives being
+// comma separated, Ex: gold=keep-3-copies,place-on-ssd;bronze=place-on-hdd
+func ParseObjectiveTiers(list string) (map[string][]string, error) {
+	tiers := map[string][]string{}
+	for _, entry := range strings.Split(list, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		tier := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || tier == "" {
+			return nil, fmt.Errorf("'%s' is not of the form tier=objectives", entry)
+		}
+		objectives := parseObjectiveList(kv[1])
+		if len(objectives) == 0 {
+			return nil, fmt.Errorf("tier '%s' has no objectives", tier)
+		}
+		if _, exists := tiers[tier]; exists {
+			return nil, fmt.Errorf("tier '%s' is listed more than once", tier)
+		}
+		tiers[tier] = objectives
+	}
+	return tiers, nil
+}
+
+// getObjectiveTierNames returns the configured tiers in alphabetical order
+func getObjectiveTierNames() []string {
+	names := make([]string, 0, len(common.ObjectiveTiers))
+	for name := range common.ObjectiveTiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Extended info recorded on shares created for share-backed volumes, read back when the volume is deleted
+func getShareBackedExtendedInfo(hsVolume *common.HSVolume) map[string]string {
+	extendedInfo := map[string]string{}
+	if hsVolume.DeleteMode != "" {
+		extendedInfo[ExtendedInfoDeleteMode] = hsVolume.DeleteMode
+	}
+	if hsVolume.DeleteSnapshots {
+		extendedInfo[ExtendedInfoDeleteSnapshots] = "true"
+	}
+	if hsVolume.RequestName != "" {
+		extendedInfo[ExtendedInfoVolumeName] = hsVolume.RequestName
+	}
+	if hsVolume.Size > 0 {
+		extendedInfo[ExtendedInfoRequestedBytes] = strconv.FormatInt(hsVolume.Size, 10)
+	}
+	return extendedInfo
+}
+
+// getShareVolumeContext returns the volume context describing the share holding a volume, its
+// backing share for file-backed volumes
+func getShareVolumeContext(share *common.ShareResponse) map[string]string {
+	volContext := map[string]string{
+		"shareName":             share.Name,
+		VolumeContextExportPath: share.ExportPath,
+	}
+	if uuid := share.Uoid["uuid"]; uuid != "" {
+		volContext["shareUuid"] = uuid
+	}
+	objectives := make([]string, len(share.Objectives.Applied))
+	for i, o := range share.Objectives.Applied {
+		objectives[i] = o.Name
+	}
+	if len(objectives) > 0 {
+		volContext["objectives"] = strings.Join(objectives, ",")
+	}
+	return volContext
+}
+
+// checkShareOwnership returns an AlreadyExists error if an existing share was not created by this
+// plugin for the requested volume. Different volume names can map to the same share name, e.g.
+// StorageClasses sharing a volumeNameFormat, and such a share must not be adopted. Shares created
+// before the volume name was recorded are assumed to belong to the volume.
+func checkShareOwnership(share *common.ShareResponse, hsVolume *common.HSVolume) error {
+	if share.ExtendedInfo[ExtendedInfoCreatedBy] != common.CsiPluginName {
+		return status.Errorf(codes.AlreadyExists, common.ShareNotOwned, share.Name, hsVolume.RequestName)
+	}
+	owner, exists := share.ExtendedInfo[ExtendedInfoVolumeName]
+	if exists && hsVolume.RequestName != "" && owner != hsVolume.RequestName {
+		return status.Errorf(codes.AlreadyExists, common.ShareNameConflict, share.Name, owner, hsVolume.RequestName)
+	}
+	return nil
+}
+
+// checkShareDeletable returns a FailedPrecondition error if a share was neither created by this
+// plugin nor adopted. Volume IDs are share paths, so a handcrafted PersistentVolume could
+// otherwise have any share on the cluster deleted.
+func checkShareDeletable(share *common.ShareResponse) error {
+	if share.ExtendedInfo[ExtendedInfoCreatedBy] == common.CsiPluginName {
+		return nil
+	}
+	if adopted, _ := strconv.ParseBool(share.ExtendedInfo[ExtendedInfoAdopted]); adopted {
+		return nil
+	}
+	return status.Errorf(codes.FailedPrecondition, common.ShareDeleteNotOwned, share.Name, ExtendedInfoAdopted)
 }
 
 func (d *CSIDriver) ensureShareBackedVolumeExists(
@@ -157,6 +473,11 @@ func (d *CSIDriver) ensureShareBackedVolumeExists(
 		return status.Errorf(codes.Internal, err.Error())
 	}
 	if share != nil { // It exists!
+		if err := checkShareOwnership(share, hsVolume); err != nil {
+			return err
+		}
+		// Volumes are found by the name of their share, wherever it is exported
+		hsVolume.ExportPath = share.ExportPath
 		if share.Size != hsVolume.Size {
 			return status.Errorf(
 				codes.AlreadyExists,
@@ -167,7 +488,17 @@ func (d *CSIDriver) ensureShareBackedVolumeExists(
 		if share.ShareState == "REMOVED" {
 			return status.Errorf(codes.Aborted, common.VolumeBeingDeleted)
 		}
-		// FIXME: Check that it's objectives, export options, deleteDelay(extended info),
+		// Re-apply objectives so changes to the StorageClass are reconciled
+		applied := make([]string, len(share.Objectives.Applied))
+		for i, o := range share.Objectives.Applied {
+			applied[i] = o.Name
+		}
+		err = d.applyObjectiveChanges(share.Name, "/", applied,
+			hsVolume.Objectives, hsVolume.ObjectivesRemove, hsVolume.ObjectivesReplace)
+		if err = checkObjectivesApplied(hsVolume, err); err != nil {
+			return err
+		}
+		// FIXME: Check that it's export options, deleteDelay(extended info),
 		//  etc match (optional functionality with CSI 1.0)
 
 		return nil
@@ -182,56 +513,64 @@ func (d *CSIDriver) ensureShareBackedVolumeExists(
 		if sourceShare == nil {
 			return status.Error(codes.NotFound, common.SourceSnapshotShareNotFound)
 		}
-		snapshots, err := d.hsclient.GetShareSnapshots(hsVolume.SourceSnapShareName)
+		snapshot, err := d.hsclient.GetShareSnapshot(hsVolume.SourceSnapShareName, path.Base(hsVolume.SourceSnapPath))
 		if err != nil {
 			log.Errorf("Failed to restore from snapshot, %v", err)
 			return status.Error(codes.Internal, common.UnknownError)
 		}
-
-		snapshotName := path.Base(hsVolume.SourceSnapPath)
-		if !slice.ContainsString(snapshots, snapshotName, strings.TrimSpace) {
+		if snapshot == "" {
 			return status.Error(codes.NotFound, common.SourceSnapshotNotFound)
 		}
 
 		err = d.hsclient.CreateShareFromSnapshot(
 			hsVolume.Name,
-			hsVolume.Path,
+			hsVolume.ExportPath,
 			hsVolume.Size,
-			hsVolume.Objectives,
+			nil,
 			hsVolume.ExportOptions,
 			hsVolume.DeleteDelay,
 			hsVolume.Comment,
 			hsVolume.SourceSnapPath,
+			getShareBackedExtendedInfo(hsVolume),
+			d.trackRestoreProgress(hsVolume.Path),
 		)
+		d.forgetRestoreProgress(hsVolume.Path)
 
 		if err != nil {
 			return status.Errorf(codes.Internal, err.Error())
 		}
+	} else if hsVolume.SourceVolumePath != "" {
+		// Clone another share-backed volume
+		err = d.cloneShare(hsVolume)
+		if err != nil {
+			return err
+		}
 	} else { // Create empty share
 		// Create the Mountvolume
 		err = d.hsclient.CreateShare(
 			hsVolume.Name,
-			hsVolume.Path,
+			hsVolume.ExportPath,
 			hsVolume.Size,
-			hsVolume.Objectives,
+			nil,
 			hsVolume.ExportOptions,
 			hsVolume.DeleteDelay,
 			hsVolume.Comment,
+			getShareBackedExtendedInfo(hsVolume),
 		)
 
 		if err != nil {
 			return status.Errorf(codes.Internal, err.Error())
 		}
 	}
-	// generate unique target path on host for setting file metadata
-	targetPath := common.ShareStagingDir + "metadata-mounts" + hsVolume.Path
-	defer common.UnmountFilesystem(targetPath)
-	err = d.publishShareBackedVolume(hsVolume.Path, targetPath, []string{}, false)
+	err = checkObjectivesApplied(hsVolume, d.setVolumeObjectives(hsVolume.Name, "/", hsVolume))
 	if err != nil {
-		log.Warnf("failed to set additional metadata on share %v", err)
+		if deleteErr := d.hsclient.DeleteShare(hsVolume.Name, 0, true); deleteErr != nil {
+			log.Errorf("could not remove share %s after failing to apply its objectives, %v", hsVolume.Name, deleteErr)
+		}
+		return err
 	}
-	// The hs client expects a trailing slash for directories
-	err = common.SetMetadataTags(targetPath+"/", hsVolume.AdditionalMetadataTags)
+
+	err = d.setMetadataTags(hsVolume.ExportPath, hsVolume.AdditionalMetadataTags)
 	if err != nil {
 		log.Warnf("failed to set additional metadata on share %v", err)
 	}
@@ -246,12 +585,13 @@ func (d *CSIDriver) ensureBackingShareExists(backingShareName string, hsVolume *
 	if share == nil {
 		err = d.hsclient.CreateShare(
 			backingShareName,
-			"/"+backingShareName,
+			GetShareExportPath(hsVolume.ExportPathPrefix, backingShareName),
 			-1,
-			[]string{},
+			hsVolume.BackingShareObjectives,
 			hsVolume.ExportOptions,
 			hsVolume.DeleteDelay,
 			hsVolume.Comment,
+			map[string]string{ExtendedInfoBackingShare: "true"},
 		)
 		if err != nil {
 			return share, status.Errorf(codes.Internal, err.Error())
@@ -261,31 +601,88 @@ func (d *CSIDriver) ensureBackingShareExists(backingShareName string, hsVolume *
 			return share, status.Errorf(codes.Internal, err.Error())
 		}
 
-		// generate unique target path on host for setting file metadata
-		targetPath := common.ShareStagingDir + "metadata-mounts" + hsVolume.Path
-		defer common.UnmountFilesystem(targetPath)
-		err = d.publishShareBackedVolume(hsVolume.Path, targetPath, []string{}, false)
-		err = common.SetMetadataTags(targetPath+"/", hsVolume.AdditionalMetadataTags)
+		// The additional metadata tags of the volume are set on its own file, the backing share
+		// only records that it was created by the plugin
+		if err = d.setMetadataTags(GetShareExportPath(hsVolume.ExportPathPrefix, backingShareName), nil); err != nil {
+			log.Warnf("failed to set metadata on backing share %v", err)
+		}
+	} else if len(hsVolume.BackingShareObjectives) > 0 {
+		// Backing shares are shared by several StorageClasses, their defaults are only ever added to
+		applied := make([]string, len(share.Objectives.Applied))
+		for i, o := range share.Objectives.Applied {
+			applied[i] = o.Name
+		}
+		err := d.applyObjectiveChanges(share.Name, "/", applied, hsVolume.BackingShareObjectives, nil, false)
 		if err != nil {
-			log.Warnf("failed to set additional metadata on share %v", err)
+			log.Warnf("failed to set default objectives on backing share %s, %v", share.Name, err)
 		}
 	}
 
 	return share, err
 }
 
+// applyDeviceFileObjectives sets the objectives of a file-backed volume at its path in the
+// backing share. They apply on top of those of the backing share, so volumes sharing a backing
+// share may have different placement and protection.
+func (d *CSIDriver) applyDeviceFileObjectives(backingShare *common.ShareResponse, hsVolume *common.HSVolume) error {
+	return checkObjectivesApplied(hsVolume, d.setVolumeObjectives(backingShare.Name, "/"+hsVolume.Name, hsVolume))
+}
+
+// getDeviceFileMetadataTags returns the tags set on the file of a file-backed volume, the default
+// extended info overridden by the volume's additional metadata tags
+func getDeviceFileMetadataTags(hsVolume *common.HSVolume) map[string]string {
+	tags := map[string]string{}
+	for k, v := range common.DefaultExtendedInfo {
+		tags[k] = v
+	}
+	for k, v := range hsVolume.AdditionalMetadataTags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// setMetadataTags records the plugin which created the file or share at path in the namespace, and
+// sets the given tags on it
+func (d *CSIDriver) setMetadataTags(path string, tags map[string]string) error {
+	details := fmt.Sprintf("CSI_DETAILS_TABLE{'%s','%s','%s','%s'}",
+		common.CsiVersion, common.CsiPluginName, common.Version, common.Githash)
+	if err := d.hsclient.SetFileAttribute(path, "CSI_DETAILS", details); err != nil {
+		log.Warnf("failed to set CSI_DETAILS metadata on %s, %v", path, err)
+	}
+	for key, value := range tags {
+		if err := d.hsclient.SetFileTag(path, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setDeviceFileMetadataTags sets the additional metadata tags of a file-backed volume on its file,
+// so that data-management policies can target individual volumes in a backing share
+func (d *CSIDriver) setDeviceFileMetadataTags(backingShare *common.ShareResponse, hsVolume *common.HSVolume) {
+	filePath := NewFileVolumeID(backingShare.ExportPath, hsVolume.Name).Path
+	err := d.setMetadataTags(filePath, getDeviceFileMetadataTags(hsVolume))
+	if err != nil {
+		log.Warnf("failed to set additional metadata on backing file for volume %v", err)
+	}
+}
+
 func (d *CSIDriver) ensureDeviceFileExists(
 	ctx context.Context,
 	backingShare *common.ShareResponse,
 	hsVolume *common.HSVolume) error {
 
 	// Check if File Exists
-	hsVolume.Path = backingShare.ExportPath + "/" + hsVolume.Name
+	hsVolume.Path = NewFileVolumeID(backingShare.ExportPath, hsVolume.Name).Path
 	file, err := d.hsclient.GetFile(hsVolume.Path)
 	if err != nil {
 		return status.Errorf(codes.Internal, err.Error())
 	}
 	if file != nil {
+		if file.Size < hsVolume.Size && (hsVolume.SourceSnapPath != "" || hsVolume.SourceVolumePath != "") {
+			// A previous attempt restored or cloned the file but did not grow it
+			return d.growRestoredDeviceFile(backingShare, hsVolume, file.Size)
+		}
 		if file.Size != hsVolume.Size {
 			return status.Errorf(
 				codes.AlreadyExists,
@@ -293,20 +690,29 @@ func (d *CSIDriver) ensureDeviceFileExists(
 				file.Size,
 				hsVolume.Size)
 		}
-		return nil
+		// Re-apply objectives so changes to the StorageClass are reconciled
+		return d.applyDeviceFileObjectives(backingShare, hsVolume)
 	}
 
 	if hsVolume.Size <= 0 {
 		return status.Error(codes.InvalidArgument, common.BlockVolumeSizeNotSpecified)
 	}
-	available, _ := strconv.ParseInt(backingShare.Space.Available, 10, 64)
+	available := backingShare.Space.AvailableBytes()
 	if hsVolume.Size > available {
 		return status.Errorf(codes.OutOfRange, common.OutOfCapacity, hsVolume.Size, available)
 	}
+	allocated, err := d.getBackingShareAllocation(backingShare)
+	if err != nil {
+		return status.Errorf(codes.Internal, err.Error())
+	}
+	err = checkBackingShareOvercommit(backingShare, allocated, hsVolume.Size, hsVolume.MaxOvercommitRatio)
+	if err != nil {
+		return err
+	}
 
-	backingDir := common.ShareStagingDir + backingShare.ExportPath
+	backingDir := common.StagingPath(backingShare.ExportPath)
 
-	deviceFile := backingDir + "/" + hsVolume.Name
+	deviceFile := path.Join(backingDir, hsVolume.Name)
 	if hsVolume.SourceSnapPath != "" {
 		// Create from snapshot
 		err := d.hsclient.RestoreFileSnapToDestination(hsVolume.SourceSnapPath, hsVolume.Path)
@@ -314,11 +720,18 @@ func (d *CSIDriver) ensureDeviceFileExists(
 			log.Errorf("Failed to restore from snapshot, %v", err)
 			return status.Error(codes.NotFound, common.UnknownError)
 		}
+	} else if hsVolume.SourceVolumePath != "" {
+		// Clone from another file-backed volume
+		defer d.scheduleBackingShareUnmount(backingShare.Name)
+		err = d.cloneDeviceFile(backingShare, hsVolume)
+		if err != nil {
+			return err
+		}
 	} else {
 		// Create empty device file
 		//// Mount Backing Share
 
-		defer d.UnmountBackingShareIfUnused(backingShare.Name)
+		defer d.scheduleBackingShareUnmount(backingShare.Name)
 		err = d.EnsureBackingShareMounted(backingShare.Name) // check if share is mounted
 		if err != nil {
 			log.Errorf("failed to ensure backing share is mounted, %v", err)
@@ -367,22 +780,70 @@ func (d *CSIDriver) ensureDeviceFileExists(
 		return err
 	}
 
-	if len(hsVolume.Objectives) > 0 {
-		err = d.hsclient.SetObjectives(backingShare.ExportPath, "/"+hsVolume.Name, hsVolume.Objectives, true)
+	if hsVolume.SourceSnapPath != "" || hsVolume.SourceVolumePath != "" {
+		file, err := d.hsclient.GetFile(hsVolume.Path)
 		if err != nil {
-			log.Warnf("failed to set objectives on backing file for volume %v", err)
+			return status.Errorf(codes.Internal, err.Error())
+		}
+		if file != nil && file.Size < hsVolume.Size {
+			err = d.growRestoredDeviceFile(backingShare, hsVolume, file.Size)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
-	// Set additional metadata on file
-	err = common.SetMetadataTags(deviceFile, hsVolume.AdditionalMetadataTags)
+	err = d.applyDeviceFileObjectives(backingShare, hsVolume)
 	if err != nil {
-		log.Warnf("failed to set additional metadata on backing file for volume %v", err)
+		defer d.scheduleBackingShareUnmount(backingShare.Name)
+		if deleteErr := d.removeBackingFile(NewFileVolumeID(backingShare.ExportPath, hsVolume.Name)); deleteErr != nil {
+			log.Errorf("could not remove file of volume %s after failing to apply its objectives, %v", hsVolume.Name, deleteErr)
+		}
+		return err
 	}
 
+	d.setDeviceFileMetadataTags(backingShare, hsVolume)
+
+	d.recordBackingShareAllocation(backingShare, allocated+hsVolume.Size, hsVolume.MaxOvercommitRatio)
+
 	return nil
 }
 
+// growRestoredDeviceFile grows a file restored from a smaller snapshot or volume to the requested capacity.
+// Its filesystem is grown by the node when the volume is published.
+func (d *CSIDriver) growRestoredDeviceFile(
+	backingShare *common.ShareResponse,
+	hsVolume *common.HSVolume,
+	restoredSize int64) error {
+
+	log.Infof("growing restored volume %s from %d to %d bytes", hsVolume.Path, restoredSize, hsVolume.Size)
+	return d.growDeviceFile(backingShare.Name, hsVolume.Path, hsVolume.Size)
+}
+
+// growDeviceFile grows the file of a file-backed volume, the caller must hold the lock on its
+// backing share. Loop devices the file is attached to keep their size until refreshed by a node.
+func (d *CSIDriver) growDeviceFile(backingShareName, volumePath string, size int64) error {
+	defer d.scheduleBackingShareUnmount(backingShareName)
+	err := d.EnsureBackingShareMounted(backingShareName)
+	if err != nil {
+		log.Errorf("failed to ensure backing share is mounted, %v", err)
+		return err
+	}
+	err = common.GrowRawFile(common.StagingPath(volumePath), size)
+	if err != nil {
+		return status.Errorf(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// isNodeExpansionRequired returns whether nodes must expand a volume after the controller resized
+// it. The loop device of a published file-backed volume keeps the old size of its file until the
+// node refreshes it, for raw block volumes as well as for filesystems, which the node also grows.
+// Share-backed volumes are resized on the cluster alone.
+func isNodeExpansionRequired(id VolumeID) bool {
+	return id.IsFileBacked()
+}
+
 func (d *CSIDriver) ensureFileBackedVolumeExists(
 	ctx context.Context,
 	hsVolume *common.HSVolume,
@@ -402,11 +863,55 @@ func (d *CSIDriver) ensureFileBackedVolumeExists(
 	return err
 }
 
+// getRequestedVolumeSize returns the size of the volume to create for a capacity range, within
+// the configured minimum and maximum volume sizes. 0 means share-backed volumes without a size.
+func getRequestedVolumeSize(cr *csi.CapacityRange, fileBacked bool) (int64, error) {
+	if cr == nil {
+		if fileBacked {
+			return common.DefaultBackingFileSizeBytes, nil
+		}
+		return 0, nil
+	}
+	required, limit := cr.GetRequiredBytes(), cr.GetLimitBytes()
+	size := required
+	if limit != 0 {
+		size = limit
+	}
+	if size == 0 {
+		return 0, nil
+	}
+	if common.MinVolumeSizeBytes > 0 && size < common.MinVolumeSizeBytes {
+		if limit != 0 {
+			return 0, status.Errorf(codes.OutOfRange, common.VolumeSizeBelowMinimum, limit, common.MinVolumeSizeBytes)
+		}
+		size = common.MinVolumeSizeBytes
+	}
+	if common.MaxVolumeSizeBytes > 0 && size > common.MaxVolumeSizeBytes {
+		if required > common.MaxVolumeSizeBytes {
+			return 0, status.Errorf(codes.OutOfRange, common.VolumeSizeAboveMaximum, required, common.MaxVolumeSizeBytes)
+		}
+		size = common.MaxVolumeSizeBytes
+	}
+	return size, nil
+}
+
 func (d *CSIDriver) CreateVolume(
 	ctx context.Context,
 	req *csi.CreateVolumeRequest) (
 	*csi.CreateVolumeResponse, error) {
 
+	// Serve with the Hammerspace credentials in the secrets of the StorageClass, if any, on the
+	// Anvil it names
+	endpoint := req.GetParameters()["endpoint"]
+	if err := d.checkProvisionerSecrets(endpoint, req.GetSecrets()); err != nil {
+		return nil, err
+	}
+	if tenant, err := d.forEndpoint(endpoint, req.GetSecrets()); err != nil {
+		return nil, err
+	} else if tenant != d {
+		return tenant.CreateVolume(ctx, req)
+	}
+
 	// Validate Parameters
 	if req.Name == "" {
 		return nil, status.Error(codes.InvalidArgument, common.EmptyVolumeId)
@@ -423,9 +928,20 @@ func (d *CSIDriver) CreateVolume(
 		return nil, err
 	}
 
-	// Check for snapshot source specified
+	accessibleTopology, err := getAccessibleTopology(req.GetAccessibilityRequirements())
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for snapshot or volume source specified
 	cs := req.VolumeContentSource
 	snap := cs.GetSnapshot()
+	sourceVolume := cs.GetVolume()
+	if sourceVolume != nil {
+		if err := checkControllerCapability(csi.ControllerServiceCapability_RPC_CLONE_VOLUME); err != nil {
+			return nil, err
+		}
+	}
 
 	// Get volumeMode
 	var volumeMode string
@@ -463,23 +979,56 @@ func (d *CSIDriver) CreateVolume(
 		return nil, status.Errorf(codes.InvalidArgument, common.NoCapabilitiesSupplied, req.Name)
 	}
 
+	// Refuse early what the cluster is not licensed for
+	if blockRequested {
+		if err := d.checkFeature(FeatureBlockVolumes); err != nil {
+			return nil, err
+		}
+	}
+	if snap != nil {
+		if err := d.checkFeature(FeatureSnapshots); err != nil {
+			return nil, err
+		}
+	}
+
 	// Check we have available capacity
-	cr := req.CapacityRange
-	var requestedSize int64
-	if cr != nil {
-		if cr.LimitBytes != 0 {
-			requestedSize = cr.LimitBytes
+	cr := req.GetCapacityRange()
+	requestedSize, err := getRequestedVolumeSize(cr, fileBacked)
+	if err != nil {
+		return nil, err
+	}
+
+	var sourceVolumeSize int64
+	if sourceVolume != nil {
+		sourceVolumeID, err := ParseVolumeID(sourceVolume.GetVolumeId())
+		if err != nil {
+			return nil, status.Error(codes.NotFound, common.SourceVolumeNotFound)
+		}
+		if sourceVolumeID.IsFileBacked() != fileBacked {
+			return nil, status.Errorf(codes.InvalidArgument, common.CloneModeMismatch, sourceVolumeID, sourceVolumeID.Mode)
+		}
+		if fileBacked {
+			sourceVolumeSize, err = d.getSourceFileSize(sourceVolumeID)
 		} else {
-			requestedSize = cr.RequiredBytes
+			// Share-backed volumes are cloned through a snapshot of their share
+			if err := d.checkFeature(FeatureSnapshots); err != nil {
+				return nil, err
+			}
+			sourceVolumeSize, err = d.getSourceShareSize(sourceVolumeID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cr == nil {
+			requestedSize = sourceVolumeSize
+		} else if requestedSize < sourceVolumeSize {
+			return nil, status.Errorf(codes.OutOfRange, common.CloneSmallerThanSource, requestedSize, sourceVolumeSize)
 		}
-	} else if fileBacked {
-		requestedSize = common.DefaultBackingFileSizeBytes
-	} else {
-		requestedSize = 0
 	}
 
 	if requestedSize > 0 {
 		var available int64
+		checked := true
 		if fileBacked {
 			// if it's file backed, we should check capacity of backing share
 			var backingShareName string
@@ -490,52 +1039,78 @@ func (d *CSIDriver) CreateVolume(
 			}
 			backingShare, err := d.hsclient.GetShare(backingShareName)
 			if backingShare == nil || err != nil {
-				available, err = d.hsclient.GetClusterAvailableCapacity()
+				available, checked, err = d.getClusterAvailableCapacity()
 				if err != nil {
-					return nil, status.Error(codes.Internal, err.Error())
+					return nil, err
 				}
 			} else {
-				available, _ = strconv.ParseInt(backingShare.Space.Available, 10, 64)
+				available = backingShare.Space.AvailableBytes()
 			}
 		} else {
-			available, err = d.hsclient.GetClusterAvailableCapacity()
+			available, checked, err = d.getClusterAvailableCapacity()
 			if err != nil {
-				return nil, status.Error(codes.Internal, err.Error())
+				return nil, err
 			}
 		}
-		if available < requestedSize {
+		if checked && available < requestedSize {
 			return nil, status.Errorf(codes.OutOfRange, common.OutOfCapacity, requestedSize, available)
 		}
 	}
 
 	//// Check if objectives exist on the cluster
-	clusterObjectiveNames, err := d.hsclient.ListObjectiveNames()
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-
-	for _, o := range vParams.Objectives {
-		if !IsValueInList(o, clusterObjectiveNames) {
+	objectives := append(append([]string{}, vParams.Objectives...), vParams.ObjectivesRemove...)
+	for _, o := range append(objectives, vParams.BackingShareObjectives...) {
+		exists, err := d.objectives.exists(o, d.hsclient.ListObjectiveNames)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if !exists {
 			return nil, status.Errorf(codes.InvalidArgument, common.InvalidObjectiveNameDoesNotExist, o)
 		}
 	}
 
+	err = checkVolumePolicy(policyRequest{
+		Operation:     PolicyOperationCreateVolume,
+		Name:          req.Name,
+		CapacityBytes: requestedSize,
+		Parameters:    req.Parameters,
+		Parsed:        &vParams,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	// Create Volume
 	defer d.releaseVolumeLock(volumeName)
 	d.getVolumeLock(volumeName)
 
 	hsVolume := &common.HSVolume{
 		DeleteDelay:            vParams.DeleteDelay,
+		DeleteMode:             vParams.DeleteMode,
+		DeleteSnapshots:        vParams.DeleteSnapshots,
 		ExportOptions:          vParams.ExportOptions,
 		Objectives:             vParams.Objectives,
+		ObjectivesRemove:       vParams.ObjectivesRemove,
+		ObjectivesReplace:      vParams.ObjectivesReplace,
+		BackingShareObjectives: vParams.BackingShareObjectives,
 		BlockBackingShareName:  vParams.BlockBackingShareName,
 		MountBackingShareName:  vParams.MountBackingShareName,
 		Size:                   requestedSize,
 		Name:                   volumeName,
+		RequestName:            req.Name,
 		VolumeMode:             volumeMode,
 		FSType:                 fsType,
 		AdditionalMetadataTags: vParams.AdditionalMetadataTags,
 		Comment:                vParams.Comment,
+		ClientMountOptions:     vParams.ClientMountOptions,
+		MountPolicy:            vParams.MountPolicy,
+		Transport:              vParams.Transport,
+		RDMAPort:               vParams.RDMAPort,
+		BlockPublishMode:       vParams.BlockPublishMode,
+		FsckOnStage:            vParams.FsckOnStage,
+		StrictObjectives:       vParams.StrictObjectives,
+		MaxOvercommitRatio:     vParams.MaxOvercommitRatio,
+		ExportPathPrefix:       vParams.ExportPathPrefix,
 	}
 	if snap != nil {
 		sourceSnapName, err := GetSnapshotNameFromSnapshotId(snap.GetSnapshotId())
@@ -550,8 +1125,20 @@ func (d *CSIDriver) CreateVolume(
 		}
 		hsVolume.SourceSnapShareName = sourceSnapShareName
 	}
+	if sourceVolume != nil {
+		hsVolume.SourceVolumePath = sourceVolume.GetVolumeId()
+	}
 
 	if fileBacked {
+		if vParams.DeleteMode != "" && vParams.DeleteMode != common.DeleteModePurge {
+			return nil, status.Errorf(codes.InvalidArgument, common.DeleteModeUnsupportedFileBacked, vParams.DeleteMode)
+		}
+		if vParams.DeleteSnapshots {
+			return nil, status.Error(codes.InvalidArgument, common.DeleteSnapshotsUnsupportedFileBacked)
+		}
+		if vParams.Transport == common.TransportRDMA {
+			return nil, status.Errorf(codes.InvalidArgument, common.TransportUnsupportedFileBacked, vParams.Transport)
+		}
 		var backingShareName string
 		if blockRequested {
 			if hsVolume.BlockBackingShareName == "" {
@@ -564,10 +1151,27 @@ func (d *CSIDriver) CreateVolume(
 			}
 			backingShareName = hsVolume.MountBackingShareName
 		}
+		if vParams.MaxVolumesPerBackingShare > 0 {
+			// Serialize volume creation in the series so that shares are not filled past the maximum
+			seriesLock := "backing-share-series/" + backingShareName
+			defer d.releaseVolumeLock(seriesLock)
+			d.getVolumeLock(seriesLock)
+			backingShareName, err = d.selectBackingShare(
+				backingShareName, volumeName, vParams.MaxVolumesPerBackingShare)
+			if err != nil {
+				return nil, err
+			}
+			if blockRequested {
+				hsVolume.BlockBackingShareName = backingShareName
+			} else {
+				hsVolume.MountBackingShareName = backingShareName
+			}
+		}
 		err = d.ensureFileBackedVolumeExists(ctx, hsVolume, backingShareName)
 		if err != nil {
 			return nil, err
 		}
+		d.trackBackingFile(hsVolume.Path, hsVolume.Size)
 	} else {
 		// TODO/FIXME: create from snapshot
 		// Workaround:
@@ -576,7 +1180,8 @@ func (d *CSIDriver) CreateVolume(
 		// move weird path to proper location
 		// NOTE: Expect this to change when we change restore from snapshot in the core product.
 
-		hsVolume.Path = common.SharePathPrefix + volumeName
+		hsVolume.Path = NewShareVolumeID(volumeName).Path
+		hsVolume.ExportPath = GetShareExportPath(hsVolume.ExportPathPrefix, volumeName)
 		err = d.ensureShareBackedVolumeExists(ctx, hsVolume)
 		if err != nil {
 			return nil, err
@@ -587,69 +1192,179 @@ func (d *CSIDriver) CreateVolume(
 	volContext := make(map[string]string)
 	volContext["size"] = strconv.FormatInt(hsVolume.Size, 10)
 	volContext["mode"] = volumeMode
+	volContext[VolumeContextVersionKey] = VolumeContextVersion
+
+	// Read-only facts about the share holding the volume, for consumers without access to the HS API
+	contextShareName := volumeName
+	if blockRequested {
+		contextShareName = hsVolume.BlockBackingShareName
+	} else if fileBacked {
+		contextShareName = hsVolume.MountBackingShareName
+	}
+	if share, err := d.hsclient.GetShare(contextShareName); err == nil && share != nil {
+		for k, v := range getShareVolumeContext(share) {
+			volContext[k] = v
+		}
+	} else {
+		log.Warnf("could not add the state of share %s to the volume context, %v", contextShareName, err)
+	}
+	// Nodes mount the share of a share-backed volume at the export path recorded here
+	if !fileBacked {
+		volContext[VolumeContextExportPath] = hsVolume.ExportPath
+	}
+	// Nodes serve the volume with the credentials for the Anvil it was provisioned on
+	if endpoint != "" {
+		volContext[VolumeContextEndpoint] = endpoint
+	}
+	// Nodes without Hammerspace credentials mount through the data-portals listed here
+	for k, v := range d.getMountInfoVolumeContext() {
+		volContext[k] = v
+	}
 
 	if volumeMode == "Block" {
 		volContext["blockBackingShareName"] = hsVolume.BlockBackingShareName
+		if hsVolume.BlockPublishMode != "" {
+			volContext["blockPublishMode"] = hsVolume.BlockPublishMode
+		}
 	} else if volumeMode == "Filesystem" && fsType != "nfs" {
 		volContext["mountBackingShareName"] = hsVolume.MountBackingShareName
 		volContext["fsType"] = fsType
+		if hsVolume.FsckOnStage != "" {
+			volContext["fsckOnStage"] = hsVolume.FsckOnStage
+		}
+		if hsVolume.SourceSnapPath != "" {
+			volContext["restoredFromSnapshot"] = "true"
+		}
+		if hsVolume.SourceVolumePath != "" {
+			volContext["clonedFromVolume"] = hsVolume.SourceVolumePath
+		}
+	} else {
+		if hsVolume.SourceVolumePath != "" {
+			volContext["clonedFromVolume"] = hsVolume.SourceVolumePath
+		}
+		if len(hsVolume.ClientMountOptions) > 0 {
+			volContext["clientMountOptions"] = strings.Join(hsVolume.ClientMountOptions, ",")
+		}
+		if len(hsVolume.MountPolicy) > 0 {
+			volContext["mountPolicy"] = strings.Join(hsVolume.MountPolicy, ",")
+		}
+		if hsVolume.Transport == common.TransportRDMA {
+			volContext["transport"] = hsVolume.Transport
+			volContext["rdmaPort"] = strconv.Itoa(hsVolume.RDMAPort)
+		}
 	}
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			CapacityBytes: hsVolume.Size,
-			VolumeId:      hsVolume.Path,
-			VolumeContext: volContext,
+			CapacityBytes:      hsVolume.Size,
+			VolumeId:           hsVolume.Path,
+			VolumeContext:      volContext,
+			AccessibleTopology: accessibleTopology,
 		},
 	}, nil
 }
 
-func (d *CSIDriver) deleteFileBackedVolume(filepath string) error {
+// deleteBackingFile deletes the file of a file-backed volume through its mounted backing share,
+// moving it to the trash of the share when HS_FILE_TRASH_RETENTION is set
+func (d *CSIDriver) deleteBackingFile(volumeID VolumeID) error {
+	// grab and defer a lock here for the backing share
+	defer d.releaseVolumeLock(volumeID.BackingShare)
+	d.getVolumeLock(volumeID.BackingShare)
+	if common.FileTrashRetention > 0 {
+		return d.trashBackingFile(volumeID)
+	}
+	return d.removeBackingFile(volumeID)
+}
+
+// resolveBackingFile mounts the backing share of a file-backed volume and returns the path of its
+// file there
+func (d *CSIDriver) resolveBackingFile(volumeID VolumeID) (string, error) {
+	destination := common.StagingPath(volumeID.BackingSharePath())
+	err := d.EnsureBackingShareMounted(volumeID.BackingShare) // check if share is mounted
+	if err != nil {
+		log.Errorf("failed to ensure backing share is mounted, %v", err)
+		return "", status.Errorf(codes.Internal, err.Error())
+	}
+	// Deleting from the staging directory while the share is not mounted would delete local files
+	if mounted, _ := common.IsShareMounted(destination); !mounted {
+		return "", status.Errorf(codes.Internal, common.UnsafeFileDelete, volumeID, destination+" is not mounted")
+	}
+	filePath, err := common.ResolveFileUnder(destination, volumeID.Name)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, common.UnsafeFileDelete, volumeID, err)
+	}
+	return filePath, nil
+}
+
+// removeBackingFile deletes the file of a file-backed volume, the caller must hold the lock on its
+// backing share
+func (d *CSIDriver) removeBackingFile(volumeID VolumeID) error {
+	filePath, err := d.resolveBackingFile(volumeID)
+	if err != nil {
+		return err
+	}
+	//// Delete File
+	err = common.DeleteFile(filePath)
+	if err != nil {
+		return status.Errorf(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+func (d *CSIDriver) deleteFileBackedVolume(volumeID VolumeID) error {
 	var exists bool
-	if exists, _ = d.hsclient.DoesFileExist(filepath); exists {
-		log.Debugf("found file-backed volume to delete, %s", filepath)
+	if exists, _ = d.hsclient.DoesFileExist(volumeID.Path); exists {
+		log.Debugf("found file-backed volume to delete, %s", volumeID)
 	}
 
 	// Check if file has snapshots and fail
-	snaps, _ := d.hsclient.GetFileSnapshots(filepath)
+	snaps, _ := d.hsclient.GetFileSnapshots(volumeID.Path)
 	if len(snaps) > 0 {
 		return status.Errorf(codes.FailedPrecondition, common.VolumeDeleteHasSnapshots)
 	}
 
-	residingSharePath := path.Dir(filepath)
-	residingShareName := path.Base(path.Dir(filepath))
-
 	if exists {
-		// mount share and delete file
-		destination := common.ShareStagingDir + path.Dir(filepath)
-		// grab and defer a lock here for the backing share
-		defer d.releaseVolumeLock(residingShareName)
-		d.getVolumeLock(residingShareName)
-		defer d.UnmountBackingShareIfUnused(residingShareName)
-		err := d.EnsureBackingShareMounted(residingSharePath) // check if share is mounted
-		if err != nil {
-			log.Errorf("failed to ensure backing share is mounted, %v", err)
-			return status.Errorf(codes.Internal, err.Error())
+		file, _ := d.hsclient.GetFile(volumeID.Path)
+		d.beginBackingShareDelete(volumeID.BackingShare)
+		err := d.deleteBackingFile(volumeID)
+		var released int64
+		// Trashed files stay allocated until they are purged
+		if err == nil && file != nil && common.FileTrashRetention <= 0 {
+			released = file.Size
 		}
-		//// Delete File
-		volumeName := GetVolumeNameFromPath(filepath)
-		err = common.DeleteFile(destination + "/" + volumeName)
+		d.endBackingShareDelete(volumeID.BackingShare, released)
 		if err != nil {
-			return status.Errorf(codes.Internal, err.Error())
+			return err
 		}
 	}
+	d.untrackBackingFile(volumeID.Path)
 
 	return nil
 }
 
 func (d *CSIDriver) deleteShareBackedVolume(share *common.ShareResponse) error {
+	deleteMode := share.ExtendedInfo[ExtendedInfoDeleteMode]
+	if deleteMode == common.DeleteModeRetain {
+		log.Infof("retaining share %s and its data, deleteMode is %s", share.Name, deleteMode)
+		return nil
+	}
+	if err := checkShareDeletable(share); err != nil {
+		return err
+	}
+
 	// Check for snapshots
 	snaps, err := d.hsclient.GetShareSnapshots(share.Name)
 	if err != nil {
 		return status.Errorf(codes.Internal, err.Error())
 	}
 	if len(snaps) > 0 {
-		return status.Errorf(codes.FailedPrecondition, common.VolumeDeleteHasSnapshots)
+		if share.ExtendedInfo[ExtendedInfoDeleteSnapshots] != "true" {
+			return status.Errorf(codes.FailedPrecondition, common.VolumeDeleteHasSnapshots)
+		}
+		log.Infof("deleting %d snapshots of share %s with it", len(snaps), share.Name)
+		if err := d.hsclient.DeleteShareSnapshots(share.Name, snaps); err != nil {
+			return status.Errorf(codes.Internal, err.Error())
+		}
 	}
 
 	deleteDelay := int64(-1)
@@ -662,7 +1377,11 @@ func (d *CSIDriver) deleteShareBackedVolume(share *common.ShareResponse) error {
 			}
 		}
 	}
-	err = d.hsclient.DeleteShare(share.Name, deleteDelay)
+	deletePath := deleteMode != common.DeleteModeExportOnly
+	if !deletePath {
+		log.Infof("removing export for share %s while preserving its path, deleteMode is %s", share.Name, deleteMode)
+	}
+	err = d.hsclient.DeleteShare(share.Name, deleteDelay, deletePath)
 	if err != nil {
 		return status.Errorf(codes.Internal, err.Error())
 	}
@@ -673,6 +1392,14 @@ func (d *CSIDriver) DeleteVolume(
 	ctx context.Context,
 	req *csi.DeleteVolumeRequest) (
 	*csi.DeleteVolumeResponse, error) {
+
+	// Serve with the Hammerspace credentials in the secrets of the StorageClass, if any
+	if tenant, err := d.forSecrets(req.GetSecrets()); err != nil {
+		return nil, err
+	} else if tenant != d {
+		return tenant.DeleteVolume(ctx, req)
+	}
+
 	volumeId := req.GetVolumeId()
 	//  If the volume is not specified, return error
 	if volumeId == "" {
@@ -682,19 +1409,34 @@ func (d *CSIDriver) DeleteVolume(
 	defer d.releaseVolumeLock(volumeId)
 	d.getVolumeLock(volumeId)
 
-	volumeName := GetVolumeNameFromPath(volumeId)
-	share, err := d.hsclient.GetShare(volumeName)
+	release, err := d.deleteVolumes.acquire(ctx, deleteVolumeOperation)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, err.Error())
+		return nil, err
 	}
-	if share == nil { // Share does not exist, may be a file-backed volume
-		err = d.deleteFileBackedVolume(volumeId)
+	defer release()
 
-		return &csi.DeleteVolumeResponse{}, err
-	} else { // Share exists and is a Filesystem
-		err = d.deleteShareBackedVolume(share)
+	id, err := ParseVolumeID(volumeId)
+	if err != nil { // No volume can have this ID, so it does not exist
+		log.Warnf("ignoring deletion of unknown volume, %v", err)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	err = checkVolumePolicy(policyRequest{Operation: PolicyOperationDeleteVolume, VolumeID: volumeId})
+	if err != nil {
+		return nil, err
+	}
+	if id.IsFileBacked() {
+		err = d.deleteFileBackedVolume(id)
 		return &csi.DeleteVolumeResponse{}, err
 	}
+	share, err := d.hsclient.GetShare(id.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	if share == nil { // Share already deleted
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	err = d.deleteShareBackedVolume(share)
+	return &csi.DeleteVolumeResponse{}, err
 
 }
 
@@ -712,10 +1454,41 @@ func (d *CSIDriver) ControllerUnpublishVolume(
 	return nil, status.Error(codes.Unimplemented, "ControllerUnpublishVolume not supported")
 }
 
+// checkVolumeTasks returns an Aborted error, for the CO to retry, while a volume is still being
+// restored from a snapshot or cloned. Changing its size meanwhile would race the task.
+func (d *CSIDriver) checkVolumeTasks(id VolumeID) error {
+	if id.IsFileBacked() {
+		if d.isVolumeCloning(id.Path) {
+			return status.Errorf(codes.Aborted, common.VolumeTaskInProgress, id)
+		}
+		return nil
+	}
+	running, err := d.hsclient.CheckIfShareCreateTaskIsRunning(id.Name)
+	if err != nil {
+		log.Warnf("could not list the tasks of share %s, %v", id.Name, err)
+		return nil
+	}
+	if running {
+		return status.Errorf(codes.Aborted, common.VolumeTaskInProgress, id)
+	}
+	return nil
+}
+
 func (d *CSIDriver) ControllerExpandVolume(
 	ctx context.Context,
 	req *csi.ControllerExpandVolumeRequest) (
 	*csi.ControllerExpandVolumeResponse, error) {
+
+	// Serve with the Hammerspace credentials in the secrets of the StorageClass, if any
+	if tenant, err := d.forSecrets(req.GetSecrets()); err != nil {
+		return nil, err
+	} else if tenant != d {
+		return tenant.ControllerExpandVolume(ctx, req)
+	}
+	if err := checkControllerCapability(csi.ControllerServiceCapability_RPC_EXPAND_VOLUME); err != nil {
+		return nil, err
+	}
+
 	var requestedSize int64
 	if req.GetCapacityRange().GetLimitBytes() != 0 {
 		requestedSize = req.GetCapacityRange().GetLimitBytes()
@@ -732,8 +1505,17 @@ func (d *CSIDriver) ControllerExpandVolume(
 		return nil, status.Error(codes.InvalidArgument, common.VolumeNotFound)
 	}
 
-	volumeName := GetVolumeNameFromPath(req.GetVolumeId())
-	share, _ := d.hsclient.GetShare(volumeName)
+	id, err := ParseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, common.VolumeNotFound)
+	}
+	if err := d.checkVolumeTasks(id); err != nil {
+		return nil, err
+	}
+	var share *common.ShareResponse
+	if !id.IsFileBacked() {
+		share, _ = d.hsclient.GetShare(id.Name)
+	}
 	if share == nil {
 		fileBacked = true
 	}
@@ -761,29 +1543,48 @@ func (d *CSIDriver) ControllerExpandVolume(
 			// && check the size of the file only resize if requested is larger than what we have
 			// if we are good, then return saying we need a resize on next mount
 			if file.Size >= requestedSize {
+				// A previous attempt may have grown the file without the filesystem being grown
 				return &csi.ControllerExpandVolumeResponse{
 					CapacityBytes:         file.Size,
-					NodeExpansionRequired: false,
+					NodeExpansionRequired: isNodeExpansionRequired(id),
 				}, nil
 			} else {
 				// if required - current > available on backend share
 				sizeDiff := requestedSize - file.Size
-				backingShareName := path.Base(path.Dir(req.GetVolumeId()))
+				backingShareName := id.BackingShare
+				defer d.releaseVolumeLock(backingShareName)
+				d.getVolumeLock(backingShareName)
 				backingShare, err := d.hsclient.GetShare(backingShareName)
 				var available int64
-				if err != nil {
+				if err != nil || backingShare == nil {
 					available = 0
 				} else {
-					available, _ = strconv.ParseInt(backingShare.Space.Available, 10, 64)
+					available = backingShare.Space.AvailableBytes()
 				}
 
 				if available-sizeDiff < 0 {
 					return nil, status.Error(codes.OutOfRange, common.OutOfCapacity)
 				}
 
+				// Reserve the new size in the backing share and grow the file, nodes grow the filesystem
+				allocated, err := d.getBackingShareAllocation(backingShare)
+				if err != nil {
+					return nil, status.Errorf(codes.Internal, err.Error())
+				}
+				ratio := getBackingShareOvercommitRatio(backingShare)
+				err = checkBackingShareOvercommit(backingShare, allocated, sizeDiff, ratio)
+				if err != nil {
+					return nil, err
+				}
+				err = d.growDeviceFile(backingShareName, req.GetVolumeId(), requestedSize)
+				if err != nil {
+					return nil, err
+				}
+				d.recordBackingShareAllocation(backingShare, allocated+sizeDiff, ratio)
+
 				return &csi.ControllerExpandVolumeResponse{
 					CapacityBytes:         requestedSize,
-					NodeExpansionRequired: true,
+					NodeExpansionRequired: isNodeExpansionRequired(id),
 				}, nil
 			}
 
@@ -792,11 +1593,7 @@ func (d *CSIDriver) ControllerExpandVolume(
 	} else {
 		//Check size: only resize if requested is larger than what we have
 
-		shareName := GetVolumeNameFromPath(req.GetVolumeId())
-		if shareName == "" {
-			return nil, status.Error(codes.NotFound, common.VolumeNotFound)
-		}
-		share, err := d.hsclient.GetShare(shareName)
+		share, err := d.hsclient.GetShare(id.Name)
 		if share == nil {
 			return nil, status.Error(codes.NotFound, common.ShareNotFound)
 		}
@@ -804,15 +1601,16 @@ func (d *CSIDriver) ControllerExpandVolume(
 		if err != nil {
 			currentSize = 0
 		} else {
-			currentSize, _ = strconv.ParseInt(share.Space.Available, 10, 64)
+			currentSize = share.Space.AvailableBytes()
 		}
 
 		if currentSize < requestedSize {
-			err = d.hsclient.UpdateShareSize(shareName, requestedSize)
+			err = d.hsclient.UpdateShareSize(id.Name, requestedSize)
 			if err != nil {
 				return nil, status.Error(codes.Internal, common.UnknownError)
 			}
 		}
+		d.recordShareExpansion(share, requestedSize)
 
 		return &csi.ControllerExpandVolumeResponse{
 			CapacityBytes:         requestedSize,
@@ -827,6 +1625,13 @@ func (d *CSIDriver) ValidateVolumeCapabilities(
 	req *csi.ValidateVolumeCapabilitiesRequest) (
 	*csi.ValidateVolumeCapabilitiesResponse, error) {
 
+	// Serve with the Hammerspace credentials in the secrets of the StorageClass, if any
+	if tenant, err := d.forSecrets(req.GetSecrets()); err != nil {
+		return nil, err
+	} else if tenant != d {
+		return tenant.ValidateVolumeCapabilities(ctx, req)
+	}
+
 	// Validate Arguments
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, common.EmptyVolumeId)
@@ -840,8 +1645,15 @@ func (d *CSIDriver) ValidateVolumeCapabilities(
 	typeMount := false
 	fileBacked := false
 
-	volumeName := GetVolumeNameFromPath(req.GetVolumeId())
-	share, _ := d.hsclient.GetShare(volumeName)
+	id, err := ParseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, common.VolumeNotFound)
+	}
+	volumeName := id.Name
+	var share *common.ShareResponse
+	if !id.IsFileBacked() {
+		share, _ = d.hsclient.GetShare(volumeName)
+	}
 	if share != nil {
 		typeMount = true
 	}
@@ -853,6 +1665,11 @@ func (d *CSIDriver) ValidateVolumeCapabilities(
 
 	typeBlock = vParams.BlockBackingShareName != ""
 	typeMount = vParams.MountBackingShareName != ""
+	if !typeBlock && !typeMount {
+		volContext := migrateVolumeContext(req.GetVolumeId(), req.GetVolumeContext(), nil)
+		typeBlock = volContext["blockBackingShareName"] != ""
+		typeMount = volContext["mountBackingShareName"] != ""
+	}
 
 	//  Check if the specified backing share or file exists
 	if share == nil {
@@ -907,7 +1724,24 @@ func (d *CSIDriver) ListVolumes(
 	req *csi.ListVolumesRequest) (
 	*csi.ListVolumesResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	if err := checkControllerCapability(csi.ControllerServiceCapability_RPC_LIST_VOLUMES); err != nil {
+		return nil, err
+	}
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, common.InvalidMaxEntries, req.GetMaxEntries())
+	}
+	entries, err := d.listVolumeEntries(req.GetStartingToken() != "")
+	if err != nil {
+		return nil, err
+	}
+	page, nextToken, err := paginateVolumes(entries, req.GetStartingToken(), req.GetMaxEntries())
+	if err != nil {
+		return nil, err
+	}
+	return &csi.ListVolumesResponse{
+		Entries:   page,
+		NextToken: nextToken,
+	}, nil
 }
 
 func (d *CSIDriver) GetCapacity(
@@ -915,6 +1749,9 @@ func (d *CSIDriver) GetCapacity(
 	req *csi.GetCapacityRequest) (
 	*csi.GetCapacityResponse, error) {
 
+	if err := checkControllerCapability(csi.ControllerServiceCapability_RPC_GET_CAPACITY); err != nil {
+		return nil, err
+	}
 	var blockRequested bool
 	var filesystemRequested bool
 	fileBacked := false
@@ -939,6 +1776,17 @@ func (d *CSIDriver) GetCapacity(
 		}, nil
 	}
 
+	served, err := isTopologyServed(req.GetAccessibleTopology())
+	if err != nil {
+		return nil, err
+	}
+	if !served {
+		log.Debugf("no capacity for topology %v, its nodes are not served by this plugin", req.GetAccessibleTopology().GetSegments())
+		return &csi.GetCapacityResponse{
+			AvailableCapacity: 0,
+		}, nil
+	}
+
 	vParams, err := parseVolParams(req.Parameters)
 	if err != nil {
 		return nil, err
@@ -956,15 +1804,21 @@ func (d *CSIDriver) GetCapacity(
 		backingShare, err := d.hsclient.GetShare(backingShareName)
 		if err != nil {
 			available = 0
+		} else if backingShare != nil {
+			available = backingShare.Space.AvailableBytes()
 		} else {
-			available, _ = strconv.ParseInt(backingShare.Space.Available, 10, 64)
+			// The backing share is created with the first volume, from the capacity of the cluster
+			available, err = d.getReadClusterCapacity()
+			if err != nil {
+				return nil, err
+			}
 		}
 
 	} else {
 		// Return all capacity of cluster for share backed volumes
-		available, err = d.hsclient.GetClusterAvailableCapacity()
+		available, err = d.getReadClusterCapacity()
 		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+			return nil, err
 		}
 	}
 
@@ -979,62 +1833,24 @@ func (d *CSIDriver) ControllerGetCapabilities(
 	req *csi.ControllerGetCapabilitiesRequest) (
 	*csi.ControllerGetCapabilitiesResponse, error) {
 
-	caps := []*csi.ControllerServiceCapability{
-		{
-			Type: &csi.ControllerServiceCapability_Rpc{
-				Rpc: &csi.ControllerServiceCapability_RPC{
-					Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-				},
-			},
-		},
-		/*
-		   {
-		       Type: &csi.ControllerServiceCapability_Rpc{
-		           Rpc: &csi.ControllerServiceCapability_RPC{
-		               Type: csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
-		           },
-		       },
-		   },
-		*/
-		{
-			Type: &csi.ControllerServiceCapability_Rpc{
-				Rpc: &csi.ControllerServiceCapability_RPC{
-					Type: csi.ControllerServiceCapability_RPC_GET_CAPACITY,
-				},
-			},
-		},
-
-		/*		{
-		    Type: &csi.ControllerServiceCapability_Rpc{
-		        Rpc: &csi.ControllerServiceCapability_RPC{
-		            Type: csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
-		        },
-		    },
-		},*/
-
-		{
-			Type: &csi.ControllerServiceCapability_Rpc{
-				Rpc: &csi.ControllerServiceCapability_RPC{
-					Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
-				},
-			},
-		},
-		{
-			Type: &csi.ControllerServiceCapability_Rpc{
-				Rpc: &csi.ControllerServiceCapability_RPC{
-					Type: csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-				},
-			},
-		},
-	}
-
 	return &csi.ControllerGetCapabilitiesResponse{
-		Capabilities: caps,
+		Capabilities: d.getControllerCapabilities(),
 	}, nil
 }
 
 func (d *CSIDriver) CreateSnapshot(ctx context.Context,
 	req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+
+	// Serve with the Hammerspace credentials in the secrets of the StorageClass, if any
+	if tenant, err := d.forSecrets(req.GetSecrets()); err != nil {
+		return nil, err
+	} else if tenant != d {
+		return tenant.CreateSnapshot(ctx, req)
+	}
+	if err := checkControllerCapability(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT); err != nil {
+		return nil, err
+	}
+
 	// Check arguments
 	if len(req.GetName()) == 0 {
 		return nil, status.Error(codes.InvalidArgument, common.EmptySnapshotId)
@@ -1046,58 +1862,127 @@ func (d *CSIDriver) CreateSnapshot(ctx context.Context,
 	if len(req.GetSourceVolumeId()) == 0 {
 		return nil, status.Error(codes.InvalidArgument, common.MissingSnapshotSourceVolumeId)
 	}
+	if err := d.checkFeature(FeatureSnapshots); err != nil {
+		return nil, err
+	}
 
 	defer d.releaseSnapshotLock(req.GetName())
 	d.getSnapshotLock(req.GetName())
 
-	// FIXME: Check to see if snapshot already exists?
-	//  (using their id somehow?, update the share extended info maybe?) what about for file-backed volumes?
-	// do we update extended info on backing share?
-	if _, exists := recentlyCreatedSnapshots[req.GetName()]; !exists {
-		// find source volume (is it file or share?
-		volumeName := GetVolumeNameFromPath(req.GetSourceVolumeId())
-		share, err := d.hsclient.GetShare(volumeName)
+	// find source volume (is it file or share?)
+	sourceVolumeID, err := ParseVolumeID(req.GetSourceVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, common.SourceVolumeNotFound)
+	}
+	volumeName := sourceVolumeID.Name
+	var share *common.ShareResponse
+	if !sourceVolumeID.IsFileBacked() {
+		share, err = d.hsclient.GetShare(volumeName)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
-		// Create the snapshot
-		var hsSnapName string
-		if share != nil {
-			hsSnapName, err = d.hsclient.SnapshotShare(volumeName)
-		} else {
-			hsSnapName, err = d.hsclient.SnapshotFile(req.GetSourceVolumeId())
-		}
+	}
+
+	// A repeated request returns the snapshot taken for the first one
+	snapshot, err := d.getRecordedSnapshot(sourceVolumeID, share, req.GetName())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	if snapshot != nil {
+		return &csi.CreateSnapshotResponse{
+			Snapshot: snapshot,
+		}, nil
+	}
+
+	freeze := false
+	if freezeParam, exists := req.GetParameters()["freezeFilesystem"]; exists {
+		freeze, err = strconv.ParseBool(freezeParam)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, err.Error())
+			return nil, status.Errorf(codes.InvalidArgument, common.InvalidFreezeFilesystem, freezeParam)
 		}
+	}
+	hooks, err := parseSnapshotHooks(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+	hookEvent := snapshotHookEvent{
+		Phase:          SnapshotHookPre,
+		SnapshotName:   req.GetName(),
+		SourceVolumeID: req.GetSourceVolumeId(),
+	}
+	err = hooks.run(hookEvent)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, common.SnapshotHookFailed, err)
+	}
 
-		snapID := GetSnapshotIDFromSnapshotName(hsSnapName, req.GetSourceVolumeId())
-		now := time.Now()
-		timeTaken := &timestamp.Timestamp{
-			Seconds: now.Unix(),
-			Nanos:   int32(now.UnixNano() % time.Second.Nanoseconds()),
+	// Create the snapshot
+	var hsSnapName string
+	if share != nil {
+		hsSnapName, err = d.hsclient.SnapshotShare(volumeName)
+	} else {
+		thaw := func() {}
+		if freeze {
+			thaw, err = d.freezeFileBackedVolume(req.GetSourceVolumeId(), req.GetName())
 		}
-		snapshotResponse := &csi.Snapshot{
-			SnapshotId:     snapID,
-			SourceVolumeId: req.GetSourceVolumeId(),
-			CreationTime:   timeTaken,
-			ReadyToUse:     true,
+		if err == nil {
+			hsSnapName, err = d.hsclient.SnapshotFile(req.GetSourceVolumeId())
+			thaw()
 		}
-		// FIXME: this is a hack to reduce the chance we create a snapshot twice
-		recentlyCreatedSnapshots[req.GetName()] = snapshotResponse
+	}
+	snapID := GetSnapshotIDFromSnapshotName(hsSnapName, req.GetSourceVolumeId())
+	if share == nil {
+		snapID = getFileSnapshotID(hsSnapName, req.GetSourceVolumeId())
+	}
+
+	// The post hook always follows the pre hook so applications are resumed
+	hookEvent.Phase = SnapshotHookPost
+	if err != nil {
+		hookEvent.Error = err.Error()
 	} else {
-		if recentlyCreatedSnapshots[req.GetName()].SourceVolumeId != req.GetSourceVolumeId() {
-			return nil, status.Errorf(codes.AlreadyExists, "snapshot already exists for a different volume")
+		hookEvent.SnapshotID = snapID
+	}
+	if hookErr := hooks.run(hookEvent); hookErr != nil {
+		log.Warnf("snapshot %s: %v", req.GetName(), hookErr)
+	}
+	if err != nil {
+		if _, isStatus := status.FromError(err); isStatus {
+			return nil, err
 		}
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+
+	now := time.Now()
+	timeTaken := &timestamp.Timestamp{
+		Seconds: now.Unix(),
+		Nanos:   int32(now.UnixNano() % time.Second.Nanoseconds()),
+	}
+	snapshotResponse := &csi.Snapshot{
+		SnapshotId:     snapID,
+		SourceVolumeId: req.GetSourceVolumeId(),
+		CreationTime:   timeTaken,
+		ReadyToUse:     true,
+	}
+	if err := d.recordSnapshot(sourceVolumeID, share, req.GetName(), snapID); err != nil {
+		log.Warnf("could not record snapshot %s of volume %s, %v", req.GetName(), req.GetSourceVolumeId(), err)
 	}
 	return &csi.CreateSnapshotResponse{
-		Snapshot: recentlyCreatedSnapshots[req.GetName()],
+		Snapshot: snapshotResponse,
 	}, nil
 }
 
 func (d *CSIDriver) DeleteSnapshot(ctx context.Context,
 	req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
 
+	// Serve with the Hammerspace credentials in the secrets of the StorageClass, if any
+	if tenant, err := d.forSecrets(req.GetSecrets()); err != nil {
+		return nil, err
+	} else if tenant != d {
+		return tenant.DeleteSnapshot(ctx, req)
+	}
+	if err := checkControllerCapability(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT); err != nil {
+		return nil, err
+	}
+
 	//  If the snapshot is not specified, return error
 	if len(req.SnapshotId) == 0 {
 		return nil, status.Error(codes.InvalidArgument, common.EmptySnapshotId)
@@ -1108,23 +1993,25 @@ func (d *CSIDriver) DeleteSnapshot(ctx context.Context,
 	if len(splitSnapId) != 2 {
 		return &csi.DeleteSnapshotResponse{}, nil
 	}
-	snapshotName, path := splitSnapId[0], splitSnapId[1]
+	snapshotName := splitSnapId[0]
 
 	// If the snapshot does not exist then return an idempotent response.
-
-	shareName := GetVolumeNameFromPath(path)
-
-	// delete if it's a share snap
-	err := d.hsclient.DeleteShareSnapshot(shareName, snapshotName)
+	sourceVolumeID, err := ParseVolumeID(splitSnapId[1])
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return &csi.DeleteSnapshotResponse{}, nil
 	}
 
-	// delete if it's a file snap
-	err = d.hsclient.DeleteFileSnapshot(path, snapshotName)
+	if sourceVolumeID.IsFileBacked() {
+		err = d.hsclient.DeleteFileSnapshot(sourceVolumeID.Path, snapshotName)
+	} else {
+		err = d.hsclient.DeleteShareSnapshot(sourceVolumeID.Name, snapshotName)
+	}
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	if err := d.forgetSnapshot(sourceVolumeID, snapshotId); err != nil {
+		log.Warnf("could not remove the record of snapshot %s, %v", snapshotId, err)
+	}
 
 	// Delete snapshot
 	return &csi.DeleteSnapshotResponse{}, nil
@@ -1133,5 +2020,45 @@ func (d *CSIDriver) DeleteSnapshot(ctx context.Context,
 func (d *CSIDriver) ListSnapshots(ctx context.Context,
 	req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	if err := checkControllerCapability(csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS); err != nil {
+		return nil, err
+	}
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, common.InvalidMaxEntries, req.GetMaxEntries())
+	}
+
+	var entries []*csi.ListSnapshotsResponse_Entry
+	var err error
+	if req.GetSnapshotId() != "" {
+		// Look up the snapshot rather than listing every snapshot
+		var entry *csi.ListSnapshotsResponse_Entry
+		entry, err = d.getSnapshotEntry(req.GetSnapshotId())
+		entries = []*csi.ListSnapshotsResponse_Entry{}
+		if entry != nil && (req.GetSourceVolumeId() == "" || entry.Snapshot.SourceVolumeId == req.GetSourceVolumeId()) {
+			entries = append(entries, entry)
+		}
+	} else if req.GetSourceVolumeId() != "" {
+		sourceVolumeID, parseErr := ParseVolumeID(req.GetSourceVolumeId())
+		if parseErr != nil {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		entries, err = d.getVolumeSnapshotEntries(sourceVolumeID)
+	} else {
+		entries, err = d.listSnapshotEntries(req.GetStartingToken() != "")
+	}
+	if err != nil {
+		if _, isStatus := status.FromError(err); isStatus {
+			return nil, err
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	page, nextToken, err := paginateSnapshots(entries, req.GetStartingToken(), req.GetMaxEntries())
+	if err != nil {
+		return nil, err
+	}
+	return &csi.ListSnapshotsResponse{
+		Entries:   page,
+		NextToken: nextToken,
+	}, nil
 }
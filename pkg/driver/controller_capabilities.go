@@ -0,0 +1,110 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// The controller advertises every capability it implements, except those named in
+// HS_DISABLED_CONTROLLER_CAPABILITIES, such as CLONE_VOLUME,EXPAND_VOLUME, and those of features
+// the cluster is not licensed for. The RPCs of a disabled capability fail with Unimplemented, so a
+// CO ignoring the capabilities cannot use them either.
+
+// controllerCapabilities are the capabilities implemented by the controller
+var controllerCapabilities = []csi.ControllerServiceCapability_RPC_Type{
+    csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+    csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+    csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+    csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+    csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+    csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+    csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+}
+
+// ParseDisabledControllerCapabilities parses a comma separated list of controller capability
+// names, which must be implemented by the controller
+func ParseDisabledControllerCapabilities(setting string) (map[string]bool, error) {
+    disabled := map[string]bool{}
+    for _, name := range strings.Split(setting, ",") {
+        name = strings.ToUpper(strings.TrimSpace(name))
+        if name == "" {
+            continue
+        }
+        known := false
+        for _, c := range controllerCapabilities {
+            known = known || c.String() == name
+        }
+        if !known {
+            names := []string{}
+            for _, c := range controllerCapabilities {
+                names = append(names, c.String())
+            }
+            return nil, fmt.Errorf(common.UnknownControllerCapability, name, strings.Join(names, ", "))
+        }
+        disabled[name] = true
+    }
+    return disabled, nil
+}
+
+// isControllerCapabilityEnabled returns whether the controller advertises capability
+func (d *CSIDriver) isControllerCapabilityEnabled(capability csi.ControllerServiceCapability_RPC_Type) bool {
+    if common.DisabledControllerCapabilities[capability.String()] {
+        return false
+    }
+    switch capability {
+    case csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+        csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT:
+        // Drop the capabilities of features the cluster is not licensed for
+        return d.isFeatureAvailable(FeatureSnapshots)
+    }
+    return true
+}
+
+// checkControllerCapability returns an Unimplemented error when capability is disabled in
+// HS_DISABLED_CONTROLLER_CAPABILITIES
+func checkControllerCapability(capability csi.ControllerServiceCapability_RPC_Type) error {
+    if common.DisabledControllerCapabilities[capability.String()] {
+        return status.Errorf(codes.Unimplemented, common.ControllerCapabilityDisabled, capability)
+    }
+    return nil
+}
+
+// getControllerCapabilities returns the capabilities advertised by the controller
+func (d *CSIDriver) getControllerCapabilities() []*csi.ControllerServiceCapability {
+    caps := []*csi.ControllerServiceCapability{}
+    for _, capability := range controllerCapabilities {
+        if !d.isControllerCapabilityEnabled(capability) {
+            continue
+        }
+        caps = append(caps, &csi.ControllerServiceCapability{
+            Type: &csi.ControllerServiceCapability_Rpc{
+                Rpc: &csi.ControllerServiceCapability_RPC{
+                    Type: capability,
+                },
+            },
+        })
+    }
+    return caps
+}
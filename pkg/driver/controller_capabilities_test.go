@@ -0,0 +1,61 @@
+package driver
+
+import (
+    "context"
+    "testing"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func getCapabilityNames(d *CSIDriver) map[string]bool {
+    resp, _ := d.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+    names := map[string]bool{}
+    for _, c := range resp.GetCapabilities() {
+        names[c.GetRpc().GetType().String()] = true
+    }
+    return names
+}
+
+func TestControllerCapabilities(t *testing.T) {
+    defer func() { common.DisabledControllerCapabilities = map[string]bool{} }()
+
+    d := &CSIDriver{}
+    names := getCapabilityNames(d)
+    if len(names) != len(controllerCapabilities) || !names["CLONE_VOLUME"] {
+        t.Logf("Expected every capability to be advertised, received %v", names)
+        t.FailNow()
+    }
+
+    disabled, err := ParseDisabledControllerCapabilities(" clone_volume,EXPAND_VOLUME,")
+    if err != nil || len(disabled) != 2 || !disabled["CLONE_VOLUME"] {
+        t.Logf("Unexpected disabled capabilities %v, %v", disabled, err)
+        t.FailNow()
+    }
+    if _, err := ParseDisabledControllerCapabilities("GET_VOLUME"); err == nil {
+        t.Logf("Expected error for a capability the controller does not implement")
+        t.FailNow()
+    }
+
+    common.DisabledControllerCapabilities = disabled
+    names = getCapabilityNames(d)
+    if names["CLONE_VOLUME"] || names["EXPAND_VOLUME"] || !names["CREATE_DELETE_VOLUME"] {
+        t.Logf("Expected disabled capabilities not to be advertised, received %v", names)
+        t.FailNow()
+    }
+    _, err = d.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{VolumeId: "/pvc-1"})
+    if status.Code(err) != codes.Unimplemented {
+        t.Logf("Expected disabled ControllerExpandVolume to be unimplemented, %v", err)
+        t.FailNow()
+    }
+
+    d.features.missing = map[string]bool{FeatureSnapshots: true}
+    names = getCapabilityNames(d)
+    if names["CREATE_DELETE_SNAPSHOT"] || names["LIST_SNAPSHOTS"] {
+        t.Logf("Expected snapshot capabilities to require the snapshot license, received %v", names)
+        t.FailNow()
+    }
+}
@@ -1,10 +1,15 @@
 package driver
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	common "github.com/hammer-space/csi-plugin/pkg/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestParseParams(t *testing.T) {
@@ -88,6 +93,123 @@ func TestParseParams(t *testing.T) {
         t.FailNow()
     }
 
+    // Test delete mode
+    stringParams = map[string]string{
+        "deleteMode": "delete-export-only",
+    }
+    actualParams, err = parseVolParams(stringParams)
+    if err != nil || actualParams.DeleteMode != common.DeleteModeExportOnly {
+        t.Logf("Unexpected delete mode %s, %v", actualParams.DeleteMode, err)
+        t.FailNow()
+    }
+
+    stringParams = map[string]string{
+        "deleteMode": "shred",
+    }
+    _, err = parseVolParams(stringParams)
+    if err == nil {
+        t.Logf("expected error")
+        t.FailNow()
+    }
+
+    // Test delete snapshots
+    actualParams, err = parseVolParams(map[string]string{"deleteSnapshots": "true"})
+    if err != nil || !actualParams.DeleteSnapshots {
+        t.Logf("Unexpected delete snapshots %v, %v", actualParams.DeleteSnapshots, err)
+        t.FailNow()
+    }
+    if _, err = parseVolParams(map[string]string{"deleteSnapshots": "always"}); err == nil {
+        t.Logf("expected error for deleteSnapshots")
+        t.FailNow()
+    }
+
+    // Test transport
+    stringParams = map[string]string{
+        "transport": "rdma",
+    }
+    actualParams, err = parseVolParams(stringParams)
+    if err != nil || actualParams.Transport != common.TransportRDMA || actualParams.RDMAPort != common.DefaultRDMAPort {
+        t.Logf("Unexpected transport %s:%d, %v", actualParams.Transport, actualParams.RDMAPort, err)
+        t.FailNow()
+    }
+
+    for _, p := range []map[string]string{{"transport": "udp"}, {"transport": "rdma", "rdmaPort": "70000"}} {
+        _, err = parseVolParams(p)
+        if err == nil {
+            t.Logf("expected error for %v", p)
+            t.FailNow()
+        }
+    }
+
+    // Test block publish mode
+    stringParams = map[string]string{
+        "blockPublishMode": "device",
+    }
+    actualParams, err = parseVolParams(stringParams)
+    if err != nil || actualParams.BlockPublishMode != common.BlockPublishModeDevice {
+        t.Logf("Unexpected block publish mode %s, %v", actualParams.BlockPublishMode, err)
+        t.FailNow()
+    }
+    _, err = parseVolParams(map[string]string{"blockPublishMode": "symlink"})
+    if err == nil {
+        t.Logf("expected error for blockPublishMode symlink")
+        t.FailNow()
+    }
+
+    // Test filesystem check on stage
+    actualParams, err = parseVolParams(map[string]string{"fsckOnStage": "repair"})
+    if err != nil || actualParams.FsckOnStage != common.FsckOnStageRepair {
+        t.Logf("Unexpected fsckOnStage %s, %v", actualParams.FsckOnStage, err)
+        t.FailNow()
+    }
+    _, err = parseVolParams(map[string]string{"fsckOnStage": "true"})
+    if err == nil {
+        t.Logf("expected error for fsckOnStage true")
+        t.FailNow()
+    }
+
+    // Test max volumes per backing share
+    actualParams, err = parseVolParams(map[string]string{"maxVolumesPerBackingShare": "500"})
+    if err != nil || actualParams.MaxVolumesPerBackingShare != 500 {
+        t.Logf("Unexpected max volumes per backing share %d, %v", actualParams.MaxVolumesPerBackingShare, err)
+        t.FailNow()
+    }
+    for _, maxVolumes := range []string{"0", "-1", "many"} {
+        _, err = parseVolParams(map[string]string{"maxVolumesPerBackingShare": maxVolumes})
+        if err == nil {
+            t.Logf("expected error for maxVolumesPerBackingShare %s", maxVolumes)
+            t.FailNow()
+        }
+    }
+
+    // Test max overcommit ratio
+    actualParams, err = parseVolParams(map[string]string{"maxOvercommitRatio": "1.5"})
+    if err != nil || actualParams.MaxOvercommitRatio != 1.5 {
+        t.Logf("Unexpected max overcommit ratio %v, %v", actualParams.MaxOvercommitRatio, err)
+        t.FailNow()
+    }
+    for _, ratio := range []string{"0", "-1", "lots"} {
+        _, err = parseVolParams(map[string]string{"maxOvercommitRatio": ratio})
+        if err == nil {
+            t.Logf("expected error for maxOvercommitRatio %s", ratio)
+            t.FailNow()
+        }
+    }
+
+    // Test export path prefix
+    actualParams, err = parseVolParams(map[string]string{"exportPathPrefix": "/k8s/prod/"})
+    if err != nil || actualParams.ExportPathPrefix != "/k8s/prod" {
+        t.Logf("Unexpected export path prefix %v, %v", actualParams.ExportPathPrefix, err)
+        t.FailNow()
+    }
+    for _, prefix := range []string{"k8s", "/k8s/../prod", "/k8s//prod", "/k8s/./prod"} {
+        _, err = parseVolParams(map[string]string{"exportPathPrefix": prefix})
+        if err == nil {
+            t.Logf("expected error for exportPathPrefix %s", prefix)
+            t.FailNow()
+        }
+    }
+
     // Test objectives
     expectedObjectives := []string{
         "obj1", "obj2", "obj3",
@@ -103,6 +225,50 @@ func TestParseParams(t *testing.T) {
         t.FailNow()
     }
 
+    // Test objective removal and replacement
+    stringParams = map[string]string{
+        "objectives":        "obj1",
+        "objectivesRemove":  "obj2, obj3",
+        "objectivesReplace": "true",
+    }
+    actualParams, err = parseVolParams(stringParams)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if !reflect.DeepEqual(actualParams.ObjectivesRemove, []string{"obj2", "obj3"}) || !actualParams.ObjectivesReplace {
+        t.Logf("Objective changes not parsed")
+        t.Logf("Actual: %v", actualParams)
+        t.FailNow()
+    }
+
+    stringParams = map[string]string{
+        "objectives":       "obj1",
+        "objectivesRemove": "obj1",
+    }
+    _, err = parseVolParams(stringParams)
+    if err == nil {
+        t.Logf("expected error")
+        t.FailNow()
+    }
+
+    // Test backing share default objectives
+    stringParams = map[string]string{
+        "objectives":             "place-on-ssd",
+        "backingShareObjectives": "keep-online, ",
+    }
+    actualParams, err = parseVolParams(stringParams)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if !reflect.DeepEqual(actualParams.BackingShareObjectives, []string{"keep-online"}) ||
+        !reflect.DeepEqual(actualParams.Objectives, []string{"place-on-ssd"}) {
+        t.Logf("Backing share objectives not parsed")
+        t.Logf("Actual: %v", actualParams)
+        t.FailNow()
+    }
+
     // Test export options
     expectedOptions := []common.ShareExportOptions{
         {
@@ -185,3 +351,277 @@ func TestParseParams(t *testing.T) {
     }
 
 }
+
+func TestCheckShareOwnership(t *testing.T) {
+    hsVolume := &common.HSVolume{Name: "pvc-1", RequestName: "pvc-1"}
+
+    // Created for this volume
+    share := &common.ShareResponse{Name: "pvc-1", ExtendedInfo: map[string]string{
+        ExtendedInfoCreatedBy:  common.CsiPluginName,
+        ExtendedInfoVolumeName: "pvc-1",
+    }}
+    if err := checkShareOwnership(share, hsVolume); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+
+    // Created by an earlier plugin version, without the volume name
+    share = &common.ShareResponse{Name: "pvc-1", ExtendedInfo: map[string]string{
+        ExtendedInfoCreatedBy: common.CsiPluginName,
+    }}
+    if err := checkShareOwnership(share, hsVolume); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+
+    // Created for another volume
+    share = &common.ShareResponse{Name: "pvc-1", ExtendedInfo: map[string]string{
+        ExtendedInfoCreatedBy:  common.CsiPluginName,
+        ExtendedInfoVolumeName: "pvc-2",
+    }}
+    if err := checkShareOwnership(share, hsVolume); status.Code(err) != codes.AlreadyExists {
+        t.Logf("Expected AlreadyExists, received %v", err)
+        t.FailNow()
+    }
+
+    // Not created by the plugin
+    share = &common.ShareResponse{Name: "pvc-1", ExtendedInfo: map[string]string{}}
+    if err := checkShareOwnership(share, hsVolume); status.Code(err) != codes.AlreadyExists {
+        t.Logf("Expected AlreadyExists, received %v", err)
+        t.FailNow()
+    }
+}
+
+func TestCheckShareDeletable(t *testing.T) {
+    share := &common.ShareResponse{Name: "pvc-1", ExtendedInfo: map[string]string{
+        ExtendedInfoCreatedBy: common.CsiPluginName,
+    }}
+    if err := checkShareDeletable(share); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+
+    // Not created by the plugin
+    share = &common.ShareResponse{Name: "important-share", ExtendedInfo: map[string]string{}}
+    if err := checkShareDeletable(share); status.Code(err) != codes.FailedPrecondition {
+        t.Logf("Expected FailedPrecondition, received %v", err)
+        t.FailNow()
+    }
+    share.ExtendedInfo[ExtendedInfoAdopted] = "false"
+    if err := checkShareDeletable(share); status.Code(err) != codes.FailedPrecondition {
+        t.Logf("Expected FailedPrecondition, received %v", err)
+        t.FailNow()
+    }
+
+    // Adopted by an administrator
+    share.ExtendedInfo[ExtendedInfoAdopted] = "true"
+    if err := checkShareDeletable(share); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+}
+
+func TestParseDefaultExtendedInfo(t *testing.T) {
+    extendedInfo, err := ParseDefaultExtendedInfo("environment=prod, cluster = east,")
+    expected := map[string]string{"environment": "prod", "cluster": "east"}
+    if err != nil || !reflect.DeepEqual(extendedInfo, expected) {
+        t.Logf("Expected %v, received %v, %v", expected, extendedInfo, err)
+        t.FailNow()
+    }
+    for _, invalid := range []string{"environment", "=prod", "a=b=c", "csi_volume_name=pvc-1"} {
+        if _, err := ParseDefaultExtendedInfo(invalid); err == nil {
+            t.Logf("Expected error for %s", invalid)
+            t.FailNow()
+        }
+    }
+
+    defer func() { common.DefaultExtendedInfo = map[string]string{} }()
+    common.DefaultExtendedInfo = extendedInfo
+    // The volume's tags take precedence
+    hsVolume := &common.HSVolume{AdditionalMetadataTags: map[string]string{"environment": "test"}}
+    expected = map[string]string{"environment": "test", "cluster": "east"}
+    if tags := getDeviceFileMetadataTags(hsVolume); !reflect.DeepEqual(tags, expected) {
+        t.Logf("Expected %v, received %v", expected, tags)
+        t.FailNow()
+    }
+    // The plugin's extended info takes precedence
+    common.DefaultExtendedInfo = map[string]string{"cluster": "east", "csi_created_by_plugin_name": "other"}
+    shareExtendedInfo := common.GetCommonExtendedInfo()
+    if shareExtendedInfo["cluster"] != "east" || shareExtendedInfo[ExtendedInfoCreatedBy] != common.CsiPluginName {
+        t.Logf("Unexpected share extended info %v", shareExtendedInfo)
+        t.FailNow()
+    }
+}
+
+func TestParseObjectiveTiers(t *testing.T) {
+    tiers, err := ParseObjectiveTiers("gold=keep-3-copies, place-on-ssd; bronze = place-on-hdd;")
+    expected := map[string][]string{"gold": {"keep-3-copies", "place-on-ssd"}, "bronze": {"place-on-hdd"}}
+    if err != nil || !reflect.DeepEqual(tiers, expected) {
+        t.Logf("Expected %v, received %v, %v", expected, tiers, err)
+        t.FailNow()
+    }
+    for _, invalid := range []string{"gold", "=place-on-ssd", "gold=", "gold=a;gold=b"} {
+        if _, err := ParseObjectiveTiers(invalid); err == nil {
+            t.Logf("Expected error for %s", invalid)
+            t.FailNow()
+        }
+    }
+
+    defer func() { common.ObjectiveTiers = map[string][]string{} }()
+    common.ObjectiveTiers = tiers
+    params, err := parseVolParams(map[string]string{"tier": "gold", "objectives": "place-on-ssd,no-atime"})
+    expectedObjectives := []string{"keep-3-copies", "place-on-ssd", "no-atime"}
+    if err != nil || !reflect.DeepEqual(params.Objectives, expectedObjectives) {
+        t.Logf("Expected objectives %v, received %v, %v", expectedObjectives, params.Objectives, err)
+        t.FailNow()
+    }
+    if _, err := parseVolParams(map[string]string{"tier": "silver"}); err == nil {
+        t.Logf("Expected error for an unknown tier")
+        t.FailNow()
+    }
+    if _, err := parseVolParams(map[string]string{"tier": "gold", "objectivesRemove": "place-on-ssd"}); err == nil {
+        t.Logf("Expected error for removing an objective of the tier")
+        t.FailNow()
+    }
+}
+
+func TestGetRequestedVolumeSize(t *testing.T) {
+    defer func(min, max int64) {
+        common.MinVolumeSizeBytes, common.MaxVolumeSizeBytes = min, max
+    }(common.MinVolumeSizeBytes, common.MaxVolumeSizeBytes)
+    common.MinVolumeSizeBytes = 1000
+    common.MaxVolumeSizeBytes = 5000
+
+    tests := []struct {
+        cr         *csi.CapacityRange
+        fileBacked bool
+        expected   int64
+        code       codes.Code
+    }{
+        {nil, true, common.DefaultBackingFileSizeBytes, codes.OK},
+        {nil, false, 0, codes.OK},
+        {&csi.CapacityRange{RequiredBytes: 2000}, true, 2000, codes.OK},
+        {&csi.CapacityRange{RequiredBytes: 2000, LimitBytes: 3000}, true, 3000, codes.OK},
+        {&csi.CapacityRange{RequiredBytes: 10}, true, 1000, codes.OK},
+        {&csi.CapacityRange{RequiredBytes: 10, LimitBytes: 500}, true, 0, codes.OutOfRange},
+        {&csi.CapacityRange{RequiredBytes: 2000, LimitBytes: 8000}, true, 5000, codes.OK},
+        {&csi.CapacityRange{RequiredBytes: 6000}, true, 0, codes.OutOfRange},
+    }
+    for _, test := range tests {
+        actual, err := getRequestedVolumeSize(test.cr, test.fileBacked)
+        if status.Code(err) != test.code {
+            t.Logf("Expected %v for %v, received %v", test.code, test.cr, err)
+            t.FailNow()
+        }
+        if actual != test.expected {
+            t.Logf("Expected size %d for %v, received %d", test.expected, test.cr, actual)
+            t.FailNow()
+        }
+    }
+}
+
+func TestGetShareVolumeContext(t *testing.T) {
+    share := &common.ShareResponse{
+        Uoid:       map[string]string{"uuid": "ac486652-6957-43cd-ac75-9885b3b3e9c9", "objectType": "SHARE"},
+        Name:       "pvc-1",
+        ExportPath: "/pvc-1",
+        Objectives: common.ObjectivesResponse{
+            Applied: []common.AppliedObjectiveResponse{{Name: "keep-online"}, {Name: "place-on-ssd"}},
+        },
+    }
+    expected := map[string]string{
+        "shareName":  "pvc-1",
+        "exportPath": "/pvc-1",
+        "shareUuid":  "ac486652-6957-43cd-ac75-9885b3b3e9c9",
+        "objectives": "keep-online,place-on-ssd",
+    }
+    actual := getShareVolumeContext(share)
+    if !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", actual)
+        t.FailNow()
+    }
+
+    expected = map[string]string{"shareName": "pvc-2", "exportPath": "/pvc-2"}
+    actual = getShareVolumeContext(&common.ShareResponse{Name: "pvc-2", ExportPath: "/pvc-2"})
+    if !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", actual)
+        t.FailNow()
+    }
+}
+
+func TestParseParamsAggregatesErrors(t *testing.T) {
+    stringParams := map[string]string{
+        "deleteDelay":                 "notanumber",
+        "transport":                   "udp",
+        "objectivs":                   "keep-online",
+        "exportOption":                "*,RW,false",
+        "exportOptions":               "*,RW",
+        "csi.storage.k8s.io/pvc/name": "pvc-1",
+        "strictParameters":            "true",
+    }
+    _, err := parseVolParams(stringParams)
+    if status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected InvalidArgument, received %v", err)
+        t.FailNow()
+    }
+    message := status.Convert(err).Message()
+    for _, expected := range []string{
+        fmt.Sprintf(common.UnknownParameters, "exportOption (did you mean exportOptions?), objectivs (did you mean objectives?)"),
+        fmt.Sprintf(common.InvalidDeleteDelay, "notanumber"),
+        fmt.Sprintf(common.InvalidTransport, "udp"),
+        fmt.Sprintf(common.InvalidExportOptions, "*,RW"),
+    } {
+        if !strings.Contains(message, expected) {
+            t.Logf("Expected '%s' in error: %s", expected, message)
+            t.FailNow()
+        }
+    }
+    if strings.Contains(message, "pvc/name") {
+        t.Logf("Kubernetes parameters should be accepted: %s", message)
+        t.FailNow()
+    }
+}
+
+func TestParseParamsUnknownNotStrict(t *testing.T) {
+    _, err := parseVolParams(map[string]string{"objctives": "keep-online"})
+    if err != nil {
+        t.Logf("Unknown parameters should only be rejected in strict mode, %v", err)
+        t.FailNow()
+    }
+    _, err = parseVolParams(map[string]string{"strictParameters": "maybe"})
+    if status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected InvalidArgument, received %v", err)
+        t.FailNow()
+    }
+}
+
+func TestNearestParameterName(t *testing.T) {
+    for name, expected := range map[string]string{
+        "objctives":          "objectives",
+        "Objectives":         "objectives",
+        "mountbackingshare":  "mountBackingShareName",
+        "fstype":             "fsType",
+        "rdma":               "",
+        "maxOvercommitRatoi": "maxOvercommitRatio",
+    } {
+        actual := nearestParameterName(name, volumeParameterNames)
+        if actual != expected {
+            t.Logf("Nearest parameter to %s, expected '%s', received '%s'", name, expected, actual)
+            t.FailNow()
+        }
+    }
+}
+
+func TestIsNodeExpansionRequired(t *testing.T) {
+    // Raw block volumes need their loop device refreshed on the node too
+    if !isNodeExpansionRequired(NewFileVolumeID("/backing", "pvc-1")) {
+        t.Logf("Expected file-backed volumes to require node expansion")
+        t.FailNow()
+    }
+    if isNodeExpansionRequired(VolumeID{Mode: VolumeIDModeShare, Name: "pvc-1", Path: "/pvc-1"}) {
+        t.Logf("Expected share-backed volumes not to require node expansion")
+        t.FailNow()
+    }
+}
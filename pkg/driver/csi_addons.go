@@ -0,0 +1,146 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "context"
+    "net"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "github.com/golang/protobuf/ptypes/wrappers"
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+    "github.com/hammer-space/csi-plugin/pkg/csiaddons"
+)
+
+// csiAddonsServer serves the csi-addons services on CSI_ADDONS_ENDPOINT, for the csi-addons
+// sidecar. Their Probe differs from the CSI one, so they are not served by the driver itself.
+type csiAddonsServer struct {
+    d *CSIDriver
+}
+
+func (s *csiAddonsServer) GetIdentity(
+    ctx context.Context,
+    req *csiaddons.GetIdentityRequest) (
+    *csiaddons.GetIdentityResponse, error) {
+
+    return &csiaddons.GetIdentityResponse{
+        Name:          common.CsiPluginName,
+        VendorVersion: common.Version,
+    }, nil
+}
+
+func (s *csiAddonsServer) GetCapabilities(
+    ctx context.Context,
+    req *csiaddons.GetCapabilitiesRequest) (
+    *csiaddons.GetCapabilitiesResponse, error) {
+
+    capabilities := []*csiaddons.Capability{}
+    if s.d.servesController() {
+        capabilities = append(capabilities,
+            &csiaddons.Capability{Service: &csiaddons.Capability_Service{Type: csiaddons.ControllerService}},
+            &csiaddons.Capability{ReclaimSpace: &csiaddons.Capability_ReclaimSpace{Type: csiaddons.ReclaimSpaceOffline}})
+    }
+    if s.d.servesNode() {
+        capabilities = append(capabilities,
+            &csiaddons.Capability{Service: &csiaddons.Capability_Service{Type: csiaddons.NodeService}},
+            &csiaddons.Capability{ReclaimSpace: &csiaddons.Capability_ReclaimSpace{Type: csiaddons.ReclaimSpaceOnline}})
+    }
+    return &csiaddons.GetCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+func (s *csiAddonsServer) Probe(
+    ctx context.Context,
+    req *csiaddons.ProbeRequest) (
+    *csiaddons.ProbeResponse, error) {
+
+    resp, err := s.d.Probe(ctx, &csi.ProbeRequest{})
+    if err != nil {
+        return nil, err
+    }
+    return &csiaddons.ProbeResponse{Ready: &wrappers.BoolValue{Value: resp.GetReady().GetValue()}}, nil
+}
+
+func (s *csiAddonsServer) ControllerReclaimSpace(
+    ctx context.Context,
+    req *csiaddons.ControllerReclaimSpaceRequest) (
+    *csiaddons.ControllerReclaimSpaceResponse, error) {
+
+    if req.VolumeId == "" {
+        return nil, status.Error(codes.InvalidArgument, common.EmptyVolumeId)
+    }
+    d, err := s.d.forSecrets(req.Secrets)
+    if err != nil {
+        return nil, err
+    }
+    err = d.controllerReclaimSpace(req.VolumeId)
+    if status.Code(err) == codes.FailedPrecondition {
+        // The sidecar asks the nodes publishing the volume to reclaim its space
+        log.Infof("not reclaiming space of volume %s on the controller, %v", req.VolumeId, err)
+        return &csiaddons.ControllerReclaimSpaceResponse{}, nil
+    } else if err != nil {
+        return nil, err
+    }
+    return &csiaddons.ControllerReclaimSpaceResponse{}, nil
+}
+
+func (s *csiAddonsServer) NodeReclaimSpace(
+    ctx context.Context,
+    req *csiaddons.NodeReclaimSpaceRequest) (
+    *csiaddons.NodeReclaimSpaceResponse, error) {
+
+    if req.VolumeId == "" {
+        return nil, status.Error(codes.InvalidArgument, common.EmptyVolumeId)
+    }
+    if req.VolumePath == "" {
+        return nil, status.Error(codes.InvalidArgument, common.EmptyTargetPath)
+    }
+    if err := s.d.nodeReclaimSpace(req.VolumeId, req.VolumePath); err != nil {
+        return nil, err
+    }
+    return &csiaddons.NodeReclaimSpaceResponse{}, nil
+}
+
+// ServeCSIAddons serves the csi-addons services on l until the driver is stopped
+func (c *CSIDriver) ServeCSIAddons(l net.Listener) {
+    server := grpc.NewServer(grpc.UnaryInterceptor(c.callInterceptor))
+    addons := &csiAddonsServer{d: c}
+    csiaddons.RegisterIdentityServer(server, addons)
+    if c.servesController() {
+        csiaddons.RegisterReclaimSpaceControllerServer(server, addons)
+    }
+    if c.servesNode() {
+        csiaddons.RegisterReclaimSpaceNodeServer(server, addons)
+    }
+
+    c.wg.Add(1)
+    go func() {
+        defer c.wg.Done()
+        <-c.stopCh
+        server.Stop()
+    }()
+    go func() {
+        if err := server.Serve(l); err != nil {
+            log.Errorf("csi-addons endpoint stopped: %v", err)
+        }
+    }()
+    log.Infof("serving csi-addons services on %s", l.Addr())
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "sync"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// dataPortalCache holds the data-portals available to this node, so that NodeGetInfo and mounts of
+// backing shares on busy nodes do not each list them from the Anvil. The list is fetched again
+// after common.DataPortalCacheTTL, or after no data-portal could be mounted from. If fetching
+// fails, the expired list is used rather than failing the mount.
+type dataPortalCache struct {
+    lock    sync.Mutex
+    portals []common.DataPortal
+    fetched time.Time
+    now     func() time.Time
+}
+
+func (c *dataPortalCache) currentTime() time.Time {
+    if c.now != nil {
+        return c.now()
+    }
+    return time.Now()
+}
+
+// get returns the cached data-portals, using fetch to list them when the cache has expired
+func (c *dataPortalCache) get(fetch func() ([]common.DataPortal, error)) ([]common.DataPortal, error) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    if !c.fetched.IsZero() && c.currentTime().Sub(c.fetched) < common.DataPortalCacheTTL {
+        return c.portals, nil
+    }
+    portals, err := fetch()
+    if err != nil {
+        if c.fetched.IsZero() {
+            return nil, err
+        }
+        log.Warnf("could not list data-portals, using the list fetched %v ago, %v",
+            c.currentTime().Sub(c.fetched).Round(time.Second), err)
+        return c.portals, nil
+    }
+    c.portals = portals
+    c.fetched = c.currentTime()
+    return portals, nil
+}
+
+// invalidate makes the next get fetch the data-portals
+func (c *dataPortalCache) invalidate() {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    c.fetched = time.Time{}
+}
+
+// getDataPortals returns the data-portals available to this node
+func (d *CSIDriver) getDataPortals() ([]common.DataPortal, error) {
+    return d.dataPortals.get(func() ([]common.DataPortal, error) {
+        return d.hsclient.GetDataPortals(d.NodeID)
+    })
+}
@@ -0,0 +1,63 @@
+package driver
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestDataPortalCache(t *testing.T) {
+    now := time.Unix(0, 0)
+    cache := &dataPortalCache{now: func() time.Time { return now }}
+
+    fetches := 0
+    var fetchErr error
+    clusterPortals := []common.DataPortal{{Uoid: map[string]string{"uuid": "dp1"}}}
+    fetch := func() ([]common.DataPortal, error) {
+        fetches++
+        if fetchErr != nil {
+            return nil, fetchErr
+        }
+        return clusterPortals, nil
+    }
+
+    // Nothing cached and the fetch fails
+    fetchErr = errors.New("anvil unavailable")
+    if _, err := cache.get(fetch); err == nil {
+        t.Logf("Expected an error with nothing cached")
+        t.FailNow()
+    }
+
+    fetchErr = nil
+    portals, err := cache.get(fetch)
+    if err != nil || len(portals) != 1 || fetches != 2 {
+        t.Logf("Expected one data-portal after a fetch, portals=%v fetches=%d err=%v", portals, fetches, err)
+        t.FailNow()
+    }
+    cache.get(fetch)
+    if fetches != 2 {
+        t.Logf("Expected the cached list to be used, fetches=%d", fetches)
+        t.FailNow()
+    }
+
+    // Expired, and the fetch fails
+    now = now.Add(common.DataPortalCacheTTL)
+    fetchErr = errors.New("anvil unavailable")
+    portals, err = cache.get(fetch)
+    if err != nil || len(portals) != 1 || fetches != 3 {
+        t.Logf("Expected the expired list to be used, portals=%v fetches=%d err=%v", portals, fetches, err)
+        t.FailNow()
+    }
+
+    // Invalidated
+    fetchErr = nil
+    clusterPortals = []common.DataPortal{{Uoid: map[string]string{"uuid": "dp1"}}, {Uoid: map[string]string{"uuid": "dp2"}}}
+    cache.invalidate()
+    portals, err = cache.get(fetch)
+    if err != nil || len(portals) != 2 || fetches != 4 {
+        t.Logf("Expected a fetch after invalidation, portals=%v fetches=%d err=%v", portals, fetches, err)
+        t.FailNow()
+    }
+}
@@ -19,7 +19,6 @@ package driver
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net"
 	"os"
 	"strconv"
@@ -37,19 +36,82 @@ import (
 	"google.golang.org/grpc/reflection"
 )
 
+// sharedState is the state of the plugin process which the drivers for the credentials in CSI
+// secrets share with the driver they were created by: the locks, and the operations running on
+// this host
+type sharedState struct {
+    volumeLocks     map[string]*sync.Mutex //This only grows and may be a memory issue
+    volumeLocksLock sync.Mutex
+    snapshotLocks   map[string]*sync.Mutex
+
+    clones     map[string]*cloneTask // destination volume path -> background copy
+    clonesLock sync.Mutex
+
+    deleteBatches   backingShareDeleteBatches
+    loopDevicesLock sync.Mutex // held while checking the loop device budget and attaching a device
+    unmountJanitor  backingShareJanitor
+
+    attachLocks     map[string]*os.File // volume ID -> shared lock of this node on its backing file
+    attachLocksLock sync.Mutex
+}
+
+func newSharedState() *sharedState {
+    return &sharedState{
+        volumeLocks:   make(map[string]*sync.Mutex),
+        snapshotLocks: make(map[string]*sync.Mutex),
+        clones:        make(map[string]*cloneTask),
+        attachLocks:   make(map[string]*os.File),
+    }
+}
+
 type CSIDriver struct {
-    listener      net.Listener
-    server        *grpc.Server
-    wg            sync.WaitGroup
-    running       bool
-    lock          sync.Mutex
-    volumeLocks   map[string]*sync.Mutex //This only grows and may be a memory issue
-    snapshotLocks map[string]*sync.Mutex
-    hsclient      *client.HammerspaceClient
-    NodeID        string
+    *sharedState
+
+    listener net.Listener
+    server   *grpc.Server
+    wg       sync.WaitGroup
+    running  bool
+    lock     sync.Mutex
+    hsclient *client.HammerspaceClient
+    NodeID   string
+
+    backingFiles       map[string]int64 // file-backed volume path -> expected size, checked by the scrubber
+    backingFilesLoaded bool             // whether the files of existing backing shares were added since leading
+    backingFilesLock   sync.Mutex
+    stopCh             chan struct{}
+    objectives         objectiveCache
+    dataPortals        dataPortalCache
+    clusterCapacity    clusterCapacityCache
+
+    portalNFSVersions  map[string]string // data-portal address -> last negotiated NFS version
+    portalVersionsLock sync.Mutex
+
+    nodeState *nodeStateStore
+
+    hsVersion     string
+    hsVersionLock sync.Mutex
+
+    features clusterFeatures
+    leader   leaderState
+
+    usageLevels     map[string]int // volume ID -> highest usage threshold reached
+    usageLevelsLock sync.Mutex
+
+    nodeOperations *operationLimiter
+    deleteVolumes  *operationLimiter
+    volumeList     volumeListCache
+    snapshotList   snapshotListCache
+    mountInfo      mountInfoCache
+
+    tenants *tenantDrivers // drivers for the credentials in CSI secrets, nil in those drivers
 }
 
 func NewCSIDriver(endpoint, username, password, tlsVerifyStr string) *CSIDriver {
+    if common.NodeCredentialless {
+        // Nodes without credentials never call the Hammerspace API
+        common.UseAnvil = false
+        return newCSIDriver(nil)
+    }
     tlsVerify := false
     if os.Getenv("HS_TLS_VERIFY") != "" {
         tlsVerify, _ = strconv.ParseBool(tlsVerifyStr)
@@ -64,27 +126,43 @@ func NewCSIDriver(endpoint, username, password, tlsVerifyStr string) *CSIDriver
     // We now require mounting through a DSX server
     common.UseAnvil = false
 
+    d := newCSIDriver(client)
+    d.tenants = newTenantDrivers()
+    return d
+}
+
+// newCSIDriver returns a driver using hsclient for the Hammerspace API
+func newCSIDriver(hsclient *client.HammerspaceClient) *CSIDriver {
     return &CSIDriver{
-        hsclient:      client,
-        volumeLocks:   make(map[string]*sync.Mutex),
-        snapshotLocks: make(map[string]*sync.Mutex),
-        NodeID:        os.Getenv("CSI_NODE_NAME"),
+        sharedState:   newSharedState(),
+        hsclient:      hsclient,
+        NodeID:        getNodeID(),
+        backingFiles:  make(map[string]int64),
+        portalNFSVersions: make(map[string]string),
+        stopCh:        make(chan struct{}),
+        nodeState:     newNodeStateStore(common.NodeStateDir),
+        usageLevels:   make(map[string]int),
+        nodeOperations: newNodeOperationLimiter(common.NodePublishConcurrency),
+        deleteVolumes:  newDeleteVolumeLimiter(common.DeleteVolumeConcurrency),
     }
-
 }
 
 func (c *CSIDriver) getVolumeLock(volName string) {
+    c.volumeLocksLock.Lock()
     if _, exists := c.volumeLocks[volName]; !exists {
         c.volumeLocks[volName] = &sync.Mutex{}
     }
-    c.volumeLocks[volName].Lock()
+    volumeLock := c.volumeLocks[volName]
+    c.volumeLocksLock.Unlock()
+    volumeLock.Lock()
 }
 
 func (c *CSIDriver) releaseVolumeLock(volName string) {
-    if _, exists := c.volumeLocks[volName]; exists {
-        if exists {
-            c.volumeLocks[volName].Unlock()
-        }
+    c.volumeLocksLock.Lock()
+    volumeLock, exists := c.volumeLocks[volName]
+    c.volumeLocksLock.Unlock()
+    if exists {
+        volumeLock.Unlock()
     }
 }
 
@@ -133,16 +211,84 @@ func (c *CSIDriver) Start(l net.Listener) error {
         }),
     )
 
-    csi.RegisterControllerServer(c.server, c)
     csi.RegisterIdentityServer(c.server, c)
-    csi.RegisterNodeServer(c.server, c)
+    if c.servesController() {
+        csi.RegisterControllerServer(c.server, c)
+    }
+    if c.servesNode() {
+        csi.RegisterNodeServer(c.server, c)
+    }
     reflection.Register(c.server)
 
     // Start listening for requests
-    waitForServer := make(chan bool)
-    c.goServe(waitForServer)
-    <-waitForServer
-    c.running = true
+    return c.startServing(func() {
+        waitForServer := make(chan bool)
+        c.goServe(waitForServer)
+        <-waitForServer
+        c.running = true
+    })
+}
+
+// startServing does the work needed before requests are served, starts serving them with serve
+// and then starts the background tasks. The CSI and CSI v0 servers both start through it.
+func (c *CSIDriver) startServing(serve func()) error {
+    log.Infof("serving CSI services in %s mode", common.ServiceMode)
+
+    // Detect licensed features before the CO asks for the controller capabilities
+    if c.NodeID == "" && c.servesController() {
+        c.detectFeatures()
+    }
+
+    // Refuse to publish volumes which would not reach the pods
+    if c.NodeID != "" {
+        if err := CheckNodeMounts(); err != nil {
+            return err
+        }
+    }
+
+    if c.NodeID != "" && common.LoadLoopModuleOnStart {
+        if err := common.LoadLoopModule(); err != nil {
+            log.Warnf("file-backed volumes may not be published on this node, %v", err)
+        }
+    }
+
+    // Replay volumes staged and published before a restart
+    if c.servesNode() {
+        if err := c.nodeState.load(); err != nil {
+            log.Warnf("could not load node state from %s, %v", common.NodeStateDir, err)
+        }
+        if common.NodeCredentialless {
+            c.loadNodeMountInfo()
+        }
+        if c.NodeID != "" {
+            c.markAttachedVolumes()
+        }
+    }
+
+    serve()
+
+    if c.servesNode() {
+        c.startFreezeWatcher()
+    }
+
+    if c.NodeID != "" && common.NodeMountWarmup {
+        c.warmUpBackingShareMounts()
+    }
+
+    if common.BackingFileScrubInterval > 0 && c.servesController() {
+        c.startBackingFileScrubber(common.BackingFileScrubInterval)
+    }
+    if common.ReclaimSpaceInterval > 0 {
+        c.startSpaceReclaimer(common.ReclaimSpaceInterval)
+    }
+    // Nodes which also serve the controller would mount every backing share on every node
+    if common.FileTrashRetention > 0 && c.NodeID == "" && c.servesController() {
+        interval := common.FileTrashRetention
+        if interval > trashPurgeInterval {
+            interval = trashPurgeInterval
+        }
+        c.startTrashPurger(interval)
+    }
     return nil
 }
 
@@ -154,6 +300,7 @@ func (c *CSIDriver) Stop() {
         return
     }
 
+    close(c.stopCh)
     c.server.Stop()
     c.wg.Wait()
 }
@@ -183,21 +330,62 @@ func (c *CSIDriver) callInterceptor(
     return rsp, err
 }
 
+// strippedSecrets replaces the values of the secrets in the logged requests, as protosanitizer does
+const strippedSecrets = "***stripped***"
+
+// stripSecrets returns message as generic JSON with the values of every secrets field replaced,
+// CSI requests carry the Hammerspace credentials of tenants and csi-addons requests in them
+func stripSecrets(message interface{}) interface{} {
+    if message == nil {
+        return nil
+    }
+    raw, err := json.Marshal(message)
+    if err != nil {
+        return nil
+    }
+    var generic interface{}
+    if err := json.Unmarshal(raw, &generic); err != nil {
+        return nil
+    }
+    stripSecretFields(generic)
+    return generic
+}
+
+func stripSecretFields(value interface{}) {
+    switch v := value.(type) {
+    case map[string]interface{}:
+        for key, field := range v {
+            if key == "secrets" || key == "Secrets" {
+                v[key] = strippedSecrets
+                continue
+            }
+            stripSecretFields(field)
+        }
+    case []interface{}:
+        for _, item := range v {
+            stripSecretFields(item)
+        }
+    }
+}
+
 func logGRPC(method string, request, reply interface{}, err error) {
     // Log JSON with the request and response for easier parsing
     logMessage := struct {
-        Method   string
-        Request  interface{}
-        Response interface{}
-        Error    string
+        Method     string
+        Request    interface{}
+        Response   interface{}
+        Error      string
+        Attributes map[string]string
     }{
-        Method:   method,
-        Request:  request,
-        Response: reply,
+        Method:     method,
+        Request:    stripSecrets(request),
+        Response:   stripSecrets(reply),
+        Attributes: getCallAttributes(request, err),
     }
     if err != nil {
         logMessage.Error = err.Error()
     }
+    recordCall(method, logMessage.Attributes, err)
     msg, _ := json.Marshal(logMessage)
-    fmt.Printf("gRPCCall: %s\n", msg)
+    log.Infof("gRPCCall: %s", msg)
 }
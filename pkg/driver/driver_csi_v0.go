@@ -14,6 +14,8 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
+//go:build !nocsiv0
+
 package driver
 
 import (
@@ -33,6 +35,9 @@ import (
 	"k8s.io/kubernetes/pkg/kubelet/kubeletconfig/util/log"
 )
 
+// CSIv0Supported is whether this binary can serve CSI 0.3, builds tagged nocsiv0 leave it out
+const CSIv0Supported = true
+
 type CSIDriver_v0Support struct {
     listener      net.Listener
     server        *grpc.Server
@@ -81,17 +86,22 @@ func (c *CSIDriver_v0Support) Start(l net.Listener) error {
         }),
     )
 
-    csi_v0.RegisterControllerServer(c.server, c)
     csi_v0.RegisterIdentityServer(c.server, c)
-    csi_v0.RegisterNodeServer(c.server, c)
+    if c.driver.servesController() {
+        csi_v0.RegisterControllerServer(c.server, c)
+    }
+    if c.driver.servesNode() {
+        csi_v0.RegisterNodeServer(c.server, c)
+    }
     reflection.Register(c.server)
 
-    // Start listening for requests
-    waitForServer := make(chan bool)
-    c.goServe(waitForServer)
-    <-waitForServer
-    c.running = true
-    return nil
+    // Start listening for requests, with the same startup work and background tasks as CSI 1.x
+    return c.driver.startServing(func() {
+        waitForServer := make(chan bool)
+        c.goServe(waitForServer)
+        <-waitForServer
+        c.running = true
+    })
 }
 
 func (c *CSIDriver_v0Support) Stop() {
@@ -102,8 +112,10 @@ func (c *CSIDriver_v0Support) Stop() {
         return
     }
 
+    close(c.driver.stopCh)
     c.server.Stop()
     c.wg.Wait()
+    c.driver.wg.Wait()
 }
 
 func (c *CSIDriver_v0Support) Close() {
@@ -144,10 +156,13 @@ func (d *CSIDriver_v0Support) CreateVolume(
         caps = append(caps, capv1)
     }
 
-    // CapacityRange from v0 -> v1
-    capacityRange := &csi.CapacityRange{
-        RequiredBytes: req.GetCapacityRange().GetRequiredBytes(),
-        LimitBytes: req.GetCapacityRange().GetLimitBytes(),
+    // CapacityRange from v0 -> v1, leaving it unset when the request has none so the default size applies
+    var capacityRange *csi.CapacityRange
+    if req.GetCapacityRange() != nil {
+        capacityRange = &csi.CapacityRange{
+            RequiredBytes: req.GetCapacityRange().GetRequiredBytes(),
+            LimitBytes: req.GetCapacityRange().GetLimitBytes(),
+        }
     }
 
     //call driver
@@ -369,6 +384,9 @@ func (d *CSIDriver_v0Support) GetPluginCapabilities(
     req *csi_v0.GetPluginCapabilitiesRequest) (
     *csi_v0.GetPluginCapabilitiesResponse, error) {
 
+    if !d.driver.servesController() {
+        return &csi_v0.GetPluginCapabilitiesResponse{}, nil
+    }
     return &csi_v0.GetPluginCapabilitiesResponse{
         Capabilities: []*csi_v0.PluginCapability{
             {
@@ -399,6 +417,7 @@ func (d *CSIDriver_v0Support) NodeStageVolume(
         VolumeId: req.GetVolumeId(),
         VolumeCapability: capv1,
         PublishContext: req.GetVolumeAttributes(),
+        VolumeContext: req.GetVolumeAttributes(),
         Secrets: req.GetNodeStageSecrets(),
     })
 
@@ -418,28 +437,35 @@ func (d *CSIDriver_v0Support) NodeUnstageVolume(
     return &csi_v0.NodeUnstageVolumeResponse{}, err
 }
 
+// ConvertVolumeCapabilityFromv0Tov1 converts a CSI 0.3 volume capability. Raw block capabilities
+// are rejected unless HS_CSI_V0_BLOCK_VOLUMES is set.
 func ConvertVolumeCapabilityFromv0Tov1(capability *csi_v0.VolumeCapability) (*csi.VolumeCapability, error) {
 
-    // convert accesstype
-    accessType := capability.GetMount()
-
-    if accessType == nil {
-        return &csi.VolumeCapability{}, status.Error(codes.InvalidArgument, common.BlockVolumesUnsupported)
+    accessMode := &csi.VolumeCapability_AccessMode{
+        Mode: csi.VolumeCapability_AccessMode_Mode(capability.AccessMode.GetMode()),
     }
 
-    accessMode := csi.VolumeCapability_AccessMode_Mode(capability.AccessMode.GetMode())
-
-    return &csi.VolumeCapability{
-        AccessType: &csi.VolumeCapability_Mount{
-            Mount: &csi.VolumeCapability_MountVolume{
-                FsType: accessType.GetFsType(),
-                MountFlags: accessType.GetMountFlags(),
+    // convert accesstype
+    if accessType := capability.GetMount(); accessType != nil {
+        return &csi.VolumeCapability{
+            AccessType: &csi.VolumeCapability_Mount{
+                Mount: &csi.VolumeCapability_MountVolume{
+                    FsType: accessType.GetFsType(),
+                    MountFlags: accessType.GetMountFlags(),
+                },
             },
-        },
-        AccessMode: &csi.VolumeCapability_AccessMode{
-            Mode: accessMode,
-        },
-    }, nil
+            AccessMode: accessMode,
+        }, nil
+    }
+    if capability.GetBlock() != nil && common.CSIv0BlockVolumes {
+        return &csi.VolumeCapability{
+            AccessType: &csi.VolumeCapability_Block{
+                Block: &csi.VolumeCapability_BlockVolume{},
+            },
+            AccessMode: accessMode,
+        }, nil
+    }
+    return &csi.VolumeCapability{}, status.Error(codes.InvalidArgument, common.BlockVolumesUnsupported)
 }
 
 func (d *CSIDriver_v0Support) NodePublishVolume(
@@ -0,0 +1,40 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//go:build nocsiv0
+
+package driver
+
+import (
+    "errors"
+    "net"
+)
+
+// CSIv0Supported is whether this binary can serve CSI 0.3, builds tagged nocsiv0 leave it out
+const CSIv0Supported = false
+
+// CSIDriver_v0Support stands in for the CSI 0.3 server in builds without it, and fails to start
+type CSIDriver_v0Support struct{}
+
+func NewCSIDriver_v0Support(driver *CSIDriver) *CSIDriver_v0Support {
+    return &CSIDriver_v0Support{}
+}
+
+func (c *CSIDriver_v0Support) Start(l net.Listener) error {
+    return errors.New("this plugin was built without CSI 0.3 support")
+}
+
+func (c *CSIDriver_v0Support) Stop() {}
@@ -1,3 +1,5 @@
+//go:build !nocsiv0
+
 package driver
 
 import (
@@ -70,4 +72,18 @@ func TestConvertVolumeCapablityfromv0tov1(t *testing.T) {
             t.FailNow()
         }
     }
+
+    // Test that Raw volumes are translated behind HS_CSI_V0_BLOCK_VOLUMES
+    common.CSIv0BlockVolumes = true
+    defer func() { common.CSIv0BlockVolumes = false }()
+    actualcpv1, err = ConvertVolumeCapabilityFromv0Tov1(capv0)
+    if err != nil {
+        t.Logf("unexpected error, %s", err)
+        t.FailNow()
+    }
+    if actualcpv1.GetBlock() == nil ||
+        actualcpv1.GetAccessMode().GetMode() != csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
+        t.Logf("Expected block capability, actual: %v", actualcpv1)
+        t.FailNow()
+    }
 }
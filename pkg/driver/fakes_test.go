@@ -0,0 +1,41 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+)
+
+// newFakeDriver returns a driver whose Hammerspace client sends its requests to the handlers of
+// mux. Logins always succeed. The fake API is stopped when the test completes, its URL is the
+// endpoint of the driver's client.
+func newFakeDriver(t *testing.T, mux *http.ServeMux) *CSIDriver {
+    mux.HandleFunc(client.BasePath+"/login", func(w http.ResponseWriter, r *http.Request) {})
+    server := httptest.NewServer(mux)
+    t.Cleanup(server.Close)
+
+    hsclient, err := client.NewHammerspaceClient(server.URL, "user", "password", false)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    return &CSIDriver{sharedState: newSharedState(), hsclient: hsclient}
+}
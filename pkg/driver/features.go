@@ -0,0 +1,108 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "strings"
+    "sync"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Cluster features which depend on the Hammerspace license, and the keyword identifying them
+// in the features of the installed licenses
+const (
+    FeatureSnapshots    = "snapshots"
+    FeatureBlockVolumes = "block volumes"
+)
+
+var featureLicenseKeywords = map[string]string{
+    FeatureSnapshots:    "SNAPSHOT",
+    FeatureBlockVolumes: "BLOCK",
+}
+
+// clusterFeatures are the features the cluster is licensed for. Until licenses are detected,
+// or when the cluster does not report any, every feature is assumed available so that
+// operations are not refused because of a detection problem.
+type clusterFeatures struct {
+    lock     sync.Mutex
+    detected bool
+    missing  map[string]bool
+}
+
+// getMissingFeatures returns the features not covered by any unexpired license
+func getMissingFeatures(licenses []common.LicenseResponse) map[string]bool {
+    missing := map[string]bool{}
+    for feature, keyword := range featureLicenseKeywords {
+        licensed := false
+        for _, license := range licenses {
+            if license.Expired {
+                continue
+            }
+            for _, f := range license.Features {
+                if strings.Contains(strings.ToUpper(f), keyword) {
+                    licensed = true
+                }
+            }
+        }
+        if !licensed {
+            missing[feature] = true
+        }
+    }
+    return missing
+}
+
+// detectFeatures reads the cluster licenses, once they have been read successfully it does nothing
+func (d *CSIDriver) detectFeatures() {
+    d.features.lock.Lock()
+    defer d.features.lock.Unlock()
+    if d.features.detected {
+        return
+    }
+    licenses, err := d.hsclient.ListLicenses()
+    if err != nil {
+        log.Warnf("could not detect licensed features, assuming all are available, %v", err)
+        return
+    }
+    d.features.detected = true
+    if len(licenses) == 0 {
+        log.Infof("cluster reports no licenses, assuming all features are available")
+        return
+    }
+    d.features.missing = getMissingFeatures(licenses)
+    for feature := range d.features.missing {
+        log.Warnf("cluster is not licensed for %s, the feature is disabled", feature)
+    }
+}
+
+func (d *CSIDriver) isFeatureAvailable(feature string) bool {
+    d.features.lock.Lock()
+    defer d.features.lock.Unlock()
+    return !d.features.missing[feature]
+}
+
+// checkFeature returns a FailedPrecondition error when the cluster is not licensed for feature
+func (d *CSIDriver) checkFeature(feature string) error {
+    if !d.isFeatureAvailable(feature) {
+        return status.Errorf(codes.FailedPrecondition, common.FeatureNotLicensed, feature)
+    }
+    return nil
+}
@@ -0,0 +1,40 @@
+package driver
+
+import (
+    "reflect"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestGetMissingFeatures(t *testing.T) {
+    licenses := []common.LicenseResponse{
+        {Name: "base", Features: []string{"DATA_SERVICES", "Block_Volumes"}},
+        {Name: "trial", Features: []string{"SNAPSHOTS"}, Expired: true},
+    }
+    missing := getMissingFeatures(licenses)
+    expected := map[string]bool{FeatureSnapshots: true}
+    if !reflect.DeepEqual(missing, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", missing)
+        t.FailNow()
+    }
+
+    d := &CSIDriver{}
+    if err := d.checkFeature(FeatureSnapshots); err != nil {
+        t.Logf("Expected features to be available until detected, %v", err)
+        t.FailNow()
+    }
+    d.features.missing = missing
+    if err := d.checkFeature(FeatureSnapshots); status.Code(err) != codes.FailedPrecondition {
+        t.Logf("Expected FailedPrecondition, got %v", err)
+        t.FailNow()
+    }
+    if err := d.checkFeature(FeatureBlockVolumes); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+}
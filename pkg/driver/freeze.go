@@ -0,0 +1,271 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "io/ioutil"
+    "os"
+    "path"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Filesystems on file-backed volumes are frozen for snapshots through files in a directory of the
+// volume's backing share, which the controller and the nodes publishing the volume both have mounted:
+//   <volume>.published.<node>  written by a node while it has the volume published
+//   <volume>.request           written by the controller, contains the snapshot name to freeze for
+//   <volume>.frozen.<node>     written by a node once the filesystem is frozen for the request
+// The controller removes the request once the snapshot is taken and the nodes thaw the filesystem.
+// Nodes also thaw after FreezeTimeout so that a lost controller cannot leave a volume frozen.
+const (
+    freezeDirName      = ".csi-freeze"
+    freezePollInterval = time.Second
+)
+
+func getFreezeFile(volumeID, suffix string) string {
+    id, _ := ParseVolumeID(volumeID)
+    freezeDir := common.StagingPath(id.BackingSharePath(), freezeDirName)
+    return path.Join(freezeDir, id.Name+"."+suffix)
+}
+
+func writeFreezeFile(filePath, contents string) error {
+    if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+        return err
+    }
+    return ioutil.WriteFile(filePath, []byte(contents), 0644)
+}
+
+func readFreezeFile(filePath string) string {
+    data, err := ioutil.ReadFile(filePath)
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(string(data))
+}
+
+func isFileBackedFilesystem(v nodeVolumeState) bool {
+    return v.State == NodeVolumePublished && v.BackingShareName != "" && v.FSType != ""
+}
+
+// markVolumeFreezable lets the controller know this node must freeze the volume for snapshots
+func (d *CSIDriver) markVolumeFreezable(volumeID string) {
+    err := writeFreezeFile(getFreezeFile(volumeID, "published."+d.NodeID), d.NodeID)
+    if err != nil {
+        log.Warnf("could not mark volume %s as published for filesystem freezes, %v", volumeID, err)
+    }
+}
+
+func (d *CSIDriver) unmarkVolumeFreezable(volumeID string) {
+    err := os.Remove(getFreezeFile(volumeID, "published."+d.NodeID))
+    if err != nil && !os.IsNotExist(err) {
+        log.Warnf("could not unmark volume %s as published for filesystem freezes, %v", volumeID, err)
+    }
+}
+
+type freezeState struct {
+    requestID string
+    frozenAt  time.Time
+    thawed    bool
+}
+
+func (d *CSIDriver) thawVolume(volumeID, targetPath string) {
+    if err := common.ThawFilesystem(targetPath); err != nil {
+        log.Errorf("could not thaw filesystem of volume %s at %s, %v", volumeID, targetPath, err)
+    }
+    os.Remove(getFreezeFile(volumeID, "frozen."+d.NodeID))
+}
+
+// checkFreezeRequests freezes and thaws the filesystems of published file-backed volumes to
+// follow the requests made by the controller. frozen is keyed by target path.
+func (d *CSIDriver) checkFreezeRequests(frozen map[string]freezeState) {
+    published := map[string]bool{}
+    for _, v := range d.nodeState.list() {
+        if !isFileBackedFilesystem(v) {
+            continue
+        }
+        published[v.Path] = true
+        requestID := readFreezeFile(getFreezeFile(v.VolumeID, "request"))
+
+        state, tracked := frozen[v.Path]
+        if tracked && state.requestID == requestID {
+            if !state.thawed && time.Since(state.frozenAt) > common.FreezeTimeout {
+                log.Warnf("thawing volume %s, frozen for snapshot %s longer than %v",
+                    v.VolumeID, requestID, common.FreezeTimeout)
+                d.thawVolume(v.VolumeID, v.Path)
+                state.thawed = true
+                frozen[v.Path] = state
+            }
+            continue
+        }
+        // The request was completed or replaced
+        if tracked {
+            if !state.thawed {
+                log.Infof("thawing volume %s after snapshot %s", v.VolumeID, state.requestID)
+                d.thawVolume(v.VolumeID, v.Path)
+            }
+            delete(frozen, v.Path)
+        }
+        if requestID == "" {
+            continue
+        }
+
+        log.Infof("freezing volume %s at %s for snapshot %s", v.VolumeID, v.Path, requestID)
+        if err := common.FreezeFilesystem(v.Path); err != nil {
+            log.Errorf("could not freeze filesystem of volume %s at %s, %v", v.VolumeID, v.Path, err)
+            continue
+        }
+        state = freezeState{requestID: requestID, frozenAt: time.Now()}
+        err := writeFreezeFile(getFreezeFile(v.VolumeID, "frozen."+d.NodeID), requestID)
+        if err != nil {
+            // The controller will give up waiting, do not stay frozen until then
+            log.Errorf("could not acknowledge freeze of volume %s, %v", v.VolumeID, err)
+            d.thawVolume(v.VolumeID, v.Path)
+            state.thawed = true
+        }
+        frozen[v.Path] = state
+    }
+    // Forget volumes which were unpublished
+    for p := range frozen {
+        if !published[p] {
+            delete(frozen, p)
+        }
+    }
+}
+
+// startFreezeWatcher follows freeze requests for volumes published on this node until the driver is stopped
+func (d *CSIDriver) startFreezeWatcher() {
+    d.wg.Add(1)
+    go func() {
+        defer d.wg.Done()
+        frozen := map[string]freezeState{}
+        ticker := time.NewTicker(freezePollInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-d.stopCh:
+                for _, v := range d.nodeState.list() {
+                    if state, tracked := frozen[v.Path]; tracked && !state.thawed {
+                        d.thawVolume(v.VolumeID, v.Path)
+                    }
+                }
+                return
+            case <-ticker.C:
+                d.checkFreezeRequests(frozen)
+            }
+        }
+    }()
+}
+
+// getPublishingNodes returns the nodes which have marked the volume as published
+func getPublishingNodes(volumeID string) ([]string, error) {
+    freezeDir := path.Dir(getFreezeFile(volumeID, ""))
+    files, err := ioutil.ReadDir(freezeDir)
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    id, _ := ParseVolumeID(volumeID)
+    publishedPrefix := id.Name + ".published."
+    nodes := []string{}
+    for _, f := range files {
+        if strings.HasPrefix(f.Name(), publishedPrefix) {
+            nodes = append(nodes, strings.TrimPrefix(f.Name(), publishedPrefix))
+        }
+    }
+    return nodes, nil
+}
+
+// getPendingFreezeNodes returns the nodes publishing the volume which have not frozen it for requestID
+func getPendingFreezeNodes(volumeID, requestID string) ([]string, error) {
+    nodes, err := getPublishingNodes(volumeID)
+    if err != nil {
+        return nil, err
+    }
+    pending := []string{}
+    for _, node := range nodes {
+        if readFreezeFile(getFreezeFile(volumeID, "frozen."+node)) != requestID {
+            pending = append(pending, node)
+        }
+    }
+    return pending, nil
+}
+
+// freezeFileBackedVolume asks the nodes publishing a file-backed volume to freeze its filesystem
+// and waits until they all have. The returned function releases the freeze.
+func (d *CSIDriver) freezeFileBackedVolume(volumeID, requestID string) (func(), error) {
+    id, _ := ParseVolumeID(volumeID)
+    backingShareName := id.BackingShare
+    // The lock on the backing share is held until the volume is thawed, so that the share is not
+    // unmounted while nodes look for the request
+    d.getVolumeLock(backingShareName)
+    err := d.EnsureBackingShareMounted(backingShareName)
+    if err != nil {
+        d.releaseVolumeLock(backingShareName)
+        log.Errorf("failed to ensure backing share is mounted, %v", err)
+        return nil, err
+    }
+    release := func() {
+        d.scheduleBackingShareUnmount(backingShareName)
+        d.releaseVolumeLock(backingShareName)
+    }
+    requestFile := getFreezeFile(volumeID, "request")
+    thaw := func() {
+        if pending, _ := getPendingFreezeNodes(volumeID, requestID); len(pending) > 0 {
+            log.Warnf("volume %s was thawed on %v before snapshot %s completed", volumeID, pending, requestID)
+        }
+        os.Remove(requestFile)
+        release()
+    }
+
+    pending, err := getPendingFreezeNodes(volumeID, requestID)
+    if err != nil {
+        release()
+        return nil, status.Error(codes.Internal, err.Error())
+    }
+    if len(pending) == 0 {
+        log.Infof("volume %s is not published, no filesystem to freeze", volumeID)
+        return thaw, nil
+    }
+
+    err = writeFreezeFile(requestFile, requestID)
+    if err != nil {
+        release()
+        return nil, status.Error(codes.Internal, err.Error())
+    }
+    deadline := time.Now().Add(common.FreezeTimeout)
+    for len(pending) > 0 {
+        if time.Now().After(deadline) {
+            thaw()
+            return nil, status.Errorf(codes.Unavailable, common.FreezeTimedOut, volumeID, strings.Join(pending, ","))
+        }
+        time.Sleep(freezePollInterval)
+        pending, err = getPendingFreezeNodes(volumeID, requestID)
+        if err != nil {
+            thaw()
+            return nil, status.Error(codes.Internal, err.Error())
+        }
+    }
+    log.Infof("filesystem of volume %s frozen for snapshot %s", volumeID, requestID)
+    return thaw, nil
+}
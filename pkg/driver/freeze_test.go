@@ -0,0 +1,52 @@
+package driver
+
+import (
+    "io/ioutil"
+    "os"
+    "path"
+    "reflect"
+    "testing"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestGetPendingFreezeNodes(t *testing.T) {
+    backingDir, err := ioutil.TempDir(common.ShareStagingDir, "freeze-test")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(backingDir)
+    volumeID := "/" + path.Base(backingDir) + "/test-volume"
+
+    pending, err := getPendingFreezeNodes(volumeID, "snap1")
+    if err != nil || len(pending) != 0 {
+        t.Logf("Expected no nodes for an unpublished volume, %v %v", pending, err)
+        t.FailNow()
+    }
+
+    for _, f := range []string{"published.node1", "published.node2"} {
+        if err := writeFreezeFile(getFreezeFile(volumeID, f), ""); err != nil {
+            t.Log(err)
+            t.FailNow()
+        }
+    }
+    // node2 acknowledged a previous request only
+    writeFreezeFile(getFreezeFile(volumeID, "frozen.node1"), "snap1")
+    writeFreezeFile(getFreezeFile(volumeID, "frozen.node2"), "snap0")
+
+    expected := []string{"node2"}
+    pending, err = getPendingFreezeNodes(volumeID, "snap1")
+    if err != nil || !reflect.DeepEqual(pending, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v, %v", pending, err)
+        t.FailNow()
+    }
+
+    writeFreezeFile(getFreezeFile(volumeID, "frozen.node2"), "snap1")
+    pending, err = getPendingFreezeNodes(volumeID, "snap1")
+    if err != nil || len(pending) != 0 {
+        t.Logf("Expected all nodes to be frozen, %v %v", pending, err)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Subsystems reported by the detailed health check
+const (
+    HealthHammerspaceAPI = "hammerspace-api"
+    HealthLogin          = "login"
+    HealthObjectiveCache = "objective-cache"
+    HealthDataPortals    = "data-portals"
+    HealthBinaries       = "binaries"
+)
+
+// SubsystemHealth is the state of one subsystem of the plugin
+type SubsystemHealth struct {
+    Name    string `json:"name"`
+    Healthy bool   `json:"healthy"`
+    Message string `json:"message,omitempty"`
+}
+
+// DetailedHealth is the body served at /healthz/detailed
+type DetailedHealth struct {
+    Healthy    bool              `json:"healthy"`
+    Degraded   []string          `json:"degraded"`
+    Subsystems []SubsystemHealth `json:"subsystems"`
+}
+
+func newDetailedHealth(subsystems []SubsystemHealth) DetailedHealth {
+    health := DetailedHealth{
+        Healthy:    true,
+        Degraded:   []string{},
+        Subsystems: subsystems,
+    }
+    for _, s := range subsystems {
+        if !s.Healthy {
+            health.Healthy = false
+            health.Degraded = append(health.Degraded, s.Name)
+        }
+    }
+    return health
+}
+
+func subsystemHealth(name string, err error, message string) SubsystemHealth {
+    if err != nil {
+        return SubsystemHealth{Name: name, Healthy: false, Message: err.Error()}
+    }
+    return SubsystemHealth{Name: name, Healthy: true, Message: message}
+}
+
+// getRequiredBinaries returns the binaries which must be installed for this plugin to be ready
+func (d *CSIDriver) getRequiredBinaries() []string {
+    binaries := common.RequiredBinaries
+    if d.NodeID != "" {
+        binaries = append(append([]string{}, binaries...), common.RequiredNodeBinaries...)
+    }
+    return binaries
+}
+
+// checkHealth checks each subsystem of the plugin. Unlike Probe it does not stop at the first
+// failure, so that all degraded subsystems are reported.
+func (d *CSIDriver) checkHealth() DetailedHealth {
+    subsystems := []SubsystemHealth{}
+    // Nodes without credentials never call the Hammerspace API
+    if !common.NodeCredentialless {
+        subsystems = append(subsystems, d.checkAPIHealth()...)
+    }
+
+    var err error
+    if missing := common.FindMissingBinaries(d.getRequiredBinaries()); len(missing) > 0 {
+        err = fmt.Errorf(common.MissingBinaries, strings.Join(missing, ", "))
+    }
+    subsystems = append(subsystems, subsystemHealth(HealthBinaries, err, ""))
+
+    return newDetailedHealth(subsystems)
+}
+
+// checkAPIHealth checks the subsystems depending on the Hammerspace API
+func (d *CSIDriver) checkAPIHealth() []SubsystemHealth {
+    subsystems := []SubsystemHealth{}
+
+    version, err := d.hsclient.GetClusterSoftwareVersion()
+    subsystems = append(subsystems, subsystemHealth(HealthHammerspaceAPI, err, "cluster version "+version))
+
+    lastLogin, err := d.hsclient.LastLogin()
+    if err == nil && lastLogin.IsZero() {
+        err = fmt.Errorf("not logged in yet")
+    }
+    subsystems = append(subsystems, subsystemHealth(HealthLogin, err,
+        fmt.Sprintf("logged in %v ago", time.Since(lastLogin).Round(time.Second))))
+
+    if d.NodeID == "" {
+        age, err := d.objectives.warm(d.hsclient.ListObjectiveNames)
+        subsystems = append(subsystems, subsystemHealth(HealthObjectiveCache, err,
+            fmt.Sprintf("objectives fetched %v ago", age.Round(time.Second))))
+    }
+
+    portals, err := d.hsclient.GetDataPortals(d.NodeID)
+    if err == nil && len(portals) == 0 {
+        err = fmt.Errorf("no data-portals available")
+    }
+    subsystems = append(subsystems, subsystemHealth(HealthDataPortals, err,
+        fmt.Sprintf("%d data-portals available", len(portals))))
+    return subsystems
+}
+
+// ServeDetailedHealth reports the state of each subsystem as JSON, with status 503 if any is degraded
+func (d *CSIDriver) ServeDetailedHealth(w http.ResponseWriter, r *http.Request) {
+    health := d.checkHealth()
+    w.Header().Set("Content-Type", "application/json")
+    if !health.Healthy {
+        log.Warnf("detailed health check found degraded subsystems %v", health.Degraded)
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }
+    json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,33 @@
+package driver
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+)
+
+func TestNewDetailedHealth(t *testing.T) {
+    health := newDetailedHealth([]SubsystemHealth{
+        subsystemHealth(HealthHammerspaceAPI, nil, "cluster version 5.0.0"),
+        subsystemHealth(HealthDataPortals, errors.New("no data-portals available"), "0 data-portals available"),
+        subsystemHealth(HealthBinaries, nil, ""),
+    })
+    if health.Healthy {
+        t.Logf("Expected health to be degraded")
+        t.FailNow()
+    }
+    if !reflect.DeepEqual(health.Degraded, []string{HealthDataPortals}) {
+        t.Logf("Unexpected degraded subsystems %v", health.Degraded)
+        t.FailNow()
+    }
+    if health.Subsystems[1].Message != "no data-portals available" {
+        t.Logf("Expected the error as message, got %s", health.Subsystems[1].Message)
+        t.FailNow()
+    }
+
+    health = newDetailedHealth([]SubsystemHealth{subsystemHealth(HealthBinaries, nil, "")})
+    if !health.Healthy || len(health.Degraded) != 0 {
+        t.Logf("Expected health to be healthy, %v", health)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "strings"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// The volume policy hook and the snapshot hooks are commands run with /bin/sh and webhooks POSTed
+// JSON by the controller. Both kinds of hook are called through the helpers below. Commands only
+// get the variables in hookEnvironment from the environment of the plugin, which also holds the
+// Hammerspace credentials.
+const maxHookResponseBytes = 1024
+
+var hookEnvironment = []string{"PATH"}
+
+// hookRejected is returned by postHookWebhook when the webhook answers with a non-2xx status
+type hookRejected struct {
+    statusCode int
+    body       string
+}
+
+func (e hookRejected) Error() string {
+    return fmt.Sprintf("status code %d, %s", e.statusCode, e.body)
+}
+
+// postHookWebhook POSTs payload as JSON to webhook, failing with hookRejected and the start of the
+// response body if it is not accepted
+func postHookWebhook(webhook string, payload interface{}) error {
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+    client := &http.Client{Timeout: common.CommandExecTimeout}
+    resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        reason, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxHookResponseBytes))
+        return hookRejected{resp.StatusCode, strings.TrimSpace(string(reason))}
+    }
+    return nil
+}
+
+// runHookCommand runs command with /bin/sh in an environment of hookEnvironment and the given
+// NAME=value variables, returning its output
+func runHookCommand(command string, variables ...string) ([]byte, error) {
+    args := []string{"-i"}
+    for _, name := range hookEnvironment {
+        if value, exists := os.LookupEnv(name); exists {
+            args = append(args, name+"="+value)
+        }
+    }
+    args = append(append(args, variables...), "/bin/sh", "-c", command)
+    return common.ExecCommand("env", args...)
+}
+
+// isSnapshotHookAllowed returns whether the administrator allowed a snapshot hook command or
+// webhook in HS_SNAPSHOT_HOOK_ALLOWLIST. Commands must be listed as they are given, webhooks
+// may also be under a listed URL ending with a slash.
+func isSnapshotHookAllowed(hook string) bool {
+    for _, allowed := range common.SnapshotHookAllowlist {
+        if hook == allowed {
+            return true
+        }
+        isURL := strings.HasPrefix(allowed, "http://") || strings.HasPrefix(allowed, "https://")
+        if isURL && strings.HasSuffix(allowed, "/") && strings.HasPrefix(hook, allowed) {
+            return true
+        }
+    }
+    return false
+}
@@ -17,15 +17,42 @@ limitations under the License.
 package driver
 
 import (
+	"runtime"
+	"strings"
+
 	"golang.org/x/net/context"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/hammer-space/csi-plugin/pkg/client"
 	"github.com/hammer-space/csi-plugin/pkg/common"
+	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	wrappers "google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+// MetricBuildInfo is always 1, its labels identify the build of the running plugin so fleet
+// audits can tell which versions run where
+const MetricBuildInfo = "hs_csi_build_info"
+
+func init() {
+    common.RegisterMetric(MetricBuildInfo, common.MetricTypeGauge,
+        "Build of the running plugin, labelled with its version, always 1")
+}
+
+// RecordBuildInfo exports the build of the plugin as hs_csi_build_info, once the CSI version it
+// serves is known
+func RecordBuildInfo() {
+    common.SetMetric(MetricBuildInfo, map[string]string{
+        "version":      common.Version,
+        "githash":      common.Githash,
+        "build_date":   common.BuildDate,
+        "go_version":   runtime.Version(),
+        "csi_version":  common.CsiVersion,
+        "hs_api_level": client.APILevel,
+    }, 1)
+}
+
 func (d *CSIDriver) GetPluginInfo(
     ctx context.Context,
     req *csi.GetPluginInfoRequest) (
@@ -33,6 +60,11 @@ func (d *CSIDriver) GetPluginInfo(
 
     manifest := map[string]string{}
     manifest["githash"] = common.Githash
+    manifest["buildDate"] = common.BuildDate
+    manifest["goVersion"] = runtime.Version()
+    manifest["csiVersion"] = common.CsiVersion
+    manifest["hsAPILevel"] = client.APILevel
+    manifest["hsVersion"] = d.getHSVersion()
 
     return &csi.GetPluginInfoResponse{
         Name:          common.CsiPluginName,
@@ -41,19 +73,55 @@ func (d *CSIDriver) GetPluginInfo(
     }, nil
 }
 
+// getHSVersion returns the software version of the Hammerspace cluster, which is
+// looked up once it can be reached and cached for the life of the plugin
+func (d *CSIDriver) getHSVersion() string {
+    if common.NodeCredentialless {
+        return "unknown"
+    }
+    d.hsVersionLock.Lock()
+    defer d.hsVersionLock.Unlock()
+    if d.hsVersion == "" {
+        version, err := d.hsclient.GetClusterSoftwareVersion()
+        if err != nil || version == "" {
+            log.Warnf("could not determine Hammerspace version, %v", err)
+            return "unknown"
+        }
+        d.hsVersion = version
+    }
+    return d.hsVersion
+}
+
 func (d *CSIDriver) Probe(
     ctx context.Context,
     req *csi.ProbeRequest) (
     *csi.ProbeResponse, error) {
 
-    // Make sure the client and backend can communicate
-    err := d.hsclient.EnsureLogin()
+    // Make sure the client and backend can communicate, nodes without credentials never log in
+    var err error
+    if !common.NodeCredentialless {
+        err = d.hsclient.EnsureLogin()
+    }
     if err != nil {
+        log.Warnf("probe failed, could not log in to Hammerspace, %v", err)
         return &csi.ProbeResponse{
             Ready: &wrappers.BoolValue{Value: false},
         }, status.Errorf(codes.Unavailable, err.Error())
     }
 
+    // Retry detecting licensed features if the cluster could not be reached at startup
+    if d.NodeID == "" {
+        d.detectFeatures()
+    }
+
+    // Make sure the binaries used to mount and format volumes are installed
+    if missing := common.FindMissingBinaries(d.getRequiredBinaries()); len(missing) > 0 {
+        log.Warnf("probe failed, missing binaries %v", missing)
+        return &csi.ProbeResponse{
+            Ready: &wrappers.BoolValue{Value: false},
+        }, status.Errorf(codes.FailedPrecondition, common.MissingBinaries, strings.Join(missing, ", "))
+    }
+
     return &csi.ProbeResponse{
         Ready: &wrappers.BoolValue{Value: true},
     }, nil
@@ -64,36 +132,42 @@ func (d *CSIDriver) GetPluginCapabilities(
     req *csi.GetPluginCapabilitiesRequest) (
     *csi.GetPluginCapabilitiesResponse, error) {
 
-    return &csi.GetPluginCapabilitiesResponse{
-        Capabilities: []*csi.PluginCapability{
-            {
-                Type: &csi.PluginCapability_Service_{
-                    Service: &csi.PluginCapability_Service{
-                        Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-                    },
+    capabilities := []*csi.PluginCapability{
+        {
+            Type: &csi.PluginCapability_Service_{
+                Service: &csi.PluginCapability_Service{
+                    Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
                 },
             },
-            {
-                Type: &csi.PluginCapability_Service_{
-                    Service: &csi.PluginCapability_Service{
-                        Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
-                    },
+        },
+        {
+            Type: &csi.PluginCapability_VolumeExpansion_{
+                VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+                    Type: csi.PluginCapability_VolumeExpansion_ONLINE,
                 },
             },
-            {
-               Type: &csi.PluginCapability_VolumeExpansion_{
-                   VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
-                       Type: csi.PluginCapability_VolumeExpansion_ONLINE,
-                   },
-               },
+        },
+        {
+            Type: &csi.PluginCapability_VolumeExpansion_{
+                VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+                    Type: csi.PluginCapability_VolumeExpansion_OFFLINE,
+                },
             },
+        },
+    }
+    // The node DaemonSet does not serve the controller service in node mode
+    if d.servesController() {
+        capabilities = append([]*csi.PluginCapability{
             {
-                Type: &csi.PluginCapability_VolumeExpansion_{
-                    VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
-                        Type: csi.PluginCapability_VolumeExpansion_OFFLINE,
+                Type: &csi.PluginCapability_Service_{
+                    Service: &csi.PluginCapability_Service{
+                        Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
                     },
                 },
             },
-        },
+        }, capabilities...)
+    }
+    return &csi.GetPluginCapabilitiesResponse{
+        Capabilities: capabilities,
     }, nil
 }
@@ -0,0 +1,36 @@
+package driver
+
+import (
+    "runtime"
+    "strings"
+    "testing"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestRecordBuildInfo(t *testing.T) {
+    RecordBuildInfo()
+    metrics := common.RenderMetrics()
+    var line string
+    for _, l := range strings.Split(metrics, "\n") {
+        if strings.HasPrefix(l, MetricBuildInfo+"{") {
+            line = l
+        }
+    }
+    for _, expected := range []string{
+        `version="` + common.Version + `"`,
+        `go_version="` + runtime.Version() + `"`,
+        `hs_api_level="` + client.APILevel + `"`,
+        `csi_version="` + common.CsiVersion + `"`,
+    } {
+        if !strings.Contains(line, expected) {
+            t.Logf("Expected %s in %s, received %s", expected, MetricBuildInfo, line)
+            t.FailNow()
+        }
+    }
+    if !strings.HasSuffix(line, " 1") {
+        t.Logf("Expected %s to be 1, received %s", MetricBuildInfo, line)
+        t.FailNow()
+    }
+}
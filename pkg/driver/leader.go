@@ -0,0 +1,115 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// When the controller runs with more than one replica, the sidecars elect which replica serves
+// requests, but the background loops of this process run in every replica. HS_LEADER_CHECK tells
+// the plugin how to find out whether its replica is the leader, so only that one runs them:
+//   file:<path>       leader while the file exists, e.g. written by a leader election sidecar
+//   http(s)://<url>   leader while a GET of the URL returns 200
+// Without a check every replica is considered the leader.
+const (
+    leaderCheckFilePrefix = "file:"
+    leaderCheckCacheTime  = 5 * time.Second
+)
+
+type leaderState struct {
+    lock      sync.Mutex
+    isLeader  bool
+    checked   bool
+    checkedAt time.Time
+}
+
+var leaderHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// ValidateLeaderCheck returns an error if the leader check is not of a supported form
+func ValidateLeaderCheck(check string) error {
+    if check == "" {
+        return nil
+    }
+    if strings.HasPrefix(check, leaderCheckFilePrefix) {
+        if strings.TrimPrefix(check, leaderCheckFilePrefix) == "" {
+            return fmt.Errorf("leader check file path is empty")
+        }
+        return nil
+    }
+    u, err := url.Parse(check)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("leader check must be file:<path> or an http or https URL, received '%s'", check)
+    }
+    return nil
+}
+
+func runLeaderCheck(check string) (bool, error) {
+    if check == "" {
+        return true, nil
+    }
+    if strings.HasPrefix(check, leaderCheckFilePrefix) {
+        _, err := os.Stat(strings.TrimPrefix(check, leaderCheckFilePrefix))
+        if os.IsNotExist(err) {
+            return false, nil
+        }
+        return err == nil, err
+    }
+    resp, err := leaderHTTPClient.Get(check)
+    if err != nil {
+        return false, err
+    }
+    resp.Body.Close()
+    return resp.StatusCode == http.StatusOK, nil
+}
+
+// isLeader reports whether this replica should run background loops. A replica which cannot
+// determine its leadership stops them, two replicas running them at once is the worse outcome.
+func (d *CSIDriver) isLeader() bool {
+    d.leader.lock.Lock()
+    defer d.leader.lock.Unlock()
+    if d.leader.checked && time.Since(d.leader.checkedAt) < leaderCheckCacheTime {
+        return d.leader.isLeader
+    }
+
+    isLeader, err := runLeaderCheck(common.LeaderCheck)
+    if err != nil {
+        log.Warnf("could not determine leadership, %v", err)
+        isLeader = false
+    }
+    if !d.leader.checked || isLeader != d.leader.isLeader {
+        if isLeader {
+            log.Infof("this replica is the leader, running background tasks")
+        } else {
+            log.Infof("this replica is not the leader, pausing background tasks")
+        }
+    }
+    d.leader.isLeader = isLeader
+    d.leader.checked = true
+    d.leader.checkedAt = time.Now()
+    return isLeader
+}
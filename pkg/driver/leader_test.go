@@ -0,0 +1,82 @@
+package driver
+
+import (
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path"
+    "testing"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestValidateLeaderCheck(t *testing.T) {
+    valid := []string{"", "file:/var/run/leader", "http://localhost:8080/leader", "https://leader.example.com"}
+    for _, check := range valid {
+        if err := ValidateLeaderCheck(check); err != nil {
+            t.Logf("Expected %s to be valid, %v", check, err)
+            t.FailNow()
+        }
+    }
+    invalid := []string{"file:", "/var/run/leader", "tcp://localhost:8080", "http://"}
+    for _, check := range invalid {
+        if err := ValidateLeaderCheck(check); err == nil {
+            t.Logf("Expected %s to be invalid", check)
+            t.FailNow()
+        }
+    }
+}
+
+func TestIsLeader(t *testing.T) {
+    defer func() { common.LeaderCheck = "" }()
+    dir, err := ioutil.TempDir("", "leader")
+    if err != nil {
+        t.FailNow()
+    }
+    defer os.RemoveAll(dir)
+    leaderFile := path.Join(dir, "leader")
+
+    d := &CSIDriver{}
+    if !d.isLeader() {
+        t.Logf("Expected to be the leader without a leader check")
+        t.FailNow()
+    }
+
+    common.LeaderCheck = "file:" + leaderFile
+    d = &CSIDriver{}
+    if d.isLeader() {
+        t.Logf("Expected not to be the leader without the leader file")
+        t.FailNow()
+    }
+    ioutil.WriteFile(leaderFile, []byte{}, 0644)
+    d.leader.checked = false
+    if !d.isLeader() {
+        t.Logf("Expected to be the leader with the leader file")
+        t.FailNow()
+    }
+
+    leader := false
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !leader {
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+    }))
+    defer server.Close()
+    common.LeaderCheck = server.URL
+    d = &CSIDriver{}
+    if d.isLeader() {
+        t.Logf("Expected not to be the leader when the endpoint fails")
+        t.FailNow()
+    }
+    leader = true
+    if d.isLeader() {
+        t.Logf("Expected the leadership to be cached")
+        t.FailNow()
+    }
+    d.leader.checked = false
+    if !d.isLeader() {
+        t.Logf("Expected to be the leader when the endpoint succeeds")
+        t.FailNow()
+    }
+}
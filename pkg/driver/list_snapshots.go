@@ -0,0 +1,238 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "path"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    timestamp "google.golang.org/protobuf/types/known/timestamppb"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// ListSnapshots looks up a single snapshot, or the snapshots of a single volume, with one or two
+// API calls when snapshot_id or source_volume_id is set. Otherwise the snapshots of every volume
+// ListVolumes returns are listed, one or two calls per volume, and the listing answers the
+// requests for its further pages like ListVolumes. Snapshots are ordered by snapshot ID, which is
+// also the next_token.
+const (
+    // Time at the start of the names of share snapshots, 2019.10.01.12.00.00.snap
+    shareSnapshotTimeLayout = "2006.01.02.15.04.05"
+)
+
+// Layouts tried for the time of file snapshots
+var fileSnapshotTimeLayouts = []string{"2006-01-02-15-04-05", "2006-01-02-15-04", time.RFC3339}
+
+type snapshotListCache struct {
+    lock     sync.Mutex
+    entries  []*csi.ListSnapshotsResponse_Entry
+    listedAt time.Time
+}
+
+// getFileSnapshotTime returns the time part of the name of a file snapshot, which is how the
+// API matches file snapshots
+func getFileSnapshotTime(snapshotName string) string {
+    fields := strings.SplitN(path.Base(snapshotName), "-", 6)
+    if len(fields) > 5 {
+        fields = fields[:5]
+    }
+    return strings.Join(fields, "-")
+}
+
+// parseSnapshotTime returns the time a snapshot was taken from the start of its name, nil if the
+// name does not start with a time in one of layouts
+func parseSnapshotTime(snapshotName string, layouts []string) *timestamp.Timestamp {
+    for _, layout := range layouts {
+        if len(snapshotName) < len(layout) {
+            continue
+        }
+        t, err := time.Parse(layout, snapshotName[:len(layout)])
+        if err == nil {
+            return &timestamp.Timestamp{Seconds: t.Unix()}
+        }
+    }
+    return nil
+}
+
+// getFileSnapshotID returns the ID of a snapshot of the file of a file-backed volume. SnapshotFile
+// names snapshots after the time they were taken and the file, <time>-<file name>, while the API
+// lists them by time alone, so listed snapshots get the ID CreateSnapshot returned for them.
+func getFileSnapshotID(snapshotName, sourceVolumeID string) string {
+    suffix := "-" + path.Base(sourceVolumeID)
+    if !strings.HasSuffix(snapshotName, suffix) {
+        snapshotName += suffix
+    }
+    return GetSnapshotIDFromSnapshotName(snapshotName, sourceVolumeID)
+}
+
+func newFileSnapshotEntry(snapshotTime, sourceVolumeID string) *csi.ListSnapshotsResponse_Entry {
+    entry := newSnapshotEntry(snapshotTime, sourceVolumeID, fileSnapshotTimeLayouts)
+    entry.Snapshot.SnapshotId = getFileSnapshotID(snapshotTime, sourceVolumeID)
+    return entry
+}
+
+func newSnapshotEntry(snapshotName, sourceVolumeID string, layouts []string) *csi.ListSnapshotsResponse_Entry {
+    return &csi.ListSnapshotsResponse_Entry{
+        Snapshot: &csi.Snapshot{
+            SnapshotId:     GetSnapshotIDFromSnapshotName(snapshotName, sourceVolumeID),
+            SourceVolumeId: sourceVolumeID,
+            CreationTime:   parseSnapshotTime(snapshotName, layouts),
+            ReadyToUse:     true,
+        },
+    }
+}
+
+func sortSnapshotEntries(entries []*csi.ListSnapshotsResponse_Entry) {
+    sort.Slice(entries, func(i, j int) bool {
+        return entries[i].Snapshot.SnapshotId < entries[j].Snapshot.SnapshotId
+    })
+}
+
+// paginateSnapshots returns the page of entries starting at the snapshot startingToken, or after
+// where it would be if it was deleted since, and the token of the next page
+func paginateSnapshots(entries []*csi.ListSnapshotsResponse_Entry, startingToken string, maxEntries int32) (
+    []*csi.ListSnapshotsResponse_Entry, string, error) {
+
+    if maxEntries < 0 {
+        return nil, "", status.Errorf(codes.InvalidArgument, common.InvalidMaxEntries, maxEntries)
+    }
+    start := 0
+    if startingToken != "" {
+        if _, err := GetShareNameFromSnapshotId(startingToken); err != nil {
+            return nil, "", status.Errorf(codes.Aborted, common.InvalidStartingToken, startingToken)
+        }
+        start = sort.Search(len(entries), func(i int) bool {
+            return entries[i].Snapshot.SnapshotId >= startingToken
+        })
+    }
+    end := len(entries)
+    if maxEntries > 0 && start+int(maxEntries) < end {
+        end = start + int(maxEntries)
+    }
+    nextToken := ""
+    if end < len(entries) {
+        nextToken = entries[end].Snapshot.SnapshotId
+    }
+    return entries[start:end], nextToken, nil
+}
+
+// getVolumeSnapshotEntries returns the snapshots of a volume, none if the volume does not exist
+func (d *CSIDriver) getVolumeSnapshotEntries(volumeID VolumeID) ([]*csi.ListSnapshotsResponse_Entry, error) {
+    entries := []*csi.ListSnapshotsResponse_Entry{}
+    if volumeID.IsFileBacked() {
+        exists, err := d.hsclient.DoesFileExist(volumeID.Path)
+        if err != nil || !exists {
+            return entries, err
+        }
+        snaps, err := d.hsclient.GetFileSnapshots(volumeID.Path)
+        if err != nil {
+            return nil, err
+        }
+        for _, snap := range snaps {
+            entries = append(entries, newFileSnapshotEntry(snap.Time, volumeID.Path))
+        }
+    } else {
+        share, err := d.hsclient.GetShare(volumeID.Name)
+        if err != nil || share == nil {
+            return entries, err
+        }
+        snaps, err := d.hsclient.GetShareSnapshots(share.Name)
+        if err != nil {
+            return nil, err
+        }
+        for _, snap := range snaps {
+            entries = append(entries, newSnapshotEntry(snap, volumeID.Path, []string{shareSnapshotTimeLayout}))
+        }
+    }
+    sortSnapshotEntries(entries)
+    return entries, nil
+}
+
+// getSnapshotEntry returns the snapshot with the ID snapshotID, nil if it does not exist
+func (d *CSIDriver) getSnapshotEntry(snapshotID string) (*csi.ListSnapshotsResponse_Entry, error) {
+    tokens := strings.SplitN(snapshotID, "|", 2)
+    if len(tokens) != 2 {
+        return nil, nil
+    }
+    volumeID, err := ParseVolumeID(tokens[1])
+    if err != nil {
+        return nil, nil
+    }
+    snapshotName := tokens[0]
+    if !volumeID.IsFileBacked() {
+        snap, err := d.hsclient.GetShareSnapshot(volumeID.Name, snapshotName)
+        if err != nil || snap == "" {
+            return nil, err
+        }
+        return newSnapshotEntry(snap, volumeID.Path, []string{shareSnapshotTimeLayout}), nil
+    }
+
+    exists, err := d.hsclient.DoesFileExist(volumeID.Path)
+    if err != nil || !exists {
+        return nil, err
+    }
+    snaps, err := d.hsclient.GetFileSnapshots(volumeID.Path)
+    if err != nil {
+        return nil, err
+    }
+    for _, snap := range snaps {
+        if getFileSnapshotTime(snap.Time) == getFileSnapshotTime(snapshotName) {
+            entry := newFileSnapshotEntry(snap.Time, volumeID.Path)
+            // Keep the ID the CO knows the snapshot by
+            entry.Snapshot.SnapshotId = snapshotID
+            return entry, nil
+        }
+    }
+    return nil, nil
+}
+
+// listSnapshotEntries returns the snapshots of the volumes, listing them again unless the caller
+// is continuing a recent listing
+func (d *CSIDriver) listSnapshotEntries(continuing bool) ([]*csi.ListSnapshotsResponse_Entry, error) {
+    d.snapshotList.lock.Lock()
+    defer d.snapshotList.lock.Unlock()
+    if continuing && d.snapshotList.entries != nil && time.Since(d.snapshotList.listedAt) < volumeListCacheTTL {
+        return d.snapshotList.entries, nil
+    }
+    volumes, err := d.listVolumeEntries(continuing)
+    if err != nil {
+        return nil, err
+    }
+    entries := []*csi.ListSnapshotsResponse_Entry{}
+    for _, volume := range volumes {
+        volumeID, _ := ParseVolumeID(volume.Volume.VolumeId)
+        snaps, err := d.getVolumeSnapshotEntries(volumeID)
+        if err != nil {
+            // The volume may have been deleted since it was listed
+            log.Warnf("could not list the snapshots of volume %s, %v", volumeID, err)
+            continue
+        }
+        entries = append(entries, snaps...)
+    }
+    sortSnapshotEntries(entries)
+    d.snapshotList.entries = entries
+    d.snapshotList.listedAt = time.Now()
+    return entries, nil
+}
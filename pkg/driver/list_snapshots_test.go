@@ -0,0 +1,119 @@
+package driver
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+)
+
+func TestParseSnapshotTime(t *testing.T) {
+    expected := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC).Unix()
+    taken := parseSnapshotTime("2019.10.01.12.00.00.snap", []string{shareSnapshotTimeLayout})
+    if taken == nil || taken.Seconds != expected {
+        t.Logf("Expected share snapshot taken at %d, received %v", expected, taken)
+        t.FailNow()
+    }
+    taken = parseSnapshotTime("2019-10-01-12-00-00", fileSnapshotTimeLayouts)
+    if taken == nil || taken.Seconds != expected {
+        t.Logf("Expected file snapshot taken at %d, received %v", expected, taken)
+        t.FailNow()
+    }
+    if taken := parseSnapshotTime("manual", []string{shareSnapshotTimeLayout}); taken != nil {
+        t.Logf("Expected no time for a snapshot without one in its name, received %v", taken)
+        t.FailNow()
+    }
+}
+
+func TestFileSnapshotIDs(t *testing.T) {
+    // Listed snapshots have the ID CreateSnapshot returned for the name SnapshotFile gave them
+    expected := "2019-10-01-12-00-00-pvc-a|/backing/pvc-a"
+    created := getFileSnapshotID("2019-10-01-12-00-00-pvc-a", "/backing/pvc-a")
+    listed := newFileSnapshotEntry("2019-10-01-12-00-00", "/backing/pvc-a")
+    if created != expected || listed.Snapshot.SnapshotId != expected {
+        t.Logf("Expected %s, received %s when created and %s when listed", expected, created, listed.Snapshot.SnapshotId)
+        t.FailNow()
+    }
+    if listed.Snapshot.CreationTime == nil {
+        t.Logf("Expected the time of the listed snapshot")
+        t.FailNow()
+    }
+}
+
+func TestPaginateSnapshots(t *testing.T) {
+    entries := []*csi.ListSnapshotsResponse_Entry{
+        newSnapshotEntry("2019.10.01.12.00.00.snap", "/pvc-b", nil),
+        newSnapshotEntry("2019.10.01.12.00.00.snap", "/pvc-a", nil),
+        newSnapshotEntry("2019.10.02.12.00.00.snap", "/pvc-a", nil),
+    }
+    sortSnapshotEntries(entries)
+
+    page, next, err := paginateSnapshots(entries, "", 2)
+    if err != nil || len(page) != 2 || next != "2019.10.02.12.00.00.snap|/pvc-a" {
+        t.Logf("Unexpected first page %v, next %s, %v", page, next, err)
+        t.FailNow()
+    }
+    page, next, err = paginateSnapshots(entries, next, 2)
+    if err != nil || len(page) != 1 || page[0].Snapshot.SourceVolumeId != "/pvc-a" || next != "" {
+        t.Logf("Unexpected last page %v, next %s, %v", page, next, err)
+        t.FailNow()
+    }
+    if _, _, err := paginateSnapshots(entries, "not-a-token", 0); status.Code(err) != codes.Aborted {
+        t.Logf("Expected Aborted for an invalid token, received %v", err)
+        t.FailNow()
+    }
+    if _, _, err := paginateSnapshots(entries, "", -1); status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected InvalidArgument for negative max entries, received %v", err)
+        t.FailNow()
+    }
+}
+
+func TestListSnapshotsBySnapshotID(t *testing.T) {
+    mux := http.NewServeMux()
+    d := newFakeDriver(t, mux)
+
+    listed := 0
+    mux.HandleFunc(client.BasePath+"/share-snapshots/snapshot-list/pvc-a", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `["2019.10.01.12.00.00.snap", "current"]`)
+    })
+    mux.HandleFunc(client.BasePath+"/shares", func(w http.ResponseWriter, r *http.Request) {
+        listed++
+        fmt.Fprintf(w, `[]`)
+    })
+
+
+    for snapshotID, expected := range map[string]int{
+        "2019.10.01.12.00.00.snap|/pvc-a": 1,
+        "2019.10.02.12.00.00.snap|/pvc-a": 0,
+        "not-a-snapshot-id":               0,
+    } {
+        resp, err := d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{SnapshotId: snapshotID})
+        if err != nil {
+            t.Logf("Unexpected error, %v", err)
+            t.FailNow()
+        }
+        if len(resp.GetEntries()) != expected {
+            t.Logf("Expected %d snapshots with ID %s, received %v", expected, snapshotID, resp.GetEntries())
+            t.FailNow()
+        }
+    }
+    resp, err := d.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{
+        SnapshotId:     "2019.10.01.12.00.00.snap|/pvc-a",
+        SourceVolumeId: "/pvc-b",
+    })
+    if err != nil || len(resp.GetEntries()) != 0 {
+        t.Logf("Expected no snapshot of another volume, received %v, %v", resp.GetEntries(), err)
+        t.FailNow()
+    }
+    if listed != 0 {
+        t.Logf("Expected snapshots looked up by ID without listing shares")
+        t.FailNow()
+    }
+}
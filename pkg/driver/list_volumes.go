@@ -0,0 +1,176 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// ListVolumes returns the volumes created by the plugin ordered by volume ID, which is also the
+// next_token, so that pages stay consistent while volumes are created and deleted. The API leaves
+// out removed shares, the plugin those which are not its volumes. File-backed volumes are listed
+// from the files in their mounted backing shares.
+const (
+    shareListSpec = "shareState!=REMOVED"
+    // How long a listing answers the requests for its further pages
+    volumeListCacheTTL = 10 * time.Second
+)
+
+type volumeListCache struct {
+    lock     sync.Mutex
+    entries  []*csi.ListVolumesResponse_Entry
+    listedAt time.Time
+}
+
+// isShareBackedVolume returns whether a share is the share of a volume created by the plugin,
+// including those created before the volume name was recorded. Its backing shares are not.
+func isShareBackedVolume(share *common.ShareResponse) bool {
+    return share.ShareState != "REMOVED" &&
+        share.ExtendedInfo[ExtendedInfoCreatedBy] == common.CsiPluginName &&
+        !isBackingShare(share)
+}
+
+// getShareVolumeEntries returns the volumes among shares ordered by volume ID
+func getShareVolumeEntries(shares []common.ShareResponse) []*csi.ListVolumesResponse_Entry {
+    entries := []*csi.ListVolumesResponse_Entry{}
+    for i := range shares {
+        if !isShareBackedVolume(&shares[i]) {
+            continue
+        }
+        entries = append(entries, &csi.ListVolumesResponse_Entry{
+            Volume: &csi.Volume{
+                VolumeId:      NewShareVolumeID(shares[i].Name).Path,
+                CapacityBytes: shares[i].Size,
+                VolumeContext: getShareVolumeContext(&shares[i]),
+            },
+        })
+    }
+    sortVolumeEntries(entries)
+    return entries
+}
+
+// getFileVolumeEntries returns the volumes of files, their size by name, in a backing share.
+// Files still being cloned are not volumes yet.
+func getFileVolumeEntries(backingShare *common.ShareResponse, files map[string]int64) []*csi.ListVolumesResponse_Entry {
+    entries := []*csi.ListVolumesResponse_Entry{}
+    for name, size := range files {
+        if strings.HasSuffix(name, cloneTempSuffix) {
+            continue
+        }
+        entries = append(entries, &csi.ListVolumesResponse_Entry{
+            Volume: &csi.Volume{
+                VolumeId:      NewFileVolumeID(backingShare.ExportPath, name).Path,
+                CapacityBytes: size,
+            },
+        })
+    }
+    return entries
+}
+
+func sortVolumeEntries(entries []*csi.ListVolumesResponse_Entry) {
+    sort.Slice(entries, func(i, j int) bool {
+        return entries[i].Volume.VolumeId < entries[j].Volume.VolumeId
+    })
+}
+
+// listFileVolumeEntries returns the file-backed volumes in the backing shares among shares
+func (d *CSIDriver) listFileVolumeEntries(shares []common.ShareResponse) ([]*csi.ListVolumesResponse_Entry, error) {
+    entries := []*csi.ListVolumesResponse_Entry{}
+    for i := range shares {
+        share := &shares[i]
+        if share.ShareState == "REMOVED" || !isBackingShare(share) {
+            continue
+        }
+        files, err := d.listBackingShareFiles(share)
+        if err != nil {
+            return nil, status.Errorf(codes.Internal, common.ListBackingShareFailed, share.Name, err)
+        }
+        entries = append(entries, getFileVolumeEntries(share, files)...)
+    }
+    return entries, nil
+}
+
+// listBackingShareFiles returns the files in a backing share, mounting it with its lock held
+func (d *CSIDriver) listBackingShareFiles(backingShare *common.ShareResponse) (map[string]int64, error) {
+    defer d.releaseVolumeLock(backingShare.Name)
+    d.getVolumeLock(backingShare.Name)
+    defer d.scheduleBackingShareUnmount(backingShare.Name)
+    if err := d.EnsureBackingShareMounted(backingShare.Name); err != nil {
+        return nil, err
+    }
+    return listBackingFiles(common.StagingPath(backingShare.ExportPath))
+}
+
+// paginateVolumes returns the page of entries starting at the volume startingToken, or after where
+// it would be if it was deleted since, and the token of the next page
+func paginateVolumes(entries []*csi.ListVolumesResponse_Entry, startingToken string, maxEntries int32) (
+    []*csi.ListVolumesResponse_Entry, string, error) {
+
+    if maxEntries < 0 {
+        return nil, "", status.Errorf(codes.InvalidArgument, common.InvalidMaxEntries, maxEntries)
+    }
+    start := 0
+    if startingToken != "" {
+        if _, err := ParseVolumeID(startingToken); err != nil {
+            return nil, "", status.Errorf(codes.Aborted, common.InvalidStartingToken, startingToken)
+        }
+        start = sort.Search(len(entries), func(i int) bool {
+            return entries[i].Volume.VolumeId >= startingToken
+        })
+    }
+    end := len(entries)
+    if maxEntries > 0 && start+int(maxEntries) < end {
+        end = start + int(maxEntries)
+    }
+    nextToken := ""
+    if end < len(entries) {
+        nextToken = entries[end].Volume.VolumeId
+    }
+    return entries[start:end], nextToken, nil
+}
+
+// listVolumeEntries returns the volumes, listing the shares again unless the caller is continuing
+// a recent listing
+func (d *CSIDriver) listVolumeEntries(continuing bool) ([]*csi.ListVolumesResponse_Entry, error) {
+    d.volumeList.lock.Lock()
+    defer d.volumeList.lock.Unlock()
+    if continuing && d.volumeList.entries != nil && time.Since(d.volumeList.listedAt) < volumeListCacheTTL {
+        return d.volumeList.entries, nil
+    }
+    shares, err := d.hsclient.ListSharesPaged(shareListSpec)
+    if err != nil {
+        return nil, status.Error(codes.Internal, err.Error())
+    }
+    fileEntries, err := d.listFileVolumeEntries(shares)
+    if err != nil {
+        return nil, err
+    }
+    entries := append(getShareVolumeEntries(shares), fileEntries...)
+    sortVolumeEntries(entries)
+    d.volumeList.entries = entries
+    d.volumeList.listedAt = time.Now()
+    return d.volumeList.entries, nil
+}
@@ -0,0 +1,97 @@
+package driver
+
+import (
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestPaginateVolumes(t *testing.T) {
+    owned := func(name string) common.ShareResponse {
+        return common.ShareResponse{Name: name, ExtendedInfo: map[string]string{
+            ExtendedInfoCreatedBy:  common.CsiPluginName,
+            ExtendedInfoVolumeName: name,
+        }}
+    }
+    shares := []common.ShareResponse{
+        owned("pvc-c"), owned("pvc-a"), owned("pvc-b"),
+        {Name: "backing", ExtendedInfo: map[string]string{
+            ExtendedInfoCreatedBy: common.CsiPluginName, ExtendedInfoBackingShare: "true"}},
+        {Name: "other"},
+    }
+    removed := owned("pvc-d")
+    removed.ShareState = "REMOVED"
+    entries := getShareVolumeEntries(append(shares, removed))
+    if len(entries) != 3 || entries[0].Volume.VolumeId != "/pvc-a" || entries[2].Volume.VolumeId != "/pvc-c" {
+        t.Logf("Expected the volumes ordered by ID, received %v", entries)
+        t.FailNow()
+    }
+
+    page, next, err := paginateVolumes(entries, "", 2)
+    if err != nil || len(page) != 2 || next != "/pvc-c" {
+        t.Logf("Unexpected first page %v, next %s, %v", page, next, err)
+        t.FailNow()
+    }
+    page, next, err = paginateVolumes(entries, next, 2)
+    if err != nil || len(page) != 1 || page[0].Volume.VolumeId != "/pvc-c" || next != "" {
+        t.Logf("Unexpected last page %v, next %s, %v", page, next, err)
+        t.FailNow()
+    }
+    // The volume the token names was deleted since
+    page, _, err = paginateVolumes(entries, "/pvc-bb", 0)
+    if err != nil || len(page) != 1 || page[0].Volume.VolumeId != "/pvc-c" {
+        t.Logf("Expected listing to continue after a deleted volume, received %v, %v", page, err)
+        t.FailNow()
+    }
+    if _, _, err := paginateVolumes(entries, "not-a-token", 0); status.Code(err) != codes.Aborted {
+        t.Logf("Expected Aborted for an invalid token, received %v", err)
+        t.FailNow()
+    }
+    if _, _, err := paginateVolumes(entries, "", -1); status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected InvalidArgument for negative max entries, received %v", err)
+        t.FailNow()
+    }
+
+    // File-backed volumes are listed among the others
+    backing := &common.ShareResponse{Name: "backing", ExportPath: "/backing"}
+    files := getFileVolumeEntries(backing, map[string]int64{"pvc-e": 1024, "pvc-f" + cloneTempSuffix: 1024})
+    if len(files) != 1 || files[0].Volume.VolumeId != "/backing/pvc-e" || files[0].Volume.CapacityBytes != 1024 {
+        t.Logf("Expected the file of a volume, received %v", files)
+        t.FailNow()
+    }
+    entries = append(entries, files...)
+    sortVolumeEntries(entries)
+    page, next, err = paginateVolumes(entries, "", 1)
+    if err != nil || page[0].Volume.VolumeId != "/backing/pvc-e" || next != "/pvc-a" {
+        t.Logf("Unexpected first page %v, next %s, %v", page, next, err)
+        t.FailNow()
+    }
+    page, _, err = paginateVolumes(entries, "/backing/pvc-e", 1)
+    if err != nil || page[0].Volume.VolumeId != "/backing/pvc-e" {
+        t.Logf("Expected a file-backed volume to be a token, received %v, %v", page, err)
+        t.FailNow()
+    }
+}
+
+func TestIsShareBackedVolume(t *testing.T) {
+    for _, test := range []struct {
+        extendedInfo map[string]string
+        expected     bool
+    }{
+        {map[string]string{ExtendedInfoCreatedBy: common.CsiPluginName, ExtendedInfoVolumeName: "pvc-a"}, true},
+        // Created before the volume name was recorded
+        {map[string]string{ExtendedInfoCreatedBy: common.CsiPluginName}, true},
+        {map[string]string{ExtendedInfoCreatedBy: common.CsiPluginName, ExtendedInfoBackingShare: "true"}, false},
+        {map[string]string{ExtendedInfoCreatedBy: common.CsiPluginName, ExtendedInfoAllocatedBytes: "0"}, false},
+        {map[string]string{}, false},
+    } {
+        share := &common.ShareResponse{Name: "share", ExtendedInfo: test.extendedInfo}
+        if actual := isShareBackedVolume(share); actual != test.expected {
+            t.Logf("Expected %v for a share with extended info %v, received %v", test.expected, test.extendedInfo, actual)
+            t.FailNow()
+        }
+    }
+}
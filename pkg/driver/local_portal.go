@@ -0,0 +1,90 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "net"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// A node which is itself a DSX running a data-portal mounts backing shares from that portal over
+// the loopback, at common.LocalDataPortalAddress, rather than across the network. The local
+// portal is the one whose node has the name of this node, or whose address is one of this
+// host's. The other data-portals are only tried if the local one cannot mount the share.
+
+// Lists the addresses of this host's interfaces, replaced in tests
+var getHostAddresses = func() ([]string, error) {
+    addrs, err := net.InterfaceAddrs()
+    if err != nil {
+        return nil, err
+    }
+    addresses := []string{}
+    for _, addr := range addrs {
+        if ipNet, ok := addr.(*net.IPNet); ok {
+            addresses = append(addresses, ipNet.IP.String())
+        }
+    }
+    return addresses, nil
+}
+
+// findLocalDataPortal returns the index of the data-portal running on this node, -1 if none is
+func (d *CSIDriver) findLocalDataPortal(portals []common.DataPortal) int {
+    if d.NodeID == "" {
+        return -1
+    }
+    for i, p := range portals {
+        if p.Node.Name == d.NodeID {
+            return i
+        }
+    }
+    hostAddresses, err := getHostAddresses()
+    if err != nil {
+        log.Warnf("could not list the addresses of this host, %v", err)
+        return -1
+    }
+    for i, p := range portals {
+        if p.Node.MgmtIpAddress.Address != "" && IsValueInList(p.Node.MgmtIpAddress.Address, hostAddresses) {
+            return i
+        }
+    }
+    return -1
+}
+
+func isSameDataPortal(a, b common.DataPortal) bool {
+    return a.Uoid["uuid"] == b.Uoid["uuid"] && a.Node.Name == b.Node.Name &&
+        a.Node.MgmtIpAddress.Address == b.Node.MgmtIpAddress.Address
+}
+
+// preferLocalDataPortal moves the data-portal running on this node first, and returns the address
+// to mount it at. The address is empty when no data-portal runs on this node.
+func (d *CSIDriver) preferLocalDataPortal(portals []common.DataPortal) ([]common.DataPortal, string) {
+    i := d.findLocalDataPortal(portals)
+    if i < 0 {
+        return portals, ""
+    }
+    local := portals[i]
+    address := common.LocalDataPortalAddress
+    if address == "" {
+        address = local.Node.MgmtIpAddress.Address
+    }
+    common.SampledInfof("Found data-portal %s on this node, mounting it at %s", local.Uoid["uuid"], address)
+    ordered := append([]common.DataPortal{local}, portals[:i]...)
+    return append(ordered, portals[i+1:]...), address
+}
@@ -0,0 +1,67 @@
+package driver
+
+import (
+    "testing"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func testDataPortal(uuid, nodeName, address string) common.DataPortal {
+    return common.DataPortal{
+        Uoid: map[string]string{"uuid": uuid},
+        Node: common.DataPortalNode{
+            Name:          nodeName,
+            MgmtIpAddress: common.DataPortalNodeAddress{Address: address},
+        },
+    }
+}
+
+func TestPreferLocalDataPortal(t *testing.T) {
+    defer func(f func() ([]string, error), address string) {
+        getHostAddresses, common.LocalDataPortalAddress = f, address
+    }(getHostAddresses, common.LocalDataPortalAddress)
+    hostAddresses := []string{"127.0.0.1", "10.0.0.3"}
+    getHostAddresses = func() ([]string, error) { return hostAddresses, nil }
+
+    portals := []common.DataPortal{
+        testDataPortal("dp1", "dsx1", "10.0.0.1"),
+        testDataPortal("dp2", "dsx2", "10.0.0.2"),
+        testDataPortal("dp3", "dsx3", "10.0.0.3"),
+    }
+    d := &CSIDriver{NodeID: "worker"}
+
+    // The portal found by the address of this host is first and mounted over the loopback
+    ordered, address := d.preferLocalDataPortal(portals)
+    if address != "127.0.0.1" || len(ordered) != 3 || ordered[0].Uoid["uuid"] != "dp3" ||
+        ordered[1].Uoid["uuid"] != "dp1" || ordered[2].Uoid["uuid"] != "dp2" {
+        t.Logf("Expected dp3 first at the loopback, received %v at %s", ordered, address)
+        t.FailNow()
+    }
+    if !isSameDataPortal(ordered[0], portals[2]) || isSameDataPortal(ordered[1], portals[2]) {
+        t.Logf("Expected only the local data-portal to match it")
+        t.FailNow()
+    }
+
+    // The portal of a node of the same name is preferred to address matches
+    d.NodeID = "dsx2"
+    if ordered, _ = d.preferLocalDataPortal(portals); ordered[0].Uoid["uuid"] != "dp2" {
+        t.Logf("Expected dp2 first, received %v", ordered)
+        t.FailNow()
+    }
+
+    // Without a loopback address the local portal is mounted at its own address
+    common.LocalDataPortalAddress = ""
+    if _, address = d.preferLocalDataPortal(portals); address != "10.0.0.2" {
+        t.Logf("Expected the address of dp2, received %s", address)
+        t.FailNow()
+    }
+
+    // Remote portals are left in order
+    d.NodeID = "worker"
+    hostAddresses = []string{"127.0.0.1"}
+    ordered, address = d.preferLocalDataPortal(portals)
+    if address != "" || ordered[0].Uoid["uuid"] != "dp1" {
+        t.Logf("Expected no local data-portal, received %v at %s", ordered, address)
+        t.FailNow()
+    }
+}
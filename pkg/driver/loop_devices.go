@@ -0,0 +1,145 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Loop devices are a host-wide resource shared with other workloads on the node. With
+// common.LoopDeviceBudget set, a node refuses to publish block volumes once the plugin has that
+// many loop devices attached, counting those whose backing file is in a mounted backing share.
+const (
+    MetricLoopDevicesAttached       = "hs_csi_loop_devices_attached"
+    MetricLoopDeviceBudgetExhausted = "hs_csi_loop_device_budget_exhausted_total"
+)
+
+func init() {
+    common.RegisterMetric(MetricLoopDevicesAttached, common.MetricTypeGauge,
+        "Loop devices attached by the plugin on this node")
+    common.RegisterMetric(MetricLoopDeviceBudgetExhausted, common.MetricTypeCounter,
+        "Block volumes not published because the node had reached its loop device budget")
+}
+
+var allocateLoopDevice = common.EnsureFreeLoopbackDeviceFile
+
+// countPluginLoopDevices returns the number of loop devices attached to files in backing shares,
+// recording it in MetricLoopDevicesAttached
+func countPluginLoopDevices() (int, error) {
+    backingFiles, err := common.GetLoopBackingFiles()
+    if err != nil {
+        return 0, err
+    }
+    prefix := common.StagingPath() + "/"
+    count := 0
+    for _, backingFile := range backingFiles {
+        if strings.HasPrefix(backingFile, prefix) {
+            count++
+        }
+    }
+    common.SetMetric(MetricLoopDevicesAttached, nil, float64(count))
+    return count, nil
+}
+
+// checkLoopDeviceBudget returns a ResourceExhausted error if the plugin may not attach another
+// loop device on this node
+func checkLoopDeviceBudget() error {
+    count, err := countPluginLoopDevices()
+    if err != nil {
+        if common.LoopDeviceBudget > 0 {
+            return status.Errorf(codes.Internal, "could not count attached loop devices, %v", err)
+        }
+        return nil
+    }
+    if common.LoopDeviceBudget > 0 && count >= common.LoopDeviceBudget {
+        common.IncMetric(MetricLoopDeviceBudgetExhausted, nil)
+        return status.Errorf(codes.ResourceExhausted, common.LoopDeviceBudgetExhausted,
+            count, common.LoopDeviceBudget)
+    }
+    return nil
+}
+
+// detachUnmountedLoopDevice detaches a loop device attached by the plugin once the filesystem on
+// it is unmounted. Devices set up by mount itself are detached by the kernel, and are skipped.
+// Failures are only logged, the unpublish itself succeeded.
+func detachUnmountedLoopDevice(device string) {
+    backingFiles, err := common.GetLoopBackingFiles()
+    if err != nil {
+        log.Warnf("could not list backing files for loop devices, %v", err)
+        return
+    }
+    if !strings.HasPrefix(backingFiles[device], common.StagingPath()+"/") {
+        return
+    }
+    log.Infof("detaching loop device, %s", device)
+    output, err := common.ExecCommandWithTimeout(common.UnmountTimeout, "losetup", "-d", device)
+    if err != nil {
+        log.Warnf("could not detach loop device %s, %s, %v", device, output, err)
+    }
+    countPluginLoopDevices()
+}
+
+// attachLoopDevice attaches filePath to a free loop device and returns the device. Another process
+// may take the device the kernel reported free before losetup attaches it, so attaching is retried
+// with a newly allocated device up to common.LosetupRetries times.
+func (d *CSIDriver) attachLoopDevice(filePath string, readOnly bool, trace *publishTrace) (string, error) {
+    d.loopDevicesLock.Lock()
+    defer d.loopDevicesLock.Unlock()
+
+    if err := checkLoopDeviceBudget(); err != nil {
+        return "", err
+    }
+
+    var device string
+    for attempt := 0; ; attempt++ {
+        deviceNumber, err := allocateLoopDevice()
+        if err != nil {
+            log.Error(err.Error())
+            return "", err
+        }
+        device = fmt.Sprintf("/dev/loop%d", deviceNumber)
+
+        losetupFlags := []string{}
+        if readOnly {
+            losetupFlags = append(losetupFlags, "-r")
+        }
+        losetupFlags = append(losetupFlags, device, filePath)
+        endStage := trace.stage(publishStageLosetup)
+        output, err := common.ExecCommand("losetup", losetupFlags...)
+        endStage()
+        if err == nil {
+            break
+        }
+        log.Errorf("issue setting up loop device: device=%s, filePath=%s, attempt %d, %s, %v",
+            device, filePath, attempt+1, output, err.Error())
+        if attempt >= common.LosetupRetries {
+            return "", status.Errorf(codes.Internal, common.LoopDeviceAttachFailed, device, filePath)
+        }
+        time.Sleep(common.LosetupRetryInterval)
+    }
+    log.Infof("File %s attached to %s", filePath, device)
+    countPluginLoopDevices()
+    return device, nil
+}
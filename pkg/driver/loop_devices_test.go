@@ -0,0 +1,143 @@
+package driver
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// fakeAttachedLoopDevices points common.SysBlockDir at a temporary directory with loop devices
+// attached to the given backing files
+func fakeAttachedLoopDevices(t *testing.T, backingFiles ...string) func() {
+    sysBlockDir, err := ioutil.TempDir("", "sys-block")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    for i, backingFile := range backingFiles {
+        loopDir := filepath.Join(sysBlockDir, fmt.Sprintf("loop%d", i), "loop")
+        os.MkdirAll(loopDir, 0755)
+        ioutil.WriteFile(filepath.Join(loopDir, "backing_file"), []byte(backingFile+"\n"), 0644)
+    }
+    common.SysBlockDir = sysBlockDir
+    return func() {
+        common.SysBlockDir = "/sys/block"
+        os.RemoveAll(sysBlockDir)
+    }
+}
+
+func TestCheckLoopDeviceBudget(t *testing.T) {
+    defer fakeAttachedLoopDevices(t,
+        common.StagingPath("backing-share", "vol1"),
+        common.StagingPath("backing-share", "vol2"),
+        "/var/lib/other-workload/disk.img",
+    )()
+    defer func() { common.LoopDeviceBudget = 0 }()
+
+    count, err := countPluginLoopDevices()
+    if err != nil || count != 2 {
+        t.Logf("Expected 2 loop devices, received %d, %v", count, err)
+        t.FailNow()
+    }
+
+    common.LoopDeviceBudget = 0
+    if err := checkLoopDeviceBudget(); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    common.LoopDeviceBudget = 3
+    if err := checkLoopDeviceBudget(); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    common.LoopDeviceBudget = 2
+    if err := checkLoopDeviceBudget(); status.Code(err) != codes.ResourceExhausted {
+        t.Logf("Expected ResourceExhausted, received %v", err)
+        t.FailNow()
+    }
+}
+
+func TestAttachLoopDeviceRetries(t *testing.T) {
+    defer fakeAttachedLoopDevices(t)()
+    next := uint64(0)
+    allocateLoopDevice = func() (uint64, error) {
+        next++
+        return next, nil
+    }
+    defer func(execCommand func(string, ...string) ([]byte, error)) {
+        common.ExecCommand = execCommand
+    }(common.ExecCommand)
+    commands := [][]string{}
+    common.ExecCommand = func(command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        if len(commands) < 3 {
+            return []byte("device busy"), fmt.Errorf("exit status 1")
+        }
+        return []byte(""), nil
+    }
+    retries, interval := common.LosetupRetries, common.LosetupRetryInterval
+    defer func() {
+        allocateLoopDevice = common.EnsureFreeLoopbackDeviceFile
+        common.LosetupRetries, common.LosetupRetryInterval = retries, interval
+    }()
+    common.LosetupRetryInterval = 0
+
+    d := &CSIDriver{sharedState: newSharedState()}
+    common.LosetupRetries = 2
+    device, err := d.attachLoopDevice("/tmp/share/vol", true, nil)
+    if err != nil || device != "/dev/loop3" {
+        t.Logf("Expected /dev/loop3, received %s, %v", device, err)
+        t.FailNow()
+    }
+    expected := [][]string{
+        {"losetup", "-r", "/dev/loop1", "/tmp/share/vol"},
+        {"losetup", "-r", "/dev/loop2", "/tmp/share/vol"},
+        {"losetup", "-r", "/dev/loop3", "/tmp/share/vol"},
+    }
+    if !reflect.DeepEqual(commands, expected) {
+        t.Logf("Expected %v, received %v", expected, commands)
+        t.FailNow()
+    }
+
+    commands = [][]string{}
+    common.LosetupRetries = 1
+    _, err = d.attachLoopDevice("/tmp/share/vol", false, nil)
+    if status.Code(err) != codes.Internal || len(commands) != 2 {
+        t.Logf("Expected Internal after 2 attempts, received %v after %d", err, len(commands))
+        t.FailNow()
+    }
+}
+
+func TestDetachUnmountedLoopDevice(t *testing.T) {
+    defer fakeAttachedLoopDevices(t,
+        common.StagingPath("backing-share", "vol1"),
+        "/var/lib/other-workload/disk.img",
+    )()
+    defer func(execCommand func(time.Duration, string, ...string) ([]byte, error)) {
+        common.ExecCommandWithTimeout = execCommand
+    }(common.ExecCommandWithTimeout)
+    commands := [][]string{}
+    common.ExecCommandWithTimeout = func(timeout time.Duration, command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        return []byte(""), nil
+    }
+
+    // Only devices attached to files in backing shares are detached
+    for _, device := range []string{"/dev/loop0", "/dev/loop1", "/dev/loop7"} {
+        detachUnmountedLoopDevice(device)
+    }
+    expected := [][]string{{"losetup", "-d", "/dev/loop0"}}
+    if !reflect.DeepEqual(commands, expected) {
+        t.Logf("Expected %v, received %v", expected, commands)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "strings"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// A backing share can stay in the mount table after its data-portal went away, for example after
+// an Anvil failover, and every access to it then hangs. Before a mounted backing share is reused
+// it is statfs'd, and if that does not answer within HS_MOUNT_HEALTH_CHECK_TIMEOUT it is unmounted,
+// lazily if need be, and mounted again. A share with loop devices attached to its files is never
+// unmounted this way, a slow statfs does not make it stale and the devices would be left dangling.
+const (
+    MetricStaleMountRemounts = "hs_csi_stale_mount_remounts_total"
+)
+
+func init() {
+    common.RegisterMetric(MetricStaleMountRemounts, common.MetricTypeCounter,
+        "Times a mounted backing share did not respond and was unmounted to be mounted again")
+}
+
+// isMountResponsive is replaced in tests
+var isMountResponsive = func(mountPath string) error {
+    return common.StatfsWithTimeout(mountPath, common.MountHealthCheckTimeout)
+}
+
+// releaseStaleMount unmounts the backing share mounted at mountPath if it does not respond,
+// returning whether it was unmounted and must be mounted again. It fails with Unavailable if
+// a loop device is attached to a file on the share.
+func releaseStaleMount(backingShareName, mountPath string) (bool, error) {
+    err := isMountResponsive(mountPath)
+    if err == nil {
+        return false, nil
+    }
+    backingFiles, listErr := common.GetLoopBackingFiles()
+    if listErr != nil {
+        return false, status.Errorf(codes.Unavailable, common.UnresponsiveMountInUse, backingShareName, "unknown", listErr)
+    }
+    for device, backingFile := range backingFiles {
+        if strings.HasPrefix(backingFile, mountPath+"/") {
+            log.Warnf("backing share %s mounted at %s is not responding, not remounting it while %s uses %s, %v",
+                backingShareName, mountPath, device, backingFile, err)
+            return false, status.Errorf(codes.Unavailable, common.UnresponsiveMountInUse, backingShareName, device, err)
+        }
+    }
+    log.Warnf("backing share %s mounted at %s is not responding, remounting it, %v", backingShareName, mountPath, err)
+    common.IncMetric(MetricStaleMountRemounts, map[string]string{"share": backingShareName})
+    if err := common.UnmountWithEscalation(mountPath); err != nil {
+        return false, err
+    }
+    return true, nil
+}
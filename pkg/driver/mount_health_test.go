@@ -0,0 +1,45 @@
+package driver
+
+import (
+    "io/ioutil"
+    "os"
+    "testing"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestReleaseStaleMount(t *testing.T) {
+    dir, err := ioutil.TempDir("", "backing")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(dir)
+
+    remount, err := releaseStaleMount("backing", dir)
+    if err != nil || remount {
+        t.Logf("Expected responsive mount to be kept, received %v, %v", remount, err)
+        t.FailNow()
+    }
+
+    defer func(f func(string) error) { isMountResponsive = f }(isMountResponsive)
+    isMountResponsive = func(string) error { return common.ErrStatTimeout }
+    defer func(f func(string) (string, bool, error)) { common.GetMountSource = f }(common.GetMountSource)
+    common.GetMountSource = func(string) (string, bool, error) { return "", false, nil }
+
+    defer fakeAttachedLoopDevices(t, "/var/lib/other-workload/disk.img")()
+    remount, err = releaseStaleMount("backing", dir)
+    if err != nil || !remount {
+        t.Logf("Expected hung mount to be released, received %v, %v", remount, err)
+        t.FailNow()
+    }
+
+    defer fakeAttachedLoopDevices(t, dir+"/pvc-1")()
+    remount, err = releaseStaleMount("backing", dir)
+    if status.Code(err) != codes.Unavailable || remount {
+        t.Logf("Expected mount with an attached loop device to be kept, received %v, %v", remount, err)
+        t.FailNow()
+    }
+}
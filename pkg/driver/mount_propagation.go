@@ -0,0 +1,97 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Nodes publish volumes by mounting them below the kubelet root dir, HS_KUBELET_ROOT_DIR, which
+// must be mounted in the plugin container from the host with Bidirectional mount propagation, as
+// must the staging dir where backing shares are mounted. Otherwise the mounts stay in the
+// container and the volumes are empty in the pods, or disappear when the plugin restarts. The
+// node plugin checks its mounts when it starts and refuses to start rather than publish such
+// volumes, and refuses target paths outside the kubelet root dir, which kubelets started with
+// another --root-dir send. An empty HS_KUBELET_ROOT_DIR disables the checks.
+
+// checkMountPropagation returns an error unless mounts made below dir propagate to the host
+func checkMountPropagation(mounts []common.MountInfo, dir string) error {
+    mount := common.FindMount(mounts, dir)
+    if mount == nil {
+        return fmt.Errorf(common.MountNotShared, dir, "/", common.MountPropagationPrivate)
+    }
+    if mount.Propagation != common.MountPropagationShared {
+        return fmt.Errorf(common.MountNotShared, dir, mount.MountPoint, mount.Propagation)
+    }
+    return nil
+}
+
+// findKubeletMounts returns the mount points which look like a kubelet root dir
+func findKubeletMounts(mounts []common.MountInfo) []string {
+    found := []string{}
+    for _, m := range mounts {
+        if strings.Contains(filepath.Base(m.MountPoint), "kubelet") && !IsValueInList(m.MountPoint, found) {
+            found = append(found, m.MountPoint)
+        }
+    }
+    return found
+}
+
+// CheckNodeMounts returns an error if volumes published by the node would not reach the pods
+func CheckNodeMounts() error {
+    if common.KubeletRootDir == "" {
+        return nil
+    }
+    mounts, err := common.ReadMountInfo()
+    if err != nil {
+        return err
+    }
+    if _, err := os.Stat(common.KubeletRootDir); err != nil {
+        found := ""
+        if kubeletMounts := findKubeletMounts(mounts); len(kubeletMounts) > 0 {
+            found = fmt.Sprintf(", the container mounts %s", strings.Join(kubeletMounts, ", "))
+        }
+        return fmt.Errorf(common.KubeletRootDirMissing, common.KubeletRootDir, found)
+    }
+    for _, dir := range []string{common.KubeletRootDir, common.ShareStagingDir} {
+        if err := checkMountPropagation(mounts, dir); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// checkKubeletPath returns a FailedPrecondition error if a target path is not below the kubelet
+// root dir
+func checkKubeletPath(targetPath string) error {
+    if common.KubeletRootDir == "" {
+        return nil
+    }
+    rel, err := filepath.Rel(common.KubeletRootDir, targetPath)
+    if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+        return status.Errorf(codes.FailedPrecondition, common.TargetOutsideKubeletRootDir, targetPath, common.KubeletRootDir)
+    }
+    return nil
+}
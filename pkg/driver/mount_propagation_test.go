@@ -0,0 +1,96 @@
+package driver
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path"
+    "strings"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestCheckNodeMounts(t *testing.T) {
+    defer func(rootDir, mountInfo string) {
+        common.KubeletRootDir, common.MountInfoFile = rootDir, mountInfo
+    }(common.KubeletRootDir, common.MountInfoFile)
+
+    dir, _ := ioutil.TempDir("", "node-mounts")
+    defer os.RemoveAll(dir)
+    common.KubeletRootDir = path.Join(dir, "kubelet")
+    common.MountInfoFile = path.Join(dir, "mountinfo")
+    writeMountInfo := func(kubeletFields, stagingFields string) {
+        mountInfo := fmt.Sprintf("1 0 0:1 / / rw - overlay overlay rw\n"+
+            "2 1 8:1 / %s rw %s - ext4 /dev/sda1 rw\n"+
+            "3 1 8:1 / %s rw %s - ext4 /dev/sda1 rw\n",
+            common.KubeletRootDir, kubeletFields, common.ShareStagingDir, stagingFields)
+        ioutil.WriteFile(common.MountInfoFile, []byte(mountInfo), 0644)
+    }
+
+    writeMountInfo("shared:1", "shared:2")
+    err := CheckNodeMounts()
+    if err == nil || !strings.Contains(err.Error(), "HS_KUBELET_ROOT_DIR") {
+        t.Logf("Expected a missing kubelet root dir to be reported, %v", err)
+        t.FailNow()
+    }
+    os.Mkdir(common.KubeletRootDir, 0755)
+    if err := CheckNodeMounts(); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+
+    writeMountInfo("master:1", "shared:2")
+    err = CheckNodeMounts()
+    if err == nil || !strings.Contains(err.Error(), common.MountPropagationSlave) {
+        t.Logf("Expected a slave kubelet root dir to be reported, %v", err)
+        t.FailNow()
+    }
+    writeMountInfo("shared:1", "")
+    err = CheckNodeMounts()
+    if err == nil || !strings.Contains(err.Error(), common.ShareStagingDir) {
+        t.Logf("Expected a private staging dir to be reported, %v", err)
+        t.FailNow()
+    }
+
+    common.KubeletRootDir = ""
+    if err := CheckNodeMounts(); err != nil {
+        t.Logf("Expected the checks to be disabled, %v", err)
+        t.FailNow()
+    }
+}
+
+func TestFindKubeletMounts(t *testing.T) {
+    mounts := common.ParseMountInfo("1 0 0:1 / / rw - overlay overlay rw\n" +
+        "2 1 8:1 / /data/kubelet rw shared:1 - ext4 /dev/sda1 rw\n" +
+        "3 2 8:1 / /data/kubelet/pods rw shared:1 - ext4 /dev/sda1 rw\n")
+    found := findKubeletMounts(mounts)
+    if len(found) != 1 || found[0] != "/data/kubelet" {
+        t.Logf("Expected /data/kubelet to be found, received %v", found)
+        t.FailNow()
+    }
+}
+
+func TestCheckKubeletPath(t *testing.T) {
+    defer func(rootDir string) { common.KubeletRootDir = rootDir }(common.KubeletRootDir)
+
+    common.KubeletRootDir = "/var/lib/kubelet"
+    if err := checkKubeletPath("/var/lib/kubelet/pods/a/volumes/kubernetes.io~csi/pvc-a/mount"); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    for _, targetPath := range []string{"/data/kubelet/pods/a", "/var/lib/kubelet-2/pods/a", "/var/lib"} {
+        if err := checkKubeletPath(targetPath); status.Code(err) != codes.FailedPrecondition {
+            t.Logf("Expected %s to be refused, %v", targetPath, err)
+            t.FailNow()
+        }
+    }
+    common.KubeletRootDir = ""
+    if err := checkKubeletPath("/tmp/target"); err != nil {
+        t.Logf("Expected the check to be disabled, %v", err)
+        t.FailNow()
+    }
+}
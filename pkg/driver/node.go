@@ -20,9 +20,10 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/hammer-space/csi-plugin/pkg/common"
@@ -33,11 +34,25 @@ import (
 	"k8s.io/kubernetes/pkg/util/mount"
 )
 
+const (
+    // Attempts to list the data-portals before NodeGetInfo falls back to the last known topology
+    nodeInfoAttempts   = 3
+    nodeInfoRetryDelay = 2 * time.Second
+)
+
 func (d *CSIDriver) NodeStageVolume(
     ctx context.Context,
     req *csi.NodeStageVolumeRequest) (
     *csi.NodeStageVolumeResponse, error) {
 
+    // Serve with the Hammerspace credentials in the secrets of the StorageClass, if any, on the
+    // Anvil the volume was provisioned on
+    if tenant, err := d.forEndpoint(req.GetVolumeContext()[VolumeContextEndpoint], req.GetSecrets()); err != nil {
+        return nil, err
+    } else if tenant != d {
+        return tenant.NodeStageVolume(ctx, req)
+    }
+
     if req.GetVolumeId() == "" {
         return nil, status.Error(codes.InvalidArgument, common.EmptyVolumeId)
     }
@@ -45,14 +60,81 @@ func (d *CSIDriver) NodeStageVolume(
     if req.GetStagingTargetPath() == "" {
         return nil, status.Error(codes.InvalidArgument, common.EmptyStagingTargetPath)
     }
+    if err := checkKubeletPath(req.GetStagingTargetPath()); err != nil {
+        return nil, err
+    }
 
     if req.GetVolumeCapability() == nil {
         return nil, status.Error(codes.InvalidArgument, common.NoCapabilitiesSupplied)
     }
 
+    volContext := migrateVolumeContext(req.GetVolumeId(), req.GetVolumeContext(), req.GetVolumeCapability())
+    mountInfo := d.rememberVolumeMountInfo(req.GetVolumeId(), volContext)
+
+    // Recorded so the backing share can be mounted ahead of NodePublishVolume after a restart
+    backingShareName := volContext["mountBackingShareName"]
+    if req.GetVolumeCapability().GetBlock() != nil {
+        backingShareName = volContext["blockBackingShareName"]
+    }
+
+    // Volumes which may be published on several nodes at once are not checked, another node may
+    // have the filesystem mounted
+    accessMode := req.GetVolumeCapability().GetAccessMode().GetMode()
+    multiNode := accessMode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY ||
+        accessMode == csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER ||
+        accessMode == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+    if fsckMode := volContext["fsckOnStage"]; fsckMode != "" && req.GetVolumeCapability().GetMount() != nil && !multiNode {
+        err := d.checkFileBackedFilesystem(req.GetVolumeId(), backingShareName,
+            volContext["fsType"], fsckMode == common.FsckOnStageRepair)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    d.recordNodeVolume(&nodeVolumeState{
+        VolumeID:         req.GetVolumeId(),
+        State:            NodeVolumeStaged,
+        Path:             req.GetStagingTargetPath(),
+        FSType:           volContext["fsType"],
+        BackingShareName: backingShareName,
+        MountInfo:        mountInfo,
+    })
+
     return &csi.NodeStageVolumeResponse{}, nil
 }
 
+// checkFileBackedFilesystem checks the filesystem of a file-backed volume before it is mounted, so
+// that pods do not silently mount a filesystem left corrupt by an unclean shutdown. Filesystems
+// already in use on this node are not checked.
+func (d *CSIDriver) checkFileBackedFilesystem(volumeID, backingShareName, fsType string, repair bool) error {
+    defer d.releaseVolumeLock(backingShareName)
+    d.getVolumeLock(backingShareName)
+
+    err := d.EnsureBackingShareMounted(backingShareName)
+    if err != nil {
+        return err
+    }
+    defer d.scheduleBackingShareUnmount(backingShareName)
+
+    filePath := common.StagingPath(volumeID)
+    backingFiles, err := common.GetLoopBackingFiles()
+    if err != nil {
+        return status.Errorf(codes.Internal, "could not list backing files for loop devices, %v", err)
+    }
+    for device, backingFile := range backingFiles {
+        if backingFile == filePath {
+            log.Infof("not checking the filesystem of volume %s, it is in use by %s", volumeID, device)
+            return nil
+        }
+    }
+
+    err = common.CheckFilesystem(filePath, fsType, repair)
+    if err != nil {
+        return status.Errorf(codes.FailedPrecondition, common.FilesystemCheckFailed, volumeID, err)
+    }
+    return nil
+}
+
 func (d *CSIDriver) NodeUnstageVolume(
     ctx context.Context,
     req *csi.NodeUnstageVolumeRequest) (
@@ -66,12 +148,14 @@ func (d *CSIDriver) NodeUnstageVolume(
         return nil, status.Error(codes.InvalidArgument, common.EmptyStagingTargetPath)
     }
 
+    d.forgetNodeVolume(req.GetVolumeId(), req.GetStagingTargetPath())
+
     return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
 func (d *CSIDriver) publishShareBackedVolume(
     exportPath,
-    targetPath string, mountFlags []string, readOnly bool) error{
+    targetPath string, mountFlags []string, readOnly bool, trace *publishTrace) error{
 
     notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath)
     if err != nil {
@@ -86,22 +170,52 @@ func (d *CSIDriver) publishShareBackedVolume(
     }
 
     if !notMnt {
-        log.Debugf("Volume already published at %s", targetPath)
+        common.SampledDebugf("Volume already published at %s", targetPath)
         return nil
     }
 
     if readOnly {
         mountFlags = append(mountFlags, "ro")
     }
-    err = d.MountShareAtBestDataportal(exportPath, targetPath, mountFlags)
+    err = d.mountShareAtBestDataportal(exportPath, targetPath, mountFlags, trace)
     return err
 }
 
+// publishShareBackedVolumeWithTransport mounts the share over the requested transport. RDMA mounts
+// fall back to TCP when the host has no RDMA devices or no data-portal accepts the RDMA mount.
+func (d *CSIDriver) publishShareBackedVolumeWithTransport(
+    exportPath, targetPath string, mountFlags []string, readOnly bool, transport string, port int,
+    trace *publishTrace) error {
+
+    if transport == common.TransportRDMA {
+        if !common.IsRDMAAvailable() {
+            log.Warnf("no RDMA devices found under %s, mounting %s over TCP", common.RDMADeviceDir, exportPath)
+        } else {
+            rdmaFlags := append(append([]string{}, mountFlags...), common.GetTransportMountOptions(transport, port)...)
+            err := d.publishShareBackedVolume(exportPath, targetPath, rdmaFlags, readOnly, trace)
+            if err == nil {
+                return nil
+            }
+            log.Warnf("could not mount %s over RDMA, falling back to TCP, %v", exportPath, err)
+        }
+    }
+    return d.publishShareBackedVolume(exportPath, targetPath, mountFlags, readOnly, trace)
+}
+
 func (d *CSIDriver) publishFileBackedVolume(
-    backingShareName, volumePath, targetPath, fsType string, mountFlags []string, readOnly bool) (error) {
+    backingShareName, volumePath, targetPath, fsType string, mountFlags []string, readOnly bool,
+    blockPublishMode string, trace *publishTrace) (err error) {
     defer d.releaseVolumeLock(backingShareName)
     d.getVolumeLock(backingShareName)
 
+    // Device nodes are not mount points, check for one left by a previous publish
+    if fsType == "" && blockPublishMode == common.BlockPublishModeDevice {
+        if fi, err := os.Lstat(targetPath); err == nil && fi.Mode()&os.ModeDevice != 0 {
+            common.SampledDebugf("Volume already published at %s", targetPath)
+            return nil
+        }
+    }
+
     notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath)
     if err != nil {
         if os.IsNotExist(err) {
@@ -120,62 +234,81 @@ func (d *CSIDriver) publishFileBackedVolume(
         }
     }
     if !notMnt {
-        log.Debugf("Volume already published at %s", targetPath)
+        common.SampledDebugf("Volume already published at %s", targetPath)
         return nil
     }
 
     // Ensure the backing share is mounted
-    err = d.EnsureBackingShareMounted(backingShareName)
+    err = d.ensureBackingShareMounted(backingShareName, trace)
     if err != nil {
         return err
     }
 
+    // Keep the controller from deallocating space of the file while it is in use, see reclaim.go
+    err = d.markVolumeAttached(volumePath, targetPath)
+    if err != nil {
+        d.scheduleBackingShareUnmount(backingShareName)
+        return err
+    }
+    defer func() {
+        if err != nil {
+            d.unmarkVolumeAttached(volumePath, targetPath)
+        }
+    }()
+
     // Mount the file
     log.Infof("Mounting file-backed volume at %s", targetPath)
-    filePath := common.ShareStagingDir + volumePath
+    filePath := common.StagingPath(volumePath)
 
     // If no fsType specified, mount as a device
     if fsType == "" {
-        deviceNumber, err := common.EnsureFreeLoopbackDeviceFile()
+        deviceStr, err := d.attachLoopDevice(filePath, readOnly, trace)
         if err != nil {
-            log.Error(err.Error())
+            d.scheduleBackingShareUnmount(backingShareName)
             return err
         }
-        deviceStr := fmt.Sprintf("/dev/loop%d", deviceNumber)
 
-        losetupFlags := []string{}
-        // is read only?
-        if readOnly {
-            losetupFlags = append(losetupFlags, "-r")
-        }
-        losetupFlags = append(losetupFlags, deviceStr)
-        losetupFlags = append(losetupFlags, filePath)
-        output, err := exec.Command("losetup", losetupFlags...).CombinedOutput()
-        if err != nil {
-            log.Errorf("issue setting up loop device: device=%s, filePath=%s, %s, %v",
-                deviceStr, filePath, output, err.Error())
-            exec.Command("losetup", "-d", deviceStr)
-            d.UnmountBackingShareIfUnused(backingShareName)
-            return status.Errorf(codes.Internal, common.LoopDeviceAttachFailed, deviceStr, filePath)
+        if blockPublishMode == common.BlockPublishModeDevice {
+            // expose the loop device itself at the target path
+            endStage := trace.stage(publishStageDeviceNode)
+            err = common.MakeBlockDeviceNode(deviceStr, targetPath)
+            endStage()
+        } else {
+            // bind mount to target path
+            endStage := trace.stage(publishStageBindMount)
+            err = common.BindMountDevice(deviceStr, targetPath)
+            endStage()
         }
-        log.Infof("File %s attached to %s", filePath, deviceStr)
-
-        // bind mount to target path
-        err = common.BindMountDevice(deviceStr, targetPath)
         if err != nil {
             // clean up losetup
             // FIXME, sometimes this command succeeds and doesnt do the detach, make a retry here
             exec.Command("losetup", "-d", deviceStr)
-            d.UnmountBackingShareIfUnused(backingShareName)
+            d.scheduleBackingShareUnmount(backingShareName)
             return err
         }
-    } else {
-        if readOnly {
-            mountFlags = append(mountFlags, "ro")
+    } else if readOnly {
+        // Read-only replicas may be published on several nodes while a writer holds the volume on
+        // another, the file is attached read-only so that nothing on this node can write to it
+        deviceStr, err := d.attachLoopDevice(filePath, true, trace)
+        if err != nil {
+            d.scheduleBackingShareUnmount(backingShareName)
+            return err
+        }
+        mountFlags = append(mountFlags, common.GetReadOnlyReplicaMountOptions(fsType)...)
+        endStage := trace.stage(publishStageFSMount)
+        err = common.MountFilesystem(deviceStr, targetPath, fsType, mountFlags)
+        endStage()
+        if err != nil {
+            common.ExecCommand("losetup", "-d", deviceStr)
+            d.scheduleBackingShareUnmount(backingShareName)
+            return err
         }
+    } else {
+        endStage := trace.stage(publishStageFSMount)
         err = common.MountFilesystem(filePath, targetPath, fsType, mountFlags)
+        endStage()
         if err != nil {
-            d.UnmountBackingShareIfUnused(backingShareName)
+            d.scheduleBackingShareUnmount(backingShareName)
             return err
         }
     }
@@ -187,6 +320,14 @@ func (d *CSIDriver) NodePublishVolume(
     req *csi.NodePublishVolumeRequest) (
     *csi.NodePublishVolumeResponse, error) {
 
+    // Serve with the Hammerspace credentials in the secrets of the StorageClass, if any, on the
+    // Anvil the volume was provisioned on
+    if tenant, err := d.forEndpoint(req.GetVolumeContext()[VolumeContextEndpoint], req.GetSecrets()); err != nil {
+        return nil, err
+    } else if tenant != d {
+        return tenant.NodePublishVolume(ctx, req)
+    }
+
     if req.GetVolumeId() == "" {
         return nil, status.Error(codes.InvalidArgument, common.EmptyVolumeId)
     }
@@ -194,6 +335,9 @@ func (d *CSIDriver) NodePublishVolume(
     if req.GetTargetPath() == "" {
         return nil, status.Error(codes.InvalidArgument, common.EmptyTargetPath)
     }
+    if err := checkKubeletPath(req.GetTargetPath()); err != nil {
+        return nil, err
+    }
 
     if req.GetVolumeCapability() == nil {
         return nil, status.Errorf(codes.InvalidArgument, common.NoCapabilitiesSupplied, req.GetVolumeId())
@@ -202,8 +346,21 @@ func (d *CSIDriver) NodePublishVolume(
     defer d.releaseVolumeLock(req.GetVolumeId())
     d.getVolumeLock(req.GetVolumeId())
 
+    trace := newPublishTrace(req.GetVolumeId())
+    defer trace.finish()
+
+    endStage := trace.stage(publishStageQueue)
+    release, err := d.nodeOperations.acquire(ctx, nodeOperationPublish)
+    endStage()
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+
     log.Infof("Attempting to publish volume %s", req.GetVolumeId())
 
+    volContext := migrateVolumeContext(req.GetVolumeId(), req.GetVolumeContext(), req.GetVolumeCapability())
+    mountInfo := d.rememberVolumeMountInfo(req.GetVolumeId(), volContext)
     var volumeMode, fsType string
     var mountFlags []string
     cap := req.GetVolumeCapability()
@@ -214,7 +371,7 @@ func (d *CSIDriver) NodePublishVolume(
         volumeMode = "Filesystem"
         fsType = cap.GetMount().FsType
         if fsType == "" {
-            fsType = req.GetVolumeContext()["fsType"]
+            fsType = volContext["fsType"]
             if fsType == "" {
                 fsType = "nfs"
             }
@@ -225,19 +382,87 @@ func (d *CSIDriver) NodePublishVolume(
     }
 
     if fsType == "nfs" {
-        err := d.publishShareBackedVolume(req.GetVolumeId(), req.GetTargetPath(), mountFlags, req.GetReadonly())
+        if clientMountOptions, exists := volContext["clientMountOptions"]; exists {
+            options, err := common.ParseClientMountOptions(clientMountOptions)
+            if err != nil {
+                return nil, status.Error(codes.InvalidArgument, err.Error())
+            }
+            err = common.ValidateMountOptionsForKernel(options)
+            if err != nil {
+                return nil, status.Error(codes.InvalidArgument, err.Error())
+            }
+            mountFlags = append(mountFlags, options...)
+        }
+        if mountPolicy, exists := volContext["mountPolicy"]; exists {
+            options, err := common.ParseMountPolicy(mountPolicy)
+            if err != nil {
+                return nil, status.Error(codes.InvalidArgument, err.Error())
+            }
+            mountFlags = append(mountFlags, options...)
+        }
+        transport := volContext["transport"]
+        rdmaPort := common.DefaultRDMAPort
+        if portStr, exists := volContext["rdmaPort"]; exists {
+            port, err := strconv.Atoi(portStr)
+            if err != nil {
+                return nil, status.Errorf(codes.InvalidArgument, common.InvalidRDMAPort, portStr)
+            }
+            rdmaPort = port
+        }
+        err := d.publishShareBackedVolumeWithTransport(
+            getShareExportPath(req.GetVolumeId(), volContext), req.GetTargetPath(), mountFlags, req.GetReadonly(), transport, rdmaPort, trace)
+        if err == nil {
+            d.recordNodeVolume(&nodeVolumeState{
+                VolumeID:   req.GetVolumeId(),
+                State:      NodeVolumePublished,
+                Path:       req.GetTargetPath(),
+                VolumeMode: volumeMode,
+                FSType:     fsType,
+                MountFlags: mountFlags,
+                ReadOnly:   req.GetReadonly(),
+                MountInfo:  mountInfo,
+            })
+        }
         return &csi.NodePublishVolumeResponse{}, err
     } else {
         var backingShareName string
         if volumeMode == "Block"{
-            backingShareName = req.GetVolumeContext()["blockBackingShareName"]
+            backingShareName = volContext["blockBackingShareName"]
         } else {
-            backingShareName = req.GetVolumeContext()["mountBackingShareName"]
+            backingShareName = volContext["mountBackingShareName"]
         }
         log.Infof("Found backing share %s for volume %s", backingShareName, req.GetVolumeId())
 
         err := d.publishFileBackedVolume(
-            backingShareName, req.GetVolumeId(), req.GetTargetPath(), fsType, mountFlags, req.GetReadonly())
+            backingShareName, req.GetVolumeId(), req.GetTargetPath(), fsType, mountFlags, req.GetReadonly(),
+            volContext["blockPublishMode"], trace)
+        restored := volContext["restoredFromSnapshot"] == "true" ||
+            volContext["clonedFromVolume"] != ""
+        if err == nil && fsType != "" && !req.GetReadonly() && restored {
+            // The filesystem has the size of its source, grow it to the size of the volume
+            endStage := trace.stage(publishStageFSGrow)
+            err = common.GrowMountedFilesystem(req.GetTargetPath(), fsType)
+            endStage()
+            if err != nil {
+                return nil, status.Error(codes.Internal, err.Error())
+            }
+        }
+        if err == nil {
+            d.recordNodeVolume(&nodeVolumeState{
+                VolumeID:         req.GetVolumeId(),
+                State:            NodeVolumePublished,
+                Path:             req.GetTargetPath(),
+                VolumeMode:       volumeMode,
+                FSType:           fsType,
+                BackingShareName: backingShareName,
+                MountFlags:       mountFlags,
+                ReadOnly:         req.GetReadonly(),
+                MountInfo:        mountInfo,
+            })
+            if fsType != "" && !req.GetReadonly() {
+                d.markVolumeFreezable(req.GetVolumeId())
+            }
+        }
         return &csi.NodePublishVolumeResponse{}, err
 
     }
@@ -249,7 +474,11 @@ func (d *CSIDriver) unpublishFileBackedVolume(
     volumePath, targetPath string) (error) {
 
     //determine backing share
-    backingShareName := filepath.Dir(volumePath)
+    id, err := ParseVolumeID(volumePath)
+    if err != nil || !id.IsFileBacked() {
+        return status.Error(codes.NotFound, common.VolumeNotFound)
+    }
+    backingShareName := id.BackingShare
 
     defer d.releaseVolumeLock(backingShareName)
     d.getVolumeLock(backingShareName)
@@ -263,10 +492,10 @@ func (d *CSIDriver) unpublishFileBackedVolume(
     log.Infof("found device %s for mount %s", lodevice, targetPath)
 
     // Remove bind mount
-    output, err := common.ExecCommand("umount", "-f", targetPath)
+    err = common.UnmountWithEscalation(targetPath)
     if err != nil {
         log.Errorf("could not remove bind mount, %s", err)
-        return status.Error(codes.Internal, err.Error())
+        return err
     }
 
     // delete target path
@@ -278,21 +507,16 @@ func (d *CSIDriver) unpublishFileBackedVolume(
 
     // detach from loopback device
     log.Infof("detaching loop device, %s", lodevice)
-    output, err = exec.Command("losetup", "-d", lodevice).CombinedOutput()
+    output, err := common.ExecCommandWithTimeout(common.UnmountTimeout, "losetup", "-d", lodevice)
     if err != nil {
         log.Errorf("%s, %v", output, err.Error())
         return status.Error(codes.Internal, err.Error())
     }
 
+    d.unmarkVolumeAttached(volumePath, targetPath)
+
     // Unmount backing share if appropriate
-    unmounted, err := d.UnmountBackingShareIfUnused(backingShareName)
-    if unmounted {
-        log.Infof("unmounted backing share, %s", backingShareName)
-    }
-    if err != nil {
-        log.Errorf("unmounted backing share, %s, failed: %v", backingShareName, err)
-        return status.Error(codes.Internal, err.Error())
-    }
+    d.scheduleBackingShareUnmount(backingShareName)
     return nil
 }
 func (d *CSIDriver) NodeUnpublishVolume(
@@ -311,10 +535,26 @@ func (d *CSIDriver) NodeUnpublishVolume(
     defer d.releaseVolumeLock(req.GetVolumeId())
     d.getVolumeLock(req.GetVolumeId())
 
-    targetPath := req.GetTargetPath()
-    fi, err := os.Stat(targetPath)
+    release, err := d.nodeOperations.acquire(ctx, nodeOperationUnpublish)
     if err != nil {
+        return nil, err
+    }
+    defer release()
+
+    targetPath := req.GetTargetPath()
+    fi, err := common.StatWithTimeout(targetPath, common.UnmountTimeout)
+    if err == common.ErrStatTimeout {
+        // Only NFS mounts hang, unmount without inspecting the target path
+        log.Warnf("target path %s is not responding, unmounting", targetPath)
+        err = common.UnmountFilesystem(targetPath)
+        if err != nil {
+            return nil, err
+        }
+        d.forgetNodeVolume(req.GetVolumeId(), targetPath)
+        return &csi.NodeUnpublishVolumeResponse{}, nil
+    } else if err != nil {
         log.Infof("target path does not exist on this host, %s", targetPath)
+        d.forgetNodeVolume(req.GetVolumeId(), targetPath)
         return &csi.NodeUnpublishVolumeResponse{}, nil
     }
 
@@ -324,15 +564,27 @@ func (d *CSIDriver) NodeUnpublishVolume(
         if err != nil {
             return nil, err
         }
+        forgetBlockVolumeStats(req.GetVolumeId())
     case mode.IsDir(): // if target path is a directory, it's filesystem
+        device, _, _ := common.GetMountSource(targetPath)
         err := common.UnmountFilesystem(targetPath)
         if err != nil {
-            return nil, status.Error(codes.Internal, err.Error())
+            return nil, err
+        }
+        if strings.HasPrefix(device, "/dev/loop") {
+            // Read-only replicas of file-backed volumes are mounted from a loop device of their own
+            detachUnmountedLoopDevice(device)
+        }
+        if v, exists := d.nodeState.get(targetPath); exists && isFileBackedFilesystem(v) {
+            d.unmarkVolumeFreezable(req.GetVolumeId())
+            d.unmarkVolumeAttached(req.GetVolumeId(), targetPath)
         }
     default:
         return nil, status.Error(codes.InvalidArgument, common.TargetPathUnknownFiletype)
     }
 
+    d.forgetVolumeUsage(req.GetVolumeId())
+    d.forgetNodeVolume(req.GetVolumeId(), targetPath)
     return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
@@ -375,27 +627,57 @@ func (d *CSIDriver) NodeGetCapabilities(
     }, nil
 }
 
-func (d *CSIDriver) NodeGetInfo(ctx context.Context,
-    req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-
-    // Determine if this node is a data portal
-    dataPortals, err := d.hsclient.GetDataPortals(d.NodeID)
+// getNodeTopology determines whether this node is a data portal. While the Anvil cannot be reached
+// the topology last reported is used, so that nodes can still register during an outage.
+func (d *CSIDriver) getNodeTopology(ctx context.Context) map[string]string {
+    // Nodes without credentials cannot list the data-portals
+    if common.NodeCredentialless {
+        if topology := d.nodeState.getTopology(); topology != nil {
+            return topology
+        }
+        return map[string]string{common.TopologyKeyDataPortal: strconv.FormatBool(false)}
+    }
+    dataPortals, err := d.getDataPortals()
+    for attempt := 1; err != nil && attempt < nodeInfoAttempts && ctx.Err() == nil; attempt++ {
+        log.Warnf("Could not list data-portals, retrying, %v", err)
+        select {
+        case <-ctx.Done():
+        case <-time.After(nodeInfoRetryDelay):
+            dataPortals, err = d.getDataPortals()
+        }
+    }
     if err != nil {
-        log.Errorf("Could not list data-portals, %s", err.Error())
+        if topology := d.nodeState.getTopology(); topology != nil {
+            log.Errorf("Could not list data-portals, reporting the last known topology %v, %v", topology, err)
+            return topology
+        }
+        log.Errorf("Could not list data-portals, reporting this node is not a data-portal, %v", err)
     }
+
     var isDataPortal bool
     for _, p := range dataPortals {
         if p.Node.Name == d.NodeID {
             isDataPortal = true
         }
     }
+    topology := map[string]string{
+        common.TopologyKeyDataPortal: strconv.FormatBool(isDataPortal),
+    }
+    if err == nil {
+        if err := d.nodeState.putTopology(topology); err != nil {
+            log.Warnf("Could not record node topology, %v", err)
+        }
+    }
+    return topology
+}
+
+func (d *CSIDriver) NodeGetInfo(ctx context.Context,
+    req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
 
     csiNodeResponse := &csi.NodeGetInfoResponse{
         NodeId: d.NodeID,
         AccessibleTopology: &csi.Topology{
-            Segments: map[string]string{
-                common.TopologyKeyDataPortal: strconv.FormatBool(isDataPortal),
-            },
+            Segments: d.getNodeTopology(ctx),
         },
     }
     return csiNodeResponse, nil
@@ -417,14 +699,35 @@ func (d *CSIDriver) NodeGetVolumeStats(ctx context.Context,
     if err != nil {
         return nil, status.Error(codes.NotFound, common.VolumeNotFound)
     }
+    // Block volumes have no filesystem to statfs, report the size and I/O of their loop device
+    if v, exists := d.nodeState.get(req.GetVolumePath()); exists && v.VolumeMode == "Block" && v.BackingShareName != "" {
+        device, err := common.DetermineLoopDeviceFromBackingFile(common.StagingPath(req.GetVolumeId()))
+        if err != nil {
+            return nil, status.Error(codes.Internal, err.Error())
+        }
+        stats, err := common.ReadBlockDeviceStats(device)
+        if err != nil {
+            return nil, status.Error(codes.Internal, err.Error())
+        }
+        recordBlockVolumeStats(req.GetVolumeId(), stats)
+        return &csi.NodeGetVolumeStatsResponse{
+            Usage: []*csi.VolumeUsage{
+                {
+                    Unit:  csi.VolumeUsage_BYTES,
+                    Total: stats.SizeBytes,
+                },
+            },
+        }, nil
+    }
+
     // Check if volume is on a backing share
     isFileBacked := false
-    _, err = os.Stat(common.ShareStagingDir + req.GetVolumeId())
+    _, err = os.Stat(common.StagingPath(req.GetVolumeId()))
     if err == nil {
         isFileBacked = true
     }
-    // TODO: Add reporting for block only volumes
-    if isFileBacked {
+    // Nodes without credentials statfs the NFS mount of share-backed volumes too
+    if isFileBacked || common.NodeCredentialless {
         // Do statfs on the node of the mount point to get the actual usage. Executed automatically on the correct node
         var st syscall.Statfs_t
         err = syscall.Statfs(req.GetVolumePath(), &st)
@@ -432,7 +735,7 @@ func (d *CSIDriver) NodeGetVolumeStats(ctx context.Context,
             return nil, status.Error(codes.NotFound, common.FileNotFound)
         }
         // helpful to know the volume path on the nodes if troubleshooting is required
-        log.Infof("volume path is: %s", req.GetVolumePath())
+        common.SampledInfof("volume path is: %s", req.GetVolumePath())
 
         // blocksize is typically 1024 - using st.Bsize in case it is not always true
         // this math equals the df command output
@@ -443,6 +746,7 @@ func (d *CSIDriver) NodeGetVolumeStats(ctx context.Context,
         inodestotal := int64(st.Files)
         inodesavail := int64(st.Ffree)
         inodesused := int64(inodestotal - inodesavail)
+        d.checkVolumeUsage(req.GetVolumeId(), req.GetVolumePath(), used, total)
         return &csi.NodeGetVolumeStatsResponse{
             Usage: []*csi.VolumeUsage{
                 {
@@ -461,19 +765,23 @@ func (d *CSIDriver) NodeGetVolumeStats(ctx context.Context,
         }, nil
     } else {
         // NFS backend
-        volumeName := GetVolumeNameFromPath(req.GetVolumeId())
-        share, err := d.hsclient.GetShare(volumeName)
+        id, err := ParseVolumeID(req.GetVolumeId())
+        if err != nil || id.IsFileBacked() {
+            return nil, status.Error(codes.NotFound, common.VolumeNotFound)
+        }
+        share, err := d.hsclient.GetShare(id.Name)
         if err != nil {
             return nil, status.Error(codes.NotFound, common.ShareNotFound)
         }
 
-        available, _ := strconv.ParseInt(share.Space.Available, 10, 64)
-        used, _ := strconv.ParseInt(share.Space.Used, 10, 64)
-        total, _ := strconv.ParseInt(share.Space.Total, 10, 64)
+        available := share.Space.AvailableBytes()
+        used := share.Space.UsedBytes()
+        total := share.Space.TotalBytes()
 
-        inodes_available, _ := strconv.ParseInt(share.Inodes.Available, 10, 64)
-        inodes_used, _ := strconv.ParseInt(share.Inodes.Used, 10, 64)
-        inodes_total, _ := strconv.ParseInt(share.Inodes.Total, 10, 64)
+        inodes_available := share.Inodes.AvailableCount()
+        inodes_used := share.Inodes.UsedCount()
+        inodes_total := share.Inodes.TotalCount()
+        d.checkVolumeUsage(req.GetVolumeId(), req.GetVolumePath(), used, total)
 
         return &csi.NodeGetVolumeStatsResponse{
             Usage: []*csi.VolumeUsage{
@@ -501,6 +809,13 @@ func (d *CSIDriver) NodeExpandVolume(
     req *csi.NodeExpandVolumeRequest) (
     *csi.NodeExpandVolumeResponse, error) {
 
+    if req.GetVolumeId() == "" {
+        return nil, status.Error(codes.InvalidArgument, common.EmptyVolumeId)
+    }
+    if req.GetVolumePath() == "" {
+        return nil, status.Error(codes.InvalidArgument, common.EmptyVolumePath)
+    }
+
     var requestedSize int64
     if req.GetCapacityRange().GetLimitBytes() != 0 {
         requestedSize = req.GetCapacityRange().GetLimitBytes()
@@ -508,33 +823,21 @@ func (d *CSIDriver) NodeExpandVolume(
         requestedSize = req.GetCapacityRange().GetRequiredBytes()
     }
 
-    // Find Share
-    typeMount := false
-    fileBacked := false
-
-    volumeName := GetVolumeNameFromPath(req.GetVolumeId())
-    share, _ := d.hsclient.GetShare(volumeName)
-    if share != nil {
-        typeMount = true;
-        if isMounted, _ := common.IsShareMounted(share.ExportPath); !isMounted {
-            return nil, status.Error(codes.FailedPrecondition, common.ShareNotMounted)
-        }
-    } else {
-        fileBacked = true
+    // Share-backed volumes are resized by the controller, there is nothing to do on the node
+    id, err := ParseVolumeID(req.GetVolumeId())
+    if err != nil {
+        return nil, status.Error(codes.NotFound, common.VolumeNotFound)
+    }
+    if !id.IsFileBacked() {
+        return &csi.NodeExpandVolumeResponse{}, nil
     }
 
-    //  Check if the specified backing share or file exists
-    if share == nil {
-        backingFileExists, err := d.hsclient.DoesFileExist(req.GetVolumeId())
-        if err != nil {
-            log.Error(err)
-        }
-        if !backingFileExists{
-            return nil, status.Error(codes.InvalidArgument, common.VolumeNotFound)
-        } else {
-            fileBacked = true
-        }
+    // Locate the volume as it was published at the volume path, the capability is optional
+    v, exists := d.nodeState.get(req.GetVolumePath())
+    if !exists || v.VolumeID != req.GetVolumeId() || v.BackingShareName == "" {
+        return nil, status.Errorf(codes.NotFound, common.VolumeNotPublishedAt, req.GetVolumeId(), req.GetVolumePath())
     }
+    typeMount := v.FSType != ""
     switch req.GetVolumeCapability().GetAccessType().(type) {
     case *csi.VolumeCapability_Block:
         typeMount = false
@@ -542,23 +845,36 @@ func (d *CSIDriver) NodeExpandVolume(
         typeMount = true
     }
 
-    if fileBacked{
-        // Ensure it's file-backed, otherwise no-op
-        // Resize device
-        err := common.ExpandDeviceFileSize(common.ShareStagingDir +req.GetVolumeId(), requestedSize)
+    defer d.releaseVolumeLock(v.BackingShareName)
+    d.getVolumeLock(v.BackingShareName)
+
+    // The capacity range is optional, keep the size the controller gave the file then
+    deviceFile := common.StagingPath(req.GetVolumeId())
+    if requestedSize == 0 {
+        info, err := os.Stat(deviceFile)
         if err != nil {
-            return nil, err
+            return nil, status.Error(codes.Internal, err.Error())
         }
-        if typeMount {
-            err = common.ExpandFilesystem(common.ShareStagingDir +req.GetVolumeId(), req.VolumeCapability.GetMount().FsType)
-            if err != nil {
-                return nil, err
-            }
+        requestedSize = info.Size()
+    }
+
+    // Grow the file and refresh the loop device it is attached to, whether it is bind
+    // mounted, exposed as a device node or holds a mounted filesystem
+    err = common.ExpandDeviceFileSize(deviceFile, requestedSize)
+    if err != nil {
+        return nil, status.Error(codes.Internal, err.Error())
+    }
+    if typeMount {
+        fsType := v.FSType
+        if fsType == "" {
+            fsType = req.GetVolumeCapability().GetMount().GetFsType()
+        }
+        err = common.GrowMountedFilesystem(req.GetVolumePath(), fsType)
+        if err != nil {
+            return nil, status.Error(codes.Internal, err.Error())
         }
-        return &csi.NodeExpandVolumeResponse{
-            CapacityBytes: requestedSize,
-            }, nil
-    } else {
-        return nil, nil
     }
+    return &csi.NodeExpandVolumeResponse{
+        CapacityBytes: requestedSize,
+    }, nil
 }
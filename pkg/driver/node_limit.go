@@ -0,0 +1,102 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "context"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// A node asked to publish many volumes at once, e.g. when a large StatefulSet scales up, would
+// otherwise run as many mounts and losetup commands in parallel. With common.NodePublishConcurrency
+// set, publish and unpublish operations beyond the limit queue until a slot is free or the CO
+// gives up on the call.
+const (
+    MetricNodeOperationsInFlight = "hs_csi_node_operations_in_flight"
+    MetricNodeOperationsQueued   = "hs_csi_node_operations_queued"
+    MetricNodeOperationWait      = "hs_csi_node_operation_wait_seconds_total"
+
+    nodeOperationPublish   = "publish"
+    nodeOperationUnpublish = "unpublish"
+)
+
+func init() {
+    common.RegisterMetric(MetricNodeOperationsInFlight, common.MetricTypeGauge,
+        "Node publish and unpublish operations running")
+    common.RegisterMetric(MetricNodeOperationsQueued, common.MetricTypeGauge,
+        "Node publish and unpublish operations waiting for the concurrency limit")
+    common.RegisterMetric(MetricNodeOperationWait, common.MetricTypeCounter,
+        "Time node operations spent waiting for the concurrency limit")
+}
+
+// operationLimiter bounds how many operations run at once, recording them in its metrics
+type operationLimiter struct {
+    slots chan struct{} // nil when unlimited
+
+    inFlightMetric string
+    queuedMetric   string
+    waitMetric     string
+}
+
+func newOperationLimiter(limit int, inFlightMetric, queuedMetric, waitMetric string) *operationLimiter {
+    l := &operationLimiter{
+        inFlightMetric: inFlightMetric,
+        queuedMetric:   queuedMetric,
+        waitMetric:     waitMetric,
+    }
+    if limit > 0 {
+        l.slots = make(chan struct{}, limit)
+    }
+    return l
+}
+
+func newNodeOperationLimiter(limit int) *operationLimiter {
+    return newOperationLimiter(limit,
+        MetricNodeOperationsInFlight, MetricNodeOperationsQueued, MetricNodeOperationWait)
+}
+
+// acquire waits for a slot to run operation, returning the function releasing it. It returns an
+// Aborted error if ctx ends first, so the CO retries the call later.
+func (l *operationLimiter) acquire(ctx context.Context, operation string) (func(), error) {
+    labels := map[string]string{"operation": operation}
+    if l.slots != nil {
+        start := time.Now()
+        common.AddMetric(l.queuedMetric, labels, 1)
+        select {
+        case l.slots <- struct{}{}:
+            common.AddMetric(l.queuedMetric, labels, -1)
+        case <-ctx.Done():
+            common.AddMetric(l.queuedMetric, labels, -1)
+            return nil, status.Errorf(codes.Aborted, common.OperationQueueTimeout,
+                operation, time.Since(start).Round(time.Millisecond), ctx.Err())
+        }
+        common.AddMetric(l.waitMetric, labels, time.Since(start).Seconds())
+    }
+
+    common.AddMetric(l.inFlightMetric, labels, 1)
+    return func() {
+        common.AddMetric(l.inFlightMetric, labels, -1)
+        if l.slots != nil {
+            <-l.slots
+        }
+    }, nil
+}
@@ -0,0 +1,64 @@
+package driver
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestNodeOperationLimiter(t *testing.T) {
+    // Unlimited
+    l := newNodeOperationLimiter(0)
+    for i := 0; i < 10; i++ {
+        if _, err := l.acquire(context.Background(), nodeOperationPublish); err != nil {
+            t.Logf("Unexpected error, %v", err)
+            t.FailNow()
+        }
+    }
+
+    l = newNodeOperationLimiter(2)
+    release1, err := l.acquire(context.Background(), nodeOperationPublish)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    release2, err := l.acquire(context.Background(), nodeOperationUnpublish)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+
+    // Queued until the CO gives up
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    _, err = l.acquire(ctx, nodeOperationPublish)
+    if status.Code(err) != codes.Aborted {
+        t.Logf("Expected Aborted, received %v", err)
+        t.FailNow()
+    }
+
+    // Queued until a slot is released
+    acquired := make(chan error)
+    go func() {
+        release, err := l.acquire(context.Background(), nodeOperationPublish)
+        if err == nil {
+            release()
+        }
+        acquired <- err
+    }()
+    select {
+    case <-acquired:
+        t.Logf("Expected the operation to be queued")
+        t.FailNow()
+    case <-time.After(10 * time.Millisecond):
+    }
+    release1()
+    if err := <-acquired; err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    release2()
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "path"
+    "strings"
+    "sync"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Nodes only use the Hammerspace API to find the data-portals to mount shares through and the
+// export paths of backing shares. CreateVolume records the data-portals, and the mount prefix, in
+// the volume context, so that nodes started with HS_NODE_CREDENTIALLESS mount through them and
+// never hold Hammerspace credentials. The export path of the backing share of a file-backed
+// volume is read from the volume ID, and that of the share of a share-backed volume from the
+// volume context, or the volume ID for volumes provisioned before exportPathPrefix. The mount
+// information is kept with the node state so backing shares can be mounted again after a restart.
+const (
+    VolumeContextDataPortals = "dataPortals"
+    VolumeContextMountPrefix = "mountPrefix"
+    VolumeContextExportPath  = "exportPath"
+)
+
+// volumeMountInfo is how a node without credentials mounts the share exported at ExportPath
+type volumeMountInfo struct {
+    ExportPath  string   `json:"exportPath"`
+    DataPortals []string `json:"dataPortals"`
+    MountPrefix string   `json:"mountPrefix,omitempty"`
+}
+
+// mountInfoCache holds the mount information of the shares of the volumes seen by this node
+type mountInfoCache struct {
+    lock         sync.Mutex
+    byExportPath map[string]*volumeMountInfo
+}
+
+// getMountInfoExportPath returns the export path of the share mounted for a volume
+func getMountInfoExportPath(volumeID VolumeID, volContext map[string]string) string {
+    if volumeID.IsFileBacked() {
+        return volumeID.BackingSharePath()
+    }
+    return getShareExportPath(volumeID.Path, volContext)
+}
+
+// getShareExportPath returns the export path of the share of the share-backed volume volumeID
+func getShareExportPath(volumeID string, volContext map[string]string) string {
+    if exportPath := volContext[VolumeContextExportPath]; exportPath != "" {
+        return exportPath
+    }
+    return volumeID
+}
+
+// getVolumeMountInfo returns the mount information in the context of a volume, nil if the context
+// lists no data-portals
+func getVolumeMountInfo(volumeID string, volContext map[string]string) *volumeMountInfo {
+    id, err := ParseVolumeID(volumeID)
+    if err != nil || volContext[VolumeContextDataPortals] == "" {
+        return nil
+    }
+    portals := []string{}
+    for _, portal := range strings.Split(volContext[VolumeContextDataPortals], ",") {
+        if portal = strings.TrimSpace(portal); portal != "" {
+            portals = append(portals, portal)
+        }
+    }
+    return &volumeMountInfo{
+        ExportPath:  getMountInfoExportPath(id, volContext),
+        DataPortals: portals,
+        MountPrefix: volContext[VolumeContextMountPrefix],
+    }
+}
+
+// put records info, replacing what was known of its share
+func (c *mountInfoCache) put(info *volumeMountInfo) {
+    if info == nil {
+        return
+    }
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    if c.byExportPath == nil {
+        c.byExportPath = make(map[string]*volumeMountInfo)
+    }
+    c.byExportPath[info.ExportPath] = info
+}
+
+// get returns the mount information of the share exported at exportPath, nil if it is unknown
+func (c *mountInfoCache) get(exportPath string) *volumeMountInfo {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    return c.byExportPath[exportPath]
+}
+
+// getBackingShareExportPath returns the export path of the backing share named name. The backing
+// shares created by the plugin are exported at /<name>.
+func (c *mountInfoCache) getBackingShareExportPath(name string) string {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    for exportPath := range c.byExportPath {
+        if path.Base(exportPath) == name {
+            return exportPath
+        }
+    }
+    return common.SharePathPrefix + name
+}
+
+// rememberVolumeMountInfo records the mount information in the context of a volume on nodes
+// without credentials and returns it, to be kept with the node state
+func (d *CSIDriver) rememberVolumeMountInfo(volumeID string, volContext map[string]string) *volumeMountInfo {
+    if !common.NodeCredentialless {
+        return nil
+    }
+    info := getVolumeMountInfo(volumeID, volContext)
+    d.mountInfo.put(info)
+    return info
+}
+
+// loadNodeMountInfo records the mount information kept with the node state
+func (d *CSIDriver) loadNodeMountInfo() {
+    for _, v := range d.nodeState.list() {
+        d.mountInfo.put(v.MountInfo)
+    }
+}
+
+// getBackingShare returns the backing share named name, as recorded in the volume contexts seen
+// by this node when it has no credentials
+func (d *CSIDriver) getBackingShare(name string) (*common.ShareResponse, error) {
+    if !common.NodeCredentialless {
+        return d.hsclient.GetShare(name)
+    }
+    return &common.ShareResponse{
+        Name:       name,
+        ExportPath: d.mountInfo.getBackingShareExportPath(name),
+    }, nil
+}
+
+// getContextDataPortals returns the data-portals recorded for the share exported at exportPath,
+// and the mount prefix to use with them
+func (d *CSIDriver) getContextDataPortals(exportPath string) ([]common.DataPortal, string, error) {
+    info := d.mountInfo.get(exportPath)
+    if info == nil || len(info.DataPortals) == 0 {
+        return nil, "", status.Errorf(codes.FailedPrecondition, common.NoVolumeMountInfo, exportPath)
+    }
+    portals := make([]common.DataPortal, len(info.DataPortals))
+    for i, address := range info.DataPortals {
+        portals[i].Node.MgmtIpAddress.Address = address
+        portals[i].Uoid = map[string]string{"uuid": address}
+    }
+    return portals, info.MountPrefix, nil
+}
+
+// getMountInfoVolumeContext returns the data-portals and mount prefix to record in the context of
+// a new volume. The floating data-portal addresses, when the cluster has them, come first.
+func (d *CSIDriver) getMountInfoVolumeContext() map[string]string {
+    volContext := map[string]string{}
+    portals, err := d.getDataPortals()
+    if err != nil {
+        log.Warnf("could not add the data-portals to the volume context, %v", err)
+        return volContext
+    }
+    addresses := []string{}
+    if fipaddr, err := d.hsclient.GetPortalFloatingIp(); err == nil && fipaddr != "" {
+        addresses = append(addresses, fipaddr)
+    }
+    for _, p := range portals {
+        if address := p.Node.MgmtIpAddress.Address; address != "" && !IsValueInList(address, addresses) {
+            addresses = append(addresses, address)
+        }
+    }
+    if len(addresses) > 0 {
+        volContext[VolumeContextDataPortals] = strings.Join(addresses, ",")
+    }
+    if common.DataPortalMountPrefix != "" {
+        volContext[VolumeContextMountPrefix] = common.DataPortalMountPrefix
+    }
+    return volContext
+}
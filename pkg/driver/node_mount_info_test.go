@@ -0,0 +1,91 @@
+package driver
+
+import (
+    "fmt"
+    "net/http"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestGetVolumeMountInfo(t *testing.T) {
+    volContext := map[string]string{
+        VolumeContextDataPortals: "10.0.0.1, 10.0.0.2,",
+        VolumeContextMountPrefix: "/mnt/data-portal",
+    }
+    info := getVolumeMountInfo("/pvc-a", volContext)
+    if info == nil || info.ExportPath != "/pvc-a" || len(info.DataPortals) != 2 ||
+        info.DataPortals[1] != "10.0.0.2" || info.MountPrefix != "/mnt/data-portal" {
+        t.Logf("Unexpected mount info of a share-backed volume, %v", info)
+        t.FailNow()
+    }
+    info = getVolumeMountInfo("/backing/pvc-b", volContext)
+    if info == nil || info.ExportPath != "/backing" {
+        t.Logf("Expected the backing share of a file-backed volume to be mounted, received %v", info)
+        t.FailNow()
+    }
+    volContext[VolumeContextExportPath] = "/k8s/prod/pvc-a"
+    info = getVolumeMountInfo("/pvc-a", volContext)
+    if info == nil || info.ExportPath != "/k8s/prod/pvc-a" {
+        t.Logf("Expected the export path in the volume context to be mounted, received %v", info)
+        t.FailNow()
+    }
+    if info := getVolumeMountInfo("/pvc-a", map[string]string{}); info != nil {
+        t.Logf("Expected no mount info without data-portals, received %v", info)
+        t.FailNow()
+    }
+}
+
+func TestCredentiallessBackingShare(t *testing.T) {
+    defer func(credentialless bool) { common.NodeCredentialless = credentialless }(common.NodeCredentialless)
+    common.NodeCredentialless = true
+    d := &CSIDriver{}
+
+    share, err := d.getBackingShare("backing")
+    if err != nil || share.ExportPath != "/backing" {
+        t.Logf("Expected backing share exported at /backing, received %v, %v", share, err)
+        t.FailNow()
+    }
+    d.rememberVolumeMountInfo("/exports/backing/pvc-b", map[string]string{VolumeContextDataPortals: "10.0.0.1"})
+    share, err = d.getBackingShare("backing")
+    if err != nil || share.ExportPath != "/exports/backing" {
+        t.Logf("Expected the export path recorded for the backing share, received %v, %v", share, err)
+        t.FailNow()
+    }
+
+    portals, _, err := d.getContextDataPortals("/exports/backing")
+    if err != nil || len(portals) != 1 || portals[0].Node.MgmtIpAddress.Address != "10.0.0.1" {
+        t.Logf("Unexpected data-portals %v, %v", portals, err)
+        t.FailNow()
+    }
+    if _, _, err := d.getContextDataPortals("/pvc-a"); status.Code(err) != codes.FailedPrecondition {
+        t.Logf("Expected FailedPrecondition without mount info, received %v", err)
+        t.FailNow()
+    }
+}
+
+func TestMountInfoVolumeContext(t *testing.T) {
+    mux := http.NewServeMux()
+    d := newFakeDriver(t, mux)
+
+    mux.HandleFunc(client.BasePath+"/data-portals/", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `[
+            {"operState": "UP", "adminState": "UP", "dataPortalType": "NFS_V3", "node": {"mgmtIpAddress": {"address": "10.0.0.1"}}},
+            {"operState": "DOWN", "adminState": "UP", "dataPortalType": "NFS_V3", "node": {"mgmtIpAddress": {"address": "10.0.0.2"}}},
+            {"operState": "UP", "adminState": "UP", "dataPortalType": "NFS_V3", "node": {"mgmtIpAddress": {"address": "10.0.0.3"}}}
+        ]`)
+    })
+    mux.HandleFunc(client.BasePath+"/cntl/state", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `{}`)
+    })
+
+    volContext := d.getMountInfoVolumeContext()
+    if volContext[VolumeContextDataPortals] != "10.0.0.1,10.0.0.3" {
+        t.Logf("Expected the data-portals which are up, received %v", volContext)
+        t.FailNow()
+    }
+}
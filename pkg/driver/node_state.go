@@ -0,0 +1,274 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sync"
+
+    log "github.com/sirupsen/logrus"
+)
+
+const (
+    nodeStateFileName = "node-state.json"
+    nodeStateVersion  = 1
+
+    NodeVolumeStaged    = "staged"
+    NodeVolumePublished = "published"
+)
+
+// nodeVolumeState is the record kept for each staging or target path of a volume on this node
+type nodeVolumeState struct {
+    VolumeID         string   `json:"volumeId"`
+    State            string   `json:"state"`
+    Path             string   `json:"path"`
+    VolumeMode       string   `json:"volumeMode,omitempty"`
+    FSType           string   `json:"fsType,omitempty"`
+    BackingShareName string   `json:"backingShareName,omitempty"`
+    MountFlags       []string `json:"mountFlags,omitempty"`
+    ReadOnly         bool     `json:"readOnly,omitempty"`
+
+    MountInfo *volumeMountInfo `json:"mountInfo,omitempty"` // kept on nodes without credentials
+}
+
+type nodeStateFile struct {
+    Version  int                         `json:"version"`
+    Volumes  map[string]*nodeVolumeState `json:"volumes"`            // keyed by staging or target path
+    Topology map[string]string           `json:"topology,omitempty"` // last topology reported by NodeGetInfo
+}
+
+// nodeStateStore records the volumes staged and published on this node. Every change rewrites the
+// state file to a temporary file which is synced and renamed over the previous one, so a crash
+// leaves either the old or the new state on disk, never a partial write.
+type nodeStateStore struct {
+    lock     sync.Mutex
+    dir      string
+    volumes  map[string]*nodeVolumeState
+    topology map[string]string
+}
+
+func newNodeStateStore(dir string) *nodeStateStore {
+    return &nodeStateStore{
+        dir:     dir,
+        volumes: make(map[string]*nodeVolumeState),
+    }
+}
+
+func (s *nodeStateStore) statePath() string {
+    return filepath.Join(s.dir, nodeStateFileName)
+}
+
+// load replays the state file from disk. Records whose path no longer exists on the host
+// were cleaned up while the plugin was not running and are dropped.
+func (s *nodeStateStore) load() error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    if s.dir == "" {
+        return nil
+    }
+    if err := os.MkdirAll(s.dir, 0750); err != nil {
+        return err
+    }
+    // Remove temporary files left behind by a crash mid-write
+    if leftovers, err := filepath.Glob(s.statePath() + ".tmp*"); err == nil {
+        for _, f := range leftovers {
+            os.Remove(f)
+        }
+    }
+
+    data, err := ioutil.ReadFile(s.statePath())
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+    state := nodeStateFile{}
+    if err := json.Unmarshal(data, &state); err != nil {
+        return err
+    }
+
+    pruned := false
+    for p, v := range state.Volumes {
+        if _, err := os.Stat(p); os.IsNotExist(err) {
+            log.Infof("dropping node state for volume %s, %s no longer exists", v.VolumeID, p)
+            delete(state.Volumes, p)
+            pruned = true
+            continue
+        }
+        log.Infof("recovered node state for volume %s, %s at %s", v.VolumeID, v.State, p)
+    }
+    if state.Volumes != nil {
+        s.volumes = state.Volumes
+    }
+    s.topology = state.Topology
+    if pruned {
+        return s.persist()
+    }
+    return nil
+}
+
+// persist must be called with the lock held
+func (s *nodeStateStore) persist() error {
+    if s.dir == "" {
+        return nil
+    }
+    data, err := json.Marshal(nodeStateFile{
+        Version:  nodeStateVersion,
+        Volumes:  s.volumes,
+        Topology: s.topology,
+    })
+    if err != nil {
+        return err
+    }
+
+    tmp, err := ioutil.TempFile(s.dir, nodeStateFileName+".tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp.Name(), s.statePath()); err != nil {
+        return err
+    }
+
+    // Sync the directory so the rename itself survives a crash
+    if dir, err := os.Open(s.dir); err == nil {
+        dir.Sync()
+        dir.Close()
+    }
+    return nil
+}
+
+func (s *nodeStateStore) put(v *nodeVolumeState) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    previous := s.volumes[v.Path]
+    s.volumes[v.Path] = v
+    err := s.persist()
+    if err != nil {
+        // Keep memory consistent with what is on disk
+        if previous != nil {
+            s.volumes[v.Path] = previous
+        } else {
+            delete(s.volumes, v.Path)
+        }
+    }
+    return err
+}
+
+func (s *nodeStateStore) remove(p string) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    previous, exists := s.volumes[p]
+    if !exists {
+        return nil
+    }
+    delete(s.volumes, p)
+    err := s.persist()
+    if err != nil {
+        s.volumes[p] = previous
+    }
+    return err
+}
+
+func (s *nodeStateStore) get(p string) (nodeVolumeState, bool) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if v, exists := s.volumes[p]; exists {
+        return *v, true
+    }
+    return nodeVolumeState{}, false
+}
+
+// putTopology records the topology reported by this node, to report again when the Anvil cannot be reached
+func (s *nodeStateStore) putTopology(topology map[string]string) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    previous := s.topology
+    s.topology = topology
+    err := s.persist()
+    if err != nil {
+        s.topology = previous
+    }
+    return err
+}
+
+func (s *nodeStateStore) getTopology() map[string]string {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return s.topology
+}
+
+func (s *nodeStateStore) list() []nodeVolumeState {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    volumes := make([]nodeVolumeState, 0, len(s.volumes))
+    for _, v := range s.volumes {
+        volumes = append(volumes, *v)
+    }
+    return volumes
+}
+
+// recordNodeVolume and forgetNodeVolume do not fail the calling RPC, the mount itself is the source of truth
+func (d *CSIDriver) recordNodeVolume(v *nodeVolumeState) {
+    if err := d.nodeState.put(v); err != nil {
+        log.Warnf("could not record node state for volume %s at %s, %v", v.VolumeID, v.Path, err)
+    }
+}
+
+func (d *CSIDriver) forgetNodeVolume(volumeID, p string) {
+    if err := d.nodeState.remove(p); err != nil {
+        log.Warnf("could not remove node state for volume %s at %s, %v", volumeID, p, err)
+    }
+}
+
+// warmUpBackingShareMounts mounts the backing shares of the volumes recorded on this node in the
+// background, so the first NodePublishVolume after a reboot does not wait on the mount
+func (d *CSIDriver) warmUpBackingShareMounts() {
+    backingShares := map[string]bool{}
+    for _, v := range d.nodeState.list() {
+        if v.BackingShareName != "" {
+            backingShares[v.BackingShareName] = true
+        }
+    }
+    for backingShareName := range backingShares {
+        d.wg.Add(1)
+        go func(backingShareName string) {
+            defer d.wg.Done()
+            defer d.releaseVolumeLock(backingShareName)
+            d.getVolumeLock(backingShareName)
+            log.Infof("warming up mount of backing share %s", backingShareName)
+            if err := d.EnsureBackingShareMounted(backingShareName); err != nil {
+                log.Warnf("could not warm up mount of backing share %s, %v", backingShareName, err)
+            }
+        }(backingShareName)
+    }
+}
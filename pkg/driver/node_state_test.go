@@ -0,0 +1,164 @@
+package driver
+
+import (
+    "context"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestNodeStateStore(t *testing.T) {
+    dir, err := ioutil.TempDir("", "node-state")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(dir)
+
+    targetPath := filepath.Join(dir, "target")
+    if err := os.Mkdir(targetPath, 0750); err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    published := &nodeVolumeState{
+        VolumeID:   "/test-share",
+        State:      NodeVolumePublished,
+        Path:       targetPath,
+        VolumeMode: "Filesystem",
+        FSType:     "nfs",
+        MountFlags: []string{"nconnect=4"},
+    }
+    stale := &nodeVolumeState{
+        VolumeID: "/backing/test-file",
+        State:    NodeVolumeStaged,
+        Path:     filepath.Join(dir, "removed"),
+    }
+
+    store := newNodeStateStore(dir)
+    if err := store.put(published); err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    if err := store.put(stale); err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+
+    // A crash mid-write leaves a temporary file behind
+    if err := ioutil.WriteFile(store.statePath()+".tmp123", []byte("{"), 0600); err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+
+    replayed := newNodeStateStore(dir)
+    if err := replayed.load(); err != nil {
+        t.Logf("Unexpected error loading node state, %v", err)
+        t.FailNow()
+    }
+    expected := []nodeVolumeState{*published}
+    if actual := replayed.list(); !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", actual)
+        t.FailNow()
+    }
+    if leftovers, _ := filepath.Glob(store.statePath() + ".tmp*"); len(leftovers) != 0 {
+        t.Logf("Expected temporary state files to be removed, found %v", leftovers)
+        t.FailNow()
+    }
+
+    if err := replayed.remove(targetPath); err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    empty := newNodeStateStore(dir)
+    if err := empty.load(); err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    if actual := empty.list(); len(actual) != 0 {
+        t.Logf("Expected no volumes after removal, found %v", actual)
+        t.FailNow()
+    }
+}
+
+func TestNodeStateTopology(t *testing.T) {
+    dir, err := ioutil.TempDir("", "node-state")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(dir)
+
+    store := newNodeStateStore(dir)
+    if topology := store.getTopology(); topology != nil {
+        t.Logf("Expected no topology, received %v", topology)
+        t.FailNow()
+    }
+    expected := map[string]string{"topology.csi.hammerspace.com/is-data-portal": "true"}
+    if err := store.putTopology(expected); err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+
+    replayed := newNodeStateStore(dir)
+    if err := replayed.load(); err != nil {
+        t.Logf("Unexpected error loading node state, %v", err)
+        t.FailNow()
+    }
+    if actual := replayed.getTopology(); !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", actual)
+        t.FailNow()
+    }
+}
+
+func TestNodeExpandVolumeWithoutCapacity(t *testing.T) {
+    backingDir, err := ioutil.TempDir(common.ShareStagingDir, "expand-test")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(backingDir)
+    volumeID := "/" + filepath.Base(backingDir) + "/pvc-1"
+    ioutil.WriteFile(common.StagingPath(volumeID), make([]byte, 4096), 0644)
+    defer fakeAttachedLoopDevices(t, common.StagingPath(volumeID))()
+    commands := [][]string{}
+    defer func(f func(string, ...string) ([]byte, error)) { common.ExecCommand = f }(common.ExecCommand)
+    common.ExecCommand = func(command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        return nil, nil
+    }
+
+    d := &CSIDriver{sharedState: newSharedState(), nodeState: newNodeStateStore("")}
+    d.nodeState.put(&nodeVolumeState{
+        VolumeID:         volumeID,
+        State:            NodeVolumePublished,
+        Path:             "/target",
+        VolumeMode:       "Block",
+        BackingShareName: filepath.Base(backingDir),
+    })
+    // The file keeps its size, it is not resized to 0
+    resp, err := d.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+        VolumeId:   volumeID,
+        VolumePath: "/target",
+    })
+    if err != nil || resp.GetCapacityBytes() != 4096 {
+        t.Logf("Expected the volume to keep its 4096 bytes, received %v, %v", resp, err)
+        t.FailNow()
+    }
+    expected := [][]string{
+        {"qemu-img", "resize", "-fraw", common.StagingPath(volumeID), "4096"},
+        {"losetup", "-c", "/dev/loop0"},
+    }
+    if !reflect.DeepEqual(commands, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", commands)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "sync"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+)
+
+const (
+    objectiveCacheTTL         = 5 * time.Minute
+    objectiveNegativeCacheTTL = 30 * time.Second
+)
+
+// objectiveCache holds the objective names defined on the cluster. A name that is missing from the
+// cached list triggers a single refresh before it is rejected, so objectives created on the
+// Hammerspace side are picked up immediately. Names still missing after the refresh are remembered
+// briefly to avoid refreshing on every request for a bad objective.
+type objectiveCache struct {
+    lock     sync.Mutex
+    names    []string
+    fetched  time.Time
+    negative map[string]time.Time
+    now      func() time.Time
+}
+
+func (c *objectiveCache) currentTime() time.Time {
+    if c.now != nil {
+        return c.now()
+    }
+    return time.Now()
+}
+
+func (c *objectiveCache) refresh(fetch func() ([]string, error)) error {
+    names, err := fetch()
+    if err != nil {
+        return err
+    }
+    c.names = names
+    c.fetched = c.currentTime()
+    return nil
+}
+
+// exists reports whether the named objective is defined on the cluster, using fetch to list objectives
+func (c *objectiveCache) exists(name string, fetch func() ([]string, error)) (bool, error) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    now := c.currentTime()
+    if c.negative == nil {
+        c.negative = map[string]time.Time{}
+    }
+    if missingSince, exists := c.negative[name]; exists {
+        if now.Sub(missingSince) < objectiveNegativeCacheTTL {
+            return false, nil
+        }
+        delete(c.negative, name)
+    }
+
+    refreshed := false
+    if c.fetched.IsZero() || now.Sub(c.fetched) >= objectiveCacheTTL {
+        if err := c.refresh(fetch); err != nil {
+            return false, err
+        }
+        refreshed = true
+    }
+    if IsValueInList(name, c.names) {
+        return true, nil
+    }
+
+    if !refreshed {
+        log.Debugf("objective %s not in cached list, refreshing", name)
+        if err := c.refresh(fetch); err != nil {
+            return false, err
+        }
+        if IsValueInList(name, c.names) {
+            return true, nil
+        }
+    }
+    c.negative[name] = now
+    return false, nil
+}
+
+// warm refreshes the cached objectives if they have expired, and returns how long ago they were fetched
+func (c *objectiveCache) warm(fetch func() ([]string, error)) (time.Duration, error) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    if c.fetched.IsZero() || c.currentTime().Sub(c.fetched) >= objectiveCacheTTL {
+        if err := c.refresh(fetch); err != nil {
+            return 0, err
+        }
+    }
+    return c.currentTime().Sub(c.fetched), nil
+}
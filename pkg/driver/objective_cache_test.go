@@ -0,0 +1,79 @@
+package driver
+
+import (
+    "testing"
+    "time"
+)
+
+func TestObjectiveCache(t *testing.T) {
+    now := time.Unix(0, 0)
+    cache := &objectiveCache{now: func() time.Time { return now }}
+
+    fetches := 0
+    clusterObjectives := []string{"obj1"}
+    fetch := func() ([]string, error) {
+        fetches++
+        return clusterObjectives, nil
+    }
+
+    exists, err := cache.exists("obj1", fetch)
+    if err != nil || !exists || fetches != 1 {
+        t.Logf("Expected obj1 to exist after one fetch, exists=%v fetches=%d err=%v", exists, fetches, err)
+        t.FailNow()
+    }
+
+    // Objective created on the cluster after the list was cached
+    clusterObjectives = []string{"obj1", "obj2"}
+    exists, err = cache.exists("obj2", fetch)
+    if err != nil || !exists || fetches != 2 {
+        t.Logf("Expected a refresh on miss, exists=%v fetches=%d err=%v", exists, fetches, err)
+        t.FailNow()
+    }
+
+    // Missing objectives are cached briefly
+    exists, _ = cache.exists("missing", fetch)
+    if exists || fetches != 3 {
+        t.Logf("Expected missing objective to be rejected after a refresh, fetches=%d", fetches)
+        t.FailNow()
+    }
+    exists, _ = cache.exists("missing", fetch)
+    if exists || fetches != 3 {
+        t.Logf("Expected negative lookup to be cached, fetches=%d", fetches)
+        t.FailNow()
+    }
+
+    now = now.Add(objectiveNegativeCacheTTL)
+    clusterObjectives = []string{"obj1", "obj2", "missing"}
+    exists, _ = cache.exists("missing", fetch)
+    if !exists || fetches != 4 {
+        t.Logf("Expected negative lookup to expire, fetches=%d", fetches)
+        t.FailNow()
+    }
+}
+
+func TestObjectiveCacheWarm(t *testing.T) {
+    now := time.Unix(0, 0)
+    cache := &objectiveCache{now: func() time.Time { return now }}
+    fetches := 0
+    fetch := func() ([]string, error) {
+        fetches++
+        return []string{"obj1"}, nil
+    }
+
+    age, err := cache.warm(fetch)
+    if err != nil || age != 0 || fetches != 1 {
+        t.Logf("Expected a cold cache to be fetched, age=%v fetches=%d err=%v", age, fetches, err)
+        t.FailNow()
+    }
+    now = now.Add(time.Minute)
+    age, err = cache.warm(fetch)
+    if err != nil || age != time.Minute || fetches != 1 {
+        t.Logf("Expected a warm cache not to be fetched, age=%v fetches=%d err=%v", age, fetches, err)
+        t.FailNow()
+    }
+    now = now.Add(objectiveCacheTTL)
+    if _, err = cache.warm(fetch); err != nil || fetches != 2 {
+        t.Logf("Expected an expired cache to be fetched, fetches=%d err=%v", fetches, err)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/url"
+    "strings"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// The volume policy hook, HS_VOLUME_POLICY_HOOK, lets storage administrators approve each volume
+// created or deleted by the controller. It is either
+//   http(s)://<url>   POSTed the policy request as JSON, a 2xx response allows the operation
+//   <command>         run with /bin/sh with the request as JSON in CSI_POLICY_REQUEST, exit code 0
+//                     allows the operation
+// Denied operations fail with PermissionDenied, with the response body or command output as the
+// reason. Operations fail with Unavailable, for the CO to retry, if the hook cannot be called.
+const (
+    PolicyOperationCreateVolume = "CreateVolume"
+    PolicyOperationDeleteVolume = "DeleteVolume"
+)
+
+// policyRequest is the operation submitted to the volume policy hook
+type policyRequest struct {
+    Operation     string                     `json:"operation"`
+    Name          string                     `json:"name,omitempty"`
+    VolumeID      string                     `json:"volumeId,omitempty"`
+    CapacityBytes int64                      `json:"capacityBytes,omitempty"`
+    Parameters    map[string]string          `json:"parameters,omitempty"`
+    Parsed        *common.HSVolumeParameters `json:"parsedParameters,omitempty"`
+}
+
+// ValidatePolicyHook returns an error if an http(s) policy hook is not a valid URL
+func ValidatePolicyHook(hook string) error {
+    if !strings.HasPrefix(hook, "http://") && !strings.HasPrefix(hook, "https://") {
+        return nil
+    }
+    u, err := url.Parse(hook)
+    if err != nil || u.Host == "" {
+        return fmt.Errorf("policy hook URL is invalid, received '%s'", hook)
+    }
+    return nil
+}
+
+// policyDenied is returned by the hook callers when the hook denied the operation
+type policyDenied struct {
+    reason string
+}
+
+func (e policyDenied) Error() string {
+    return e.reason
+}
+
+var postPolicyWebhook = func(webhook string, request policyRequest) error {
+    err := postHookWebhook(webhook, request)
+    if rejected, ok := err.(hookRejected); ok {
+        return policyDenied{rejected.Error()}
+    }
+    return err
+}
+
+// runPolicyCommand runs the policy hook command, which denies the operation by exiting non-zero
+var runPolicyCommand = func(command string, request policyRequest) error {
+    body, err := json.Marshal(request)
+    if err != nil {
+        return err
+    }
+    output, err := runHookCommand(command,
+        "CSI_POLICY_OPERATION="+request.Operation,
+        "CSI_POLICY_REQUEST="+string(body))
+    if err != nil {
+        reason := strings.TrimSpace(string(output))
+        if len(reason) > maxHookResponseBytes {
+            reason = reason[:maxHookResponseBytes]
+        }
+        return policyDenied{fmt.Sprintf("%s, %v", reason, err)}
+    }
+    return nil
+}
+
+// checkVolumePolicy submits an operation to the volume policy hook, if one is configured
+func checkVolumePolicy(request policyRequest) error {
+    hook := common.VolumePolicyHook
+    if hook == "" {
+        return nil
+    }
+    var err error
+    if strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://") {
+        err = postPolicyWebhook(hook, request)
+    } else {
+        err = runPolicyCommand(hook, request)
+    }
+    if err == nil {
+        return nil
+    }
+    if denied, ok := err.(policyDenied); ok {
+        log.Warnf("volume policy denied %s of %s%s, %s", request.Operation, request.Name, request.VolumeID, denied.reason)
+        return status.Errorf(codes.PermissionDenied, common.VolumePolicyDenied, request.Operation, denied.reason)
+    }
+    return status.Errorf(codes.Unavailable, common.VolumePolicyUnavailable, request.Operation, err)
+}
@@ -0,0 +1,106 @@
+package driver
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestCheckVolumePolicyWebhook(t *testing.T) {
+    received := []policyRequest{}
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var request policyRequest
+        json.NewDecoder(r.Body).Decode(&request)
+        received = append(received, request)
+        if request.CapacityBytes > 1000 {
+            w.WriteHeader(http.StatusForbidden)
+            fmt.Fprintf(w, "volumes are limited to 1000 bytes")
+        }
+    }))
+    defer server.Close()
+    defer func() { common.VolumePolicyHook = "" }()
+    common.VolumePolicyHook = server.URL
+
+    request := policyRequest{
+        Operation:     PolicyOperationCreateVolume,
+        Name:          "pvc-1",
+        CapacityBytes: 1000,
+        Parameters:    map[string]string{"objectives": "keep-online"},
+        Parsed:        &common.HSVolumeParameters{Objectives: []string{"keep-online"}},
+    }
+    if err := checkVolumePolicy(request); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if len(received) != 1 || received[0].Parsed == nil || received[0].Parameters["objectives"] != "keep-online" {
+        t.Logf("Expected the parameters to be sent to the webhook, received %v", received)
+        t.FailNow()
+    }
+
+    request.CapacityBytes = 2000
+    err := checkVolumePolicy(request)
+    if status.Code(err) != codes.PermissionDenied {
+        t.Logf("Expected PermissionDenied, received %v", err)
+        t.FailNow()
+    }
+    if expected := fmt.Sprintf(common.VolumePolicyDenied, PolicyOperationCreateVolume,
+        "status code 403, volumes are limited to 1000 bytes"); status.Convert(err).Message() != expected {
+        t.Logf("Expected '%s', received '%s'", expected, status.Convert(err).Message())
+        t.FailNow()
+    }
+
+    server.Close()
+    if err := checkVolumePolicy(request); status.Code(err) != codes.Unavailable {
+        t.Logf("Expected Unavailable when the webhook cannot be reached, received %v", err)
+        t.FailNow()
+    }
+}
+
+func TestCheckVolumePolicyCommand(t *testing.T) {
+    defer func() { common.VolumePolicyHook = "" }()
+    common.VolumePolicyHook = "/etc/hammerspace/volume-policy"
+
+    defer func(execCommand func(string, ...string) ([]byte, error)) {
+        common.ExecCommand = execCommand
+    }(common.ExecCommand)
+    var exitErr error
+    commands := [][]string{}
+    common.ExecCommand = func(command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        return []byte("deletion is not allowed\n"), exitErr
+    }
+
+    request := policyRequest{Operation: PolicyOperationDeleteVolume, VolumeID: "/pvc-1"}
+    if err := checkVolumePolicy(request); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    expected := []string{"env", "-i", "PATH=" + os.Getenv("PATH"),
+        "CSI_POLICY_OPERATION=DeleteVolume",
+        `CSI_POLICY_REQUEST={"operation":"DeleteVolume","volumeId":"/pvc-1"}`,
+        "/bin/sh", "-c", "/etc/hammerspace/volume-policy"}
+    if len(commands) != 1 || fmt.Sprint(commands[0]) != fmt.Sprint(expected) {
+        t.Logf("Expected command %v, received %v", expected, commands)
+        t.FailNow()
+    }
+
+    exitErr = errors.New("exit status 1")
+    if err := checkVolumePolicy(request); status.Code(err) != codes.PermissionDenied {
+        t.Logf("Expected PermissionDenied, received %v", err)
+        t.FailNow()
+    }
+
+    if ValidatePolicyHook("https://") == nil || ValidatePolicyHook("/usr/local/bin/policy") != nil {
+        t.Logf("Unexpected policy hook validation")
+        t.FailNow()
+    }
+}
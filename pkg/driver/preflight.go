@@ -0,0 +1,107 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+const (
+    PreflightEnvironment = "environment"
+    PreflightLogin       = "hammerspace-login"
+    PreflightPermissions = "hammerspace-permissions"
+    PreflightBinaries    = "binaries"
+    PreflightLoopDevices = "loop-devices"
+    PreflightKernelNFS   = "kernel-nfs"
+    PreflightNodeMounts  = "node-mounts"
+)
+
+type PreflightCheck struct {
+    Name    string `json:"name"`
+    Passed  bool   `json:"passed"`
+    Skipped bool   `json:"skipped,omitempty"`
+    Message string `json:"message,omitempty"`
+}
+
+// PreflightReport is printed as JSON by the --preflight mode of the plugin
+type PreflightReport struct {
+    Passed bool             `json:"passed"`
+    Checks []PreflightCheck `json:"checks"`
+}
+
+func (r *PreflightReport) add(name, message string, err error) {
+    check := PreflightCheck{Name: name, Passed: err == nil, Message: message}
+    if err != nil {
+        check.Message = err.Error()
+        r.Passed = false
+    }
+    r.Checks = append(r.Checks, check)
+}
+
+func (r *PreflightReport) skip(name, reason string) {
+    r.Checks = append(r.Checks, PreflightCheck{Name: name, Skipped: true, Message: reason})
+}
+
+// RunPreflight checks that the host and the Hammerspace cluster are ready to run the plugin,
+// without changing either. envErr is the result of validating the environment variables.
+func RunPreflight(envErr error, endpoint, username, password string, tlsVerify bool) *PreflightReport {
+    report := &PreflightReport{Passed: true}
+    report.add(PreflightEnvironment, "", envErr)
+
+    if envErr != nil {
+        report.skip(PreflightLogin, "environment is invalid")
+        report.skip(PreflightPermissions, "environment is invalid")
+    } else {
+        // Creating the client logs in
+        hsclient, err := client.NewHammerspaceClient(endpoint, username, password, tlsVerify)
+        report.add(PreflightLogin, endpoint, err)
+        if err != nil {
+            report.skip(PreflightPermissions, "could not log in")
+        } else {
+            var shares []common.ShareResponse
+            shares, err = hsclient.ListShares()
+            if err == nil {
+                _, err = hsclient.ListObjectiveNames()
+            }
+            report.add(PreflightPermissions, fmt.Sprintf("listed %d shares and the cluster objectives", len(shares)), err)
+        }
+    }
+
+    binaries := append(append([]string{}, common.RequiredBinaries...), common.RequiredNodeBinaries...)
+    var err error
+    if missing := common.FindMissingBinaries(binaries); len(missing) > 0 {
+        err = fmt.Errorf(common.MissingBinaries, strings.Join(missing, ", "))
+    }
+    report.add(PreflightBinaries, strings.Join(binaries, ", "), err)
+
+    report.add(PreflightLoopDevices, "", common.CheckLoopDeviceSupport())
+
+    versions, err := common.GetKernelNFSVersions()
+    report.add(PreflightKernelNFS, "supported NFS versions: "+strings.Join(versions, ", "), err)
+
+    if common.KubeletRootDir == "" {
+        report.skip(PreflightNodeMounts, "HS_KUBELET_ROOT_DIR is empty")
+    } else {
+        report.add(PreflightNodeMounts, common.KubeletRootDir+", "+common.ShareStagingDir, CheckNodeMounts())
+    }
+
+    return report
+}
@@ -0,0 +1,90 @@
+package driver
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os/exec"
+    "strings"
+    "testing"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func getPreflightCheck(t *testing.T, report *PreflightReport, name string) PreflightCheck {
+    for _, check := range report.Checks {
+        if check.Name == name {
+            return check
+        }
+    }
+    t.Logf("Expected the %s check in %v", name, report.Checks)
+    t.FailNow()
+    return PreflightCheck{}
+}
+
+func TestRunPreflight(t *testing.T) {
+    defer func(kubeletRootDir string) { common.KubeletRootDir = kubeletRootDir }(common.KubeletRootDir)
+    common.KubeletRootDir = ""
+    defer func() { common.LookPath = exec.LookPath }()
+    common.LookPath = func(file string) (string, error) {
+        if file == "qemu-img" {
+            return "", errors.New("not found")
+        }
+        return "/usr/bin/" + file, nil
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc(client.BasePath+"/login", func(w http.ResponseWriter, r *http.Request) {
+        if r.FormValue("username") != "admin" {
+            w.WriteHeader(401)
+        }
+    })
+    mux.HandleFunc(client.BasePath+"/shares", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `[{"name": "share"}]`)
+    })
+    mux.HandleFunc(client.BasePath+"/objectives", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `[]`)
+    })
+    server := httptest.NewServer(mux)
+    defer server.Close()
+    endpoint := server.URL
+
+    // The cluster is not checked with an invalid environment
+    report := RunPreflight(errors.New("HS_ENDPOINT must be set"), endpoint, "admin", "password", false)
+    if report.Passed || getPreflightCheck(t, report, PreflightEnvironment).Passed {
+        t.Logf("Expected the environment check to fail, received %v", report)
+        t.FailNow()
+    }
+    for _, name := range []string{PreflightLogin, PreflightPermissions} {
+        if !getPreflightCheck(t, report, name).Skipped {
+            t.Logf("Expected the %s check to be skipped, received %v", name, report)
+            t.FailNow()
+        }
+    }
+
+    report = RunPreflight(nil, endpoint, "denied", "password", false)
+    if report.Passed || getPreflightCheck(t, report, PreflightLogin).Passed ||
+        !getPreflightCheck(t, report, PreflightPermissions).Skipped {
+        t.Logf("Expected the login check to fail, received %v", report)
+        t.FailNow()
+    }
+
+    report = RunPreflight(nil, endpoint, "admin", "password", false)
+    permissions := getPreflightCheck(t, report, PreflightPermissions)
+    if !getPreflightCheck(t, report, PreflightLogin).Passed || !permissions.Passed ||
+        !strings.Contains(permissions.Message, "listed 1 shares") {
+        t.Logf("Expected the cluster checks to pass, received %v", report)
+        t.FailNow()
+    }
+    binaries := getPreflightCheck(t, report, PreflightBinaries)
+    if report.Passed || binaries.Passed || !strings.Contains(binaries.Message, "qemu-img") {
+        t.Logf("Expected the missing binary to be reported, received %v", report)
+        t.FailNow()
+    }
+    if !getPreflightCheck(t, report, PreflightNodeMounts).Skipped {
+        t.Logf("Expected the node mounts check to be skipped without a kubelet root dir")
+        t.FailNow()
+    }
+}
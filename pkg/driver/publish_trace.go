@@ -0,0 +1,123 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// NodePublishVolume times each of its steps, so a slow pod start can be attributed to a stage.
+// The breakdown is logged when the call completes and the time spent in each stage is added to
+// MetricPublishStageSeconds. Stages run more than once, such as mounts tried against several
+// data-portals, are summed.
+const (
+    MetricPublishStageSeconds = "hs_csi_node_publish_stage_seconds_total"
+    MetricPublishStageRuns    = "hs_csi_node_publish_stage_runs_total"
+
+    publishStageQueue       = "queue"
+    publishStageGetShare    = "get-share"
+    publishStageDataPortals = "data-portals"
+    publishStageShowmount   = "showmount"
+    publishStageNFSMount    = "nfs-mount"
+    publishStageLosetup     = "losetup"
+    publishStageBindMount   = "bind-mount"
+    publishStageDeviceNode  = "device-node"
+    publishStageFSMount     = "fs-mount"
+    publishStageFSGrow      = "fs-grow"
+)
+
+func init() {
+    common.RegisterMetric(MetricPublishStageSeconds, common.MetricTypeCounter,
+        "Time NodePublishVolume spent in each stage")
+    common.RegisterMetric(MetricPublishStageRuns, common.MetricTypeCounter,
+        "Times NodePublishVolume ran each stage")
+}
+
+type publishStage struct {
+    name     string
+    duration time.Duration
+    runs     int
+}
+
+// publishTrace records the stages of one NodePublishVolume. Its methods do nothing on a nil
+// trace, so the helpers it times can be called outside of a publish.
+type publishTrace struct {
+    volumeID string
+    start    time.Time
+    stages   []*publishStage
+}
+
+func newPublishTrace(volumeID string) *publishTrace {
+    return &publishTrace{volumeID: volumeID, start: time.Now()}
+}
+
+// stage starts timing a stage, the returned function ends it
+func (t *publishTrace) stage(name string) func() {
+    if t == nil {
+        return func() {}
+    }
+    start := time.Now()
+    return func() {
+        t.record(name, time.Since(start))
+    }
+}
+
+func (t *publishTrace) record(name string, duration time.Duration) {
+    for _, s := range t.stages {
+        if s.name == name {
+            s.duration += duration
+            s.runs++
+            return
+        }
+    }
+    t.stages = append(t.stages, &publishStage{name: name, duration: duration, runs: 1})
+}
+
+// breakdown formats the stages in the order they first ran, Ex: data-portals=12ms nfs-mount=1.2s(x2)
+func (t *publishTrace) breakdown() string {
+    parts := make([]string, 0, len(t.stages))
+    for _, s := range t.stages {
+        part := fmt.Sprintf("%s=%v", s.name, s.duration.Round(time.Millisecond))
+        if s.runs > 1 {
+            part += fmt.Sprintf("(x%d)", s.runs)
+        }
+        parts = append(parts, part)
+    }
+    return strings.Join(parts, " ")
+}
+
+// finish logs the breakdown of the publish and records its stages in the metrics
+func (t *publishTrace) finish() {
+    if t == nil {
+        return
+    }
+    for _, s := range t.stages {
+        labels := map[string]string{"stage": s.name}
+        common.AddMetric(MetricPublishStageSeconds, labels, s.duration.Seconds())
+        common.AddMetric(MetricPublishStageRuns, labels, float64(s.runs))
+    }
+    log.WithFields(log.Fields{
+        "volumeId": t.volumeID,
+        "duration": time.Since(t.start).Round(time.Millisecond).String(),
+    }).Infof("publish stages: %s", t.breakdown())
+}
@@ -0,0 +1,41 @@
+package driver
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestPublishTrace(t *testing.T) {
+    // A nil trace times nothing
+    var trace *publishTrace
+    trace.stage(publishStageNFSMount)()
+    trace.finish()
+
+    trace = newPublishTrace("/test-trace")
+    trace.record(publishStageDataPortals, 12*time.Millisecond)
+    trace.record(publishStageNFSMount, 1*time.Second)
+    trace.record(publishStageShowmount, 5*time.Millisecond)
+    trace.record(publishStageNFSMount, 200*time.Millisecond)
+    trace.stage(publishStageFSMount)()
+
+    expected := "data-portals=12ms nfs-mount=1.2s(x2) showmount=5ms fs-mount=0s"
+    if breakdown := trace.breakdown(); breakdown != expected {
+        t.Logf("Expected %s, received %s", expected, breakdown)
+        t.FailNow()
+    }
+
+    trace.finish()
+    metrics := common.RenderMetrics()
+    for _, expected := range []string{
+        MetricPublishStageSeconds + `{stage="nfs-mount"} 1.2`,
+        MetricPublishStageRuns + `{stage="nfs-mount"} 2`,
+    } {
+        if !strings.Contains(metrics, expected) {
+            t.Logf("Expected %s in metrics, received\n%s", expected, metrics)
+            t.FailNow()
+        }
+    }
+}
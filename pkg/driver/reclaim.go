@@ -0,0 +1,283 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "io/ioutil"
+    "os"
+    "path"
+    "sort"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Space freed inside file-backed volumes is returned to the Hammerspace share in two ways, following
+// the csi-addons ReclaimSpace operations. On nodes the mounted filesystem is trimmed, and the loop
+// device punches holes in the backing file for the discarded blocks. On the controller, zero-filled
+// ranges of backing files which are not published anywhere are deallocated.
+//
+// Whether a backing file is in use is told through files in a directory of its backing share:
+//   <volume>.lock             locked shared by each node using the file, for any kind of publish,
+//                             and exclusively by the controller while it deallocates space
+//   <volume>.attached.<node>  written by a node once it holds its lock, and removed once no target
+//                             path on the node uses the file
+// The locks are kept by the NFS server, so unlike the contents of the directory they are not
+// subject to the caching of each client. A publish racing with the controller either holds its
+// lock first and the controller skips the file, or fails with Unavailable for the CO to retry.
+// Locks do not survive a restart of the plugin, the marks do and are checked by the controller
+// too, and a restarted node plugin takes the locks of the volumes it publishes again.
+const reclaimDirName = ".csi-reclaim"
+
+func getReclaimFile(volumeID, suffix string) string {
+    id, _ := ParseVolumeID(volumeID)
+    reclaimDir := common.StagingPath(id.BackingSharePath(), reclaimDirName)
+    return path.Join(reclaimDir, id.Name+"."+suffix)
+}
+
+// lockVolumeAttached takes the shared lock of this node on the backing file of a volume, unless
+// it holds it already
+func (d *CSIDriver) lockVolumeAttached(volumeID string) error {
+    d.attachLocksLock.Lock()
+    defer d.attachLocksLock.Unlock()
+    if _, exists := d.attachLocks[volumeID]; exists {
+        return nil
+    }
+    f, err := common.LockFile(getReclaimFile(volumeID, "lock"), false)
+    if err == common.ErrFileLocked {
+        return status.Errorf(codes.Unavailable, common.ReclaimSpaceInProgress, volumeID)
+    } else if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    d.attachLocks[volumeID] = f
+    return nil
+}
+
+// markVolumeAttached records that this node uses the backing file of a volume, failing with
+// Unavailable while the controller is deallocating space from it. The caller must hold the lock
+// on the backing share.
+func (d *CSIDriver) markVolumeAttached(volumeID, targetPath string) error {
+    if err := d.lockVolumeAttached(volumeID); err != nil {
+        return err
+    }
+    err := writeFreezeFile(getReclaimFile(volumeID, "attached."+d.NodeID), d.NodeID)
+    if err != nil {
+        d.unmarkVolumeAttached(volumeID, targetPath)
+        return status.Error(codes.Internal, err.Error())
+    }
+    return nil
+}
+
+// unmarkVolumeAttached removes the mark of this node on a volume unless it is published at
+// another target path than targetPath
+func (d *CSIDriver) unmarkVolumeAttached(volumeID, targetPath string) {
+    for _, v := range d.nodeState.list() {
+        if v.VolumeID == volumeID && v.Path != targetPath && v.State == NodeVolumePublished {
+            return
+        }
+    }
+    err := os.Remove(getReclaimFile(volumeID, "attached."+d.NodeID))
+    if err != nil && !os.IsNotExist(err) {
+        log.Warnf("could not unmark volume %s as attached, %v", volumeID, err)
+    }
+    d.attachLocksLock.Lock()
+    defer d.attachLocksLock.Unlock()
+    if f, exists := d.attachLocks[volumeID]; exists {
+        f.Close()
+        delete(d.attachLocks, volumeID)
+    }
+}
+
+// markAttachedVolumes marks and locks the file-backed volumes published on this node before a
+// restart, an earlier version of the plugin may not have marked them and the locks went with it
+func (d *CSIDriver) markAttachedVolumes() {
+    for _, v := range d.nodeState.list() {
+        if v.State != NodeVolumePublished || v.BackingShareName == "" {
+            continue
+        }
+        id, err := ParseVolumeID(v.VolumeID)
+        if err != nil {
+            continue
+        }
+        // Without the backing share the marker would be written to the local staging directory
+        if mounted, _ := common.IsShareMounted(common.StagingPath(id.BackingSharePath())); !mounted {
+            continue
+        }
+        err = writeFreezeFile(getReclaimFile(v.VolumeID, "attached."+d.NodeID), d.NodeID)
+        if err != nil {
+            log.Warnf("could not mark volume %s as attached, %v", v.VolumeID, err)
+        }
+        // The mark keeps the controller from reclaiming the space of the volume if this fails
+        if err = d.lockVolumeAttached(v.VolumeID); err != nil {
+            log.Warnf("could not lock volume %s as attached, %v", v.VolumeID, err)
+        }
+    }
+}
+
+// getAttachedNodes returns the nodes which have marked the backing file of a volume as in use.
+// Nodes which only mark the volumes they publish for filesystem freezes are included.
+func getAttachedNodes(volumeID string) ([]string, error) {
+    files, err := ioutil.ReadDir(path.Dir(getReclaimFile(volumeID, "")))
+    if err != nil && !os.IsNotExist(err) {
+        return nil, err
+    }
+    id, _ := ParseVolumeID(volumeID)
+    attachedPrefix := id.Name + ".attached."
+    attached := map[string]bool{}
+    for _, f := range files {
+        if strings.HasPrefix(f.Name(), attachedPrefix) {
+            attached[strings.TrimPrefix(f.Name(), attachedPrefix)] = true
+        }
+    }
+    published, err := getPublishingNodes(volumeID)
+    if err != nil {
+        return nil, err
+    }
+    for _, node := range published {
+        attached[node] = true
+    }
+    nodes := []string{}
+    for node := range attached {
+        nodes = append(nodes, node)
+    }
+    sort.Strings(nodes)
+    return nodes, nil
+}
+
+// nodeReclaimSpace trims the filesystem of a file-backed volume published at targetPath
+func (d *CSIDriver) nodeReclaimSpace(volumeID, targetPath string) error {
+    v, exists := d.nodeState.get(targetPath)
+    if !exists || v.VolumeID != volumeID {
+        return status.Errorf(codes.NotFound, common.VolumeNotPublishedAt, volumeID, targetPath)
+    }
+    if !isFileBackedFilesystem(v) {
+        // Share-backed volumes have no backing file, block volumes have no filesystem to trim
+        return status.Errorf(codes.FailedPrecondition, common.ReclaimSpaceUnsupported, volumeID)
+    }
+    output, err := common.TrimFilesystem(targetPath)
+    if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    log.Infof("reclaimed space of volume %s at %s, %s", volumeID, targetPath, output)
+    return nil
+}
+
+// controllerReclaimSpace deallocates the zero-filled ranges of a file-backed volume's backing file.
+// Published volumes are trimmed on their nodes instead, as the filesystem may be writing to them.
+func (d *CSIDriver) controllerReclaimSpace(volumeID string) error {
+    id, err := ParseVolumeID(volumeID)
+    if err != nil || !id.IsFileBacked() {
+        return status.Errorf(codes.InvalidArgument, common.ReclaimSpaceUnsupported, volumeID)
+    }
+    backingShareName := id.BackingShare
+    exists, err := d.hsclient.DoesFileExist(volumeID)
+    if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    if !exists {
+        return status.Error(codes.NotFound, common.VolumeNotFound)
+    }
+
+    defer d.releaseVolumeLock(backingShareName)
+    d.getVolumeLock(backingShareName)
+    defer d.scheduleBackingShareUnmount(backingShareName)
+    err = d.EnsureBackingShareMounted(backingShareName)
+    if err != nil {
+        return err
+    }
+
+    // Held until the holes are dug, nodes publishing the volume meanwhile fail to take their lock
+    lock, err := common.LockFile(getReclaimFile(volumeID, "lock"), true)
+    if err == common.ErrFileLocked {
+        nodes, _ := getAttachedNodes(volumeID)
+        return status.Errorf(codes.FailedPrecondition, common.ReclaimSpaceVolumePublished, volumeID, nodes)
+    } else if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    defer lock.Close()
+    nodes, err := getAttachedNodes(volumeID)
+    if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    if len(nodes) > 0 {
+        return status.Errorf(codes.FailedPrecondition, common.ReclaimSpaceVolumePublished, volumeID, nodes)
+    }
+
+    err = common.DigHoles(common.StagingPath(volumeID))
+    if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    log.Infof("reclaimed space of unpublished volume %s", volumeID)
+    return nil
+}
+
+// reclaimSpace trims the file-backed volumes published on this node, and deallocates unused
+// space from the unpublished backing files created by this controller while it is the leader
+func (d *CSIDriver) reclaimSpace() {
+    for _, v := range d.nodeState.list() {
+        if !isFileBackedFilesystem(v) {
+            continue
+        }
+        if err := d.nodeReclaimSpace(v.VolumeID, v.Path); err != nil {
+            log.Warnf("could not reclaim space of volume %s, %v", v.VolumeID, err)
+        }
+    }
+    // Backing files are deallocated by the leading controller, nodes would have to mount every
+    // backing share
+    if d.NodeID != "" || !d.servesController() {
+        return
+    }
+    if !d.isLeader() {
+        d.forgetBackingFiles()
+        return
+    }
+    if err := d.ensureBackingFilesLoaded(); err != nil {
+        log.Warnf("could not load the files of existing backing shares, %v", err)
+    }
+    for filePath := range d.getTrackedBackingFiles() {
+        err := d.controllerReclaimSpace(filePath)
+        if status.Code(err) == codes.FailedPrecondition {
+            log.Debugf("skipping space reclaim of %s, %v", filePath, err)
+        } else if err != nil {
+            log.Warnf("could not reclaim space of volume %s, %v", filePath, err)
+        }
+    }
+}
+
+// startSpaceReclaimer runs reclaimSpace every interval until the driver is stopped
+func (d *CSIDriver) startSpaceReclaimer(interval time.Duration) {
+    log.Infof("starting space reclaimer with interval %v", interval)
+    d.wg.Add(1)
+    go func() {
+        defer d.wg.Done()
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-d.stopCh:
+                return
+            case <-ticker.C:
+                d.reclaimSpace()
+            }
+        }
+    }()
+}
@@ -0,0 +1,154 @@
+package driver
+
+import (
+    "context"
+    "io/ioutil"
+    "net"
+    "os"
+    "path"
+    "reflect"
+    "testing"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+    "github.com/hammer-space/csi-plugin/pkg/csiaddons"
+)
+
+func TestReclaimMarkers(t *testing.T) {
+    backingDir, err := ioutil.TempDir(common.ShareStagingDir, "reclaim-test")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(backingDir)
+    volumeID := "/" + path.Base(backingDir) + "/test-volume"
+    d := &CSIDriver{sharedState: newSharedState(), NodeID: "node1", nodeState: newNodeStateStore("")}
+
+    if err := d.markVolumeAttached(volumeID, "/target/a"); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    // The controller cannot lock the file while the node does
+    if _, err := common.LockFile(getReclaimFile(volumeID, "lock"), true); err != common.ErrFileLocked {
+        t.Logf("Expected the file to be locked by the node, received %v", err)
+        t.FailNow()
+    }
+    // Nodes which only mark volumes for filesystem freezes are in use too
+    writeFreezeFile(getFreezeFile(volumeID, "published.node2"), "node2")
+    expected := []string{"node1", "node2"}
+    if nodes, err := getAttachedNodes(volumeID); err != nil || !reflect.DeepEqual(nodes, expected) {
+        t.Logf("Expected %v, received %v, %v", expected, nodes, err)
+        t.FailNow()
+    }
+    os.Remove(getFreezeFile(volumeID, "published.node2"))
+
+    // The mark stays while the volume is published at another target path
+    d.nodeState.put(&nodeVolumeState{VolumeID: volumeID, State: NodeVolumePublished, Path: "/target/b"})
+    d.unmarkVolumeAttached(volumeID, "/target/a")
+    if nodes, _ := getAttachedNodes(volumeID); len(nodes) != 1 {
+        t.Logf("Expected the volume to stay attached, received %v", nodes)
+        t.FailNow()
+    }
+    d.nodeState.remove("/target/b")
+    d.unmarkVolumeAttached(volumeID, "/target/b")
+    if nodes, _ := getAttachedNodes(volumeID); len(nodes) != 0 {
+        t.Logf("Expected the volume not to be attached, received %v", nodes)
+        t.FailNow()
+    }
+
+    // Publishing fails while the controller holds the lock
+    lock, err := common.LockFile(getReclaimFile(volumeID, "lock"), true)
+    if err != nil {
+        t.Logf("Expected the controller to lock the file, received %v", err)
+        t.FailNow()
+    }
+    if err := d.markVolumeAttached(volumeID, "/target/a"); status.Code(err) != codes.Unavailable {
+        t.Logf("Expected Unavailable during a reclaim, received %v", err)
+        t.FailNow()
+    }
+    if nodes, _ := getAttachedNodes(volumeID); len(nodes) != 0 {
+        t.Logf("Expected the volume not to be marked, received %v", nodes)
+        t.FailNow()
+    }
+    lock.Close()
+    if err := d.markVolumeAttached(volumeID, "/target/a"); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+}
+
+func TestNodeReclaimSpace(t *testing.T) {
+    d := &CSIDriver{NodeID: "node1", nodeState: newNodeStateStore("")}
+    d.nodeState.put(&nodeVolumeState{
+        VolumeID: "/backing/pvc-a", State: NodeVolumePublished, Path: "/target/a", BackingShareName: "backing"})
+
+    if err := d.nodeReclaimSpace("/backing/pvc-b", "/target/a"); status.Code(err) != codes.NotFound {
+        t.Logf("Expected NotFound for another volume, received %v", err)
+        t.FailNow()
+    }
+    if err := d.nodeReclaimSpace("/backing/pvc-a", "/target/a"); status.Code(err) != codes.FailedPrecondition {
+        t.Logf("Expected FailedPrecondition for a block volume, received %v", err)
+        t.FailNow()
+    }
+}
+
+func TestServeCSIAddons(t *testing.T) {
+    defer func(mode string) { common.ServiceMode = mode }(common.ServiceMode)
+    common.ServiceMode = common.ServiceModeNode
+    d := &CSIDriver{NodeID: "node1", nodeState: newNodeStateStore(""), stopCh: make(chan struct{})}
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    d.ServeCSIAddons(l)
+    defer func() {
+        close(d.stopCh)
+        d.wg.Wait()
+    }()
+
+    conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer conn.Close()
+    ctx := context.Background()
+
+    identity := &csiaddons.GetIdentityResponse{}
+    err = conn.Invoke(ctx, "/identity.Identity/GetIdentity", &csiaddons.GetIdentityRequest{}, identity)
+    if err != nil || identity.Name != common.CsiPluginName {
+        t.Logf("Unexpected identity %v, %v", identity, err)
+        t.FailNow()
+    }
+
+    capabilities := &csiaddons.GetCapabilitiesResponse{}
+    err = conn.Invoke(ctx, "/identity.Identity/GetCapabilities", &csiaddons.GetCapabilitiesRequest{}, capabilities)
+    expected := []*csiaddons.Capability{
+        {Service: &csiaddons.Capability_Service{Type: csiaddons.NodeService}},
+        {ReclaimSpace: &csiaddons.Capability_ReclaimSpace{Type: csiaddons.ReclaimSpaceOnline}},
+    }
+    if err != nil || !reflect.DeepEqual(capabilities.Capabilities, expected) {
+        t.Logf("Expected %v, received %v, %v", expected, capabilities.Capabilities, err)
+        t.FailNow()
+    }
+
+    err = conn.Invoke(ctx, "/reclaimspace.ReclaimSpaceNode/NodeReclaimSpace",
+        &csiaddons.NodeReclaimSpaceRequest{VolumeId: "/backing/pvc-a", VolumePath: "/target/a"},
+        &csiaddons.NodeReclaimSpaceResponse{})
+    if status.Code(err) != codes.NotFound {
+        t.Logf("Expected NotFound for a volume which is not published, received %v", err)
+        t.FailNow()
+    }
+    // Node plugins do not serve the controller operation
+    err = conn.Invoke(ctx, "/reclaimspace.ReclaimSpaceController/ControllerReclaimSpace",
+        &csiaddons.ControllerReclaimSpaceRequest{VolumeId: "/backing/pvc-a"},
+        &csiaddons.ControllerReclaimSpaceResponse{})
+    if status.Code(err) != codes.Unimplemented {
+        t.Logf("Expected Unimplemented, received %v", err)
+        t.FailNow()
+    }
+}
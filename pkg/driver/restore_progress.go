@@ -0,0 +1,69 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "time"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Restoring a share-backed volume from a snapshot copies the snapshot in a cluster task, which
+// CreateVolume waits for. The progress the task reports each time it is polled is exported, so
+// users can tell how long a PersistentVolumeClaim restored from a snapshot will stay pending. The
+// CSI version implemented by the plugin has no volume condition to report it to the CO.
+const (
+    MetricRestoreProgress = "hs_csi_volume_restore_progress_ratio"
+    MetricRestoreElapsed  = "hs_csi_volume_restore_elapsed_seconds"
+)
+
+func init() {
+    common.RegisterMetric(MetricRestoreProgress, common.MetricTypeGauge,
+        "Fraction of the restore of a volume from a snapshot which is complete")
+    common.RegisterMetric(MetricRestoreElapsed, common.MetricTypeGauge,
+        "Time since the restore of a volume from a snapshot started")
+}
+
+// getRestoreRemaining estimates the time left to restore a volume, progress of 100 percent having
+// taken elapsed so far. It returns 0 until the task reports progress.
+func getRestoreRemaining(progress float64, elapsed time.Duration) time.Duration {
+    if progress <= 0 || progress >= 100 {
+        return 0
+    }
+    return time.Duration(float64(elapsed) * (100 - progress) / progress)
+}
+
+// trackRestoreProgress returns the function recording the progress of the task restoring volumeID
+func (d *CSIDriver) trackRestoreProgress(volumeID string) func(common.Task) {
+    started := time.Now()
+    labels := map[string]string{"volume_id": volumeID}
+    return func(task common.Task) {
+        elapsed := time.Since(started)
+        common.SetMetric(MetricRestoreProgress, labels, task.Progress/100)
+        common.SetMetric(MetricRestoreElapsed, labels, elapsed.Seconds())
+        common.SampledInfof("restore of volume %s is %.0f%% complete after %v, about %v left",
+            volumeID, task.Progress, elapsed.Round(time.Second),
+            getRestoreRemaining(task.Progress, elapsed).Round(time.Second))
+    }
+}
+
+// forgetRestoreProgress removes the metrics of a restore once its task has ended
+func (d *CSIDriver) forgetRestoreProgress(volumeID string) {
+    labels := map[string]string{"volume_id": volumeID}
+    common.DeleteMetric(MetricRestoreProgress, labels)
+    common.DeleteMetric(MetricRestoreElapsed, labels)
+}
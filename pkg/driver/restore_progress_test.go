@@ -0,0 +1,36 @@
+package driver
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestGetRestoreRemaining(t *testing.T) {
+    if remaining := getRestoreRemaining(25, time.Minute); remaining != 3*time.Minute {
+        t.Logf("Expected 3m left, received %v", remaining)
+        t.FailNow()
+    }
+    for _, progress := range []float64{0, 100} {
+        if remaining := getRestoreRemaining(progress, time.Minute); remaining != 0 {
+            t.Logf("Expected no estimate at %v%%, received %v", progress, remaining)
+            t.FailNow()
+        }
+    }
+}
+
+func TestTrackRestoreProgress(t *testing.T) {
+    d := &CSIDriver{}
+    d.trackRestoreProgress("/restored")(common.Task{Status: "EXECUTING", Progress: 40})
+    if metrics := common.RenderMetrics(); !strings.Contains(metrics, MetricRestoreProgress+`{volume_id="/restored"} 0.4`) {
+        t.Logf("Expected the restore progress in metrics, received %s", metrics)
+        t.FailNow()
+    }
+    d.forgetRestoreProgress("/restored")
+    if metrics := common.RenderMetrics(); strings.Contains(metrics, `volume_id="/restored"`) {
+        t.Logf("Expected the restore metrics to be removed, received %s", metrics)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "time"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+const (
+    MetricScrubRuns         = "hs_csi_backing_file_scrub_runs_total"
+    MetricScrubMissing      = "hs_csi_backing_file_scrub_missing_total"
+    MetricScrubSizeMismatch = "hs_csi_backing_file_scrub_size_mismatch_total"
+    MetricScrubErrors       = "hs_csi_backing_file_scrub_errors_total"
+    MetricScrubTracked      = "hs_csi_backing_file_scrub_tracked_files"
+)
+
+func init() {
+    common.RegisterMetric(MetricScrubRuns, common.MetricTypeCounter,
+        "Number of completed backing file scrub passes")
+    common.RegisterMetric(MetricScrubMissing, common.MetricTypeCounter,
+        "Number of times a CSI-owned backing file was found missing")
+    common.RegisterMetric(MetricScrubSizeMismatch, common.MetricTypeCounter,
+        "Number of times a CSI-owned backing file did not match its recorded size")
+    common.RegisterMetric(MetricScrubErrors, common.MetricTypeCounter,
+        "Number of backing files that could not be checked due to API errors")
+    common.RegisterMetric(MetricScrubTracked, common.MetricTypeGauge,
+        "Number of backing files checked during the last scrub pass")
+}
+
+// trackBackingFile records the expected size of a file-backed volume so the scrubber can verify it
+func (d *CSIDriver) trackBackingFile(filePath string, size int64) {
+    d.backingFilesLock.Lock()
+    defer d.backingFilesLock.Unlock()
+    d.backingFiles[filePath] = size
+}
+
+func (d *CSIDriver) untrackBackingFile(filePath string) {
+    d.backingFilesLock.Lock()
+    defer d.backingFilesLock.Unlock()
+    delete(d.backingFiles, filePath)
+}
+
+func (d *CSIDriver) getTrackedBackingFiles() map[string]int64 {
+    d.backingFilesLock.Lock()
+    defer d.backingFilesLock.Unlock()
+    files := make(map[string]int64, len(d.backingFiles))
+    for p, s := range d.backingFiles {
+        files[p] = s
+    }
+    return files
+}
+
+// loadBackingFiles tracks the files in the backing shares created by the plugin, so that the
+// volumes created before this replica started or became the leader are scrubbed too. Files are
+// tracked with their current size unless they already are.
+func (d *CSIDriver) loadBackingFiles() error {
+    shares, err := d.hsclient.ListShares()
+    if err != nil {
+        return err
+    }
+    for i := range shares {
+        share := &shares[i]
+        if share.ShareState == "REMOVED" || !isBackingShare(share) {
+            continue
+        }
+        if err := d.loadBackingShareFiles(share); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// loadBackingShareFiles tracks the files in a backing share. The lock on the share is held so
+// that files deleted meanwhile are not tracked again.
+func (d *CSIDriver) loadBackingShareFiles(backingShare *common.ShareResponse) error {
+    defer d.releaseVolumeLock(backingShare.Name)
+    d.getVolumeLock(backingShare.Name)
+    defer d.scheduleBackingShareUnmount(backingShare.Name)
+    if err := d.EnsureBackingShareMounted(backingShare.Name); err != nil {
+        return err
+    }
+    files, err := listBackingFiles(common.StagingPath(backingShare.ExportPath))
+    if err != nil {
+        return err
+    }
+
+    d.backingFilesLock.Lock()
+    defer d.backingFilesLock.Unlock()
+    for name, size := range files {
+        filePath := NewFileVolumeID(backingShare.ExportPath, name).Path
+        if _, tracked := d.backingFiles[filePath]; !tracked {
+            d.backingFiles[filePath] = size
+        }
+    }
+    return nil
+}
+
+// ensureBackingFilesLoaded loads the files of the backing shares once after this replica becomes
+// the leader, the previous leader may have created volumes meanwhile
+func (d *CSIDriver) ensureBackingFilesLoaded() error {
+    d.backingFilesLock.Lock()
+    loaded := d.backingFilesLoaded
+    d.backingFilesLock.Unlock()
+    if loaded {
+        return nil
+    }
+    if err := d.loadBackingFiles(); err != nil {
+        return err
+    }
+    d.backingFilesLock.Lock()
+    d.backingFilesLoaded = true
+    d.backingFilesLock.Unlock()
+    return nil
+}
+
+// forgetBackingFiles stops tracking backing files while another replica leads and creates and
+// deletes volumes, they are loaded again once this replica leads
+func (d *CSIDriver) forgetBackingFiles() {
+    d.backingFilesLock.Lock()
+    defer d.backingFilesLock.Unlock()
+    d.backingFiles = make(map[string]int64)
+    d.backingFilesLoaded = false
+}
+
+// scrubBackingFiles verifies every tracked backing file still exists on the backend and matches
+// its recorded size, and that the shares of share-backed volumes have their requested capacity.
+// Discrepancies are logged and counted so they are caught before a pod fails to start.
+func (d *CSIDriver) scrubBackingFiles() {
+    if !d.isLeader() {
+        d.forgetBackingFiles()
+        return
+    }
+    // Node plugins which also serve the controller would mount every backing share on every node
+    if d.NodeID == "" {
+        if err := d.ensureBackingFilesLoaded(); err != nil {
+            log.Warnf("could not load the files of existing backing shares, %v", err)
+            common.IncMetric(MetricScrubErrors, nil)
+        }
+    }
+    files := d.getTrackedBackingFiles()
+    log.Debugf("scrubbing %d backing files", len(files))
+
+    for filePath, expectedSize := range files {
+        file, err := d.hsclient.GetFile(filePath)
+        if err != nil {
+            log.Warnf("could not check backing file %s, %v", filePath, err)
+            common.IncMetric(MetricScrubErrors, nil)
+            continue
+        }
+        id, _ := ParseVolumeID(filePath)
+        backingShareLabels := map[string]string{"backing_share": id.BackingShare}
+        fileLog := log.WithFields(log.Fields{
+            "event":        "BackingFileScrub",
+            "path":         filePath,
+            "expectedSize": expectedSize,
+        })
+        if file == nil {
+            fileLog.Error("backing file for volume is missing")
+            common.IncMetric(MetricScrubMissing, backingShareLabels)
+            continue
+        }
+        if file.Size < expectedSize {
+            fileLog.WithField("actualSize", file.Size).Error("backing file is smaller than its recorded size")
+            common.IncMetric(MetricScrubSizeMismatch, backingShareLabels)
+        } else if file.Size > expectedSize {
+            // Files only grow when a node completes an expansion, adopt the new size
+            d.backingFilesLock.Lock()
+            if _, tracked := d.backingFiles[filePath]; tracked {
+                d.backingFiles[filePath] = file.Size
+            }
+            d.backingFilesLock.Unlock()
+        }
+    }
+    d.scrubShareSizes()
+    common.SetMetric(MetricScrubTracked, nil, float64(len(files)))
+    common.IncMetric(MetricScrubRuns, nil)
+}
+
+// startBackingFileScrubber runs scrubBackingFiles every interval until the driver is stopped
+func (d *CSIDriver) startBackingFileScrubber(interval time.Duration) {
+    log.Infof("starting backing file scrubber with interval %v", interval)
+    d.wg.Add(1)
+    go func() {
+        defer d.wg.Done()
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-d.stopCh:
+                return
+            case <-ticker.C:
+                d.scrubBackingFiles()
+            }
+        }
+    }()
+}
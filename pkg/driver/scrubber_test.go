@@ -0,0 +1,117 @@
+package driver
+
+import (
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestIsBackingShare(t *testing.T) {
+    for _, test := range []struct {
+        extendedInfo map[string]string
+        expected     bool
+    }{
+        {map[string]string{ExtendedInfoCreatedBy: common.CsiPluginName, ExtendedInfoBackingShare: "true"}, true},
+        // Created before backing shares were marked
+        {map[string]string{ExtendedInfoCreatedBy: common.CsiPluginName, ExtendedInfoAllocatedBytes: "0"}, true},
+        {map[string]string{ExtendedInfoCreatedBy: common.CsiPluginName, ExtendedInfoVolumeName: "pvc-a"}, false},
+        {map[string]string{ExtendedInfoBackingShare: "true"}, false},
+    } {
+        share := &common.ShareResponse{Name: "share", ExtendedInfo: test.extendedInfo}
+        if actual := isBackingShare(share); actual != test.expected {
+            t.Logf("Expected %v for a share with extended info %v, received %v", test.expected, test.extendedInfo, actual)
+            t.FailNow()
+        }
+    }
+}
+
+func TestListBackingFiles(t *testing.T) {
+    backingDir, err := ioutil.TempDir("", "backing")
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(backingDir)
+    ioutil.WriteFile(path.Join(backingDir, "pvc-a"), make([]byte, 10), 0644)
+    ioutil.WriteFile(path.Join(backingDir, "pvc-b"), make([]byte, 20), 0644)
+    ioutil.WriteFile(path.Join(backingDir, ".pvc-c.tmp"), make([]byte, 30), 0644)
+    ioutil.WriteFile(path.Join(backingDir, "pvc-d"+cloneTempSuffix), make([]byte, 40), 0644)
+    os.MkdirAll(path.Join(backingDir, trashDirName), 0755)
+
+    files, err := listBackingFiles(backingDir)
+    expected := map[string]int64{"pvc-a": 10, "pvc-b": 20}
+    if err != nil || !reflect.DeepEqual(files, expected) {
+        t.Logf("Expected %v, received %v, %v", expected, files, err)
+        t.FailNow()
+    }
+    // Clones in progress take space in the share all the same
+    if total, err := sumBackingFileSizes(backingDir); err != nil || total != 70 {
+        t.Logf("Expected 70 bytes, received %d, %v", total, err)
+        t.FailNow()
+    }
+}
+
+func TestScrubBackingFiles(t *testing.T) {
+    mux := http.NewServeMux()
+    d := newFakeDriver(t, mux)
+    shareLists := 0
+    mux.HandleFunc(client.BasePath+"/shares", func(w http.ResponseWriter, r *http.Request) {
+        shareLists++
+        fmt.Fprintf(w, `[]`)
+    })
+    mux.HandleFunc(client.BasePath+"/files", func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Query().Get("path") {
+        case "/backing/pvc-a":
+            fmt.Fprintf(w, `{"name": "pvc-a", "path": "/backing/pvc-a", "size": "100"}`)
+        case "/backing/pvc-b":
+            fmt.Fprintf(w, `{"name": "pvc-b", "path": "/backing/pvc-b", "size": "200"}`)
+        default:
+            w.WriteHeader(404)
+        }
+    })
+    d.backingFiles = map[string]int64{}
+    d.trackBackingFile("/backing/pvc-a", 100)
+    d.trackBackingFile("/backing/pvc-b", 50)
+    d.trackBackingFile("/backing/pvc-c", 100)
+
+    d.scrubBackingFiles()
+    // Expanded files are tracked with their new size
+    expected := map[string]int64{"/backing/pvc-a": 100, "/backing/pvc-b": 200, "/backing/pvc-c": 100}
+    if files := d.getTrackedBackingFiles(); !reflect.DeepEqual(files, expected) {
+        t.Logf("Expected %v, received %v", expected, files)
+        t.FailNow()
+    }
+    metrics := common.RenderMetrics()
+    if !strings.Contains(metrics, MetricScrubMissing+`{backing_share="backing"}`) {
+        t.Logf("Expected the missing file to be counted, received %s", metrics)
+        t.FailNow()
+    }
+    if !strings.Contains(metrics, MetricScrubTracked+" 3") {
+        t.Logf("Expected 3 tracked files, received %s", metrics)
+        t.FailNow()
+    }
+
+    // Existing backing shares are only listed once, and tracked files forgotten by replicas
+    // which are not the leader
+    d.scrubBackingFiles()
+    if shareLists != 3 {
+        t.Logf("Expected the backing shares to be listed once besides the size checks, received %d", shareLists)
+        t.FailNow()
+    }
+    defer func(check string) { common.LeaderCheck = check }(common.LeaderCheck)
+    common.LeaderCheck = "file:" + path.Join(os.TempDir(), "no-such-leader-file")
+    d.leader = leaderState{}
+    d.scrubBackingFiles()
+    if files := d.getTrackedBackingFiles(); len(files) != 0 || d.backingFilesLoaded {
+        t.Logf("Expected backing files to be forgotten, received %v", files)
+        t.FailNow()
+    }
+}
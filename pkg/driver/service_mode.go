@@ -0,0 +1,58 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "os"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// The plugin serves the identity service and, depending on common.ServiceMode, the controller
+// service, the node service or both. The controller Deployment runs in controller mode so it never
+// loads kernel modules or touches host mounts, and the node DaemonSet runs in node mode so it
+// does not detect cluster features, scrub backing files or answer controller RPCs. In the default
+// mode both services are served, the plugin is a node if CSI_NODE_NAME is set.
+
+// ValidateServiceMode returns an error if mode is not one of the service modes
+func ValidateServiceMode(mode string) error {
+    switch mode {
+    case common.ServiceModeAll, common.ServiceModeController, common.ServiceModeNode:
+        return nil
+    }
+    return fmt.Errorf("mode must be %s, %s or %s, received '%s'",
+        common.ServiceModeAll, common.ServiceModeController, common.ServiceModeNode, mode)
+}
+
+// getNodeID returns the ID of the node the plugin serves, "" when it only serves the controller
+func getNodeID() string {
+    if common.ServiceMode == common.ServiceModeController {
+        return ""
+    }
+    return os.Getenv("CSI_NODE_NAME")
+}
+
+// servesController returns whether the controller service is served
+func (d *CSIDriver) servesController() bool {
+    return common.ServiceMode != common.ServiceModeNode
+}
+
+// servesNode returns whether the node service is served
+func (d *CSIDriver) servesNode() bool {
+    return common.ServiceMode != common.ServiceModeController
+}
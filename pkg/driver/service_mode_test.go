@@ -0,0 +1,77 @@
+package driver
+
+import (
+    "context"
+    "os"
+    "testing"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestValidateServiceMode(t *testing.T) {
+    for _, mode := range []string{common.ServiceModeAll, common.ServiceModeController, common.ServiceModeNode} {
+        if err := ValidateServiceMode(mode); err != nil {
+            t.Logf("Expected mode %s to be valid, received %v", mode, err)
+            t.FailNow()
+        }
+    }
+    for _, mode := range []string{"", "Controller", "both"} {
+        if err := ValidateServiceMode(mode); err == nil {
+            t.Logf("Expected mode '%s' to be invalid", mode)
+            t.FailNow()
+        }
+    }
+}
+
+func TestGetNodeID(t *testing.T) {
+    defer func(mode string) { common.ServiceMode = mode }(common.ServiceMode)
+    defer os.Setenv("CSI_NODE_NAME", os.Getenv("CSI_NODE_NAME"))
+    os.Setenv("CSI_NODE_NAME", "node-1")
+
+    for mode, expected := range map[string]string{
+        common.ServiceModeAll:        "node-1",
+        common.ServiceModeController: "",
+        common.ServiceModeNode:       "node-1",
+    } {
+        common.ServiceMode = mode
+        if nodeID := getNodeID(); nodeID != expected {
+            t.Logf("Expected node ID '%s' in %s mode, received '%s'", expected, mode, nodeID)
+            t.FailNow()
+        }
+    }
+}
+
+func TestPluginCapabilitiesByServiceMode(t *testing.T) {
+    defer func(mode string) { common.ServiceMode = mode }(common.ServiceMode)
+    d := &CSIDriver{}
+
+    for mode, expected := range map[string]bool{
+        common.ServiceModeAll:        true,
+        common.ServiceModeController: true,
+        common.ServiceModeNode:       false,
+    } {
+        common.ServiceMode = mode
+        resp, err := d.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+        if err != nil {
+            t.Logf("Unexpected error in %s mode, %v", mode, err)
+            t.FailNow()
+        }
+        controller := false
+        for _, c := range resp.GetCapabilities() {
+            if c.GetService().GetType() == csi.PluginCapability_Service_CONTROLLER_SERVICE {
+                controller = true
+            }
+        }
+        if controller != expected {
+            t.Logf("Expected controller service %v in %s mode, received %v", expected, mode, controller)
+            t.FailNow()
+        }
+        if len(resp.GetCapabilities()) < 3 {
+            t.Logf("Expected the volume expansion and topology capabilities in %s mode, received %v",
+                mode, resp.GetCapabilities())
+            t.FailNow()
+        }
+    }
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// The controller records the capacity requested for a share-backed volume, and its expansions, in
+// the extended info of its share. Each scrub pass compares the recorded capacity to the size limit
+// of the share, so a share resized outside of the plugin, e.g. shrunk in the GUI, is reported
+// instead of surprising the application with ENOSPC.
+const (
+    ExtendedInfoRequestedBytes   = "csi_requested_bytes"
+    ExtendedInfoExpansionHistory = "csi_expansion_history"
+
+    MetricShareSizeDrift      = "hs_csi_share_size_drift_bytes"
+    MetricShareSizeDriftTotal = "hs_csi_share_size_drift_total"
+
+    // Expansions kept in the history, older ones are dropped
+    maxExpansionHistory = 10
+)
+
+func init() {
+    common.RegisterMetric(MetricShareSizeDrift, common.MetricTypeGauge,
+        "Size limit of the share of a share-backed volume minus its requested capacity, when they differ")
+    common.RegisterMetric(MetricShareSizeDriftTotal, common.MetricTypeCounter,
+        "Times a scrub pass found the share of a share-backed volume not to have its requested capacity")
+}
+
+// appendExpansionHistory adds an expansion to a history of the form
+// <RFC3339 time>:<old bytes>-><new bytes>;..., keeping the last maxExpansionHistory entries
+func appendExpansionHistory(history string, at time.Time, from, to int64) string {
+    entries := []string{}
+    if history != "" {
+        entries = strings.Split(history, ";")
+    }
+    entries = append(entries, fmt.Sprintf("%s:%d->%d", at.UTC().Format(time.RFC3339), from, to))
+    if len(entries) > maxExpansionHistory {
+        entries = entries[len(entries)-maxExpansionHistory:]
+    }
+    return strings.Join(entries, ";")
+}
+
+// getShareRequestedBytes returns the capacity recorded for a share, false if none was recorded
+func getShareRequestedBytes(share *common.ShareResponse) (int64, bool) {
+    requested, err := strconv.ParseInt(share.ExtendedInfo[ExtendedInfoRequestedBytes], 10, 64)
+    if err != nil || requested <= 0 {
+        return 0, false
+    }
+    return requested, true
+}
+
+// getShareSizeDrift returns the size limit of a share minus its recorded capacity. Shares without
+// a recorded capacity or a size limit have no drift.
+func getShareSizeDrift(share *common.ShareResponse) int64 {
+    requested, recorded := getShareRequestedBytes(share)
+    if !recorded || share.Size <= 0 {
+        return 0
+    }
+    return share.Size - requested
+}
+
+// recordShareExpansion records the new capacity of an expanded share-backed volume. Failing to
+// record is only logged, the expansion itself succeeded.
+func (d *CSIDriver) recordShareExpansion(share *common.ShareResponse, requestedSize int64) {
+    previous, recorded := getShareRequestedBytes(share)
+    if recorded && previous == requestedSize {
+        return
+    }
+    if !recorded {
+        previous = share.Size
+    }
+    extendedInfo := map[string]string{
+        ExtendedInfoRequestedBytes: strconv.FormatInt(requestedSize, 10),
+        ExtendedInfoExpansionHistory: appendExpansionHistory(
+            share.ExtendedInfo[ExtendedInfoExpansionHistory], time.Now(), previous, requestedSize),
+    }
+    if err := d.hsclient.UpdateShareExtendedInfo(share.Name, extendedInfo); err != nil {
+        log.Warnf("could not record expansion of share %s, %v", share.Name, err)
+    }
+}
+
+// scrubShareSizes reports the share-backed volumes whose share does not have the capacity
+// recorded for the volume
+func (d *CSIDriver) scrubShareSizes() {
+    shares, err := d.hsclient.ListShares()
+    if err != nil {
+        log.Warnf("could not list shares to check their size, %v", err)
+        common.IncMetric(MetricScrubErrors, nil)
+        return
+    }
+    for i := range shares {
+        share := &shares[i]
+        if share.ExtendedInfo[ExtendedInfoCreatedBy] != common.CsiPluginName {
+            continue
+        }
+        labels := map[string]string{"volume_id": NewShareVolumeID(share.Name).Path}
+        drift := getShareSizeDrift(share)
+        if drift == 0 {
+            common.DeleteMetric(MetricShareSizeDrift, labels)
+            continue
+        }
+        requested, _ := getShareRequestedBytes(share)
+        log.WithFields(log.Fields{
+            "event":          "ShareSizeDrift",
+            "share":          share.Name,
+            "requestedBytes": requested,
+            "sizeLimitBytes": share.Size,
+        }).Warn("share size limit differs from the capacity requested for the volume")
+        common.SetMetric(MetricShareSizeDrift, labels, float64(drift))
+        common.IncMetric(MetricShareSizeDriftTotal, nil)
+    }
+}
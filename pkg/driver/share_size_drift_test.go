@@ -0,0 +1,54 @@
+package driver
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestAppendExpansionHistory(t *testing.T) {
+    at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+    history := appendExpansionHistory("", at, 1000, 2000)
+    expected := "2020-01-02T03:04:05Z:1000->2000"
+    if history != expected {
+        t.Logf("Expected %s, received %s", expected, history)
+        t.FailNow()
+    }
+    for i := 0; i < maxExpansionHistory; i++ {
+        history = appendExpansionHistory(history, at, int64(2000+i), int64(2001+i))
+    }
+    entries := strings.Split(history, ";")
+    if len(entries) != maxExpansionHistory || entries[0] != "2020-01-02T03:04:05Z:2000->2001" {
+        t.Logf("Expected the last %d expansions, received %s", maxExpansionHistory, history)
+        t.FailNow()
+    }
+}
+
+func TestGetShareSizeDrift(t *testing.T) {
+    tests := []struct {
+        size          int64
+        requested     string
+        expectedDrift int64
+    }{
+        {size: 2000, requested: "2000", expectedDrift: 0},
+        {size: 1000, requested: "2000", expectedDrift: -1000},
+        {size: 3000, requested: "2000", expectedDrift: 1000},
+        // Nothing recorded, or no size limit
+        {size: 1000, requested: "", expectedDrift: 0},
+        {size: 1000, requested: "invalid", expectedDrift: 0},
+        {size: 0, requested: "2000", expectedDrift: 0},
+    }
+    for _, test := range tests {
+        share := &common.ShareResponse{Name: "pvc-1", Size: test.size, ExtendedInfo: map[string]string{}}
+        if test.requested != "" {
+            share.ExtendedInfo[ExtendedInfoRequestedBytes] = test.requested
+        }
+        if drift := getShareSizeDrift(share); drift != test.expectedDrift {
+            t.Logf("Expected drift %d for size %d and requested %s, received %d",
+                test.expectedDrift, test.size, test.requested, drift)
+            t.FailNow()
+        }
+    }
+}
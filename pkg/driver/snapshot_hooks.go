@@ -0,0 +1,104 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "net/url"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+const (
+    SnapshotHookPre  = "pre"
+    SnapshotHookPost = "post"
+)
+
+// snapshotHooks are commands and a webhook run by the controller around CreateSnapshot,
+// allowing applications to be quiesced while the snapshot is taken
+type snapshotHooks struct {
+    preCommand  string
+    postCommand string
+    webhook     string
+}
+
+// snapshotHookEvent is the body POSTed to the snapshot webhook
+type snapshotHookEvent struct {
+    Phase          string `json:"phase"`
+    SnapshotName   string `json:"snapshotName"`
+    SourceVolumeID string `json:"sourceVolumeId"`
+    SnapshotID     string `json:"snapshotId,omitempty"`
+    Error          string `json:"error,omitempty"`
+}
+
+func parseSnapshotHooks(params map[string]string) (snapshotHooks, error) {
+    hooks := snapshotHooks{
+        preCommand:  params["preSnapshotHook"],
+        postCommand: params["postSnapshotHook"],
+        webhook:     params["snapshotWebhook"],
+    }
+    if hooks.webhook != "" {
+        u, err := url.Parse(hooks.webhook)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return hooks, status.Errorf(codes.InvalidArgument, common.InvalidSnapshotWebhook, hooks.webhook)
+        }
+    }
+    // Anyone able to create a VolumeSnapshotClass would otherwise run commands in the controller
+    for _, hook := range []string{hooks.preCommand, hooks.postCommand, hooks.webhook} {
+        if hook != "" && !isSnapshotHookAllowed(hook) {
+            return hooks, status.Errorf(codes.InvalidArgument, common.SnapshotHookNotAllowed, hook)
+        }
+    }
+    return hooks, nil
+}
+
+var postSnapshotWebhook = func(webhook string, event snapshotHookEvent) error {
+    return postHookWebhook(webhook, event)
+}
+
+// run invokes the hooks for phase. Commands are run with /bin/sh and receive the
+// snapshot details as CSI_SNAPSHOT_* environment variables, and only PATH of the plugin's.
+func (h snapshotHooks) run(event snapshotHookEvent) error {
+    command := h.preCommand
+    if event.Phase == SnapshotHookPost {
+        command = h.postCommand
+    }
+    if command != "" {
+        log.Infof("running %s-snapshot hook for %s", event.Phase, event.SnapshotName)
+        output, err := runHookCommand(command,
+            "CSI_SNAPSHOT_PHASE="+event.Phase,
+            "CSI_SNAPSHOT_NAME="+event.SnapshotName,
+            "CSI_SNAPSHOT_SOURCE_VOLUME_ID="+event.SourceVolumeID,
+            "CSI_SNAPSHOT_ID="+event.SnapshotID,
+            "CSI_SNAPSHOT_ERROR="+event.Error)
+        if err != nil {
+            return fmt.Errorf("%s-snapshot hook failed, %s, %v", event.Phase, output, err)
+        }
+        log.Debugf("%s-snapshot hook output: %s", event.Phase, output)
+    }
+    if h.webhook != "" {
+        log.Infof("calling %s-snapshot webhook for %s", event.Phase, event.SnapshotName)
+        if err := postSnapshotWebhook(h.webhook, event); err != nil {
+            return fmt.Errorf("%s-snapshot webhook failed, %v", event.Phase, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,92 @@
+package driver
+
+import (
+    "fmt"
+    "os"
+    "reflect"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestSnapshotHooks(t *testing.T) {
+    _, err := parseSnapshotHooks(map[string]string{"snapshotWebhook": "ftp://example.com/hook"})
+    if err == nil {
+        t.Logf("Expected error for a non-http webhook")
+        t.FailNow()
+    }
+
+    params := map[string]string{
+        "preSnapshotHook": "pg_ctl freeze",
+        "snapshotWebhook": "https://example.com/hooks/snapshot",
+    }
+    // Hooks must be allowed by the administrator
+    defer func() { common.SnapshotHookAllowlist = nil }()
+    if _, err := parseSnapshotHooks(params); status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected hooks which are not allowed to be refused, received %v", err)
+        t.FailNow()
+    }
+    common.SnapshotHookAllowlist = []string{"pg_ctl freeze", "https://example.com"}
+    if _, err := parseSnapshotHooks(params); status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected only webhooks under a URL ending with a slash to be allowed, received %v", err)
+        t.FailNow()
+    }
+    common.SnapshotHookAllowlist = []string{"pg_ctl freeze", "https://example.com/hooks/"}
+    hooks, err := parseSnapshotHooks(params)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+
+    defer func(execCommand func(string, ...string) ([]byte, error)) {
+        common.ExecCommand = execCommand
+    }(common.ExecCommand)
+    defer func(post func(string, snapshotHookEvent) error) { postSnapshotWebhook = post }(postSnapshotWebhook)
+    commands := [][]string{}
+    common.ExecCommand = func(command string, args ...string) ([]byte, error) {
+        commands = append(commands, append([]string{command}, args...))
+        return []byte(""), nil
+    }
+    events := []snapshotHookEvent{}
+    postSnapshotWebhook = func(webhook string, event snapshotHookEvent) error {
+        events = append(events, event)
+        return nil
+    }
+
+    event := snapshotHookEvent{Phase: SnapshotHookPre, SnapshotName: "snap1", SourceVolumeID: "/vol1"}
+    if err := hooks.run(event); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    // Commands do not get the credentials in the environment of the plugin
+    expectedCommands := [][]string{{"env", "-i", "PATH=" + os.Getenv("PATH"),
+        "CSI_SNAPSHOT_PHASE=pre",
+        "CSI_SNAPSHOT_NAME=snap1",
+        "CSI_SNAPSHOT_SOURCE_VOLUME_ID=/vol1",
+        "CSI_SNAPSHOT_ID=",
+        "CSI_SNAPSHOT_ERROR=",
+        "/bin/sh", "-c", "pg_ctl freeze"}}
+    if !reflect.DeepEqual(commands, expectedCommands) || !reflect.DeepEqual(events, []snapshotHookEvent{event}) {
+        t.Logf("Expected: %v", expectedCommands)
+        t.Logf("Actual: %v, %v", commands, events)
+        t.FailNow()
+    }
+
+    // No post command is configured, only the webhook is called
+    event.Phase = SnapshotHookPost
+    if err := hooks.run(event); err != nil || len(commands) != 1 || len(events) != 2 {
+        t.Logf("Unexpected post hook calls %v %v, %v", commands, events, err)
+        t.FailNow()
+    }
+
+    postSnapshotWebhook = func(webhook string, event snapshotHookEvent) error {
+        return fmt.Errorf("webhook returned status code 500")
+    }
+    if err := hooks.run(event); err == nil {
+        t.Logf("Expected error from failed webhook")
+        t.FailNow()
+    }
+}
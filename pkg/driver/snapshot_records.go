@@ -0,0 +1,141 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "io/ioutil"
+    "net/url"
+    "os"
+    "path"
+    "strings"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    log "github.com/sirupsen/logrus"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// CreateSnapshot records the ID of each snapshot it takes under the name the CO gave it, with the
+// source volume, so that a repeated request returns the snapshot already taken, even after the
+// controller restarted. Snapshots of share-backed volumes are recorded in the extended info of the
+// share, csi_snapshot_<name>, and those of file-backed volumes in a <volume>.<name> file in the
+// .csi-snapshots directory of the backing share. DeleteSnapshot removes the record. A record of a
+// snapshot deleted otherwise is ignored.
+const (
+    ExtendedInfoSnapshotPrefix = "csi_snapshot_"
+    snapshotRecordsDirName     = ".csi-snapshots"
+)
+
+func getSnapshotRecordsDir(volumeID VolumeID) string {
+    return common.StagingPath(volumeID.BackingSharePath(), snapshotRecordsDirName)
+}
+
+func getSnapshotRecordFile(volumeID VolumeID, snapshotName string) string {
+    return path.Join(getSnapshotRecordsDir(volumeID), volumeID.Name+"."+url.PathEscape(snapshotName))
+}
+
+func readSnapshotRecord(filePath string) string {
+    data, err := ioutil.ReadFile(filePath)
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(string(data))
+}
+
+// getRecordedSnapshot returns the snapshot of a volume recorded under snapshotName, nil if there
+// is none or it no longer exists. share is the share of a share-backed volume.
+func (d *CSIDriver) getRecordedSnapshot(volumeID VolumeID, share *common.ShareResponse, snapshotName string) (*csi.Snapshot, error) {
+    snapshotID := ""
+    if share != nil {
+        snapshotID = share.ExtendedInfo[ExtendedInfoSnapshotPrefix+snapshotName]
+    } else if volumeID.IsFileBacked() {
+        if err := d.EnsureBackingShareMounted(volumeID.BackingShare); err != nil {
+            return nil, err
+        }
+        defer d.scheduleBackingShareUnmount(volumeID.BackingShare)
+        snapshotID = readSnapshotRecord(getSnapshotRecordFile(volumeID, snapshotName))
+    }
+    if snapshotID == "" {
+        return nil, nil
+    }
+    entry, err := d.getSnapshotEntry(snapshotID)
+    if err != nil || entry == nil {
+        return nil, err
+    }
+    log.Infof("snapshot %s of volume %s was already taken, %s", snapshotName, volumeID, snapshotID)
+    return entry.Snapshot, nil
+}
+
+// recordSnapshot records the snapshot of a volume with the ID snapshotID under snapshotName
+func (d *CSIDriver) recordSnapshot(volumeID VolumeID, share *common.ShareResponse, snapshotName, snapshotID string) error {
+    if share != nil {
+        return d.hsclient.UpdateShareExtendedInfo(share.Name,
+            map[string]string{ExtendedInfoSnapshotPrefix + snapshotName: snapshotID})
+    }
+    if !volumeID.IsFileBacked() {
+        return nil
+    }
+    if err := d.EnsureBackingShareMounted(volumeID.BackingShare); err != nil {
+        return err
+    }
+    defer d.scheduleBackingShareUnmount(volumeID.BackingShare)
+    if err := os.MkdirAll(getSnapshotRecordsDir(volumeID), 0755); err != nil {
+        return err
+    }
+    return ioutil.WriteFile(getSnapshotRecordFile(volumeID, snapshotName), []byte(snapshotID), 0644)
+}
+
+// forgetSnapshot removes the records of the snapshot of a volume with the ID snapshotID
+func (d *CSIDriver) forgetSnapshot(volumeID VolumeID, snapshotID string) error {
+    if !volumeID.IsFileBacked() {
+        share, err := d.hsclient.GetShare(volumeID.Name)
+        if err != nil || share == nil {
+            return err
+        }
+        keys := []string{}
+        for k, v := range share.ExtendedInfo {
+            if strings.HasPrefix(k, ExtendedInfoSnapshotPrefix) && v == snapshotID {
+                keys = append(keys, k)
+            }
+        }
+        if len(keys) == 0 {
+            return nil
+        }
+        return d.hsclient.DeleteShareExtendedInfo(share.Name, keys)
+    }
+
+    if err := d.EnsureBackingShareMounted(volumeID.BackingShare); err != nil {
+        return err
+    }
+    defer d.scheduleBackingShareUnmount(volumeID.BackingShare)
+    recordsDir := getSnapshotRecordsDir(volumeID)
+    files, err := ioutil.ReadDir(recordsDir)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+    for _, f := range files {
+        filePath := path.Join(recordsDir, f.Name())
+        if strings.HasPrefix(f.Name(), volumeID.Name+".") && readSnapshotRecord(filePath) == snapshotID {
+            if err := os.Remove(filePath); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
@@ -0,0 +1,75 @@
+package driver
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "reflect"
+    "testing"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestGetSnapshotRecordFile(t *testing.T) {
+    volumeID, _ := ParseVolumeID("/backing-1/pvc-a")
+    expected := common.StagingPath("/backing-1", snapshotRecordsDirName, "pvc-a.snap%2F1")
+    if actual := getSnapshotRecordFile(volumeID, "snap/1"); actual != expected {
+        t.Logf("Expected %s, received %s", expected, actual)
+        t.FailNow()
+    }
+}
+
+func TestShareSnapshotRecords(t *testing.T) {
+    mux := http.NewServeMux()
+    d := newFakeDriver(t, mux)
+
+    var updated map[string]interface{}
+    mux.HandleFunc(client.BasePath+"/shares/pvc-a", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == "PUT" {
+            json.NewDecoder(r.Body).Decode(&updated)
+            w.WriteHeader(202)
+            return
+        }
+        fmt.Fprintf(w, `{"name": "pvc-a", "path": "/pvc-a", "extendedInfo": {
+            "csi_snapshot_snap-1": "2019.10.01.12.00.00.snap|/pvc-a",
+            "csi_snapshot_snap-2": "2019.10.02.12.00.00.snap|/pvc-a"}}`)
+    })
+    mux.HandleFunc(client.BasePath+"/share-snapshots/snapshot-list/pvc-a", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `["2019.10.01.12.00.00.snap", "current"]`)
+    })
+
+    volumeID, _ := ParseVolumeID("/pvc-a")
+    share, err := d.hsclient.GetShare("pvc-a")
+    if err != nil || share == nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+
+    snapshot, err := d.getRecordedSnapshot(volumeID, share, "snap-1")
+    if err != nil || snapshot == nil || snapshot.SnapshotId != "2019.10.01.12.00.00.snap|/pvc-a" {
+        t.Logf("Expected the recorded snapshot, received %v, %v", snapshot, err)
+        t.FailNow()
+    }
+    if snapshot.SourceVolumeId != "/pvc-a" || snapshot.CreationTime == nil {
+        t.Logf("Unexpected snapshot %v", snapshot)
+        t.FailNow()
+    }
+    // The snapshot recorded under snap-2 was deleted since
+    if snapshot, err := d.getRecordedSnapshot(volumeID, share, "snap-2"); err != nil || snapshot != nil {
+        t.Logf("Expected no snapshot, received %v, %v", snapshot, err)
+        t.FailNow()
+    }
+    if snapshot, err := d.getRecordedSnapshot(volumeID, share, "snap-3"); err != nil || snapshot != nil {
+        t.Logf("Expected no snapshot, received %v, %v", snapshot, err)
+        t.FailNow()
+    }
+
+    // Only the record of the deleted snapshot is removed, the update task is not waited for
+    d.forgetSnapshot(volumeID, "2019.10.02.12.00.00.snap|/pvc-a")
+    expected := map[string]interface{}{"csi_snapshot_snap-1": "2019.10.01.12.00.00.snap|/pvc-a"}
+    if !reflect.DeepEqual(updated["extendedInfo"], expected) {
+        t.Logf("Expected %v, received %v", expected, updated["extendedInfo"])
+        t.FailNow()
+    }
+}
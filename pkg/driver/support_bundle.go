@@ -0,0 +1,263 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "archive/tar"
+    "compress/gzip"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "net/url"
+    "os"
+    "sort"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// With HS_SUPPORT_BUNDLE set, GET /debug/support-bundle on CSI_METRICS_ADDRESS returns a gzipped
+// tarball of what support cases need from a plugin pod: the recent logs and gRPC calls, the host's
+// mounts and loop devices, the node state, the contents of the driver's caches, its health and its
+// configuration. It is only served to clients on the loopback interface, such as kubectl
+// port-forward. Usernames, passwords, secrets, tokens and the credentials in URLs are redacted
+// from the configuration, and the gRPC calls are kept without their requests, which may carry CSI
+// secrets.
+const (
+    SupportBundlePath = "/debug/support-bundle"
+    recentCallCount   = 1000
+    redactedValue     = "<redacted>"
+)
+
+var (
+    recentCalls = common.NewLineBuffer(recentCallCount)
+
+    // Host file listing the mounts, read for the bundle
+    supportBundleMountInfo = "/proc/self/mountinfo"
+)
+
+// Environment variables included in the configuration of the bundle
+var supportBundleEnvPrefixes = []string{"HS_", "CSI_"}
+
+type recordedCall struct {
+    Time       time.Time         `json:"time"`
+    Method     string            `json:"method"`
+    Code       string            `json:"code"`
+    Error      string            `json:"error,omitempty"`
+    Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// recordCall keeps a gRPC call for support bundles
+func recordCall(method string, attributes map[string]string, err error) {
+    call := recordedCall{
+        Time:       time.Now().UTC(),
+        Method:     method,
+        Code:       status.Code(err).String(),
+        Attributes: attributes,
+    }
+    if err != nil {
+        call.Error = err.Error()
+    }
+    line, _ := json.Marshal(call)
+    recentCalls.Add(string(line))
+}
+
+func isSecretSetting(name string) bool {
+    name = strings.ToUpper(name)
+    for _, secret := range []string{"USERNAME", "PASSWORD", "SECRET", "TOKEN"} {
+        if strings.Contains(name, secret) {
+            return true
+        }
+    }
+    return false
+}
+
+// redactURLCredentials redacts the user and password of a URL, such as an endpoint
+func redactURLCredentials(value string) string {
+    u, err := url.Parse(value)
+    if err != nil || u.User == nil {
+        return value
+    }
+    return strings.Replace(value, u.User.String()+"@", redactedValue+"@", 1)
+}
+
+// getSanitizedConfig returns the plugin's settings from environ, with the secret ones redacted
+func getSanitizedConfig(environ []string) map[string]string {
+    config := map[string]string{
+        "version":    common.Version,
+        "githash":    common.Githash,
+        "csiVersion": common.CsiVersion,
+        "mode":       common.ServiceMode,
+    }
+    for _, e := range environ {
+        tokens := strings.SplitN(e, "=", 2)
+        if len(tokens) != 2 {
+            continue
+        }
+        for _, prefix := range supportBundleEnvPrefixes {
+            if !strings.HasPrefix(tokens[0], prefix) {
+                continue
+            }
+            config[tokens[0]] = redactURLCredentials(tokens[1])
+            if isSecretSetting(tokens[0]) && tokens[1] != "" {
+                config[tokens[0]] = redactedValue
+            }
+        }
+    }
+    return config
+}
+
+// getCacheContents returns what the driver has cached from the Hammerspace API and the host
+func (d *CSIDriver) getCacheContents() map[string]interface{} {
+    d.dataPortals.lock.Lock()
+    portals := map[string]interface{}{"portals": d.dataPortals.portals, "fetched": d.dataPortals.fetched}
+    d.dataPortals.lock.Unlock()
+
+    d.portalVersionsLock.Lock()
+    versions := map[string]string{}
+    for k, v := range d.portalNFSVersions {
+        versions[k] = v
+    }
+    d.portalVersionsLock.Unlock()
+
+    d.mountInfo.lock.Lock()
+    mountInfo := []*volumeMountInfo{}
+    for _, info := range d.mountInfo.byExportPath {
+        mountInfo = append(mountInfo, info)
+    }
+    d.mountInfo.lock.Unlock()
+    sort.Slice(mountInfo, func(i, j int) bool { return mountInfo[i].ExportPath < mountInfo[j].ExportPath })
+
+    d.usageLevelsLock.Lock()
+    usageLevels := map[string]int{}
+    for k, v := range d.usageLevels {
+        usageLevels[k] = v
+    }
+    d.usageLevelsLock.Unlock()
+
+    d.volumeList.lock.Lock()
+    volumeList := map[string]interface{}{"volumes": len(d.volumeList.entries), "listedAt": d.volumeList.listedAt}
+    d.volumeList.lock.Unlock()
+
+    return map[string]interface{}{
+        "dataPortals":       portals,
+        "portalNFSVersions": versions,
+        "mountInfo":         mountInfo,
+        "usageLevels":       usageLevels,
+        "volumeList":        volumeList,
+    }
+}
+
+// supportBundleFile is a file of the bundle, whose contents are collected when it is written
+type supportBundleFile struct {
+    name    string
+    collect func() ([]byte, error)
+}
+
+func jsonFile(name string, collect func() (interface{}, error)) supportBundleFile {
+    return supportBundleFile{name, func() ([]byte, error) {
+        value, err := collect()
+        if err != nil {
+            return nil, err
+        }
+        return json.MarshalIndent(value, "", "  ")
+    }}
+}
+
+func linesFile(name string, lines func() []string) supportBundleFile {
+    return supportBundleFile{name, func() ([]byte, error) {
+        return []byte(strings.Join(lines(), "\n") + "\n"), nil
+    }}
+}
+
+func (d *CSIDriver) getSupportBundleFiles() []supportBundleFile {
+    return []supportBundleFile{
+        jsonFile("config.json", func() (interface{}, error) { return getSanitizedConfig(os.Environ()), nil }),
+        linesFile("logs.jsonl", common.RecentLogs.Lines),
+        linesFile("grpc-calls.jsonl", recentCalls.Lines),
+        {"mountinfo.txt", func() ([]byte, error) { return ioutil.ReadFile(supportBundleMountInfo) }},
+        jsonFile("loop-devices.json", func() (interface{}, error) { return common.GetLoopBackingFiles() }),
+        jsonFile("node-state.json", func() (interface{}, error) { return d.nodeState.list(), nil }),
+        jsonFile("caches.json", func() (interface{}, error) { return d.getCacheContents(), nil }),
+        jsonFile("health.json", func() (interface{}, error) { return d.checkHealth(), nil }),
+    }
+}
+
+// WriteSupportBundle writes the support bundle to w as a gzipped tarball. A file which cannot be
+// collected is replaced by <name>.error, holding the error.
+func (d *CSIDriver) WriteSupportBundle(w io.Writer) error {
+    gz := gzip.NewWriter(w)
+    tw := tar.NewWriter(gz)
+    now := time.Now()
+    for _, f := range d.getSupportBundleFiles() {
+        name := f.name
+        contents, err := f.collect()
+        if err != nil {
+            name, contents = name+".error", []byte(err.Error()+"\n")
+        }
+        header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), ModTime: now}
+        if err := tw.WriteHeader(header); err != nil {
+            return err
+        }
+        if _, err := tw.Write(contents); err != nil {
+            return err
+        }
+    }
+    if err := tw.Close(); err != nil {
+        return err
+    }
+    return gz.Close()
+}
+
+// isLoopbackRequest returns whether r comes from the loopback interface
+func isLoopbackRequest(r *http.Request) bool {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return false
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+// ServeSupportBundle serves the support bundle as an attachment named after the node and time to
+// local clients
+func (d *CSIDriver) ServeSupportBundle(w http.ResponseWriter, r *http.Request) {
+    if !isLoopbackRequest(r) {
+        log.Warnf("refused support bundle to %s, it is only served to local clients", r.RemoteAddr)
+        http.Error(w, "the support bundle is only served to local clients", http.StatusForbidden)
+        return
+    }
+    source := d.NodeID
+    if source == "" {
+        source = "controller"
+    }
+    name := fmt.Sprintf("hs-csi-support-%s-%s.tar.gz", source, time.Now().UTC().Format("20060102T150405Z"))
+    w.Header().Set("Content-Type", "application/gzip")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+    if err := d.WriteSupportBundle(w); err != nil {
+        log.Errorf("could not write support bundle, %v", err)
+        return
+    }
+    log.Infof("served support bundle %s", name)
+}
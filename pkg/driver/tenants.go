@@ -0,0 +1,175 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "os"
+    "strconv"
+    "sync"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// StorageClasses may give other Hammerspace credentials, and another Anvil endpoint, in the
+// provisioner, controller-expand and node secrets. Requests carrying them are served by a driver
+// logged in with those credentials, created on first use and cached for the set of credentials.
+// Requests without these secrets use HS_ENDPOINT, HS_USERNAME and HS_PASSWORD. The endpoint
+// parameter of a StorageClass provisions its volumes on the cluster of that Anvil, and is recorded
+// in the volume context so nodes mount them from the same cluster.
+const (
+    SecretEndpoint  = "endpoint"
+    SecretUsername  = "username"
+    SecretPassword  = "password"
+    SecretTLSVerify = "tlsVerify"
+
+    VolumeContextEndpoint = "endpoint"
+)
+
+// tenantDrivers are the drivers for the credentials in CSI secrets, by the client of the pool
+// logged in with them
+type tenantDrivers struct {
+    lock    sync.Mutex
+    clients *client.ClientPool
+    drivers map[*client.HammerspaceClient]*CSIDriver
+}
+
+func newTenantDrivers() *tenantDrivers {
+    return &tenantDrivers{
+        clients: client.NewClientPool(),
+        drivers: make(map[*client.HammerspaceClient]*CSIDriver),
+    }
+}
+
+// parseTenantSecrets returns the credentials given in CSI secrets, and false if they give none.
+// The endpoint and TLS verification default to those of the driver.
+func parseTenantSecrets(secrets map[string]string, defaultEndpoint string) (string, string, string, bool, bool, error) {
+    username, password := secrets[SecretUsername], secrets[SecretPassword]
+    if username == "" && password == "" {
+        return "", "", "", false, false, nil
+    }
+    if username == "" || password == "" {
+        return "", "", "", false, false, status.Errorf(codes.InvalidArgument, common.InvalidSecrets, SecretUsername, SecretPassword)
+    }
+    endpoint := secrets[SecretEndpoint]
+    if endpoint == "" {
+        endpoint = defaultEndpoint
+    }
+    tlsVerifyStr := secrets[SecretTLSVerify]
+    if tlsVerifyStr == "" {
+        tlsVerifyStr = os.Getenv("HS_TLS_VERIFY")
+    }
+    tlsVerify := false
+    if tlsVerifyStr != "" {
+        var err error
+        tlsVerify, err = strconv.ParseBool(tlsVerifyStr)
+        if err != nil {
+            return "", "", "", false, false, status.Errorf(codes.InvalidArgument, common.InvalidSecretTLSVerify, tlsVerifyStr)
+        }
+    }
+    return endpoint, username, password, tlsVerify, true, nil
+}
+
+// forSecrets returns the driver to serve a request with the given CSI secrets, d itself when they
+// hold no Hammerspace credentials or d already serves a set of them
+func (d *CSIDriver) forSecrets(secrets map[string]string) (*CSIDriver, error) {
+    if d.tenants == nil {
+        return d, nil
+    }
+    endpoint, username, password, tlsVerify, given, err := parseTenantSecrets(secrets, d.hsclient.Endpoint())
+    if err != nil || !given {
+        return d, err
+    }
+    hsclient, err := d.tenants.clients.Get(endpoint, username, password, tlsVerify)
+    if err != nil {
+        return nil, status.Errorf(codes.Unauthenticated, common.TenantLoginFailed, endpoint, username, err)
+    }
+
+    d.tenants.lock.Lock()
+    defer d.tenants.lock.Unlock()
+    if tenant, exists := d.tenants.drivers[hsclient]; exists {
+        return tenant, nil
+    }
+    // The pool drops the clients it no longer has room for, their drivers go with them
+    for pooled := range d.tenants.drivers {
+        if !d.tenants.clients.Holds(pooled) {
+            delete(d.tenants.drivers, pooled)
+        }
+    }
+    tenant := d.newTenantDriver(hsclient)
+    d.tenants.drivers[hsclient] = tenant
+    log.Infof("serving requests with the credentials of %s at %s from CSI secrets", username, endpoint)
+    return tenant, nil
+}
+
+// forEndpoint returns the driver to serve a request for a volume on the Anvil at endpoint, as
+// given by its StorageClass, with the given CSI secrets. The secrets must hold credentials for
+// any endpoint but HS_ENDPOINT, and may only name the same endpoint.
+func (d *CSIDriver) forEndpoint(endpoint string, secrets map[string]string) (*CSIDriver, error) {
+    if endpoint == "" || d.tenants == nil {
+        return d.forSecrets(secrets)
+    }
+    if secretEndpoint := secrets[SecretEndpoint]; secretEndpoint != "" && secretEndpoint != endpoint {
+        return nil, status.Errorf(codes.InvalidArgument, common.EndpointMismatch, endpoint, secretEndpoint)
+    }
+    if secrets[SecretUsername] == "" && secrets[SecretPassword] == "" {
+        if endpoint != d.hsclient.Endpoint() {
+            return nil, status.Errorf(codes.InvalidArgument, common.EndpointWithoutSecrets, endpoint)
+        }
+        return d, nil
+    }
+    withEndpoint := make(map[string]string, len(secrets)+1)
+    for k, v := range secrets {
+        withEndpoint[k] = v
+    }
+    withEndpoint[SecretEndpoint] = endpoint
+    return d.forSecrets(withEndpoint)
+}
+
+// checkProvisionerSecrets fails when the provisioner secrets of a StorageClass provisioning on
+// another Anvil do not name it. DeleteVolume and the snapshot calls only receive the secrets,
+// without them they would look for the volume on the cluster at HS_ENDPOINT.
+func (d *CSIDriver) checkProvisionerSecrets(endpoint string, secrets map[string]string) error {
+    if endpoint == "" || d.tenants == nil || endpoint == d.hsclient.Endpoint() {
+        return nil
+    }
+    if secrets[SecretEndpoint] == "" {
+        return status.Errorf(codes.InvalidArgument, common.EndpointNotInSecrets, endpoint)
+    }
+    return nil
+}
+
+// newTenantDriver returns a driver using hsclient which shares the locks, the node's state and
+// operation limits, and the operations in progress with d. Only its caches of the cluster's
+// objectives, data-portals, capacity and features are its own.
+func (d *CSIDriver) newTenantDriver(hsclient *client.HammerspaceClient) *CSIDriver {
+    tenant := newCSIDriver(hsclient)
+    tenant.sharedState = d.sharedState
+    tenant.NodeID = d.NodeID
+    tenant.stopCh = d.stopCh
+    tenant.nodeState = d.nodeState
+    tenant.nodeOperations = d.nodeOperations
+    tenant.deleteVolumes = d.deleteVolumes
+    if tenant.NodeID == "" {
+        tenant.detectFeatures()
+    }
+    return tenant
+}
@@ -0,0 +1,208 @@
+package driver
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "path"
+    "testing"
+    "time"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestParseTenantSecrets(t *testing.T) {
+    _, _, _, _, given, err := parseTenantSecrets(map[string]string{"other": "secret"}, "https://anvil")
+    if err != nil || given {
+        t.Logf("Expected no credentials, received %v, %v", given, err)
+        t.FailNow()
+    }
+    endpoint, username, password, tlsVerify, given, err := parseTenantSecrets(
+        map[string]string{SecretUsername: "tenant", SecretPassword: "pass", SecretTLSVerify: "true"}, "https://anvil")
+    if err != nil || !given || endpoint != "https://anvil" || username != "tenant" || password != "pass" || !tlsVerify {
+        t.Logf("Unexpected credentials %s, %s, %s, %v, %v, %v", endpoint, username, password, tlsVerify, given, err)
+        t.FailNow()
+    }
+    for _, secrets := range []map[string]string{
+        {SecretUsername: "tenant"},
+        {SecretUsername: "tenant", SecretPassword: "pass", SecretTLSVerify: "sometimes"},
+    } {
+        _, _, _, _, _, err := parseTenantSecrets(secrets, "https://anvil")
+        if status.Code(err) != codes.InvalidArgument {
+            t.Logf("Expected InvalidArgument for %v, received %v", secrets, err)
+            t.FailNow()
+        }
+    }
+}
+
+func TestForSecrets(t *testing.T) {
+    logins := 0
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != client.BasePath+"/login" {
+            w.WriteHeader(404)
+            return
+        }
+        logins++
+        if r.FormValue("username") == "denied" {
+            w.WriteHeader(401)
+        }
+    }))
+    defer server.Close()
+
+    hsclient, err := client.NewHammerspaceClient(server.URL, "admin", "password", false)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    d := newCSIDriver(hsclient)
+    d.NodeID = "node-1"
+    d.tenants = newTenantDrivers()
+
+    if tenant, err := d.forSecrets(nil); err != nil || tenant != d {
+        t.Logf("Expected requests without secrets to use the driver, received %v", err)
+        t.FailNow()
+    }
+    secrets := map[string]string{SecretUsername: "tenant", SecretPassword: "pass"}
+    tenant, err := d.forSecrets(secrets)
+    if err != nil || tenant == d || tenant.hsclient.Endpoint() != server.URL {
+        t.Logf("Expected a driver for the tenant, received %v", err)
+        t.FailNow()
+    }
+    if again, _ := d.forSecrets(secrets); again != tenant || logins != 2 {
+        t.Logf("Expected the driver of the tenant to be cached, %d logins", logins)
+        t.FailNow()
+    }
+    if same, _ := tenant.forSecrets(secrets); same != tenant {
+        t.Logf("Expected the driver of the tenant to serve its requests")
+        t.FailNow()
+    }
+    if tenant.nodeState != d.nodeState || tenant.NodeID != d.NodeID {
+        t.Logf("Expected the driver of the tenant to share the node state")
+        t.FailNow()
+    }
+
+    _, err = d.forSecrets(map[string]string{SecretUsername: "denied", SecretPassword: "pass"})
+    if status.Code(err) != codes.Unauthenticated {
+        t.Logf("Expected Unauthenticated, received %v", err)
+        t.FailNow()
+    }
+}
+
+func TestForEndpoint(t *testing.T) {
+    login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != client.BasePath+"/login" {
+            w.WriteHeader(404)
+        }
+    })
+    server := httptest.NewServer(login)
+    defer server.Close()
+    other := httptest.NewServer(login)
+    defer other.Close()
+
+    hsclient, err := client.NewHammerspaceClient(server.URL, "admin", "password", false)
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    d := newCSIDriver(hsclient)
+    d.tenants = newTenantDrivers()
+
+    if tenant, err := d.forEndpoint(server.URL, nil); err != nil || tenant != d {
+        t.Logf("Expected volumes on HS_ENDPOINT to use the driver, received %v", err)
+        t.FailNow()
+    }
+    if _, err := d.forEndpoint(other.URL, nil); status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected InvalidArgument without credentials, received %v", err)
+        t.FailNow()
+    }
+    secrets := map[string]string{SecretUsername: "tenant", SecretPassword: "pass"}
+    tenant, err := d.forEndpoint(other.URL, secrets)
+    if err != nil || tenant == d || tenant.hsclient.Endpoint() != other.URL {
+        t.Logf("Expected a driver for the other Anvil, received %v", err)
+        t.FailNow()
+    }
+    if secrets[SecretEndpoint] != "" {
+        t.Logf("Expected the secrets to be left as they were")
+        t.FailNow()
+    }
+    if again, _ := d.forSecrets(map[string]string{
+        SecretEndpoint: other.URL, SecretUsername: "tenant", SecretPassword: "pass"}); again != tenant {
+        t.Logf("Expected the driver for the other Anvil to be cached")
+        t.FailNow()
+    }
+    if home, _ := d.forEndpoint(server.URL, secrets); home == tenant || home.hsclient.Endpoint() != server.URL {
+        t.Logf("Expected the same credentials on HS_ENDPOINT to use another driver")
+        t.FailNow()
+    }
+    _, err = d.forEndpoint(other.URL, map[string]string{
+        SecretEndpoint: server.URL, SecretUsername: "tenant", SecretPassword: "pass"})
+    if status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected InvalidArgument for mismatched endpoints, received %v", err)
+        t.FailNow()
+    }
+
+    if err := d.checkProvisionerSecrets(other.URL, secrets); status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected InvalidArgument for provisioner secrets without the endpoint, received %v", err)
+        t.FailNow()
+    }
+    if err := d.checkProvisionerSecrets(other.URL, map[string]string{SecretEndpoint: other.URL}); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if err := d.checkProvisionerSecrets(server.URL, secrets); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+}
+
+func TestTenantSharesState(t *testing.T) {
+    defer func(delay time.Duration) { common.BackingShareUnmountDelay = delay }(common.BackingShareUnmountDelay)
+    common.BackingShareUnmountDelay = time.Hour
+    d := newCSIDriver(newFakeDriver(t, http.NewServeMux()).hsclient)
+    d.NodeID = "node-1"
+    d.nodeState = newNodeStateStore("")
+    d.tenants = newTenantDrivers()
+
+    tenant, err := d.forSecrets(map[string]string{SecretUsername: "tenant", SecretPassword: "pass"})
+    if err != nil || tenant == d || tenant.sharedState != d.sharedState {
+        t.Logf("Expected the driver of the tenant to share the locks, received %v", err)
+        t.FailNow()
+    }
+
+    // A volume published through the tenant holds the locks of the driver
+    tenant.getVolumeLock("backing")
+    if _, exists := d.volumeLocks["backing"]; !exists {
+        t.Logf("Expected the lock taken by the tenant to be the driver's")
+        t.FailNow()
+    }
+    targetPath := path.Join(t.TempDir(), "target")
+    tenant.recordNodeVolume(&nodeVolumeState{
+        VolumeID: "/backing/pvc-a", State: NodeVolumePublished, Path: targetPath, BackingShareName: "backing"})
+    tenant.scheduleBackingShareUnmount("backing")
+    d.releaseVolumeLock("backing")
+    d.unmountJanitor.lock.Lock()
+    timer, scheduled := d.unmountJanitor.timers["backing"]
+    d.unmountJanitor.lock.Unlock()
+    if !scheduled {
+        t.Logf("Expected the unmount scheduled by the tenant to be known to the driver")
+        t.FailNow()
+    }
+    timer.Stop()
+
+    // Unpublish requests carry no secrets
+    _, err = d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+        VolumeId: "/backing/pvc-a", TargetPath: targetPath})
+    if err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if _, exists := tenant.nodeState.get(targetPath); exists {
+        t.Logf("Expected the volume published by the tenant to be forgotten")
+        t.FailNow()
+    }
+}
@@ -19,9 +19,6 @@ package driver
 import (
     "errors"
     "fmt"
-    "os/exec"
-    "path"
-    "path/filepath"
     "strings"
 
     log "github.com/sirupsen/logrus"
@@ -40,10 +37,6 @@ func IsValueInList(value string, list []string) bool {
     return false
 }
 
-func GetVolumeNameFromPath(path string) string {
-    return filepath.Base(path)
-}
-
 func GetSnapshotNameFromSnapshotId(snapshotId string) (string, error) {
     tokens := strings.SplitN(snapshotId, "|", 2)
     if len(tokens) != 2 {
@@ -57,7 +50,11 @@ func GetShareNameFromSnapshotId(snapshotId string) (string, error) {
     if len(tokens) != 2 {
         return "", errors.New(fmt.Sprintf(common.ImproperlyFormattedSnapshotId, snapshotId))
     }
-    return path.Base(tokens[1]), nil
+    sourceVolumeID, err := ParseVolumeID(tokens[1])
+    if err != nil {
+        return "", errors.New(fmt.Sprintf(common.ImproperlyFormattedSnapshotId, snapshotId))
+    }
+    return sourceVolumeID.Name, nil
 }
 
 // generate snapshot ID to be stored by the CO
@@ -67,16 +64,31 @@ func GetSnapshotIDFromSnapshotName(hsSnapName, sourceVolumeID string) (string) {
 }
 
 func (d *CSIDriver) EnsureBackingShareMounted(backingShareName string) error {
-    backingShare, err := d.hsclient.GetShare(backingShareName)
+    return d.ensureBackingShareMounted(backingShareName, nil)
+}
+
+func (d *CSIDriver) ensureBackingShareMounted(backingShareName string, trace *publishTrace) error {
+    endStage := trace.stage(publishStageGetShare)
+    backingShare, err := d.getBackingShare(backingShareName)
+    endStage()
     if err != nil {
         return status.Errorf(codes.NotFound, err.Error())
     }
     if backingShare != nil {
-        backingDir := common.ShareStagingDir + backingShare.ExportPath
+        backingDir := common.StagingPath(backingShare.ExportPath)
+        // Avoid stat'ing the mount to find whether it is mounted, it blocks if the mount is stale
+        _, isMounted, _ := common.GetMountSource(backingDir)
+        if isMounted {
+            remount, err := releaseStaleMount(backingShare.Name, backingDir)
+            if err != nil {
+                return err
+            }
+            isMounted = !remount
+        }
         // Mount backing share
-        if isMounted, _ := common.IsShareMounted(backingDir); !isMounted {
-            mo := []string{}
-            err := d.MountShareAtBestDataportal(backingShare.ExportPath, backingDir, mo)
+        if !isMounted {
+            mo := append([]string{}, common.BackingShareMountPolicy...)
+            err := d.mountShareAtBestDataportal(backingShare.ExportPath, backingDir, mo, trace)
             if err != nil {
                 log.Errorf("failed to mount backing share, %v", err)
                 return err
@@ -84,7 +96,7 @@ func (d *CSIDriver) EnsureBackingShareMounted(backingShareName string) error {
     
             log.Infof("mounted backing share, %s", backingDir)
         } else {
-            log.Infof("backing share already mounted, %s", backingDir)
+            common.SampledInfof("backing share already mounted, %s", backingDir)
         }
         return nil
     }
@@ -92,26 +104,32 @@ func (d *CSIDriver) EnsureBackingShareMounted(backingShareName string) error {
 }
 
 func (d *CSIDriver) UnmountBackingShareIfUnused(backingShareName string) (bool, error) {
-    backingShare, err := d.hsclient.GetShare(backingShareName)
-    mountPath := common.ShareStagingDir + backingShare.ExportPath
-    if isMounted, _ := common.IsShareMounted(mountPath); !isMounted {
+    if d.isBackingShareCloning(backingShareName) {
+        log.Infof("backing share, %s, still in use by a clone", backingShareName)
+        return false, nil
+    }
+    backingShare, err := d.getBackingShare(backingShareName)
+    if err != nil {
+        return false, err
+    }
+    if backingShare == nil {
+        return false, status.Error(codes.NotFound, common.BackingShareNotFound)
+    }
+    mountPath := common.StagingPath(backingShare.ExportPath)
+    // Avoid stat'ing the mount, it blocks if the backing share's data-portal is unresponsive
+    if _, isMounted, _ := common.GetMountSource(mountPath); !isMounted {
         return true, nil
     }
     // If any loopback devices are using the mount
-    output, err := exec.Command("losetup", "-a").CombinedOutput()
+    backingFiles, err := common.GetLoopBackingFiles()
     if err != nil {
         return false, status.Errorf(codes.Internal,
             "could not list backing files for loop devices, %v", err)
     }
-    devices := strings.Split(string(output), "\n")
-    for _, d := range devices {
-        if d != "" {
-            device := strings.Split(d, " ")
-            backingFile := strings.Trim(device[len(device)-1], ":()")
-            if strings.Index(backingFile, mountPath) == 0 {
-                log.Infof("backing share, %s, still in use by, %s", mountPath, devices[0])
-                return false, nil
-            }
+    for device, backingFile := range backingFiles {
+        if strings.HasPrefix(backingFile, mountPath+"/") {
+            log.Infof("backing share, %s, still in use by, %s", mountPath, device)
+            return false, nil
         }
     }
 
@@ -125,91 +143,268 @@ func (d *CSIDriver) UnmountBackingShareIfUnused(backingShareName string) (bool,
     return true, err
 }
 
+var (
+    NFSVersion42 = "4.2"
+    NFSVersion3  = "3"
+
+    // Mount options used for each NFS version, in order of preference
+    nfsVersionMountOptions = map[string][]string{
+        NFSVersion42: {"nfsvers=4.2"},
+        NFSVersion3:  {"nfsvers=3", "nolock"},
+    }
+)
+
+// getRequestedNFSVersion returns the NFS version set with vers or nfsvers in the mount flags of a
+// volume, "" if they leave it to the driver
+func getRequestedNFSVersion(mountFlags []string) string {
+    version := ""
+    for _, flag := range mountFlags {
+        for _, option := range strings.Split(flag, ",") {
+            tokens := strings.SplitN(strings.TrimSpace(option), "=", 2)
+            if len(tokens) == 2 && (tokens[0] == "vers" || tokens[0] == "nfsvers") {
+                version = tokens[1]
+            }
+        }
+    }
+    return version
+}
+
+// orderPortalsForNFSVersion returns the data-portals able to serve the requested NFS version, those
+// of its type first and otherwise in the order given. NFSv4 data-portals are left out for NFSv3.
+func orderPortalsForNFSVersion(portals []common.DataPortal, requested string) []common.DataPortal {
+    if requested == "" {
+        return portals
+    }
+    preferred := common.DataPortalTypeNFSv3
+    if strings.HasPrefix(requested, "4") {
+        preferred = common.DataPortalTypeNFSv4
+    }
+    ordered := []common.DataPortal{}
+    others := []common.DataPortal{}
+    for _, p := range portals {
+        if p.DataPortalType == preferred {
+            ordered = append(ordered, p)
+        } else if preferred == common.DataPortalTypeNFSv4 || portalSupportsNFSv3(p) {
+            others = append(others, p)
+        }
+    }
+    return append(ordered, others...)
+}
+
+// getPortalNFSVersions returns the NFS versions to try against a data-portal, in order. The version
+// negotiated on a previous mount is tried first, NFSv3 portals skip 4.2 and NFSv4 portals skip 3.
+func (d *CSIDriver) getPortalNFSVersions(address string, portal common.DataPortal) []string {
+    versions := []string{NFSVersion42, NFSVersion3}
+    if !portalSupportsNFSv4(portal) {
+        versions = []string{NFSVersion3}
+    } else if !portalSupportsNFSv3(portal) {
+        versions = []string{NFSVersion42}
+    }
+
+    d.portalVersionsLock.Lock()
+    cached, exists := d.portalNFSVersions[address]
+    d.portalVersionsLock.Unlock()
+    if !exists {
+        return versions
+    }
+    ordered := []string{cached}
+    for _, v := range versions {
+        if v != cached {
+            ordered = append(ordered, v)
+        }
+    }
+    return ordered
+}
+
+// portalMountAttempt is a data-portal to mount from with an NFS version
+type portalMountAttempt struct {
+    portal  common.DataPortal
+    address string
+    version string
+}
+
+// getPortalMountAttempts returns the data-portals to try mounting from and their NFS versions, in
+// order. Every data-portal is tried with its first version before any is tried with its second.
+func (d *CSIDriver) getPortalMountAttempts(portals []common.DataPortal,
+    getAddress func(common.DataPortal) string) []portalMountAttempt {
+
+    versions := make([][]string, len(portals))
+    addresses := make([]string, len(portals))
+    for i, p := range portals {
+        addresses[i] = getAddress(p)
+        versions[i] = d.getPortalNFSVersions(addresses[i], p)
+    }
+    attempts := []portalMountAttempt{}
+    for attempt := 0; attempt < len(nfsVersionMountOptions); attempt++ {
+        for i, p := range portals {
+            if attempt < len(versions[i]) {
+                attempts = append(attempts, portalMountAttempt{p, addresses[i], versions[i][attempt]})
+            }
+        }
+    }
+    return attempts
+}
+
+func (d *CSIDriver) setPortalNFSVersion(address, version string) {
+    d.portalVersionsLock.Lock()
+    defer d.portalVersionsLock.Unlock()
+    d.portalNFSVersions[address] = version
+}
+
+// portalSupportsNFSv4 is false for NFSv3 data-portals. Data-portals serve the NFS version of
+// their dataPortalType, those of other types may serve either.
+func portalSupportsNFSv4(portal common.DataPortal) bool {
+    return portal.DataPortalType != common.DataPortalTypeNFSv3
+}
+
+// portalSupportsNFSv3 is false for NFSv4 data-portals
+func portalSupportsNFSv3(portal common.DataPortal) bool {
+    return portal.DataPortalType != common.DataPortalTypeNFSv4
+}
+
+// getDefaultPrefixExports returns the exports of a share on the data-portal at addr under each of
+// the default mount prefixes, in order of preference
+func getDefaultPrefixExports(addr, shareExportPath string) []string {
+    exports := []string{}
+    for _, mountPrefix := range common.DefaultDataPortalMountPrefixes {
+        export := fmt.Sprintf("%s:%s%s", addr, mountPrefix, shareExportPath)
+        if !IsValueInList(export, exports) {
+            exports = append(exports, export)
+        }
+    }
+    return exports
+}
+
 func (d *CSIDriver) MountShareAtBestDataportal(shareExportPath, targetPath string, mountFlags []string) error {
+    return d.mountShareAtBestDataportal(shareExportPath, targetPath, mountFlags, nil)
+}
+
+func (d *CSIDriver) mountShareAtBestDataportal(
+    shareExportPath, targetPath string, mountFlags []string, trace *publishTrace) error {
     var err error
 
-    log.Infof("Finding best host exporting %s", shareExportPath)
+    common.SampledInfof("Finding best host exporting %s", shareExportPath)
 
-    portals, err := d.hsclient.GetDataPortals(d.NodeID)
-    if err != nil {
-        log.Errorf("Could not create list of data-portals, %v", err)
-    }
-    // Always look for floating data portal IPs
-    fipaddr, err := d.hsclient.GetPortalFloatingIp()
-    if err != nil {
-        log.Errorf("Could not contact Anvil for floating IPs, %v", err)
+    mountPrefix := common.DataPortalMountPrefix
+    var portals []common.DataPortal
+    var fipaddr string
+    endStage := trace.stage(publishStageDataPortals)
+    if common.NodeCredentialless {
+        // The floating IPs are first among the data-portals recorded by the controller
+        var contextPrefix string
+        portals, contextPrefix, err = d.getContextDataPortals(shareExportPath)
+        endStage()
+        if err != nil {
+            return err
+        }
+        if mountPrefix == "" {
+            mountPrefix = contextPrefix
+        }
+    } else {
+        portals, err = d.getDataPortals()
+        if err != nil {
+            log.Errorf("Could not create list of data-portals, %v", err)
+        }
+        // Always look for floating data portal IPs
+        fipaddr, err = d.hsclient.GetPortalFloatingIp()
+        if err != nil {
+            log.Errorf("Could not contact Anvil for floating IPs, %v", err)
+        }
+        endStage()
     }
+    portals, localAddress := d.preferLocalDataPortal(portals)
 
-    MountToDataPortal := func(portal common.DataPortal, mount_options []string) (bool){
-        addr := ""
+    getPortalAddress := func(portal common.DataPortal) string {
+        if localAddress != "" && isSameDataPortal(portal, portals[0]) {
+            return localAddress
+        }
         if len(fipaddr) > 0 {
-          addr = fipaddr
-          log.Infof("Floating IP address detected: %s", fipaddr)
-        } else {
-          addr = portal.Node.MgmtIpAddress.Address
+            common.SampledInfof("Floating IP address detected: %s", fipaddr)
+            return fipaddr
         }
-        export := ""
+        return portal.Node.MgmtIpAddress.Address
+    }
+
+    MountToDataPortal := func(portal common.DataPortal, mount_options []string) (bool){
+        addr := getPortalAddress(portal)
+        var exports []string
         // Use configured prefix if specified
-        if common.DataPortalMountPrefix != "" {
-            export = fmt.Sprintf("%s:%s%s", addr, common.DataPortalMountPrefix, shareExportPath)
+        if mountPrefix != "" {
+            exports = []string{fmt.Sprintf("%s:%s%s", addr, mountPrefix, shareExportPath)}
+        } else if common.DisableShowmount {
+            // Without the MOUNT protocol, try the default prefixes until one mounts
+            exports = getDefaultPrefixExports(addr, shareExportPath)
         } else {
             // grab exports with showmount
-            exports, err := common.GetNFSExports(addr)
+            endStage := trace.stage(publishStageShowmount)
+            available, err := common.GetNFSExports(addr)
+            endStage()
             if err != nil {
-                log.Infof("Could not get exports for data-portal at %s, %s. Error: %v", addr, portal.Uoid["uuid"], err)
+                common.SampledInfof("Could not get exports for data-portal at %s, %s. Error: %v", addr, portal.Uoid["uuid"], err)
                 return false
             }
-            log.Infof("Found exports for data-portal %s, %v", addr, exports)
+            common.SampledInfof("Found exports for data-portal %s, %v", addr, available)
 
             // Check configured prefix
             // Check the default prefixes
             for _, mountPrefix := range common.DefaultDataPortalMountPrefixes {
-                for _, e := range exports {
+                for _, e := range available {
                     if e == fmt.Sprintf("%s%s", mountPrefix, shareExportPath) {
-                        export = fmt.Sprintf("%s:%s%s", addr, mountPrefix, shareExportPath)
-                        log.Infof("Found export %s", export)
+                        exports = []string{fmt.Sprintf("%s:%s%s", addr, mountPrefix, shareExportPath)}
+                        common.SampledInfof("Found export %s", exports[0])
                         break
                     }
                 }
-                if export != "" {
+                if len(exports) > 0 {
                     break
                 }
             }
-            if export == "" {
-                log.Infof("Could not find any matching export on data-portal, %s.", portal.Uoid["uuid"])
+            if len(exports) == 0 {
+                common.SampledInfof("Could not find any matching export on data-portal, %s.", portal.Uoid["uuid"])
                 return false
             }
         }
-        mo := append(mountFlags, mount_options...)
-        err = common.MountShare(export, targetPath, mo)
-        if err != nil {
-            log.Infof("Could not mount via data-portal, %s. Error: %v", portal.Uoid["uuid"], err)
-        } else {
-            log.Infof("Mounted via data-portal, %s.", portal.Uoid["uuid"])
-            return true
+        mo := append(append([]string{}, mountFlags...), mount_options...)
+        for _, export := range exports {
+            endStage := trace.stage(publishStageNFSMount)
+            err = common.MountShare(export, targetPath, mo)
+            endStage()
+            if err != nil {
+                log.Infof("Could not mount %s via data-portal, %s. Error: %v", export, portal.Uoid["uuid"], err)
+            } else {
+                log.Infof("Mounted via data-portal, %s.", portal.Uoid["uuid"])
+                return true
+            }
         }
         return false
     }
 
-    log.Infof("Attempting to mount via NFS 4.2.")
-    mounted := false
-    for _, p := range portals {
-        mounted = MountToDataPortal(p, append(mountFlags, "nfsvers=4.2"))
-        if mounted {
-            break
+    // An NFS version set in the mount flags is used as is, with the data-portals of its type first
+    requested := getRequestedNFSVersion(mountFlags)
+    if requested != "" {
+        for _, p := range orderPortalsForNFSVersion(portals, requested) {
+            common.SampledInfof("Attempting to mount via NFS %s at %s.", requested, getPortalAddress(p))
+            if MountToDataPortal(p, nil) {
+                return nil
+            }
         }
-    }
-    if !mounted {
-        log.Infof("Could not mount via NFS 4.2, falling back to NFS 3.")
-        for _, p := range portals {
-            mounted = MountToDataPortal(p, append(mountFlags, "nfsvers=3,nolock"))
-            if mounted {
-                break
+    } else {
+        for _, a := range d.getPortalMountAttempts(portals, getPortalAddress) {
+            common.SampledInfof("Attempting to mount via NFS %s at %s.", a.version, a.address)
+            if MountToDataPortal(a.portal, nfsVersionMountOptions[a.version]) {
+                d.setPortalNFSVersion(a.address, a.version)
+                return nil
             }
         }
     }
-    if mounted {
-        return nil
+    // The data-portals may have changed since they were listed
+    d.dataPortals.invalidate()
+    if common.AnvilMountFallback && !common.NodeCredentialless {
+        err = d.mountShareAtAnvil(shareExportPath, targetPath, mountFlags, trace)
+        if err == nil {
+            return nil
+        }
+        log.Errorf("Could not mount %s from the Anvil, %v", shareExportPath, err)
     }
     return errors.New("Could not mount to any data-portals")
 }
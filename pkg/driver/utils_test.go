@@ -3,6 +3,8 @@ package driver
 import (
     "reflect"
     "testing"
+
+    common "github.com/hammer-space/csi-plugin/pkg/common"
 )
 
 func TestGetSnapshotNameFromSnapshotId(t *testing.T) {
@@ -64,12 +66,123 @@ func TestGetSnapshotIDFromSnapshotName(t *testing.T) {
     }
 }
 
-func TestGetVolumeNameFromPath(t *testing.T) {
-    expected := "test-volume"
-    actual := GetVolumeNameFromPath("/test-backing-share/test-volume")
+func TestGetPortalNFSVersions(t *testing.T) {
+    d := &CSIDriver{portalNFSVersions: map[string]string{}}
+    portal := common.DataPortal{}
+
+    expected := []string{NFSVersion42, NFSVersion3}
+    actual := d.getPortalNFSVersions("10.0.0.1", portal)
+    if !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", actual)
+        t.FailNow()
+    }
+
+    // NFSv3 portals skip 4.2, whatever they report exporting
+    expected = []string{NFSVersion3}
+    actual = d.getPortalNFSVersions("10.0.0.1", common.DataPortal{
+        DataPortalType: common.DataPortalTypeNFSv3, Exported: []string{"NFS_V4"}})
+    if !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", actual)
+        t.FailNow()
+    }
+
+    // NFSv4 portals skip 3
+    expected = []string{NFSVersion42}
+    actual = d.getPortalNFSVersions("10.0.0.2", common.DataPortal{DataPortalType: common.DataPortalTypeNFSv4})
+    if !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v", actual)
+        t.FailNow()
+    }
+
+    // previously negotiated version is tried first
+    d.setPortalNFSVersion("10.0.0.1", NFSVersion3)
+    expected = []string{NFSVersion3, NFSVersion42}
+    actual = d.getPortalNFSVersions("10.0.0.1", portal)
     if !reflect.DeepEqual(actual, expected) {
         t.Logf("Expected: %v", expected)
         t.Logf("Actual: %v", actual)
         t.FailNow()
     }
-}
\ No newline at end of file
+}
+
+func TestGetPortalMountAttempts(t *testing.T) {
+    d := &CSIDriver{portalNFSVersions: map[string]string{"10.0.0.3": NFSVersion3}}
+    portals := []common.DataPortal{
+        {Node: common.DataPortalNode{MgmtIpAddress: common.DataPortalNodeAddress{Address: "10.0.0.1"}}},
+        {DataPortalType: common.DataPortalTypeNFSv3,
+            Node: common.DataPortalNode{MgmtIpAddress: common.DataPortalNodeAddress{Address: "10.0.0.2"}}},
+        {Node: common.DataPortalNode{MgmtIpAddress: common.DataPortalNodeAddress{Address: "10.0.0.3"}}},
+    }
+    attempts := []string{}
+    for _, a := range d.getPortalMountAttempts(portals, func(p common.DataPortal) string {
+        return p.Node.MgmtIpAddress.Address
+    }) {
+        attempts = append(attempts, a.address+" "+a.version)
+    }
+    // 4.2 is tried everywhere before falling back to 3, but for the versions negotiated before
+    expected := []string{"10.0.0.1 4.2", "10.0.0.2 3", "10.0.0.3 3", "10.0.0.1 3", "10.0.0.3 4.2"}
+    if !reflect.DeepEqual(attempts, expected) {
+        t.Logf("Expected %v, received %v", expected, attempts)
+        t.FailNow()
+    }
+}
+
+func TestGetRequestedNFSVersion(t *testing.T) {
+    for _, c := range []struct {
+        flags    []string
+        expected string
+    }{
+        {nil, ""},
+        {[]string{"hard", "nconnect=4"}, ""},
+        {[]string{"nfsvers=4.1"}, "4.1"},
+        {[]string{"hard,vers=3"}, "3"},
+    } {
+        if actual := getRequestedNFSVersion(c.flags); actual != c.expected {
+            t.Logf("Expected %q for %v, received %q", c.expected, c.flags, actual)
+            t.FailNow()
+        }
+    }
+}
+
+func TestOrderPortalsForNFSVersion(t *testing.T) {
+    v3 := common.DataPortal{DataPortalType: common.DataPortalTypeNFSv3, Uoid: map[string]string{"uuid": "v3"}}
+    v4 := common.DataPortal{DataPortalType: common.DataPortalTypeNFSv4, Uoid: map[string]string{"uuid": "v4"}}
+    // Exported does not say which versions a data-portal serves
+    v4Exporting3 := common.DataPortal{DataPortalType: common.DataPortalTypeNFSv4, Exported: []string{"NFS_V3"},
+        Uoid: map[string]string{"uuid": "v4-exporting-3"}}
+    untyped := common.DataPortal{Uoid: map[string]string{"uuid": "untyped"}}
+    portals := []common.DataPortal{v3, v4, v4Exporting3, untyped}
+
+    names := func(portals []common.DataPortal) []string {
+        uuids := []string{}
+        for _, p := range portals {
+            uuids = append(uuids, p.Uoid["uuid"])
+        }
+        return uuids
+    }
+    for _, c := range []struct {
+        requested string
+        expected  []string
+    }{
+        {"", []string{"v3", "v4", "v4-exporting-3", "untyped"}},
+        {"4.1", []string{"v4", "v4-exporting-3", "v3", "untyped"}},
+        {"3", []string{"v3", "untyped"}},
+    } {
+        if actual := names(orderPortalsForNFSVersion(portals, c.requested)); !reflect.DeepEqual(actual, c.expected) {
+            t.Logf("Expected %v for NFS %q, received %v", c.expected, c.requested, actual)
+            t.FailNow()
+        }
+    }
+}
+
+func TestGetDefaultPrefixExports(t *testing.T) {
+    expected := []string{"10.0.0.1://share", "10.0.0.1:/mnt/data-portal/share", "10.0.0.1:/share"}
+    actual := getDefaultPrefixExports("10.0.0.1", "/share")
+    if !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected %v, received %v", expected, actual)
+        t.FailNow()
+    }
+}
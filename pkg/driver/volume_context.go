@@ -0,0 +1,72 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    log "github.com/sirupsen/logrus"
+)
+
+// The volume context is stored in the PersistentVolume when the volume is created and never
+// updated, so volumes created by earlier releases keep the context those releases wrote. CreateVolume
+// records the version of the context it writes, contexts without one are version 1. The node and
+// controller read the context through migrateVolumeContext, which fills in what version 1 contexts
+// may lack from the volume ID and capability.
+const (
+    VolumeContextVersionKey = "contextVersion"
+    VolumeContextVersion    = "2"
+
+    legacyVolumeContextVersion = "1"
+)
+
+// getVolumeContextVersion returns the version of a volume context
+func getVolumeContextVersion(volContext map[string]string) string {
+    if version := volContext[VolumeContextVersionKey]; version != "" {
+        return version
+    }
+    return legacyVolumeContextVersion
+}
+
+// migrateVolumeContext returns the volume context of a volume in the current version. Version 1
+// contexts of file-backed volumes may not name the backing share, which is the directory of the
+// volume's file. Without a capability the backing share is set for both block and mount access.
+func migrateVolumeContext(volumeID string, volContext map[string]string, capability *csi.VolumeCapability) map[string]string {
+    if getVolumeContextVersion(volContext) != legacyVolumeContextVersion {
+        return volContext
+    }
+    migrated := make(map[string]string, len(volContext)+2)
+    for k, v := range volContext {
+        migrated[k] = v
+    }
+    migrated[VolumeContextVersionKey] = VolumeContextVersion
+
+    id, err := ParseVolumeID(volumeID)
+    if err != nil || !id.IsFileBacked() {
+        return migrated
+    }
+    if migrated["blockBackingShareName"] != "" || migrated["mountBackingShareName"] != "" {
+        return migrated
+    }
+    if capability == nil || capability.GetBlock() != nil {
+        migrated["blockBackingShareName"] = id.BackingShare
+    }
+    if capability == nil || capability.GetMount() != nil {
+        migrated["mountBackingShareName"] = id.BackingShare
+    }
+    log.Infof("volume context of %s does not name its backing share, using %s", volumeID, id.BackingShare)
+    return migrated
+}
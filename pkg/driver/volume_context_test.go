@@ -0,0 +1,52 @@
+package driver
+
+import (
+    "reflect"
+    "testing"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestMigrateVolumeContext(t *testing.T) {
+    mount := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}
+    block := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+
+    current := map[string]string{VolumeContextVersionKey: VolumeContextVersion, "fsType": "ext4"}
+    if migrated := migrateVolumeContext("/backing/pvc-1", current, mount); !reflect.DeepEqual(migrated, current) {
+        t.Logf("Expected a current context to be unchanged, received %v", migrated)
+        t.FailNow()
+    }
+
+    legacy := map[string]string{"fsType": "ext4"}
+    migrated := migrateVolumeContext("/backing/pvc-1", legacy, mount)
+    expected := map[string]string{VolumeContextVersionKey: VolumeContextVersion, "fsType": "ext4", "mountBackingShareName": "backing"}
+    if !reflect.DeepEqual(migrated, expected) {
+        t.Logf("Expected %v, received %v", expected, migrated)
+        t.FailNow()
+    }
+    if _, exists := legacy[VolumeContextVersionKey]; exists {
+        t.Logf("Expected the request's context not to be modified")
+        t.FailNow()
+    }
+
+    migrated = migrateVolumeContext("/backing/pvc-1", map[string]string{}, block)
+    if migrated["blockBackingShareName"] != "backing" || migrated["mountBackingShareName"] != "" {
+        t.Logf("Expected the block backing share to be set, received %v", migrated)
+        t.FailNow()
+    }
+    migrated = migrateVolumeContext("/backing/pvc-1", nil, nil)
+    if migrated["blockBackingShareName"] != "backing" || migrated["mountBackingShareName"] != "backing" {
+        t.Logf("Expected both backing shares to be set without a capability, received %v", migrated)
+        t.FailNow()
+    }
+    migrated = migrateVolumeContext("/backing/pvc-1", map[string]string{"blockBackingShareName": "other"}, mount)
+    if migrated["blockBackingShareName"] != "other" || migrated["mountBackingShareName"] != "" {
+        t.Logf("Expected a named backing share to be kept, received %v", migrated)
+        t.FailNow()
+    }
+    migrated = migrateVolumeContext("/pvc-2", map[string]string{}, mount)
+    if migrated["mountBackingShareName"] != "" || migrated[VolumeContextVersionKey] != VolumeContextVersion {
+        t.Logf("Expected share-backed volumes only to be versioned, received %v", migrated)
+        t.FailNow()
+    }
+}
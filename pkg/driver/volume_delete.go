@@ -0,0 +1,95 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "sync"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Deleting a namespace deletes its volumes in a burst. The controller runs up to
+// common.DeleteVolumeConcurrency deletions at once, further ones queue. Deletions of files in the
+// same backing share still take turns on its lock to delete their file, but only the last of
+// them in flight updates the allocation of the share and schedules its unmount, for all of them.
+const (
+    MetricDeleteVolumesInFlight = "hs_csi_delete_volume_in_flight"
+    MetricDeleteVolumesQueued   = "hs_csi_delete_volume_queued"
+    MetricDeleteVolumeWait      = "hs_csi_delete_volume_wait_seconds_total"
+
+    deleteVolumeOperation = "delete volume"
+)
+
+func init() {
+    common.RegisterMetric(MetricDeleteVolumesInFlight, common.MetricTypeGauge,
+        "DeleteVolume operations running")
+    common.RegisterMetric(MetricDeleteVolumesQueued, common.MetricTypeGauge,
+        "DeleteVolume operations waiting for the concurrency limit")
+    common.RegisterMetric(MetricDeleteVolumeWait, common.MetricTypeCounter,
+        "Time DeleteVolume operations spent waiting for the concurrency limit")
+}
+
+func newDeleteVolumeLimiter(limit int) *operationLimiter {
+    return newOperationLimiter(limit,
+        MetricDeleteVolumesInFlight, MetricDeleteVolumesQueued, MetricDeleteVolumeWait)
+}
+
+// backingShareDeleteBatches tracks the deletions of files in flight in each backing share
+type backingShareDeleteBatches struct {
+    lock     sync.Mutex
+    inFlight map[string]int   // backing share name -> deletions in flight
+    released map[string]int64 // backing share name -> bytes deleted, not yet released
+}
+
+func (d *CSIDriver) beginBackingShareDelete(backingShareName string) {
+    d.deleteBatches.lock.Lock()
+    defer d.deleteBatches.lock.Unlock()
+    if d.deleteBatches.inFlight == nil {
+        d.deleteBatches.inFlight = map[string]int{}
+        d.deleteBatches.released = map[string]int64{}
+    }
+    d.deleteBatches.inFlight[backingShareName]++
+}
+
+// finishBackingShareDelete records the bytes released by a deletion. It returns true and the
+// bytes released by the batch when this was the last deletion in flight in the backing share.
+func (d *CSIDriver) finishBackingShareDelete(backingShareName string, released int64) (bool, int64) {
+    d.deleteBatches.lock.Lock()
+    defer d.deleteBatches.lock.Unlock()
+    d.deleteBatches.released[backingShareName] += released
+    d.deleteBatches.inFlight[backingShareName]--
+    if d.deleteBatches.inFlight[backingShareName] > 0 {
+        return false, 0
+    }
+    total := d.deleteBatches.released[backingShareName]
+    delete(d.deleteBatches.inFlight, backingShareName)
+    delete(d.deleteBatches.released, backingShareName)
+    return true, total
+}
+
+// endBackingShareDelete ends a deletion begun with beginBackingShareDelete. The last deletion in
+// flight releases the allocation of the batch and schedules the unmount of the backing share.
+func (d *CSIDriver) endBackingShareDelete(backingShareName string, released int64) {
+    last, total := d.finishBackingShareDelete(backingShareName, released)
+    if !last {
+        return
+    }
+    defer d.releaseVolumeLock(backingShareName)
+    d.getVolumeLock(backingShareName)
+    d.releaseBackingShareAllocation(backingShareName, total)
+    d.scheduleBackingShareUnmount(backingShareName)
+}
@@ -0,0 +1,88 @@
+package driver
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestBackingShareDeleteBatches(t *testing.T) {
+    d := &CSIDriver{sharedState: newSharedState()}
+    d.beginBackingShareDelete("backing-1")
+    d.beginBackingShareDelete("backing-1")
+    d.beginBackingShareDelete("backing-2")
+
+    if last, _ := d.finishBackingShareDelete("backing-1", 100); last {
+        t.Logf("Expected a deletion to still be in flight in backing-1")
+        t.FailNow()
+    }
+    if last, total := d.finishBackingShareDelete("backing-2", 50); !last || total != 50 {
+        t.Logf("Expected the last deletion in backing-2 to release 50 bytes, received %v, %d", last, total)
+        t.FailNow()
+    }
+    // Failed deletions release nothing
+    if last, total := d.finishBackingShareDelete("backing-1", 0); !last || total != 100 {
+        t.Logf("Expected the last deletion in backing-1 to release 100 bytes, received %v, %d", last, total)
+        t.FailNow()
+    }
+
+    // A new batch starts empty
+    d.beginBackingShareDelete("backing-1")
+    if last, total := d.finishBackingShareDelete("backing-1", 10); !last || total != 10 {
+        t.Logf("Expected a new batch to release 10 bytes, received %v, %d", last, total)
+        t.FailNow()
+    }
+}
+
+func TestDeleteShareBackedVolumeSnapshots(t *testing.T) {
+    mux := http.NewServeMux()
+    d := newFakeDriver(t, mux)
+
+    var lock sync.Mutex
+    deletedSnapshots := []string{}
+    shareDeleted := false
+    mux.HandleFunc(client.BasePath+"/share-snapshots/snapshot-list/pvc-a", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `["snap-1", "current", "snap-2"]`)
+    })
+    mux.HandleFunc(client.BasePath+"/share-snapshots/snapshot-delete/pvc-a/", func(w http.ResponseWriter, r *http.Request) {
+        lock.Lock()
+        defer lock.Unlock()
+        deletedSnapshots = append(deletedSnapshots, strings.TrimPrefix(r.URL.Path, client.BasePath+"/share-snapshots/snapshot-delete/pvc-a/"))
+    })
+    mux.HandleFunc(client.BasePath+"/shares/pvc-a", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == "DELETE" {
+            shareDeleted = true
+        }
+    })
+
+    share := &common.ShareResponse{Name: "pvc-a", ExtendedInfo: map[string]string{
+        ExtendedInfoCreatedBy: common.CsiPluginName,
+    }}
+
+    if err := d.deleteShareBackedVolume(share); status.Code(err) != codes.FailedPrecondition {
+        t.Logf("Expected a share with snapshots not to be deleted, %v", err)
+        t.FailNow()
+    }
+    if shareDeleted || len(deletedSnapshots) > 0 {
+        t.Logf("Expected nothing to be deleted")
+        t.FailNow()
+    }
+
+    share.ExtendedInfo[ExtendedInfoDeleteSnapshots] = "true"
+    if err := d.deleteShareBackedVolume(share); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if len(deletedSnapshots) != 2 || !shareDeleted {
+        t.Logf("Expected the snapshots and the share to be deleted, received %v, %v", deletedSnapshots, shareDeleted)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "path"
+    "strings"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Volume IDs are the path of the volume on the cluster, which is
+//   /<share name>                            for share-backed volumes
+//   <backing share export path>/<file name>  for file-backed volumes
+// The export path of the shares created by the plugin is /<share name>, or
+// <exportPathPrefix>/<share name> with the exportPathPrefix parameter. Share-backed volumes keep
+// the /<share name> ID wherever their share is exported, and record the export path in their
+// volume context.
+const (
+    VolumeIDModeShare = "share"
+    VolumeIDModeFile  = "file"
+)
+
+// VolumeID is a decoded volume ID
+type VolumeID struct {
+    Mode         string // VolumeIDModeShare or VolumeIDModeFile
+    BackingShare string // Name of the backing share, for file-backed volumes
+    Name         string // Name of the share or of the backing file
+    Path         string // Path of the share or of the backing file, the encoded volume ID
+}
+
+// NewShareVolumeID returns the ID of the share-backed volume in the share named name
+func NewShareVolumeID(name string) VolumeID {
+    return VolumeID{
+        Mode: VolumeIDModeShare,
+        Name: name,
+        Path: common.SharePathPrefix + name,
+    }
+}
+
+// GetShareExportPath returns the export path of the share named name created under prefix
+func GetShareExportPath(prefix, name string) string {
+    return path.Join(common.SharePathPrefix, prefix, name)
+}
+
+// NewFileVolumeID returns the ID of the file-backed volume named name in the backing share
+// exported at backingSharePath
+func NewFileVolumeID(backingSharePath, name string) VolumeID {
+    return VolumeID{
+        Mode:         VolumeIDModeFile,
+        BackingShare: path.Base(backingSharePath),
+        Name:         name,
+        Path:         path.Join(backingSharePath, name),
+    }
+}
+
+// ParseVolumeID decodes a volume ID, returning an error for IDs which are not a share path or
+// the path of a file in a backing share
+func ParseVolumeID(volumeID string) (VolumeID, error) {
+    if !strings.HasPrefix(volumeID, "/") || path.Clean(volumeID) != volumeID || volumeID == "/" {
+        return VolumeID{}, fmt.Errorf(common.InvalidVolumeID, volumeID)
+    }
+    if strings.Count(volumeID, "/") == 1 {
+        return NewShareVolumeID(strings.TrimPrefix(volumeID, common.SharePathPrefix)), nil
+    }
+    return NewFileVolumeID(path.Dir(volumeID), path.Base(volumeID)), nil
+}
+
+// IsFileBacked returns whether the volume is a file in a backing share
+func (v VolumeID) IsFileBacked() bool {
+    return v.Mode == VolumeIDModeFile
+}
+
+// BackingSharePath returns the export path of the backing share of a file-backed volume
+func (v VolumeID) BackingSharePath() string {
+    if !v.IsFileBacked() {
+        return ""
+    }
+    return path.Dir(v.Path)
+}
+
+func (v VolumeID) String() string {
+    return v.Path
+}
@@ -0,0 +1,65 @@
+package driver
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestParseVolumeID(t *testing.T) {
+    expected := VolumeID{
+        Mode:         VolumeIDModeFile,
+        BackingShare: "test-backing-share",
+        Name:         "test-volume",
+        Path:         "/test-backing-share/test-volume",
+    }
+    actual, err := ParseVolumeID("/test-backing-share/test-volume")
+    if err != nil || !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v, %v", actual, err)
+        t.FailNow()
+    }
+    if actual.BackingSharePath() != "/test-backing-share" {
+        t.Logf("Unexpected backing share path %s", actual.BackingSharePath())
+        t.FailNow()
+    }
+    if actual != NewFileVolumeID("/test-backing-share", "test-volume") {
+        t.Logf("Expected the file volume ID to round trip")
+        t.FailNow()
+    }
+
+    expected = VolumeID{
+        Mode: VolumeIDModeShare,
+        Name: "test-volume",
+        Path: "/test-volume",
+    }
+    actual, err = ParseVolumeID("/test-volume")
+    if err != nil || !reflect.DeepEqual(actual, expected) {
+        t.Logf("Expected: %v", expected)
+        t.Logf("Actual: %v, %v", actual, err)
+        t.FailNow()
+    }
+    if actual.IsFileBacked() || actual != NewShareVolumeID("test-volume") {
+        t.Logf("Expected the share volume ID to round trip")
+        t.FailNow()
+    }
+
+    if GetShareExportPath("", "test-volume") != "/test-volume" ||
+        GetShareExportPath("/k8s/prod", "test-volume") != "/k8s/prod/test-volume" {
+        t.Logf("Unexpected share export paths")
+        t.FailNow()
+    }
+
+    // Backing shares may be exported below the root
+    actual, err = ParseVolumeID("/exports/test-backing-share/test-volume")
+    if err != nil || actual.BackingShare != "test-backing-share" || actual.BackingSharePath() != "/exports/test-backing-share" {
+        t.Logf("Unexpected nested volume ID %v, %v", actual, err)
+        t.FailNow()
+    }
+
+    for _, volumeID := range []string{"", "/", "test-volume", "/test-volume/", "//test-volume", "/a/../test-volume"} {
+        if _, err := ParseVolumeID(volumeID); err == nil {
+            t.Logf("Expected error for volume ID '%s'", volumeID)
+            t.FailNow()
+        }
+    }
+}
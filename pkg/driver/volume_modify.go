@@ -0,0 +1,169 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "sort"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// ModifyVolume changes the parameters of an existing volume in place, as the ControllerModifyVolume
+// RPC of later CSI versions does for a VolumeAttributesClass. Objectives may be changed on any
+// volume, the comment and export options only on share-backed volumes, as file-backed volumes
+// share those of their backing share. Any other parameter is fixed when the volume is created.
+var mutableVolumeParameterNames = []string{
+    "tier",
+    "objectives",
+    "objectivesRemove",
+    "objectivesReplace",
+    "comment",
+    "exportOptions",
+}
+
+var shareOnlyVolumeParameterNames = []string{
+    "comment",
+    "exportOptions",
+}
+
+// checkModifiableParameters returns an InvalidArgument error listing the parameters which cannot
+// be modified on a volume
+func checkModifiableParameters(params map[string]string, fileBacked bool) error {
+    immutable := []string{}
+    shareOnly := []string{}
+    for name := range params {
+        if !IsValueInList(name, mutableVolumeParameterNames) {
+            immutable = append(immutable, name)
+        } else if fileBacked && IsValueInList(name, shareOnlyVolumeParameterNames) {
+            shareOnly = append(shareOnly, name)
+        }
+    }
+    sort.Strings(immutable)
+    sort.Strings(shareOnly)
+    if len(immutable) > 0 {
+        return status.Errorf(codes.InvalidArgument, common.ImmutableParameters, immutable, mutableVolumeParameterNames)
+    }
+    if len(shareOnly) > 0 {
+        return status.Errorf(codes.InvalidArgument, common.ModifyUnsupportedFileBacked, shareOnly)
+    }
+    return nil
+}
+
+// ModifyVolume applies the mutable parameters in params to the volume with the given ID. A
+// file-backed volume given another backingShareName is moved there first, the volume at its new
+// path is returned with the PersistentVolume to create for it, named pvName and formatted as
+// fsType, or nil when the volume was not moved.
+func (d *CSIDriver) ModifyVolume(volumeID string, params map[string]string, pvName, fsType string) (*RestoredVolume, error) {
+    id, err := ParseVolumeID(volumeID)
+    if err != nil {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+    destShareName, move := params[ParameterBackingShareName]
+    if move {
+        remaining := map[string]string{}
+        for name, value := range params {
+            if name != ParameterBackingShareName {
+                remaining[name] = value
+            }
+        }
+        params = remaining
+    }
+    if move && !id.IsFileBacked() {
+        return nil, status.Error(codes.InvalidArgument, common.MoveUnsupportedShareBacked)
+    }
+    if err := checkModifiableParameters(params, id.IsFileBacked()); err != nil {
+        return nil, err
+    }
+    vParams, err := parseVolParams(params)
+    if err != nil {
+        return nil, err
+    }
+
+    defer d.releaseVolumeLock(id.Name)
+    d.getVolumeLock(id.Name)
+
+    if !id.IsFileBacked() {
+        return nil, d.modifyShareBackedVolume(id, params, vParams)
+    }
+    var moved *RestoredVolume
+    if move {
+        moved, err = d.moveFileBackedVolume(id, destShareName, pvName, fsType)
+        if err != nil {
+            return nil, err
+        }
+        if moved != nil {
+            id, _ = ParseVolumeID(moved.VolumeID)
+        }
+    }
+    return moved, d.modifyFileBackedVolume(id, vParams)
+}
+
+func (d *CSIDriver) modifyShareBackedVolume(id VolumeID, params map[string]string, vParams common.HSVolumeParameters) error {
+    share, err := d.hsclient.GetShare(id.Name)
+    if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    if share == nil || share.ShareState == "REMOVED" {
+        return status.Error(codes.NotFound, common.VolumeNotFound)
+    }
+
+    applied := make([]string, len(share.Objectives.Applied))
+    for i, o := range share.Objectives.Applied {
+        applied[i] = o.Name
+    }
+    err = d.applyObjectiveChanges(share.Name, "/", applied,
+        vParams.Objectives, vParams.ObjectivesRemove, vParams.ObjectivesReplace)
+    if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    // parseVolParams defaults the comment, only change it when it is given
+    if _, exists := params["comment"]; exists {
+        if err := d.hsclient.UpdateShareComment(share.Name, vParams.Comment); err != nil {
+            return status.Error(codes.Internal, err.Error())
+        }
+    }
+    if _, exists := params["exportOptions"]; exists {
+        if err := d.hsclient.UpdateShareExportOptions(share.Name, vParams.ExportOptions); err != nil {
+            return status.Error(codes.Internal, err.Error())
+        }
+    }
+    log.Infof("modified volume %s, %v", id, params)
+    return nil
+}
+
+// modifyFileBackedVolume changes the objectives of the file of a volume. The objectives applied to
+// files are not reported, those to remove are unset whether or not they are applied.
+func (d *CSIDriver) modifyFileBackedVolume(id VolumeID, vParams common.HSVolumeParameters) error {
+    exists, err := d.hsclient.DoesFileExist(id.Path)
+    if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    if !exists {
+        return status.Error(codes.NotFound, common.VolumeNotFound)
+    }
+    err = d.applyObjectiveChanges(id.BackingShare, "/"+id.Name, vParams.ObjectivesRemove,
+        vParams.Objectives, vParams.ObjectivesRemove, vParams.ObjectivesReplace)
+    if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    log.Infof("modified objectives of volume %s", id)
+    return nil
+}
@@ -0,0 +1,35 @@
+package driver
+
+import (
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestCheckModifiableParameters(t *testing.T) {
+    shareParams := map[string]string{"objectives": "keep-online", "comment": "tier 1", "exportOptions": "*,RW,false"}
+    if err := checkModifiableParameters(shareParams, false); err != nil {
+        t.Logf("Expected share-backed volume parameters to be modifiable, received %v", err)
+        t.FailNow()
+    }
+    if err := checkModifiableParameters(map[string]string{"tier": "gold", "objectivesRemove": "a"}, true); err != nil {
+        t.Logf("Expected objectives of file-backed volumes to be modifiable, received %v", err)
+        t.FailNow()
+    }
+
+    err := checkModifiableParameters(shareParams, true)
+    if status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected InvalidArgument for comment of file-backed volume, received %v", err)
+        t.FailNow()
+    }
+    err = checkModifiableParameters(map[string]string{"objectives": "a", "fsType": "xfs", "deleteDelay": "0"}, false)
+    if status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected InvalidArgument for immutable parameters, received %v", err)
+        t.FailNow()
+    }
+    if msg := status.Convert(err).Message(); msg != "parameters [deleteDelay fsType] cannot be modified, only [tier objectives objectivesRemove objectivesReplace comment exportOptions] can be changed on an existing volume" {
+        t.Logf("Unexpected message %s", msg)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "os"
+    "sort"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// A file-backed volume is moved to another backing share, for example from an HDD-backed share to
+// an NVMe-backed one, by modifying its backingShareName. Only volumes which are not published are
+// moved, and the controller holds the reclaim lock of the volume meanwhile so that no node
+// publishes it. The file is snapshotted and the snapshot restored by the Anvil to a temporary file
+// in the other share, which is renamed into place once complete, so the volume only appears at
+// its new path with all of its data. Volume IDs hold the path of the file, so the moved volume
+// has a new ID, and a PersistentVolume is returned for it. The file at the old path is then
+// deleted as by DeleteVolume.
+const (
+    ParameterBackingShareName = "backingShareName"
+
+    AnnotationMovedFrom = "csi.hammerspace.com/moved-from"
+    AnnotationMovedAt   = "csi.hammerspace.com/moved-at"
+)
+
+// moveFileBackedVolume moves the file of a volume to the backing share named destShareName and
+// returns the volume at its new path, nil if it is in that share already
+func (d *CSIDriver) moveFileBackedVolume(id VolumeID, destShareName, pvName, fsType string) (*RestoredVolume, error) {
+    if destShareName == id.BackingShare {
+        return nil, nil
+    }
+    destShare, err := d.hsclient.GetShare(destShareName)
+    if err != nil {
+        return nil, status.Error(codes.Internal, err.Error())
+    }
+    if destShare == nil || destShare.ShareState == "REMOVED" || !isBackingShare(destShare) {
+        return nil, status.Errorf(codes.InvalidArgument, common.MoveDestinationNotBackingShare, destShareName)
+    }
+    sourceFile, err := d.hsclient.GetFile(id.Path)
+    if err != nil {
+        return nil, status.Error(codes.Internal, err.Error())
+    }
+    if sourceFile == nil {
+        return nil, status.Error(codes.NotFound, common.VolumeNotFound)
+    }
+    allocated, err := d.getBackingShareAllocation(destShare)
+    if err != nil {
+        return nil, status.Error(codes.Internal, err.Error())
+    }
+    ratio := getBackingShareOvercommitRatio(destShare)
+    if err := checkBackingShareOvercommit(destShare, allocated, sourceFile.Size, ratio); err != nil {
+        return nil, err
+    }
+
+    destID := NewFileVolumeID(destShare.ExportPath, id.Name)
+    if err := d.copyVolumeFile(id, destID); err != nil {
+        return nil, err
+    }
+    d.recordBackingShareAllocation(destShare, allocated+sourceFile.Size, ratio)
+    log.Infof("moved volume %s to %s", id, destID)
+
+    // The volume is in place at its new path, the old file is only left behind if this fails
+    if err := d.deleteFileBackedVolume(id); err != nil {
+        log.Warnf("could not delete the file of volume %s at its old path, delete it once it has no snapshots, %v", id, err)
+    }
+
+    if pvName == "" {
+        pvName = "moved-" + strings.ToLower(id.Name)
+    }
+    volumeMode := "Block"
+    attributes := map[string]string{"blockBackingShareName": destShare.Name}
+    if fsType != "" {
+        volumeMode = "Filesystem"
+        attributes = map[string]string{"mountBackingShareName": destShare.Name, "fsType": fsType}
+    }
+    now := time.Now()
+    pv := newRestoredPersistentVolume(pvName, destID, sourceFile.Size, volumeMode, "ReadWriteOnce", attributes, now)
+    pv["metadata"].(map[string]interface{})["annotations"] = map[string]string{
+        AnnotationMovedFrom: id.Path,
+        AnnotationMovedAt:   now.UTC().Format(time.RFC3339),
+    }
+    return &RestoredVolume{
+        VolumeID:         destID.Path,
+        CapacityBytes:    sourceFile.Size,
+        PersistentVolume: pv,
+    }, nil
+}
+
+// copyVolumeFile copies the file of the volume source to the path of dest through a snapshot,
+// while the volume is not published and cannot be
+func (d *CSIDriver) copyVolumeFile(source, dest VolumeID) error {
+    // The shares are locked in the order of their names, so that concurrent moves do not deadlock
+    shareNames := []string{source.BackingShare, dest.BackingShare}
+    sort.Strings(shareNames)
+    for _, name := range shareNames {
+        defer d.releaseVolumeLock(name)
+        d.getVolumeLock(name)
+        defer d.scheduleBackingShareUnmount(name)
+        if err := d.EnsureBackingShareMounted(name); err != nil {
+            return err
+        }
+    }
+
+    lock, err := common.LockFile(getReclaimFile(source.Path, "lock"), true)
+    if err == common.ErrFileLocked {
+        nodes, _ := getAttachedNodes(source.Path)
+        return status.Errorf(codes.FailedPrecondition, common.VolumeMovePublished, source, nodes)
+    } else if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    defer lock.Close()
+    nodes, err := getAttachedNodes(source.Path)
+    if err != nil {
+        return status.Error(codes.Internal, err.Error())
+    }
+    if len(nodes) > 0 {
+        return status.Errorf(codes.FailedPrecondition, common.VolumeMovePublished, source, nodes)
+    }
+
+    destFile := common.StagingPath(dest.Path)
+    if _, err := os.Stat(destFile); err == nil {
+        return status.Errorf(codes.AlreadyExists, common.MoveDestinationExists, dest)
+    }
+    snapshot, err := d.hsclient.SnapshotFile(source.Path)
+    if err != nil {
+        return status.Errorf(codes.Internal, common.MoveFailed, source, err)
+    }
+    defer func() {
+        if err := d.hsclient.DeleteFileSnapshot(source.Path, snapshot); err != nil {
+            log.Warnf("could not delete snapshot %s of %s taken to move it, %v", snapshot, source, err)
+        }
+    }()
+    log.Infof("moving %s to %s from snapshot %s", source, dest, snapshot)
+    tempFile := destFile + cloneTempSuffix
+    if err := d.hsclient.RestoreFileSnapToDestination(snapshot, dest.Path+cloneTempSuffix); err != nil {
+        os.Remove(tempFile)
+        return status.Errorf(codes.Internal, common.MoveFailed, source, err)
+    }
+    if err := os.Rename(tempFile, destFile); err != nil {
+        os.Remove(tempFile)
+        return status.Errorf(codes.Internal, common.MoveFailed, source, err)
+    }
+    return nil
+}
@@ -0,0 +1,116 @@
+package driver
+
+import (
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestCopyVolumeFile(t *testing.T) {
+    sourceDir, err := ioutil.TempDir(common.ShareStagingDir, "move-source")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(sourceDir)
+    destDir, err := ioutil.TempDir(common.ShareStagingDir, "move-dest")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(destDir)
+    ioutil.WriteFile(filepath.Join(sourceDir, "volume"), []byte("data"), 0644)
+
+    mux := http.NewServeMux()
+    d := newFakeDriver(t, mux)
+    for _, dir := range []string{sourceDir, destDir} {
+        name := path.Base(dir)
+        mux.HandleFunc(client.BasePath+"/shares/"+name, func(w http.ResponseWriter, r *http.Request) {
+            fmt.Fprintf(w, `{"name": "%s", "path": "/%s"}`, name, name)
+        })
+    }
+    snapshots := 0
+    mux.HandleFunc(client.BasePath+"/file-snapshots/create", func(w http.ResponseWriter, r *http.Request) {
+        snapshots++
+        fmt.Fprintf(w, `["2024-01-01-00-00-00-volume"]`)
+    })
+    restored := ""
+    mux.HandleFunc(client.BasePath+"/file-snapshots/", func(w http.ResponseWriter, r *http.Request) {
+        // The Anvil restores the snapshot to the temporary file in the destination share
+        restored = strings.TrimPrefix(r.URL.Path, client.BasePath+"/file-snapshots/2024-01-01-00-00-00-volume")
+        ioutil.WriteFile(common.StagingPath(restored), []byte("data"), 0644)
+    })
+    snapshotsDeleted := 0
+    mux.HandleFunc(client.BasePath+"/file-snapshots/delete", func(w http.ResponseWriter, r *http.Request) {
+        snapshotsDeleted++
+    })
+    defer func(f func(string) (string, bool, error)) { common.GetMountSource = f }(common.GetMountSource)
+    common.GetMountSource = func(string) (string, bool, error) { return "", true, nil }
+    defer func(f func(string) error) { isMountResponsive = f }(isMountResponsive)
+    isMountResponsive = func(string) error { return nil }
+
+    source := NewFileVolumeID("/"+path.Base(sourceDir), "volume")
+    dest := NewFileVolumeID("/"+path.Base(destDir), "volume")
+
+    // Published volumes are not moved
+    node := &CSIDriver{sharedState: newSharedState(), NodeID: "node1", nodeState: newNodeStateStore("")}
+    if err := node.markVolumeAttached(source.Path, "/target/a"); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if err := d.copyVolumeFile(source, dest); status.Code(err) != codes.FailedPrecondition {
+        t.Logf("Expected FailedPrecondition while the volume is published, received %v", err)
+        t.FailNow()
+    }
+    if snapshots != 0 {
+        t.Logf("Expected the file of a published volume not to be snapshotted")
+        t.FailNow()
+    }
+    node.unmarkVolumeAttached(source.Path, "/target/a")
+
+    if err := d.copyVolumeFile(source, dest); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+    if restored != dest.Path+cloneTempSuffix {
+        t.Logf("Expected the snapshot to be restored to %s, received %s", dest.Path+cloneTempSuffix, restored)
+        t.FailNow()
+    }
+    // The temporary file is renamed into place
+    if _, err := os.Stat(common.StagingPath(dest.Path)); err != nil {
+        t.Logf("Expected the volume at its new path, %v", err)
+        t.FailNow()
+    }
+    if _, err := os.Stat(common.StagingPath(dest.Path + cloneTempSuffix)); !os.IsNotExist(err) {
+        t.Logf("Expected the temporary file to be renamed, %v", err)
+        t.FailNow()
+    }
+    if snapshotsDeleted != 1 {
+        t.Logf("Expected the snapshot to be deleted, deleted %d", snapshotsDeleted)
+        t.FailNow()
+    }
+
+    // The volume is not copied over an existing file
+    if err := d.copyVolumeFile(source, dest); status.Code(err) != codes.AlreadyExists {
+        t.Logf("Expected AlreadyExists, received %v", err)
+        t.FailNow()
+    }
+
+    // Share-backed volumes have no backing share to move to
+    _, err = d.ModifyVolume("/pvc-share", map[string]string{ParameterBackingShareName: path.Base(destDir)}, "", "")
+    if status.Code(err) != codes.InvalidArgument {
+        t.Logf("Expected InvalidArgument, received %v", err)
+        t.FailNow()
+    }
+}
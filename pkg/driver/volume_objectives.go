@@ -0,0 +1,70 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "strconv"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Objectives which cannot be applied to a volume, e.g. because they were deleted from the cluster,
+// are by default logged and counted, and the volume is still created. With the strictObjectives
+// parameter CreateVolume fails instead, removing the volume it created so the CO retries cleanly.
+const (
+    MetricObjectiveFailures = "hs_csi_objective_failures_total"
+)
+
+func init() {
+    common.RegisterMetric(MetricObjectiveFailures, common.MetricTypeCounter,
+        "Times the objectives of a volume could not be applied, by whether the volume required them")
+}
+
+// setVolumeObjectives sets the objectives of a created volume at path in a share, replacing those
+// it inherited, and removes those listed in its objectivesRemove parameter
+func (d *CSIDriver) setVolumeObjectives(shareName, path string, hsVolume *common.HSVolume) error {
+    if len(hsVolume.Objectives) > 0 {
+        err := d.hsclient.SetObjectives(shareName, path, hsVolume.Objectives, true)
+        if err != nil {
+            return err
+        }
+    }
+    if len(hsVolume.ObjectivesRemove) > 0 {
+        return d.hsclient.RemoveObjectives(shareName, path, hsVolume.ObjectivesRemove)
+    }
+    return nil
+}
+
+// checkObjectivesApplied records a failure to apply the objectives of a volume, returning an
+// error if the volume requires its objectives
+func checkObjectivesApplied(hsVolume *common.HSVolume, err error) error {
+    if err == nil {
+        return nil
+    }
+    common.IncMetric(MetricObjectiveFailures, map[string]string{
+        "strict": strconv.FormatBool(hsVolume.StrictObjectives),
+    })
+    if hsVolume.StrictObjectives {
+        return status.Errorf(codes.Internal, common.ObjectivesNotApplied, hsVolume.Name, err)
+    }
+    log.Warnf("failed to apply objectives of volume %s, %v", hsVolume.Name, err)
+    return nil
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestCheckObjectivesApplied(t *testing.T) {
+    hsVolume := &common.HSVolume{Name: "pvc-1"}
+    if err := checkObjectivesApplied(hsVolume, nil); err != nil {
+        t.Logf("Unexpected error, %v", err)
+        t.FailNow()
+    }
+
+    // Failures are only counted unless the volume requires its objectives
+    failure := fmt.Errorf("failed to set objective")
+    if err := checkObjectivesApplied(hsVolume, failure); err != nil {
+        t.Logf("Expected best-effort objectives to succeed, %v", err)
+        t.FailNow()
+    }
+    hsVolume.StrictObjectives = true
+    if err := checkObjectivesApplied(hsVolume, failure); status.Code(err) != codes.Internal {
+        t.Logf("Expected Internal for strict objectives, received %v", err)
+        t.FailNow()
+    }
+
+    metrics := common.RenderMetrics()
+    for _, expected := range []string{
+        MetricObjectiveFailures + `{strict="false"} 1`,
+        MetricObjectiveFailures + `{strict="true"} 1`,
+    } {
+        if !strings.Contains(metrics, expected) {
+            t.Logf("Expected %s in metrics, received %s", expected, metrics)
+            t.FailNow()
+        }
+    }
+
+    params, err := parseVolParams(map[string]string{"strictObjectives": "true"})
+    if err != nil || !params.StrictObjectives {
+        t.Logf("Expected strict objectives, received %v, %v", params.StrictObjectives, err)
+        t.FailNow()
+    }
+    if _, err = parseVolParams(map[string]string{"strictObjectives": "always"}); err == nil {
+        t.Logf("Expected error for strictObjectives always")
+        t.FailNow()
+    }
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Parameters set by the Kubernetes sidecars, e.g. csi.storage.k8s.io/pvc/name with
+// --extra-create-metadata, are not part of the StorageClass and are always accepted
+const kubernetesParameterPrefix = "csi.storage.k8s.io/"
+
+// volumeParameterNames are the volume parameters understood by parseVolParams
+var volumeParameterNames = []string{
+    "deleteDelay",
+    "deleteMode",
+    "deleteSnapshots",
+    "comment",
+    "tier",
+    "objectives",
+    "objectivesRemove",
+    "objectivesReplace",
+    "strictObjectives",
+    "backingShareObjectives",
+    "blockBackingShareName",
+    "mountBackingShareName",
+    "fsType",
+    "exportOptions",
+    "volumeNameFormat",
+    "additionalMetadataTags",
+    "clientMountOptions",
+    "mountPolicy",
+    "transport",
+    "rdmaPort",
+    "blockPublishMode",
+    "fsckOnStage",
+    "maxVolumesPerBackingShare",
+    "maxOvercommitRatio",
+    "exportPathPrefix",
+    "endpoint",
+    "strictParameters",
+}
+
+// parameterErrors collects the problems found while validating parameters, so that all of them
+// are reported to the user at once rather than one per attempt
+type parameterErrors []string
+
+func (e *parameterErrors) addf(format string, args ...interface{}) {
+    *e = append(*e, fmt.Sprintf(format, args...))
+}
+
+func (e *parameterErrors) add(err error) {
+    *e = append(*e, err.Error())
+}
+
+// checkUnknown records the names in params which are not in known when strict is set, and
+// otherwise only logs a warning for them. Each is reported with the nearest known name.
+func (e *parameterErrors) checkUnknown(params map[string]string, known []string, strict bool) {
+    unknown := []string{}
+    for name := range params {
+        if !strings.HasPrefix(name, kubernetesParameterPrefix) && !IsValueInList(name, known) {
+            unknown = append(unknown, name)
+        }
+    }
+    sort.Strings(unknown)
+    for i, name := range unknown {
+        if suggestion := nearestParameterName(name, known); suggestion != "" {
+            unknown[i] = fmt.Sprintf(common.UnknownParameterSuggestion, name, suggestion)
+        }
+    }
+    if len(unknown) == 0 {
+        return
+    }
+    if strict {
+        e.addf(common.UnknownParameters, strings.Join(unknown, ", "))
+    } else {
+        common.SampledWarnf("ignoring "+common.UnknownParameters, strings.Join(unknown, ", "))
+    }
+}
+
+// nearestParameterName returns the known name closest to name, "" if none is close enough to
+// be a likely typo
+func nearestParameterName(name string, known []string) string {
+    nearest := ""
+    best := len(name) / 3
+    if best < 2 {
+        best = 2
+    }
+    for _, k := range known {
+        distance := editDistance(strings.ToLower(name), strings.ToLower(k))
+        if distance <= best {
+            nearest = k
+            best = distance
+        }
+    }
+    return nearest
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+    previous := make([]int, len(b)+1)
+    current := make([]int, len(b)+1)
+    for j := range previous {
+        previous[j] = j
+    }
+    for i := 1; i <= len(a); i++ {
+        current[0] = i
+        for j := 1; j <= len(b); j++ {
+            cost := 1
+            if a[i-1] == b[j-1] {
+                cost = 0
+            }
+            current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+        }
+        previous, current = current, previous
+    }
+    return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+    if a < b {
+        return a
+    }
+    return b
+}
+
+// err returns a single InvalidArgument error listing every problem, nil if there were none
+func (e parameterErrors) err() error {
+    if len(e) == 0 {
+        return nil
+    }
+    return status.Errorf(codes.InvalidArgument, common.InvalidParameters, strings.Join(e, "; "))
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// I/O statistics of the loop devices of published block volumes, updated each time the CO
+// requests the volume's stats. Slow I/O here with an idle application points at the NFS path
+// to the backing file.
+const (
+    MetricBlockReadBytes  = "hs_csi_block_volume_read_bytes_total"
+    MetricBlockWriteBytes = "hs_csi_block_volume_write_bytes_total"
+    MetricBlockReads      = "hs_csi_block_volume_reads_total"
+    MetricBlockWrites     = "hs_csi_block_volume_writes_total"
+    MetricBlockInFlight   = "hs_csi_block_volume_io_in_flight"
+    MetricBlockIOTime     = "hs_csi_block_volume_io_time_seconds_total"
+)
+
+var blockVolumeMetrics = []string{
+    MetricBlockReadBytes, MetricBlockWriteBytes, MetricBlockReads, MetricBlockWrites, MetricBlockInFlight, MetricBlockIOTime,
+}
+
+func init() {
+    common.RegisterMetric(MetricBlockReadBytes, common.MetricTypeCounter,
+        "Bytes read from the loop device of a block volume")
+    common.RegisterMetric(MetricBlockWriteBytes, common.MetricTypeCounter,
+        "Bytes written to the loop device of a block volume")
+    common.RegisterMetric(MetricBlockReads, common.MetricTypeCounter,
+        "Read requests completed by the loop device of a block volume")
+    common.RegisterMetric(MetricBlockWrites, common.MetricTypeCounter,
+        "Write requests completed by the loop device of a block volume")
+    common.RegisterMetric(MetricBlockInFlight, common.MetricTypeGauge,
+        "I/O requests in flight on the loop device of a block volume")
+    common.RegisterMetric(MetricBlockIOTime, common.MetricTypeCounter,
+        "Time the loop device of a block volume spent doing I/O")
+}
+
+func getBlockVolumeLabels(volumeID string) map[string]string {
+    return map[string]string{"volume_id": volumeID}
+}
+
+// recordBlockVolumeStats exports the statistics of a block volume's loop device as metrics
+func recordBlockVolumeStats(volumeID string, stats *common.BlockDeviceStats) {
+    labels := getBlockVolumeLabels(volumeID)
+    common.SetMetric(MetricBlockReadBytes, labels, float64(stats.ReadBytes))
+    common.SetMetric(MetricBlockWriteBytes, labels, float64(stats.WriteBytes))
+    common.SetMetric(MetricBlockReads, labels, float64(stats.ReadIOs))
+    common.SetMetric(MetricBlockWrites, labels, float64(stats.WriteIOs))
+    common.SetMetric(MetricBlockInFlight, labels, float64(stats.InFlight))
+    common.SetMetric(MetricBlockIOTime, labels, float64(stats.IOTimeMillis)/1000)
+}
+
+func forgetBlockVolumeStats(volumeID string) {
+    labels := getBlockVolumeLabels(volumeID)
+    for _, name := range blockVolumeMetrics {
+        common.DeleteMetric(name, labels)
+    }
+}
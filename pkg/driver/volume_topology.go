@@ -0,0 +1,69 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "sort"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// CreateVolume honors the topology requirement of the request, which the external-provisioner
+// builds from the segments reported by NodeGetInfo and the allowedTopologies of the StorageClass.
+// Volumes are reached over NFS from data-portals and other nodes alike, so a volume is accessible
+// from every segment of the requirement using the plugin's key, the preferred segments first and
+// otherwise the data-portals first. A StorageClass allowing only data-portal nodes thus keeps its
+// volumes, and the pods using them, on those nodes. Requests without a requirement get no
+// accessible topology, their volumes are accessible from all nodes.
+
+// getAccessibleTopology returns the topology a volume created for requirement is accessible from
+func getAccessibleTopology(requirement *csi.TopologyRequirement) ([]*csi.Topology, error) {
+    candidates := append(append([]*csi.Topology{}, requirement.GetPreferred()...), requirement.GetRequisite()...)
+    if len(candidates) == 0 {
+        return nil, nil
+    }
+    accessible := []*csi.Topology{}
+    seen := map[string]bool{}
+    for _, topology := range candidates {
+        served, err := isTopologyServed(topology)
+        if err != nil {
+            return nil, err
+        }
+        value, exists := topology.GetSegments()[common.TopologyKeyDataPortal]
+        if !served || !exists || seen[value] {
+            continue
+        }
+        seen[value] = true
+        accessible = append(accessible, &csi.Topology{
+            Segments: map[string]string{common.TopologyKeyDataPortal: value},
+        })
+    }
+    if len(accessible) == 0 {
+        return nil, status.Errorf(codes.ResourceExhausted, common.TopologyNotServed, common.TopologyKeyDataPortal)
+    }
+    if len(requirement.GetPreferred()) == 0 {
+        sort.SliceStable(accessible, func(i, j int) bool {
+            return accessible[i].Segments[common.TopologyKeyDataPortal] == "true" &&
+                accessible[j].Segments[common.TopologyKeyDataPortal] != "true"
+        })
+    }
+    return accessible, nil
+}
@@ -0,0 +1,72 @@
+package driver
+
+import (
+    "reflect"
+    "testing"
+
+    "github.com/container-storage-interface/spec/lib/go/csi"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestGetAccessibleTopology(t *testing.T) {
+    portal := func(value string) *csi.Topology {
+        return &csi.Topology{Segments: map[string]string{common.TopologyKeyDataPortal: value}}
+    }
+    zone := &csi.Topology{Segments: map[string]string{"topology.kubernetes.io/zone": "a"}}
+
+    tests := []struct {
+        name        string
+        requirement *csi.TopologyRequirement
+        expected    []string
+        code        codes.Code
+    }{
+        {name: "no requirement", requirement: nil, expected: nil},
+        {name: "empty requirement", requirement: &csi.TopologyRequirement{}, expected: nil},
+        {
+            name:        "data-portals first",
+            requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{portal("false"), portal("true")}},
+            expected:    []string{"true", "false"},
+        },
+        {
+            name: "preferred first",
+            requirement: &csi.TopologyRequirement{
+                Requisite: []*csi.Topology{portal("true"), portal("false")},
+                Preferred: []*csi.Topology{portal("false")},
+            },
+            expected: []string{"false", "true"},
+        },
+        {
+            name:        "only data-portals",
+            requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{portal("true"), zone}},
+            expected:    []string{"true"},
+        },
+        {
+            name:        "not served",
+            requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{zone}},
+            code:        codes.ResourceExhausted,
+        },
+        {
+            name:        "invalid segment",
+            requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{portal("maybe")}},
+            code:        codes.InvalidArgument,
+        },
+    }
+    for _, test := range tests {
+        topology, err := getAccessibleTopology(test.requirement)
+        if status.Code(err) != test.code {
+            t.Logf("Expected %v for %s, received %v", test.code, test.name, err)
+            t.FailNow()
+        }
+        var values []string
+        for _, segment := range topology {
+            values = append(values, segment.Segments[common.TopologyKeyDataPortal])
+        }
+        if !reflect.DeepEqual(values, test.expected) {
+            t.Logf("Expected topology %v for %s, received %v", test.expected, test.name, values)
+            t.FailNow()
+        }
+    }
+}
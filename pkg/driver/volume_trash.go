@@ -0,0 +1,381 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path"
+    "strconv"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Shares of deleted share-backed volumes stay in the REMOVED state for their csi_delete_delay and
+// may be undeleted meanwhile. With HS_FILE_TRASH_RETENTION set, the files of deleted file-backed
+// volumes are likewise moved to the .csi-trash directory of their backing share, named
+// <file>@<unix time of the deletion>, and purged from it by the leading controller once older
+// than the retention. Trashed files stay in the allocation of their backing share until purged.
+// RestoreDeletedVolume brings either back and describes the PersistentVolume to create for it.
+const (
+    trashDirName       = ".csi-trash"
+    trashTimeSeparator = "@"
+    // Longest time between purges of the trash of the backing shares
+    trashPurgeInterval = time.Hour
+
+    ExtendedInfoRestoredAt = "csi_restored_at"
+
+    AnnotationRestoredFrom = "csi.hammerspace.com/restored-from"
+    AnnotationRestoredAt   = "csi.hammerspace.com/restored-at"
+)
+
+// RestoredVolume is a volume brought back by RestoreDeletedVolume
+type RestoredVolume struct {
+    VolumeID         string                 `json:"volumeId"`
+    CapacityBytes    int64                  `json:"capacityBytes"`
+    PersistentVolume map[string]interface{} `json:"persistentVolume"`
+}
+
+func trashEntryName(name string, deletedAt time.Time) string {
+    return name + trashTimeSeparator + strconv.FormatInt(deletedAt.Unix(), 10)
+}
+
+// parseTrashEntryName returns the name of the file and the time it was deleted from the name of
+// an entry of the trash
+func parseTrashEntryName(entry string) (string, time.Time, bool) {
+    i := strings.LastIndex(entry, trashTimeSeparator)
+    if i <= 0 {
+        return "", time.Time{}, false
+    }
+    seconds, err := strconv.ParseInt(entry[i+1:], 10, 64)
+    if err != nil {
+        return "", time.Time{}, false
+    }
+    return entry[:i], time.Unix(seconds, 0), true
+}
+
+func isTrashEntryExpired(deletedAt time.Time, retention time.Duration, now time.Time) bool {
+    return retention > 0 && now.Sub(deletedAt) > retention
+}
+
+// moveToTrash moves filePath to the trash of the mounted backing share at backingDir
+func moveToTrash(backingDir, filePath string, now time.Time) error {
+    trashDir := path.Join(backingDir, trashDirName)
+    if err := os.MkdirAll(trashDir, 0755); err != nil {
+        return err
+    }
+    return os.Rename(filePath, path.Join(trashDir, trashEntryName(path.Base(filePath), now)))
+}
+
+// purgeTrash deletes the files deleted more than retention before now from the trash of the
+// mounted backing share at backingDir, returning the entries it deleted and their logical size
+func purgeTrash(backingDir string, retention time.Duration, now time.Time) ([]string, int64, error) {
+    trashDir := path.Join(backingDir, trashDirName)
+    entries, err := ioutil.ReadDir(trashDir)
+    if os.IsNotExist(err) {
+        return nil, 0, nil
+    }
+    if err != nil {
+        return nil, 0, err
+    }
+    purged := []string{}
+    var size int64
+    for _, entry := range entries {
+        _, deletedAt, ok := parseTrashEntryName(entry.Name())
+        if !ok || entry.IsDir() || !isTrashEntryExpired(deletedAt, retention, now) {
+            continue
+        }
+        if err := common.DeleteFile(path.Join(trashDir, entry.Name())); err != nil {
+            return purged, size, err
+        }
+        purged = append(purged, entry.Name())
+        size += entry.Size()
+    }
+    return purged, size, nil
+}
+
+// sumTrashSizes returns the logical size of the files in the trash of the mounted backing share
+// at backingDir
+func sumTrashSizes(backingDir string) (int64, error) {
+    entries, err := ioutil.ReadDir(path.Join(backingDir, trashDirName))
+    if os.IsNotExist(err) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    var total int64
+    for _, entry := range entries {
+        if !entry.IsDir() {
+            total += entry.Size()
+        }
+    }
+    return total, nil
+}
+
+// findTrashedFile returns the path of the most recent copy of the file named name in the trash of
+// the mounted backing share at backingDir which has not expired, or "" if there is none
+func findTrashedFile(backingDir, name string, retention time.Duration, now time.Time) (string, error) {
+    trashDir := path.Join(backingDir, trashDirName)
+    entries, err := ioutil.ReadDir(trashDir)
+    if os.IsNotExist(err) {
+        return "", nil
+    }
+    if err != nil {
+        return "", err
+    }
+    found := ""
+    var foundAt time.Time
+    for _, entry := range entries {
+        entryName, deletedAt, ok := parseTrashEntryName(entry.Name())
+        if !ok || entryName != name || entry.IsDir() || isTrashEntryExpired(deletedAt, retention, now) {
+            continue
+        }
+        if found == "" || deletedAt.After(foundAt) {
+            found, foundAt = path.Join(trashDir, entry.Name()), deletedAt
+        }
+    }
+    return found, nil
+}
+
+// trashBackingFile moves the file of a file-backed volume to the trash of its backing share and
+// purges the expired files from it. The caller must hold the lock on the backing share.
+func (d *CSIDriver) trashBackingFile(volumeID VolumeID) error {
+    filePath, err := d.resolveBackingFile(volumeID)
+    if err != nil {
+        return err
+    }
+    backingDir := common.StagingPath(volumeID.BackingSharePath())
+    now := time.Now()
+    if err := moveToTrash(backingDir, filePath, now); err != nil {
+        return status.Errorf(codes.Internal, err.Error())
+    }
+    log.Infof("moved file of volume %s to the trash of backing share %s", volumeID, volumeID.BackingShare)
+    d.purgeBackingShareTrash(volumeID.BackingShare, backingDir, now)
+    return nil
+}
+
+// purgeBackingShareTrash purges the expired files from the trash of the mounted backing share at
+// backingDir and releases their allocation. The caller must hold the lock on the backing share.
+func (d *CSIDriver) purgeBackingShareTrash(backingShareName, backingDir string, now time.Time) {
+    purged, size, err := purgeTrash(backingDir, common.FileTrashRetention, now)
+    if err != nil {
+        log.Warnf("could not purge the trash of backing share %s, %v", backingShareName, err)
+    }
+    if len(purged) > 0 {
+        log.Infof("purged %v from the trash of backing share %s", purged, backingShareName)
+        d.releaseBackingShareAllocation(backingShareName, size)
+    }
+}
+
+// purgeTrashes purges the expired files from the trash of every backing share while this replica
+// is the leading controller
+func (d *CSIDriver) purgeTrashes() {
+    if !d.isLeader() {
+        return
+    }
+    shares, err := d.hsclient.ListShares()
+    if err != nil {
+        log.Warnf("could not list the backing shares to purge their trash, %v", err)
+        return
+    }
+    now := time.Now()
+    for i := range shares {
+        share := &shares[i]
+        if share.ShareState == "REMOVED" || !isBackingShare(share) {
+            continue
+        }
+        func() {
+            defer d.releaseVolumeLock(share.Name)
+            d.getVolumeLock(share.Name)
+            defer d.scheduleBackingShareUnmount(share.Name)
+            if err := d.EnsureBackingShareMounted(share.Name); err != nil {
+                log.Warnf("could not mount backing share %s to purge its trash, %v", share.Name, err)
+                return
+            }
+            d.purgeBackingShareTrash(share.Name, common.StagingPath(share.ExportPath), now)
+        }()
+    }
+}
+
+// startTrashPurger runs purgeTrashes every interval until the driver is stopped
+func (d *CSIDriver) startTrashPurger(interval time.Duration) {
+    log.Infof("starting trash purger with interval %v", interval)
+    d.wg.Add(1)
+    go func() {
+        defer d.wg.Done()
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-d.stopCh:
+                return
+            case <-ticker.C:
+                d.purgeTrashes()
+            }
+        }
+    }()
+}
+
+func getRestoredExtendedInfo(pvName string, now time.Time) map[string]string {
+    return map[string]string{
+        ExtendedInfoVolumeName: pvName,
+        ExtendedInfoRestoredAt: now.UTC().Format(time.RFC3339),
+    }
+}
+
+// newRestoredPersistentVolume returns the PersistentVolume to create for a restored volume. It is
+// retained on deletion, the data was deleted once already.
+func newRestoredPersistentVolume(pvName string, volumeID VolumeID, size int64,
+    volumeMode, accessMode string, attributes map[string]string, now time.Time) map[string]interface{} {
+
+    volumeAttributes := map[string]string{VolumeContextVersionKey: VolumeContextVersion}
+    for k, v := range attributes {
+        volumeAttributes[k] = v
+    }
+    return map[string]interface{}{
+        "apiVersion": "v1",
+        "kind":       "PersistentVolume",
+        "metadata": map[string]interface{}{
+            "name": pvName,
+            "annotations": map[string]string{
+                AnnotationRestoredFrom: volumeID.Path,
+                AnnotationRestoredAt:   now.UTC().Format(time.RFC3339),
+            },
+        },
+        "spec": map[string]interface{}{
+            "capacity":                      map[string]string{"storage": strconv.FormatInt(size, 10)},
+            "accessModes":                   []string{accessMode},
+            "volumeMode":                    volumeMode,
+            "persistentVolumeReclaimPolicy": "Retain",
+            "csi": map[string]interface{}{
+                "driver":           common.CsiPluginName,
+                "volumeHandle":     volumeID.Path,
+                "volumeAttributes": volumeAttributes,
+            },
+        },
+    }
+}
+
+// RestoreDeletedVolume brings back a share-backed volume whose share is still in the REMOVED state,
+// or a file-backed volume whose file is still in the trash. The share's extended info, or the
+// file's tags, are stamped with pvName, the PersistentVolume to create for it, and the time of
+// the restore. File-backed volumes restored without fsType are described as block volumes.
+func (d *CSIDriver) RestoreDeletedVolume(volumeID, pvName, fsType string) (*RestoredVolume, error) {
+    id, err := ParseVolumeID(volumeID)
+    if err != nil {
+        return nil, err
+    }
+    if pvName == "" {
+        pvName = "restored-" + strings.ToLower(id.Name)
+    }
+    if id.IsFileBacked() {
+        return d.restoreDeletedFile(id, pvName, fsType)
+    }
+    return d.restoreDeletedShare(id, pvName)
+}
+
+func (d *CSIDriver) restoreDeletedShare(id VolumeID, pvName string) (*RestoredVolume, error) {
+    share, err := d.hsclient.GetShare(id.Name)
+    if err != nil {
+        return nil, err
+    }
+    if share == nil {
+        return nil, fmt.Errorf(common.DeletedVolumeNotFound, id)
+    }
+    if share.ShareState != "REMOVED" {
+        return nil, fmt.Errorf(common.VolumeNotDeleted, id)
+    }
+    if err := checkShareDeletable(share); err != nil {
+        return nil, fmt.Errorf(common.RestoreNotOwned, share.Name)
+    }
+    if err := d.hsclient.UndeleteShare(share.Name); err != nil {
+        return nil, err
+    }
+
+    now := time.Now()
+    // The share is back, failing to stamp it should not suggest otherwise
+    if err := d.hsclient.UpdateShareExtendedInfo(share.Name, getRestoredExtendedInfo(pvName, now)); err != nil {
+        log.Warnf("could not record the restore of share %s, %v", share.Name, err)
+    }
+    log.Infof("restored share %s for PersistentVolume %s", share.Name, pvName)
+    return &RestoredVolume{
+        VolumeID:         id.Path,
+        CapacityBytes:    share.Size,
+        PersistentVolume: newRestoredPersistentVolume(pvName, id, share.Size, "Filesystem", "ReadWriteMany", nil, now),
+    }, nil
+}
+
+func (d *CSIDriver) restoreDeletedFile(id VolumeID, pvName, fsType string) (*RestoredVolume, error) {
+    defer d.releaseVolumeLock(id.BackingShare)
+    d.getVolumeLock(id.BackingShare)
+    defer d.scheduleBackingShareUnmount(id.BackingShare)
+    backingDir := common.StagingPath(id.BackingSharePath())
+    if err := d.EnsureBackingShareMounted(id.BackingShare); err != nil {
+        return nil, err
+    }
+    filePath, err := common.ResolveFileUnder(backingDir, id.Name)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := os.Stat(filePath); err == nil {
+        return nil, fmt.Errorf(common.VolumeNotDeleted, id)
+    }
+
+    now := time.Now()
+    trashed, err := findTrashedFile(backingDir, id.Name, common.FileTrashRetention, now)
+    if err != nil {
+        return nil, err
+    }
+    if trashed == "" {
+        return nil, fmt.Errorf(common.DeletedVolumeNotFound, id)
+    }
+    if err := os.Rename(trashed, filePath); err != nil {
+        return nil, err
+    }
+    info, err := os.Stat(filePath)
+    if err != nil {
+        return nil, err
+    }
+
+    for name, value := range getRestoredExtendedInfo(pvName, now) {
+        if err := d.hsclient.SetFileTag(id.Path, name, value); err != nil {
+            log.Warnf("could not record the restore of file %s, %v", id.Path, err)
+        }
+    }
+    // The file stayed in the allocation of the backing share while in the trash
+    log.Infof("restored file %s for PersistentVolume %s", id.Path, pvName)
+
+    volumeMode := "Block"
+    attributes := map[string]string{"blockBackingShareName": id.BackingShare}
+    if fsType != "" {
+        volumeMode = "Filesystem"
+        attributes = map[string]string{"mountBackingShareName": id.BackingShare, "fsType": fsType}
+    }
+    return &RestoredVolume{
+        VolumeID:         id.Path,
+        CapacityBytes:    info.Size(),
+        PersistentVolume: newRestoredPersistentVolume(pvName, id, info.Size(), volumeMode, "ReadWriteOnce", attributes, now),
+    }, nil
+}
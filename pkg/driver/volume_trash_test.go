@@ -0,0 +1,122 @@
+package driver
+
+import (
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/hammer-space/csi-plugin/pkg/client"
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestParseTrashEntryName(t *testing.T) {
+    deletedAt := time.Unix(1700000000, 0)
+    name, parsedAt, ok := parseTrashEntryName(trashEntryName("pvc-1@a", deletedAt))
+    if !ok || name != "pvc-1@a" || !parsedAt.Equal(deletedAt) {
+        t.Logf("Expected pvc-1@a deleted at %v, received %s, %v, %v", deletedAt, name, parsedAt, ok)
+        t.FailNow()
+    }
+    for _, entry := range []string{"pvc-1", "@1700000000", "pvc-1@now"} {
+        if _, _, ok := parseTrashEntryName(entry); ok {
+            t.Logf("Expected %s to be ignored", entry)
+            t.FailNow()
+        }
+    }
+}
+
+func TestBackingFileTrash(t *testing.T) {
+    backingDir, err := ioutil.TempDir("", "backing")
+    if err != nil {
+        t.Log(err)
+        t.FailNow()
+    }
+    defer os.RemoveAll(backingDir)
+    now := time.Unix(1700000000, 0)
+    retention := time.Hour
+
+    for i, deletedAt := range []time.Time{now.Add(-2 * time.Hour), now.Add(-time.Minute), now} {
+        filePath := filepath.Join(backingDir, "pvc-1")
+        ioutil.WriteFile(filePath, []byte{byte(i)}, 0644)
+        if err := moveToTrash(backingDir, filePath, deletedAt); err != nil {
+            t.Logf("Unexpected error, %v", err)
+            t.FailNow()
+        }
+        if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+            t.Logf("Expected %s to be moved to the trash", filePath)
+            t.FailNow()
+        }
+    }
+
+    found, err := findTrashedFile(backingDir, "pvc-1", retention, now)
+    if err != nil || found != filepath.Join(backingDir, trashDirName, trashEntryName("pvc-1", now)) {
+        t.Logf("Expected the most recent copy of pvc-1, received %s, %v", found, err)
+        t.FailNow()
+    }
+    if found, _ := findTrashedFile(backingDir, "pvc-2", retention, now); found != "" {
+        t.Logf("Expected no copy of pvc-2, received %s", found)
+        t.FailNow()
+    }
+    if found, _ := findTrashedFile(backingDir, "pvc-1", retention, now.Add(2*time.Hour)); found != "" {
+        t.Logf("Expected expired copies to be ignored, received %s", found)
+        t.FailNow()
+    }
+
+    purged, size, err := purgeTrash(backingDir, retention, now)
+    if err != nil || len(purged) != 1 || purged[0] != trashEntryName("pvc-1", now.Add(-2*time.Hour)) || size != 1 {
+        t.Logf("Expected the expired copy to be purged, received %v, %d, %v", purged, size, err)
+        t.FailNow()
+    }
+    volumes, _ := listBackingShareVolumes(backingDir)
+    if len(volumes) != 0 {
+        t.Logf("Expected the trash not to be listed as volumes, received %v", volumes)
+        t.FailNow()
+    }
+    if total, _ := sumBackingFileSizes(backingDir); total != 2 {
+        t.Logf("Expected the trash to stay allocated until purged, received %d", total)
+        t.FailNow()
+    }
+}
+
+func TestPurgeTrashesLeader(t *testing.T) {
+    mux := http.NewServeMux()
+    d := newFakeDriver(t, mux)
+    shareLists := 0
+    mux.HandleFunc(client.BasePath+"/shares", func(w http.ResponseWriter, r *http.Request) {
+        shareLists++
+        w.Write([]byte(`[]`))
+    })
+
+    d.purgeTrashes()
+    if shareLists != 1 {
+        t.Logf("Expected the leader to list the backing shares, received %d", shareLists)
+        t.FailNow()
+    }
+    defer func(check string) { common.LeaderCheck = check }(common.LeaderCheck)
+    common.LeaderCheck = "file:" + filepath.Join(os.TempDir(), "no-such-leader-file")
+    d.leader = leaderState{}
+    d.purgeTrashes()
+    if shareLists != 1 {
+        t.Logf("Expected replicas which are not the leader not to purge, received %d", shareLists)
+        t.FailNow()
+    }
+}
+
+func TestNewRestoredPersistentVolume(t *testing.T) {
+    id := NewFileVolumeID("/backing", "pvc-1")
+    pv := newRestoredPersistentVolume("restored-pvc-1", id, 1024, "Block", "ReadWriteOnce",
+        map[string]string{"blockBackingShareName": "backing"}, time.Unix(1700000000, 0))
+    spec := pv["spec"].(map[string]interface{})
+    source := spec["csi"].(map[string]interface{})
+    if source["volumeHandle"] != "/backing/pvc-1" || spec["volumeMode"] != "Block" {
+        t.Logf("Unexpected PersistentVolume %v", pv)
+        t.FailNow()
+    }
+    annotations := pv["metadata"].(map[string]interface{})["annotations"].(map[string]string)
+    if annotations[AnnotationRestoredFrom] != "/backing/pvc-1" {
+        t.Logf("Expected the volume to be annotated with its origin, received %v", annotations)
+        t.FailNow()
+    }
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2019 Hammerspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+    "bytes"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "os"
+    "path"
+    "strconv"
+    "strings"
+    "time"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+// Usage of published volumes is compared to common.UsageThresholds each time the CO requests the
+// volume's stats. Crossing a threshold upwards is logged, counted and optionally posted as a
+// Kubernetes event on the volume's PersistentVolume, giving users warning before ENOSPC.
+const (
+    MetricVolumeUsageRatio        = "hs_csi_volume_usage_ratio"
+    MetricVolumeThresholdCrossing = "hs_csi_volume_usage_threshold_crossings_total"
+
+    volumeUsageEventReason = "VolumeUsageHigh"
+    kubeServiceAccountDir  = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+func init() {
+    common.RegisterMetric(MetricVolumeUsageRatio, common.MetricTypeGauge,
+        "Fraction of the capacity of a published volume which is used")
+    common.RegisterMetric(MetricVolumeThresholdCrossing, common.MetricTypeCounter,
+        "Times the usage of a volume rose above a usage threshold")
+}
+
+// getVolumeUsageLevel returns the highest threshold reached by used out of total, or 0
+func getVolumeUsageLevel(used, total int64, thresholds []int) int {
+    if total <= 0 {
+        return 0
+    }
+    level := 0
+    for _, threshold := range thresholds {
+        if used*100 >= int64(threshold)*total && threshold > level {
+            level = threshold
+        }
+    }
+    return level
+}
+
+// getPVNameFromTargetPath returns the name of the PersistentVolume kubelet publishes at
+// targetPath, .../volumes/kubernetes.io~csi/<pv name>/mount, or "" for other paths
+func getPVNameFromTargetPath(targetPath string) string {
+    dir := path.Dir(path.Clean(targetPath))
+    if path.Base(targetPath) != "mount" || path.Base(path.Dir(dir)) != "kubernetes.io~csi" {
+        return ""
+    }
+    return path.Base(dir)
+}
+
+// checkVolumeUsage records the usage of a published volume and reports the thresholds it crosses
+func (d *CSIDriver) checkVolumeUsage(volumeID, targetPath string, used, total int64) {
+    if total <= 0 {
+        return
+    }
+    labels := map[string]string{"volume_id": volumeID}
+    common.SetMetric(MetricVolumeUsageRatio, labels, float64(used)/float64(total))
+
+    level := getVolumeUsageLevel(used, total, common.UsageThresholds)
+    d.usageLevelsLock.Lock()
+    previous := d.usageLevels[volumeID]
+    d.usageLevels[volumeID] = level
+    d.usageLevelsLock.Unlock()
+    if level <= previous {
+        if level < previous {
+            log.Infof("usage of volume %s fell below %d%%", volumeID, previous)
+        }
+        return
+    }
+
+    message := fmt.Sprintf("volume %s is %d%% full, %d of %d bytes used",
+        volumeID, used*100/total, used, total)
+    log.WithFields(log.Fields{
+        "event":     volumeUsageEventReason,
+        "volumeId":  volumeID,
+        "threshold": level,
+    }).Warn(message)
+    common.IncMetric(MetricVolumeThresholdCrossing, map[string]string{
+        "volume_id": volumeID,
+        "threshold": strconv.Itoa(level),
+    })
+
+    if common.UsageEvents {
+        pvName := getPVNameFromTargetPath(targetPath)
+        if pvName == "" {
+            log.Debugf("not posting usage event for volume %s, no PersistentVolume in %s", volumeID, targetPath)
+            return
+        }
+        if err := postKubernetesEvent(pvName, d.NodeID, message); err != nil {
+            log.Warnf("could not post usage event for volume %s, %v", volumeID, err)
+        }
+    }
+}
+
+func (d *CSIDriver) forgetVolumeUsage(volumeID string) {
+    d.usageLevelsLock.Lock()
+    delete(d.usageLevels, volumeID)
+    d.usageLevelsLock.Unlock()
+    common.DeleteMetric(MetricVolumeUsageRatio, map[string]string{"volume_id": volumeID})
+}
+
+// postKubernetesEvent creates a warning event on a PersistentVolume using the pod's service account
+var postKubernetesEvent = func(pvName, host, message string) error {
+    apiHost, apiPort := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+    if apiHost == "" || apiPort == "" {
+        return fmt.Errorf("not running in a Kubernetes pod")
+    }
+    token, err := ioutil.ReadFile(path.Join(kubeServiceAccountDir, "token"))
+    if err != nil {
+        return err
+    }
+    ca, err := ioutil.ReadFile(path.Join(kubeServiceAccountDir, "ca.crt"))
+    if err != nil {
+        return err
+    }
+    certPool := x509.NewCertPool()
+    certPool.AppendCertsFromPEM(ca)
+
+    now := time.Now().UTC().Format(time.RFC3339)
+    // PersistentVolumes are not namespaced, their events are recorded in the default namespace
+    event := map[string]interface{}{
+        "apiVersion": "v1",
+        "kind":       "Event",
+        "metadata": map[string]interface{}{
+            "generateName": pvName + ".",
+            "namespace":    "default",
+        },
+        "involvedObject": map[string]interface{}{
+            "apiVersion": "v1",
+            "kind":       "PersistentVolume",
+            "name":       pvName,
+        },
+        "reason":         volumeUsageEventReason,
+        "message":        message,
+        "type":           "Warning",
+        "source":         map[string]interface{}{"component": common.CsiPluginName, "host": host},
+        "firstTimestamp": now,
+        "lastTimestamp":  now,
+        "count":          1,
+    }
+    body, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+    url := "https://" + net.JoinHostPort(apiHost, apiPort) + "/api/v1/namespaces/default/events"
+    req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+    client := &http.Client{
+        Timeout:   10 * time.Second,
+        Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: certPool}},
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("kubernetes API returned status code %d", resp.StatusCode)
+    }
+    return nil
+}
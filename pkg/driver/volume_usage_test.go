@@ -0,0 +1,71 @@
+package driver
+
+import (
+    "testing"
+
+    "github.com/hammer-space/csi-plugin/pkg/common"
+)
+
+func TestGetVolumeUsageLevel(t *testing.T) {
+    thresholds := []int{80, 90, 95}
+    cases := []struct {
+        used, total int64
+        expected    int
+    }{
+        {0, 100, 0},
+        {79, 100, 0},
+        {80, 100, 80},
+        {94, 100, 90},
+        {100, 100, 95},
+        {10, 0, 0},
+    }
+    for _, c := range cases {
+        if level := getVolumeUsageLevel(c.used, c.total, thresholds); level != c.expected {
+            t.Logf("Expected level %d for %d of %d, got %d", c.expected, c.used, c.total, level)
+            t.FailNow()
+        }
+    }
+}
+
+func TestGetPVNameFromTargetPath(t *testing.T) {
+    pvName := getPVNameFromTargetPath("/var/lib/kubelet/pods/0b0c/volumes/kubernetes.io~csi/pvc-1234/mount")
+    if pvName != "pvc-1234" {
+        t.Logf("Expected pvc-1234, got %s", pvName)
+        t.FailNow()
+    }
+    if pvName := getPVNameFromTargetPath("/tmp/target"); pvName != "" {
+        t.Logf("Expected no PV name, got %s", pvName)
+        t.FailNow()
+    }
+}
+
+func TestCheckVolumeUsage(t *testing.T) {
+    defer func() { common.UsageEvents = false }()
+    common.UsageEvents = true
+    var posted []string
+    oldPost := postKubernetesEvent
+    defer func() { postKubernetesEvent = oldPost }()
+    postKubernetesEvent = func(pvName, host, message string) error {
+        posted = append(posted, pvName)
+        return nil
+    }
+
+    d := &CSIDriver{usageLevels: make(map[string]int)}
+    targetPath := "/var/lib/kubelet/pods/0b0c/volumes/kubernetes.io~csi/pvc-1234/mount"
+    d.checkVolumeUsage("/hdd-backing/pvc-1234", targetPath, 50, 100)
+    d.checkVolumeUsage("/hdd-backing/pvc-1234", targetPath, 85, 100)
+    d.checkVolumeUsage("/hdd-backing/pvc-1234", targetPath, 86, 100)
+    d.checkVolumeUsage("/hdd-backing/pvc-1234", targetPath, 96, 100)
+    if len(posted) != 2 {
+        t.Logf("Expected an event for each threshold crossed, got %v", posted)
+        t.FailNow()
+    }
+
+    // Falling back below a threshold and crossing it again is reported again
+    d.checkVolumeUsage("/hdd-backing/pvc-1234", targetPath, 50, 100)
+    d.checkVolumeUsage("/hdd-backing/pvc-1234", targetPath, 81, 100)
+    if len(posted) != 3 {
+        t.Logf("Expected a new event after usage fell, got %v", posted)
+        t.FailNow()
+    }
+}
@@ -156,7 +156,9 @@ var _ = sanity.DescribeSanity("Hammerspace - NFS Volumes", func(sc *sanity.Sanit
 			//Check that HS objectives are set
 			if objectivesString, exists := sc.Config.TestVolumeParameters["objectives"]; exists {
 				objectives := strings.Split(objectivesString, ",")
-				share, _ := GetHammerspaceClient().GetShare(driver.GetVolumeNameFromPath(vol.GetVolume().GetVolumeId()))
+				volumeID, err := driver.ParseVolumeID(vol.GetVolume().GetVolumeId())
+				Expect(err).NotTo(HaveOccurred())
+				share, _ := GetHammerspaceClient().GetShare(volumeID.Name)
 				log.Infof("Got share %v", share)
 				objectiveNames := make([]string, len(share.Objectives.Applied))
 				for i, o := range share.Objectives.Applied {
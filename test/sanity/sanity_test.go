@@ -16,6 +16,7 @@ package sanitytest
 
 import (
 	"github.com/hammer-space/csi-plugin/pkg/client"
+	"github.com/hammer-space/csi-plugin/pkg/common"
 	"github.com/hammer-space/csi-plugin/pkg/driver"
 	"net"
 	"os"
@@ -55,6 +56,9 @@ func TestSanity(t *testing.T) {
 		os.Getenv("HS_PASSWORD"),
 		os.Getenv("HS_TLS_VERIFY"))
 
+	// The sanity tests publish volumes below /tmp, outside a kubelet root dir
+	common.KubeletRootDir = ""
+
 	go func() {
 		l, _ := net.Listen("unix", os.Getenv("CSI_ENDPOINT"))
 		d.Start(l)